@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AcceptorLatencies is a ranking of a proposer's acceptors by the latency of
+// their responses in each phase, for operators who want to see what thrifty
+// mode sees.
+type AcceptorLatencies struct {
+	Acceptors []AcceptorLatency `json:"acceptors"` // sorted by address
+	Slow      []SlowAcceptor    `json:"slow,omitempty"`
+}
+
+// AcceptorLatency is the latency of one acceptor's responses, per phase.
+type AcceptorLatency struct {
+	Addr    string       `json:"addr"`
+	Prepare PhaseLatency `json:"prepare"`
+	Accept  PhaseLatency `json:"accept"`
+}
+
+// PhaseLatency describes an acceptor's responses in one phase, over recent
+// windows of time.
+type PhaseLatency struct {
+	OneMinute   LatencyWindow `json:"1m"`
+	FiveMinutes LatencyWindow `json:"5m"`
+}
+
+// LatencyWindow holds latency quantiles of the responses in a window. They're
+// approximate, to within about 20%, and the window to within 10 seconds.
+type LatencyWindow struct {
+	Responses uint64        `json:"responses"`
+	P50       time.Duration `json:"p50_ns"`
+	P90       time.Duration `json:"p90_ns"`
+	P99       time.Duration `json:"p99_ns"`
+}
+
+// SlowAcceptor is an acceptor whose p99 latency over the last five minutes, in
+// some phase, is more than the configured multiple of the median over all the
+// acceptors which responded in that phase.
+type SlowAcceptor struct {
+	Addr   string        `json:"addr"`
+	Phase  string        `json:"phase"`
+	P99    time.Duration `json:"p99_ns"`
+	Median time.Duration `json:"median_p99_ns"`
+}
+
+// WithAcceptorLatency makes the proposer keep latency histograms of each
+// acceptor's responses, per phase, for the last five minutes, for
+// AcceptorLatencies. An acceptor is reported as slow when its p99 is more than
+// slowFactor times the median p99 in the same phase; a slowFactor of zero or
+// less reports none. By default, no histograms are kept.
+//
+// Only responses are measured: transport failures say nothing about latency,
+// and they're counted by Health instead.
+func WithAcceptorLatency(slowFactor float64) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.acceptorLatency = newAcceptorLatencyTracker(slowFactor) }
+}
+
+// AcceptorLatencies returns the current ranking. Like Summary, it doesn't wait
+// for the proposer. Without WithAcceptorLatency, it's empty.
+func (p *MemoryProposer) AcceptorLatencies() AcceptorLatencies {
+	if p.acceptorLatency == nil {
+		return AcceptorLatencies{}
+	}
+	return p.acceptorLatency.report(time.Now())
+}
+
+// The phases measured by an acceptorLatencyTracker.
+const (
+	phasePrepare = "prepare"
+	phaseAccept  = "accept"
+)
+
+const (
+	// digestPeriod is the width of each bucket of a latencyDigest.
+	digestPeriod = 10 * time.Second
+
+	// digestPeriods is how many buckets a latencyDigest keeps: five minutes'
+	// worth.
+	digestPeriods = 30
+)
+
+// acceptorLatencyTracker keeps a latencyDigest per acceptor and phase. Each
+// digest has its own mutex, held only for a few increments, and the map of
+// digests is only locked when an acceptor is first seen, so observations on
+// the hot path rarely contend, and never with each other across acceptors. A
+// nil tracker tracks nothing.
+type acceptorLatencyTracker struct {
+	slowFactor float64
+	digests    sync.Map // digestKey to *latencyDigest
+}
+
+type digestKey struct {
+	addr  string
+	phase string
+}
+
+// latencyDigest is a ring of per-period latency histograms, using the same
+// buckets as the summary.
+type latencyDigest struct {
+	mtx     sync.Mutex
+	buckets [digestPeriods]digestBucket
+}
+
+type digestBucket struct {
+	period    int64 // Unix time divided by digestPeriod
+	latencies [latencyBuckets]uint32
+}
+
+func newAcceptorLatencyTracker(slowFactor float64) *acceptorLatencyTracker {
+	return &acceptorLatencyTracker{slowFactor: slowFactor}
+}
+
+func (t *acceptorLatencyTracker) observe(now time.Time, addr, phase string, d time.Duration, err error) {
+	if t == nil || (err != nil && !isRejection(err)) {
+		return
+	}
+	key := digestKey{addr, phase}
+	v, ok := t.digests.Load(key)
+	if !ok {
+		v, _ = t.digests.LoadOrStore(key, &latencyDigest{})
+	}
+	v.(*latencyDigest).observe(now, d)
+}
+
+func (t *acceptorLatencyTracker) report(now time.Time) AcceptorLatencies {
+	byAddr := map[string]*AcceptorLatency{}
+	t.digests.Range(func(k, v interface{}) bool {
+		var (
+			key    = k.(digestKey)
+			digest = v.(*latencyDigest)
+			al     = byAddr[key.addr]
+		)
+		if al == nil {
+			al = &AcceptorLatency{Addr: key.addr}
+			byAddr[key.addr] = al
+		}
+		pl := PhaseLatency{
+			OneMinute:   digest.window(now, time.Minute),
+			FiveMinutes: digest.window(now, 5*time.Minute),
+		}
+		switch key.phase {
+		case phasePrepare:
+			al.Prepare = pl
+		case phaseAccept:
+			al.Accept = pl
+		}
+		return true
+	})
+
+	var report AcceptorLatencies
+	for _, al := range byAddr {
+		report.Acceptors = append(report.Acceptors, *al)
+	}
+	sort.Slice(report.Acceptors, func(i, j int) bool { return report.Acceptors[i].Addr < report.Acceptors[j].Addr })
+	if t.slowFactor > 0 {
+		report.Slow = append(report.Slow, slowAcceptors(report.Acceptors, phasePrepare, t.slowFactor)...)
+		report.Slow = append(report.Slow, slowAcceptors(report.Acceptors, phaseAccept, t.slowFactor)...)
+	}
+	return report
+}
+
+// slowAcceptors returns the acceptors whose five-minute p99 in the phase is
+// more than factor times the median. With an even number of acceptors, the
+// median is the lower of the middle two, so one slow acceptor out of two is
+// still reported.
+func slowAcceptors(acceptors []AcceptorLatency, phase string, factor float64) []SlowAcceptor {
+	window := func(al AcceptorLatency) LatencyWindow {
+		if phase == phasePrepare {
+			return al.Prepare.FiveMinutes
+		}
+		return al.Accept.FiveMinutes
+	}
+	var p99s []time.Duration
+	for _, al := range acceptors {
+		if w := window(al); w.Responses > 0 {
+			p99s = append(p99s, w.P99)
+		}
+	}
+	if len(p99s) < 2 {
+		return nil
+	}
+	sort.Slice(p99s, func(i, j int) bool { return p99s[i] < p99s[j] })
+	median := p99s[(len(p99s)-1)/2]
+
+	var slow []SlowAcceptor
+	for _, al := range acceptors {
+		w := window(al)
+		if w.Responses > 0 && float64(w.P99) > factor*float64(median) {
+			slow = append(slow, SlowAcceptor{Addr: al.Addr, Phase: phase, P99: w.P99, Median: median})
+		}
+	}
+	return slow
+}
+
+func (d *latencyDigest) observe(now time.Time, latency time.Duration) {
+	var (
+		period = now.Unix() / int64(digestPeriod/time.Second)
+		bucket = latencyBucket(latency)
+	)
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	b := &d.buckets[period%digestPeriods]
+	if b.period != period {
+		*b = digestBucket{period: period}
+	}
+	b.latencies[bucket]++
+}
+
+func (d *latencyDigest) window(now time.Time, width time.Duration) LatencyWindow {
+	var (
+		w         LatencyWindow
+		latencies [latencyBuckets]uint64
+		periods   = int64(width / digestPeriod)
+		newest    = now.Unix() / int64(digestPeriod/time.Second)
+	)
+	d.mtx.Lock()
+	for period := newest - periods + 1; period <= newest; period++ {
+		b := &d.buckets[(period%digestPeriods+digestPeriods)%digestPeriods]
+		if b.period != period {
+			continue
+		}
+		for i, n := range b.latencies {
+			latencies[i] += uint64(n)
+			w.Responses += uint64(n)
+		}
+	}
+	d.mtx.Unlock()
+
+	w.P50 = quantile(latencies[:], w.Responses, 0.50)
+	w.P90 = quantile(latencies[:], w.Responses, 0.90)
+	w.P99 = quantile(latencies[:], w.Responses, 0.99)
+	return w
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAcceptorLatencies(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = slowAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3)), accept: 20 * time.Millisecond}
+		p      = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithAcceptorLatency(3))
+		ctx    = context.Background()
+	)
+
+	if want, have := 0, len(p.AcceptorLatencies().Acceptors); want != have {
+		t.Errorf("before any proposal: want %d acceptors, have %d", want, have)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, _, err := p.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond) // for the slow acceptor's last accept
+
+	report := p.AcceptorLatencies()
+	if want, have := 3, len(report.Acceptors); want != have {
+		t.Fatalf("want %d acceptors, have %d", want, have)
+	}
+	for _, al := range report.Acceptors {
+		if want, have := uint64(5), al.Prepare.FiveMinutes.Responses; want != have {
+			t.Errorf("%s: prepare: want %d responses, have %d", al.Addr, want, have)
+		}
+		if want, have := uint64(5), al.Accept.OneMinute.Responses; want != have {
+			t.Errorf("%s: accept: want %d responses, have %d", al.Addr, want, have)
+		}
+	}
+	if want, have := "3", report.Acceptors[2].Addr; want != have {
+		t.Errorf("order: want %s last, have %s", want, have)
+	}
+	if p99 := report.Acceptors[2].Accept.FiveMinutes.P99; p99 < 15*time.Millisecond {
+		t.Errorf("slow acceptor: want p99 of about 20ms, have %s", p99)
+	}
+	if len(report.Slow) != 1 || report.Slow[0].Addr != "3" || report.Slow[0].Phase != phaseAccept {
+		t.Errorf("want acceptor 3 flagged slow in the accept phase, have %+v", report.Slow)
+	}
+}
+
+func TestAcceptorLatencyWindows(t *testing.T) {
+	var (
+		tracker = newAcceptorLatencyTracker(0)
+		now     = time.Now()
+	)
+	tracker.observe(now.Add(-10*time.Minute), "1", phasePrepare, time.Millisecond, nil)
+	tracker.observe(now.Add(-2*time.Minute), "1", phasePrepare, time.Millisecond, nil)
+	for i := 0; i < 99; i++ {
+		tracker.observe(now, "1", phasePrepare, time.Millisecond, nil)
+	}
+	tracker.observe(now, "1", phasePrepare, time.Second, nil)
+	tracker.observe(now, "1", phasePrepare, time.Millisecond, ErrPrepareFailed) // not a response
+
+	report := tracker.report(now)
+	if want, have := 1, len(report.Acceptors); want != have {
+		t.Fatalf("want %d acceptor, have %d", want, have)
+	}
+	pl := report.Acceptors[0].Prepare
+	if want, have := uint64(100), pl.OneMinute.Responses; want != have {
+		t.Errorf("1m: want %d responses, have %d", want, have)
+	}
+	if want, have := uint64(101), pl.FiveMinutes.Responses; want != have {
+		t.Errorf("5m: want %d responses, have %d", want, have)
+	}
+	if p50, p99 := pl.OneMinute.P50, pl.OneMinute.P99; p50 > 2*time.Millisecond || p99 > 2*time.Millisecond {
+		t.Errorf("1m: want p50 and p99 of about 1ms, have %s and %s", p50, p99)
+	}
+	if report.Slow != nil {
+		t.Errorf("slow factor of zero: want none flagged, have %+v", report.Slow)
+	}
+}
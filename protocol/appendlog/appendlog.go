@@ -0,0 +1,184 @@
+// Package appendlog uses keys as small append-only logs of records. Appends
+// succeed whatever the current value is, so they never conflict the way
+// compare-and-swaps do, and concurrent appends through a coalescing proposer
+// share a round. Each log is limited in size; when it's full, the writer
+// should rotate to a new key.
+package appendlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+var (
+	// ErrInvalidRecord indicates a record which can't be framed, i.e. one
+	// containing a newline, with newline framing.
+	ErrInvalidRecord = errors.New("record can't be framed")
+
+	// ErrInvalidOffset indicates a read from an offset past the end of the
+	// log, or not at the start of a record.
+	ErrInvalidOffset = errors.New("offset isn't at the start of a record")
+)
+
+// DefaultMaxSize is the size of a full log, unless Options say otherwise.
+const DefaultMaxSize = 1 << 20
+
+// Framing is how records are delimited in a log.
+type Framing int
+
+const (
+	// Newline terminates each record with a newline. It's readable as text,
+	// but records can't contain newlines.
+	Newline Framing = iota
+
+	// LengthPrefixed precedes each record with its length, as a uvarint. Any
+	// record can be framed.
+	LengthPrefixed
+)
+
+// Options for a log. Every writer and reader of a log must use the same
+// framing.
+type Options struct {
+	Framing Framing
+	MaxSize int // of the log's value, in bytes; zero means DefaultMaxSize
+}
+
+func (o Options) maxSize() int {
+	if o.MaxSize <= 0 {
+		return DefaultMaxSize
+	}
+	return o.MaxSize
+}
+
+// FullError is returned when an append would make a log bigger than its max
+// size. The record wasn't appended; the writer should rotate to a new key.
+type FullError struct {
+	Key     string
+	Size    int // of the log now
+	MaxSize int
+}
+
+func (fe FullError) Error() string {
+	return fmt.Sprintf("log %q is full (%d of %d bytes); rotate to a new key", fe.Key, fe.Size, fe.MaxSize)
+}
+
+// CorruptLogError is returned when a log's value can't be split into records.
+type CorruptLogError struct {
+	Key    string
+	Offset int
+}
+
+func (cle CorruptLogError) Error() string {
+	return fmt.Sprintf("log %q is corrupt at offset %d", cle.Key, cle.Offset)
+}
+
+// coalescer is implemented by proposers which can batch concurrent changes to
+// the same key, like protocol.MemoryProposer.
+type coalescer interface {
+	ProposeCoalesced(ctx context.Context, key string, f protocol.ChangeFunc) ([]byte, protocol.Ballot, error)
+}
+
+// Append adds record to the end of the log at key, creating it if necessary,
+// and returns the offset of the record, which a reader can pass to Read. If the
+// proposer can coalesce changes, it's used to do so. If the proposal fails, the
+// record may or may not have been appended.
+func Append(ctx context.Context, proposer protocol.Proposer, key string, record []byte, options Options) (offset int, err error) {
+	framed, err := frame(record, options.Framing)
+	if err != nil {
+		return 0, err
+	}
+
+	// The change function may be called more than once, if a round fails, so
+	// only the last call counts.
+	var changeErr error
+	appendRecord := func(current []byte) []byte {
+		changeErr = nil
+		if len(current)+len(framed) > options.maxSize() {
+			changeErr = FullError{Key: key, Size: len(current), MaxSize: options.maxSize()}
+			return current
+		}
+		return append(append(make([]byte, 0, len(current)+len(framed)), current...), framed...)
+	}
+
+	var state []byte
+	if c, ok := proposer.(coalescer); ok {
+		state, _, err = c.ProposeCoalesced(ctx, key, appendRecord)
+	} else {
+		state, _, err = proposer.Propose(ctx, key, appendRecord)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if changeErr != nil {
+		return 0, changeErr
+	}
+	return len(state) - len(framed), nil
+}
+
+// Read returns the records in the log at key from offset onward, and the
+// offset after them, from which to read next time. Offset zero is the start of
+// the log. A log that doesn't exist is empty.
+func Read(ctx context.Context, proposer protocol.Proposer, key string, offset int, options Options) (records [][]byte, next int, err error) {
+	identity := func(x []byte) []byte { return x }
+	state, _, err := proposer.Propose(ctx, key, identity)
+	if err != nil {
+		return nil, offset, err
+	}
+	if offset < 0 || offset > len(state) {
+		return nil, offset, ErrInvalidOffset
+	}
+	if offset > 0 && options.Framing == Newline && state[offset-1] != '\n' {
+		return nil, offset, ErrInvalidOffset
+	}
+	records, err = split(key, state, offset, options.Framing)
+	if err != nil {
+		return nil, offset, err
+	}
+	return records, len(state), nil
+}
+
+func frame(record []byte, framing Framing) ([]byte, error) {
+	switch framing {
+	case Newline:
+		if bytes.IndexByte(record, '\n') >= 0 {
+			return nil, ErrInvalidRecord
+		}
+		return append(append(make([]byte, 0, len(record)+1), record...), '\n'), nil
+	case LengthPrefixed:
+		prefix := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(prefix, uint64(len(record)))
+		return append(prefix[:n], record...), nil
+	default:
+		return nil, ErrInvalidRecord
+	}
+}
+
+func split(key string, state []byte, offset int, framing Framing) ([][]byte, error) {
+	var records [][]byte
+	for i := offset; i < len(state); {
+		switch framing {
+		case Newline:
+			n := bytes.IndexByte(state[i:], '\n')
+			if n < 0 {
+				return nil, CorruptLogError{Key: key, Offset: i}
+			}
+			records = append(records, state[i:i+n])
+			i += n + 1
+		case LengthPrefixed:
+			size, n := binary.Uvarint(state[i:])
+			if n <= 0 || size > uint64(len(state)-i-n) {
+				return nil, CorruptLogError{Key: key, Offset: i}
+			}
+			records = append(records, state[i+n:i+n+int(size)])
+			i += n + int(size)
+		default:
+			return nil, ErrInvalidRecord
+		}
+	}
+	return records, nil
+}
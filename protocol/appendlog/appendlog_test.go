@@ -0,0 +1,141 @@
+package appendlog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+func TestAppend(t *testing.T) {
+	for _, framing := range []Framing{Newline, LengthPrefixed} {
+		t.Run(fmt.Sprint(framing), func(t *testing.T) {
+			var (
+				a1      = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+				p1      = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1)
+				ctx     = context.Background()
+				options = Options{Framing: framing, MaxSize: 16}
+			)
+
+			var offsets []int
+			for _, record := range []string{"a", "", "bcd"} {
+				offset, err := Append(ctx, p1, "log", []byte(record), options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				offsets = append(offsets, offset)
+			}
+			if want, have := []int{0, 2, 3}, offsets; !reflect.DeepEqual(want, have) {
+				t.Errorf("offsets: want %v, have %v", want, have)
+			}
+
+			// Tail from the start, and from the middle.
+			records, next, err := Read(ctx, p1, "log", 0, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := []string{"a", "", "bcd"}, strs(records); !reflect.DeepEqual(want, have) {
+				t.Errorf("from 0: want %q, have %q", want, have)
+			}
+			if records, _, err = Read(ctx, p1, "log", offsets[2], options); err != nil || !reflect.DeepEqual([]string{"bcd"}, strs(records)) {
+				t.Errorf("from %d: %q, %v", offsets[2], strs(records), err)
+			}
+			if records, _, err = Read(ctx, p1, "log", next, options); err != nil || len(records) != 0 {
+				t.Errorf("from the end: %q, %v", strs(records), err)
+			}
+			if _, _, err = Read(ctx, p1, "log", next+1, options); err != ErrInvalidOffset {
+				t.Errorf("past the end: want %v, have %v", ErrInvalidOffset, err)
+			}
+
+			// A full log refuses the append, and says so.
+			_, err = Append(ctx, p1, "log", []byte("0123456789"), options)
+			if want, have := (FullError{Key: "log", Size: next, MaxSize: 16}), err; want != have {
+				t.Errorf("full: want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
+func TestAppendInvalid(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1)
+		ctx = context.Background()
+	)
+	if _, err := Append(ctx, p1, "log", []byte("a\nb"), Options{}); err != ErrInvalidRecord {
+		t.Errorf("newline: want %v, have %v", ErrInvalidRecord, err)
+	}
+	if _, err := Append(ctx, p1, "log", []byte("abc"), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Read(ctx, p1, "log", 1, Options{}); err != ErrInvalidOffset {
+		t.Errorf("mid-record: want %v, have %v", ErrInvalidOffset, err)
+	}
+	if _, _, err := p1.Propose(ctx, "junk", func([]byte) []byte { return []byte{0x05, 'a'} }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Read(ctx, p1, "junk", 0, Options{Framing: LengthPrefixed}); err != (CorruptLogError{Key: "junk"}) {
+		t.Errorf("corrupt: want CorruptLogError, have %v", err)
+	}
+}
+
+func TestAppendCoalesced(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		a2  = protocol.NewMemoryAcceptor("2", log.NewNopLogger())
+		a3  = protocol.NewMemoryAcceptor("3", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposerWithOptions("1", log.NewNopLogger(), []protocol.Acceptor{a1, a2, a3}, protocol.WithCoalescing(time.Millisecond))
+		ctx = context.Background()
+		n   = 50
+	)
+
+	var (
+		wg      sync.WaitGroup
+		mtx     sync.Mutex
+		offsets = map[int]bool{}
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			offset, err := Append(ctx, p1, "log", []byte(fmt.Sprintf("%02d", i)), Options{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mtx.Lock()
+			offsets[offset] = true
+			mtx.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	if want, have := n, len(offsets); want != have {
+		t.Errorf("distinct offsets: want %d, have %d", want, have)
+	}
+	records, _, err := Read(ctx, p1, "log", 0, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := strs(records)
+	sort.Strings(have)
+	for i := 0; i < n; i++ {
+		if want := fmt.Sprintf("%02d", i); i >= len(have) || have[i] != want {
+			t.Fatalf("records: want %s at %d, have %q", want, i, have)
+		}
+	}
+}
+
+func strs(records [][]byte) []string {
+	res := make([]string, len(records))
+	for i, r := range records {
+		res[i] = string(r)
+	}
+	return res
+}
@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConflictingBallot returns the greatest ballot that beat ours, from a failed
+// proposal. Acceptors report it in every ConflictError, from either phase, and
+// a QuorumError carries the errors from each acceptor that failed. The ballot's
+// ID is the proposer we raced against.
+func ConflictingBallot(err error) (b Ballot, ok bool) {
+	var ce ConflictError
+	if errors.As(err, &ce) {
+		return ce.Existing, true
+	}
+	var qe QuorumError
+	if !errors.As(err, &qe) {
+		return b, false
+	}
+	for _, failure := range qe.Failures {
+		if errors.As(failure, &ce) && (!ok || ce.Existing.greaterThan(b)) {
+			b, ok = ce.Existing, true
+		}
+	}
+	return b, ok
+}
+
+// Backoff computes how long a proposer should wait before retrying a proposal
+// that lost a ballot race. Against an unknown competitor, the delay is random,
+// as usual. But when the failure reveals the competing proposer, the two agree
+// on their order without talking to each other: the proposer with the smaller
+// ID retries in the first half of the backoff window, and the other in the
+// second half, so they don't keep colliding in lockstep. Within its half, each
+// waits a fixed fraction, derived from the pair of IDs, but at least half of
+// it, so the competing round has time to finish.
+type Backoff struct {
+	id   string
+	base time.Duration
+	max  time.Duration
+
+	mtx       sync.Mutex
+	conflicts map[string]uint64 // by competing proposer ID
+	rand      *rand.Rand
+}
+
+// NewBackoff returns a backoff for the proposer with the given ID. The window
+// starts at base, doubles with every attempt, and is at most max.
+func NewBackoff(id string, base, max time.Duration) *Backoff {
+	return &Backoff{
+		id:        id,
+		base:      base,
+		max:       max,
+		conflicts: map[string]uint64{},
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Delay returns how long to wait before the given attempt, counting from 1,
+// after a proposal failed with err. Conflicts are counted by competitor.
+func (bo *Backoff) Delay(attempt int, err error) time.Duration {
+	window := bo.window(attempt)
+	b, ok := ConflictingBallot(err)
+
+	bo.mtx.Lock()
+	defer bo.mtx.Unlock()
+	if !ok || b.ID == bo.id || b.ID == "" {
+		return time.Duration(bo.rand.Int63n(int64(window) + 1))
+	}
+	bo.conflicts[b.ID]++
+
+	half := window / 2
+	offset := time.Duration((1 + pairFraction(bo.id, b.ID)) / 2 * float64(half))
+	if bo.id < b.ID {
+		return offset
+	}
+	return half + offset
+}
+
+// Conflicts returns the number of conflicts with each competing proposer seen
+// by Delay.
+func (bo *Backoff) Conflicts() map[string]uint64 {
+	bo.mtx.Lock()
+	defer bo.mtx.Unlock()
+	res := make(map[string]uint64, len(bo.conflicts))
+	for id, n := range bo.conflicts {
+		res[id] = n
+	}
+	return res
+}
+
+func (bo *Backoff) window(attempt int) time.Duration {
+	window := bo.base
+	for i := 1; i < attempt && window < bo.max; i++ {
+		window *= 2
+	}
+	if window > bo.max {
+		window = bo.max
+	}
+	return window
+}
+
+// pairFraction returns a number in [0, 1) derived from the two IDs, which is
+// the same for both of them, whichever asks.
+func pairFraction(a, b string) float64 {
+	if b < a {
+		a, b = b, a
+	}
+	h := fnv.New64a()
+	h.Write([]byte(a))
+	h.Write([]byte{0})
+	h.Write([]byte(b))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
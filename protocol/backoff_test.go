@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestConflictingBallot(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithTracing(1, func(*RoundTrace) {}))
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// Prepare phase: p2 is ahead, so p1's first ballot is rejected, and
+	// without the retry, it would fail.
+	for i := 0; i < 3; i++ {
+		if _, _, err := p2.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p1.mtx.Lock()
+	defer p1.mtx.Unlock()
+	_, _, _, err := p1.propose(ctx, "k", changeFuncRead, keepMeta, regularQuorum, roundOptions{})
+	if b, ok := ConflictingBallot(err); !ok || b.ID != "2" {
+		t.Errorf("prepare: want a ballot from 2, have %v (%v), from %v", b, ok, err)
+	}
+
+	// Accept phase: p2 prepares a higher ballot between p1's phases.
+	_, _, _, err = p1.propose(ctx, "k", func(x []byte) []byte {
+		p2.Propose(ctx, "k", changeFuncRead)
+		return x
+	}, keepMeta, regularQuorum, roundOptions{})
+	if b, ok := ConflictingBallot(err); !ok || b.ID != "2" {
+		t.Errorf("accept: want a ballot from 2, have %v (%v), from %v", b, ok, err)
+	}
+
+	if _, ok := ConflictingBallot(ErrNotFound); ok {
+		t.Errorf("other error: want no ballot")
+	}
+}
+
+func TestBackoffPriority(t *testing.T) {
+	var (
+		b1       = NewBackoff("1", time.Millisecond, time.Second)
+		b2       = NewBackoff("2", time.Millisecond, time.Second)
+		from1    = QuorumError{Err: ErrPrepareFailed, Failures: map[string]error{"a": ConflictError{Existing: Ballot{Counter: 9, ID: "1"}}}}
+		from2    = QuorumError{Err: ErrPrepareFailed, Failures: map[string]error{"a": ConflictError{Existing: Ballot{Counter: 9, ID: "2"}}}}
+		inFlight = func(attempt int) time.Duration { return NewBackoff("x", time.Millisecond, time.Second).window(attempt) }
+	)
+	for attempt := 1; attempt <= 12; attempt++ {
+		d1, d2 := b1.Delay(attempt, from2), b2.Delay(attempt, from1)
+		if d1 >= d2 {
+			t.Errorf("attempt %d: want 1 to retry before 2, have %s and %s", attempt, d1, d2)
+		}
+		if window := inFlight(attempt); d2 > window {
+			t.Errorf("attempt %d: delay %s is beyond the window %s", attempt, d2, window)
+		}
+		if again := b1.Delay(attempt, from2); again != d1 {
+			t.Errorf("attempt %d: want a deterministic delay %s, have %s", attempt, d1, again)
+		}
+	}
+	if want, have := uint64(24), b1.Conflicts()["2"]; want != have {
+		t.Errorf("conflicts: want %d, have %d", want, have)
+	}
+	if d := b1.Delay(1, ErrNotFound); d > time.Millisecond {
+		t.Errorf("unknown competitor: want at most %s, have %s", time.Millisecond, d)
+	}
+}
+
+func BenchmarkBackoffContention(b *testing.B) {
+	for _, testcase := range []struct {
+		name    string
+		backoff func(id string) func(int, error) time.Duration
+	}{
+		{"uniform", func(id string) func(int, error) time.Duration {
+			window := NewBackoff(id, 2*time.Millisecond, 64*time.Millisecond).window
+			var mtx sync.Mutex
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			return func(attempt int, _ error) time.Duration {
+				mtx.Lock()
+				defer mtx.Unlock()
+				return time.Duration(r.Int63n(int64(window(attempt)) + 1))
+			}
+		}},
+		{"adaptive", func(id string) func(int, error) time.Duration {
+			return NewBackoff(id, 2*time.Millisecond, 64*time.Millisecond).Delay
+		}},
+	} {
+		b.Run(testcase.name, func(b *testing.B) {
+			conflicts := contend(b.N, testcase.backoff)
+			b.ReportMetric(float64(conflicts)/float64(b.N), "conflicts/op")
+		})
+	}
+}
+
+// contend runs two writers which write to the same key at the same moment, n
+// times, like periodic jobs on the same schedule, retrying conflicts after the
+// delay returned by the backoff, and returns the number of conflicts.
+func contend(n int, backoff func(id string) func(int, error) time.Duration) uint64 {
+	var (
+		logger    = log.NewNopLogger()
+		latency   = 100 * time.Microsecond
+		a1        = slowAcceptor{NewMemoryAcceptor("1", logger), latency, latency}
+		a2        = slowAcceptor{NewMemoryAcceptor("2", logger), latency, latency}
+		a3        = slowAcceptor{NewMemoryAcceptor("3", logger), latency, latency}
+		acceptors = []Acceptor{a1, a2, a3}
+		ctx       = context.Background()
+		p1        = NewMemoryProposer("1", logger, acceptors...)
+		p2        = NewMemoryProposer("2", logger, acceptors...)
+		delay1    = backoff("1")
+		delay2    = backoff("2")
+		conflicts uint64
+	)
+	write := func(p *MemoryProposer, delay func(int, error) time.Duration, start <-chan struct{}, wg *sync.WaitGroup) {
+		defer wg.Done()
+		<-start
+		for attempt := 1; ; attempt++ {
+			if _, _, err := p.Propose(ctx, "k", changeFuncRead); err == nil {
+				return
+			} else {
+				atomic.AddUint64(&conflicts, 1)
+				time.Sleep(delay(attempt, err))
+			}
+		}
+	}
+	for i := 0; i < n; i++ {
+		var (
+			start = make(chan struct{})
+			wg    sync.WaitGroup
+		)
+		wg.Add(2)
+		go write(p1, delay1, start, &wg)
+		go write(p2, delay2, start, &wg)
+		close(start)
+		wg.Wait()
+	}
+	return conflicts
+}
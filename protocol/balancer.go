@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoProposers indicates a Balancer without any proposers.
+var ErrNoProposers = errors.New("no proposers")
+
+// BalancerStats count the proposals made through a Balancer.
+type BalancerStats struct {
+	Preferred uint64 // proposals made by the key's preferred proposer
+	Spilled   uint64 // proposals made elsewhere, as the preferred was full or down
+	Fallback  uint64 // proposals retried elsewhere, after a refused connection
+}
+
+// Balancer sends each proposal to one of several proposers fronting the same
+// acceptors, like Router, but without a routing table: proposers are ranked
+// for each key by rendezvous hashing, and the first is preferred, so writes
+// to a key mostly go through the same proposer, and don't conflict. Adding or
+// removing a proposer only moves the keys which it gains or loses.
+//
+// The load is bounded: a proposer with more than loadFactor times its share
+// of the proposals in flight is passed over for the next in the ranking, so a
+// hot key can't pile every proposal onto one proposer. A proposer which
+// refuses the connection is retried with the next, and passed over for
+// cooldown. Other errors are returned as they are, for the reason given by
+// Router.
+type Balancer struct {
+	loadFactor float64
+	cooldown   time.Duration
+
+	mtx       sync.Mutex
+	proposers map[string]Proposer
+	inflight  map[string]int
+	downUntil map[string]time.Time
+	stats     BalancerStats
+	now       func() time.Time
+}
+
+// NewBalancer returns a balancer for the proposers, by address. A loadFactor
+// below 1 is taken as 1.25.
+func NewBalancer(proposers map[string]Proposer, loadFactor float64, cooldown time.Duration) *Balancer {
+	if loadFactor < 1 {
+		loadFactor = 1.25
+	}
+	b := &Balancer{
+		loadFactor: loadFactor,
+		cooldown:   cooldown,
+		inflight:   map[string]int{},
+		downUntil:  map[string]time.Time{},
+		now:        time.Now,
+	}
+	b.SetProposers(proposers)
+	return b
+}
+
+// SetProposers replaces the proposers, by address.
+func (b *Balancer) SetProposers(proposers map[string]Proposer) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.proposers = make(map[string]Proposer, len(proposers))
+	for addr, p := range proposers {
+		b.proposers[addr] = p
+	}
+}
+
+// Preferred returns the address of the proposer key is sent to, when the load
+// is even, and every proposer is up.
+func (b *Balancer) Preferred(key string) (addr string, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	ranking := b.rank(key)
+	if len(ranking) == 0 {
+		return "", false
+	}
+	return ranking[0], true
+}
+
+// Propose sends the proposal to a proposer chosen for key.
+func (b *Balancer) Propose(ctx context.Context, key string, f ChangeFunc) (state []byte, ballot Ballot, err error) {
+	tried := map[string]bool{}
+	for {
+		addr, p, preferred, ok := b.acquire(key, tried)
+		if !ok {
+			if err == nil {
+				err = ErrNoProposers
+			}
+			return nil, ballot, err
+		}
+		state, ballot, err = p.Propose(ctx, key, f)
+		b.release(addr)
+		if err == nil || ClassifyFailure(err) != FailureRefused {
+			b.count(func(s *BalancerStats) {
+				switch {
+				case len(tried) > 0:
+					s.Fallback++
+				case preferred:
+					s.Preferred++
+				default:
+					s.Spilled++
+				}
+			})
+			return state, ballot, err
+		}
+		b.markDown(addr)
+		tried[addr] = true
+	}
+}
+
+// Stats returns the counts since the balancer was created.
+func (b *Balancer) Stats() BalancerStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.stats
+}
+
+// acquire chooses a proposer for key, other than those tried, and counts the
+// proposal against its load.
+func (b *Balancer) acquire(key string, tried map[string]bool) (addr string, p Proposer, preferred, ok bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var (
+		now        = b.now()
+		ranking    = b.rank(key)
+		candidates []string
+		total      int
+	)
+	for _, addr := range ranking {
+		if !tried[addr] && !now.Before(b.downUntil[addr]) {
+			candidates = append(candidates, addr)
+			total += b.inflight[addr]
+		}
+	}
+	if len(candidates) == 0 {
+		// Every proposer is down, so give the untried ones another chance.
+		for _, addr := range ranking {
+			if !tried[addr] {
+				candidates = append(candidates, addr)
+				total += b.inflight[addr]
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, false, false
+	}
+
+	// With loadFactor at least 1, some candidate is always below capacity.
+	var (
+		capacity = int(math.Ceil(b.loadFactor * float64(total+1) / float64(len(candidates))))
+		chosen   = candidates[0]
+	)
+	for _, addr := range candidates {
+		if b.inflight[addr] < capacity {
+			chosen = addr
+			break
+		}
+	}
+	b.inflight[chosen]++
+	return chosen, b.proposers[chosen], chosen == ranking[0], true
+}
+
+func (b *Balancer) release(addr string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.inflight[addr]--; b.inflight[addr] <= 0 {
+		delete(b.inflight, addr)
+	}
+}
+
+func (b *Balancer) count(f func(*BalancerStats)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	f(&b.stats)
+}
+
+func (b *Balancer) markDown(addr string) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.downUntil[addr] = b.now().Add(b.cooldown)
+}
+
+// rank orders the proposers for key, most preferred first, by rendezvous
+// hashing. It must be called with the mutex held.
+func (b *Balancer) rank(key string) []string {
+	var (
+		addrs  = make([]string, 0, len(b.proposers))
+		scores = make(map[string]uint64, len(b.proposers))
+	)
+	for addr := range b.proposers {
+		h := fnv.New64a()
+		h.Write([]byte(addr))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		addrs, scores[addr] = append(addrs, addr), mix64(h.Sum64())
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if scores[addrs[i]] != scores[addrs[j]] {
+			return scores[addrs[i]] > scores[addrs[j]]
+		}
+		return addrs[i] < addrs[j]
+	})
+	return addrs
+}
+
+// mix64 is the finalizer of MurmurHash3. FNV alone differs too little between
+// inputs which only differ in a few bytes, e.g. proposer addresses, to rank by.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBalancerAffinity(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		ctx    = context.Background()
+		keys   = []string{"a", "b", "c", "d"}
+	)
+	newProposers := func() (*MemoryProposer, *MemoryProposer) {
+		acceptors := []Acceptor{
+			NewMemoryAcceptor("1", log.With(logger, "a", 1)),
+			NewMemoryAcceptor("2", log.With(logger, "a", 2)),
+			NewMemoryAcceptor("3", log.With(logger, "a", 3)),
+		}
+		return NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), acceptors, WithKeyStats(len(keys))),
+			NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), acceptors, WithKeyStats(len(keys)))
+	}
+	conflicts := func(ps ...*MemoryProposer) (n uint64) {
+		for _, p := range ps {
+			for _, s := range p.KeyStats(len(keys), ByConflicts) {
+				n += s.Conflicts
+			}
+		}
+		return n
+	}
+
+	// Two clients write every key concurrently, through two proposers. Only
+	// proposals which conflict may fail.
+	type proposer interface {
+		Propose(ctx context.Context, key string, f ChangeFunc) ([]byte, Ballot, error)
+	}
+	run := func(mayFail bool, choose func(client, i int) proposer) {
+		var wg sync.WaitGroup
+		for client := 0; client < 2; client++ {
+			wg.Add(1)
+			go func(client int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if _, _, err := choose(client, i).Propose(ctx, keys[i%len(keys)], changeFuncRead); err != nil && !mayFail {
+						t.Error(err)
+					}
+				}
+			}(client)
+		}
+		wg.Wait()
+	}
+
+	p1, p2 := newProposers()
+	run(true, func(client, i int) proposer { return [...]proposer{p1, p2}[(client+i)%2] })
+	roundRobin := conflicts(p1, p2)
+
+	p1, p2 = newProposers()
+	proposers := map[string]Proposer{"p1": p1, "p2": p2}
+	clients := []*Balancer{NewBalancer(proposers, 0, time.Second), NewBalancer(proposers, 0, time.Second)}
+	run(false, func(client, i int) proposer { return clients[client] })
+	affinity := conflicts(p1, p2)
+
+	t.Logf("conflicts: round-robin %d, affinity %d", roundRobin, affinity)
+	if affinity != 0 {
+		t.Errorf("affinity: want no conflicts, have %d", affinity)
+	}
+	for _, c := range clients {
+		if s := c.Stats(); s.Preferred == 0 || s.Fallback != 0 {
+			t.Errorf("want preferred proposers, have %+v", s)
+		}
+	}
+}
+
+func TestBalancerRanking(t *testing.T) {
+	var (
+		proposers = map[string]Proposer{"p1": nil, "p2": nil, "p3": nil}
+		b         = NewBalancer(proposers, 0, time.Second)
+		before    = map[string]string{}
+		moved     int
+	)
+	for i := 0; i < 300; i++ {
+		key := strconv.Itoa(i)
+		before[key], _ = b.Preferred(key)
+	}
+
+	// Removing a proposer only moves its own keys.
+	delete(proposers, "p3")
+	b.SetProposers(proposers)
+	for key, addr := range before {
+		after, _ := b.Preferred(key)
+		if addr != "p3" && after != addr {
+			t.Errorf("%s: moved from %s to %s", key, addr, after)
+		}
+		if addr == "p3" {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(before) {
+		t.Errorf("want some keys on p3, have %d of %d", moved, len(before))
+	}
+}
+
+func TestBalancerFallback(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1)
+		ctx    = context.Background()
+		b      = NewBalancer(map[string]Proposer{"up": p1, "down": refusingProposer{p1}}, 0, time.Minute)
+	)
+
+	// Find a key which prefers the proposer that's down.
+	var key string
+	for i := 0; key == ""; i++ {
+		if addr, _ := b.Preferred(strconv.Itoa(i)); addr == "down" {
+			key = strconv.Itoa(i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := b.Propose(ctx, key, changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second proposal skips the proposer that's down.
+	if want, have := (BalancerStats{Spilled: 1, Fallback: 1}), b.Stats(); want != have {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+
+	if _, _, err := NewBalancer(nil, 0, 0).Propose(ctx, key, changeFuncRead); err != ErrNoProposers {
+		t.Errorf("empty: want %v, have %v", ErrNoProposers, err)
+	}
+}
@@ -8,9 +8,15 @@ import "fmt"
 // From the paper: "It's convenient to use tuples as ballot numbers. To generate
 // it a proposer combines its numerical ID with a local increasing counter:
 // (counter, ID)."
+//
+// Generation distinguishes the runs of a proposer that restarts with the same
+// ID, but without its counter, so it never reuses a ballot from an earlier
+// run; see WithGenerationFile. Proposers that don't set it use generation
+// zero, and their ballots compare and encode as they always have.
 type Ballot struct {
-	Counter uint64
-	ID      string
+	Counter    uint64
+	Generation uint64 `json:",omitempty"`
+	ID         string
 }
 
 func (b *Ballot) inc() Ballot {
@@ -19,21 +25,28 @@ func (b *Ballot) inc() Ballot {
 }
 
 func (b *Ballot) isZero() bool {
-	return b.Counter == 0 && b.ID == ""
+	return b.Counter == 0 && b.Generation == 0 && b.ID == ""
 }
 
 // From the paper: "To compare ballot tuples, we should compare the first
-// component of the tuples and use ID only as a tiebreaker."
+// component of the tuples and use ID only as a tiebreaker." The generation
+// comes between them.
 func (b *Ballot) greaterThan(other Ballot) bool {
-	if b.Counter == other.Counter {
-		return b.ID > other.ID
+	if b.Counter != other.Counter {
+		return b.Counter > other.Counter
+	}
+	if b.Generation != other.Generation {
+		return b.Generation > other.Generation
 	}
-	return b.Counter > other.Counter
+	return b.ID > other.ID
 }
 
 func (b Ballot) String() string {
 	if b.isZero() {
 		return "ø"
 	}
+	if b.Generation > 0 {
+		return fmt.Sprintf("%d/%d/%s", b.Counter, b.Generation, b.ID)
+	}
 	return fmt.Sprintf("%d/%s", b.Counter, b.ID)
 }
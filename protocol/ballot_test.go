@@ -1,7 +1,11 @@
 package protocol
 
 import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
 )
 
 func TestZeroBallotAlwaysLoses(t *testing.T) {
@@ -35,3 +39,63 @@ func TestBallotIncrement(t *testing.T) {
 		t.Fatalf("persistent ballot number: want %d, have %d", want, have)
 	}
 }
+
+// smallBallot generates ballots from small ranges, so that ties in each
+// component are common.
+type smallBallot Ballot
+
+func (smallBallot) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(smallBallot{
+		Counter:    uint64(r.Intn(3)),
+		Generation: uint64(r.Intn(3)),
+		ID:         []string{"", "a", "b"}[r.Intn(3)],
+	})
+}
+
+func TestBallotOrder(t *testing.T) {
+	for name, property := range map[string]interface{}{
+		"irreflexive": func(a smallBallot) bool {
+			x := Ballot(a)
+			return !x.greaterThan(x)
+		},
+		"total": func(a, b smallBallot) bool {
+			x, y := Ballot(a), Ballot(b)
+			return x == y || x.greaterThan(y) != y.greaterThan(x)
+		},
+		"transitive": func(a, b, c smallBallot) bool {
+			x, y, z := Ballot(a), Ballot(b), Ballot(c)
+			return !(x.greaterThan(y) && y.greaterThan(z)) || x.greaterThan(z)
+		},
+		"counter first": func(a, b smallBallot) bool {
+			x, y := Ballot(a), Ballot(b)
+			return x.Counter == y.Counter || x.greaterThan(y) == (x.Counter > y.Counter)
+		},
+	} {
+		if err := quick.Check(property, &quick.Config{MaxCount: 1000}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBallotEncoding(t *testing.T) {
+	roundTrip := func(b Ballot) bool {
+		buf, err := json.Marshal(b)
+		if err != nil {
+			return false
+		}
+		var have Ballot
+		return json.Unmarshal(buf, &have) == nil && have == b
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Ballots without a generation encode as they always have.
+	buf, err := json.Marshal(Ballot{Counter: 3, ID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `{"Counter":3,"ID":"a"}`, string(buf); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+}
@@ -14,7 +14,7 @@ import (
 func TestReadUnwrittenKey(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -42,7 +42,7 @@ func TestReadUnwrittenKey(t *testing.T) {
 func TestInitializeOnlyOnce(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -106,7 +106,7 @@ func TestInitializeOnlyOnce(t *testing.T) {
 func TestFastForward(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -137,7 +137,7 @@ func TestFastForward(t *testing.T) {
 func TestMultiKeyReads(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -169,7 +169,7 @@ func TestMultiKeyReads(t *testing.T) {
 func TestConcurrentCASWrites(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -288,9 +288,31 @@ func (pp prettyPrint) String() string {
 	return string(pp)
 }
 
-type testWriter struct{ t *testing.T }
+// testWriter forwards log lines to the test. Proposers return as soon as they
+// reach quorum, so stragglers can still be logging after the test has
+// completed, which the testing package doesn't allow. Those log lines aren't
+// interesting, so they're dropped.
+type testWriter struct {
+	mtx  sync.Mutex
+	t    *testing.T
+	done bool
+}
+
+func newTestWriter(t *testing.T) *testWriter {
+	tw := &testWriter{t: t}
+	t.Cleanup(func() {
+		tw.mtx.Lock()
+		defer tw.mtx.Unlock()
+		tw.done = true
+	})
+	return tw
+}
 
-func (tw testWriter) Write(p []byte) (int, error) {
-	tw.t.Logf("%s", string(p))
+func (tw *testWriter) Write(p []byte) (int, error) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if !tw.done {
+		tw.t.Logf("%s", string(p))
+	}
 	return len(p), nil
 }
@@ -0,0 +1,129 @@
+// Package change provides common change functions for proposals, and ways to
+// combine them.
+//
+// Every function here is a protocol.ReportingChangeFunc, which reports whether
+// its change applied: a compare-and-swap whose comparison failed, for example,
+// returns the current state unchanged, and reports false. Use Plain to pass
+// one to a method that takes a protocol.ChangeFunc.
+package change
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+// Identity leaves the state as it is. It's a read.
+func Identity() protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		return current, false
+	}
+}
+
+// Put replaces the state with value, unconditionally.
+func Put(value []byte) protocol.ReportingChangeFunc {
+	return func([]byte) ([]byte, bool) {
+		return value, true
+	}
+}
+
+// Delete removes the value, unconditionally.
+func Delete() protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		return nil, current != nil
+	}
+}
+
+// CompareAndSwap replaces the state with next, if it's equal to expected. A nil
+// expected or next means an absent value, which isn't equal to an empty one, so
+// CompareAndSwap(nil, v) creates a value, and CompareAndSwap(v, nil) deletes
+// one.
+func CompareAndSwap(expected, next []byte) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		if (current == nil) != (expected == nil) || !bytes.Equal(current, expected) {
+			return current, false
+		}
+		return next, true
+	}
+}
+
+// PutIfAbsent replaces the state with value, if the key is absent. A present,
+// empty value is left alone.
+func PutIfAbsent(value []byte) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		if current != nil {
+			return current, false
+		}
+		return value, true
+	}
+}
+
+// SetJSONField treats the state as a JSON object, and sets field to value.
+// An empty state is treated as an empty object. If the state isn't a JSON
+// object, or value can't be marshaled, the state is left as it is.
+func SetJSONField(field string, value interface{}) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		obj := map[string]json.RawMessage{}
+		if len(current) > 0 {
+			if err := json.Unmarshal(current, &obj); err != nil || obj == nil {
+				return current, false
+			}
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return current, false
+		}
+		obj[field] = raw
+		next, err := json.Marshal(obj)
+		if err != nil {
+			return current, false
+		}
+		return next, true
+	}
+}
+
+// AppendJSON treats the state as a JSON array, and appends value to it. An
+// empty state is treated as an empty array. If the state isn't a JSON array,
+// or value can't be marshaled, the state is left as it is.
+func AppendJSON(value interface{}) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		var list []json.RawMessage
+		if len(current) > 0 {
+			if err := json.Unmarshal(current, &list); err != nil || list == nil {
+				return current, false
+			}
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return current, false
+		}
+		next, err := json.Marshal(append(list, raw))
+		if err != nil {
+			return current, false
+		}
+		return next, true
+	}
+}
+
+// Compose applies fs in order, each to the state produced by the last. It
+// reports a change if any of them did.
+func Compose(fs ...protocol.ReportingChangeFunc) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		var changed bool
+		for _, f := range fs {
+			var c bool
+			current, c = f(current)
+			changed = changed || c
+		}
+		return current, changed
+	}
+}
+
+// Plain adapts f to a protocol.ChangeFunc, discarding what it reports.
+func Plain(f protocol.ReportingChangeFunc) protocol.ChangeFunc {
+	return func(current []byte) []byte {
+		next, _ := f(current)
+		return next
+	}
+}
@@ -0,0 +1,120 @@
+package change
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+func TestChangeFuncs(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		f       protocol.ReportingChangeFunc
+		current string
+		want    string
+		changed bool
+	}{
+		{"identity", Identity(), "a", "a", false},
+		{"put", Put([]byte("b")), "a", "b", true},
+		{"put same", Put([]byte("a")), "a", "a", true},
+		{"cas match", CompareAndSwap([]byte("a"), []byte("b")), "a", "b", true},
+		{"cas mismatch", CompareAndSwap([]byte("x"), []byte("b")), "a", "a", false},
+		{"cas match same", CompareAndSwap([]byte("a"), []byte("a")), "a", "a", true},
+		{"put if absent, absent", PutIfAbsent([]byte("b")), "", "b", true},
+		{"put if absent, present", PutIfAbsent([]byte("b")), "a", "a", false},
+		{"set field, empty", SetJSONField("x", 1), "", `{"x":1}`, true},
+		{"set field, existing", SetJSONField("x", "y"), `{"w":true,"x":1}`, `{"w":true,"x":"y"}`, true},
+		{"set field, not an object", SetJSONField("x", 1), `[1]`, `[1]`, false},
+		{"set field, null", SetJSONField("x", 1), `null`, `null`, false},
+		{"append, empty", AppendJSON("a"), "", `["a"]`, true},
+		{"append, existing", AppendJSON(2), `[1]`, `[1,2]`, true},
+		{"append, not an array", AppendJSON(2), `{}`, `{}`, false},
+		{"compose", Compose(CompareAndSwap([]byte("x"), []byte("b")), PutIfAbsent([]byte("c"))), "", "c", true},
+		{"compose, none", Compose(CompareAndSwap([]byte("x"), []byte("b")), Identity()), "a", "a", false},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var current []byte
+			if testcase.current != "" {
+				current = []byte(testcase.current)
+			}
+			next, changed := testcase.f(current)
+			if want, have := testcase.want, string(next); want != have {
+				t.Errorf("state: want %q, have %q", want, have)
+			}
+			if want, have := testcase.changed, changed; want != have {
+				t.Errorf("changed: want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
+func TestProposeReporting(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		a2  = protocol.NewMemoryAcceptor("2", log.NewNopLogger())
+		a3  = protocol.NewMemoryAcceptor("3", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1, a2, a3)
+		ctx = context.Background()
+	)
+
+	// A swap to the same value is distinguishable from a failed swap.
+	if _, _, err := p1.Propose(ctx, "k", Plain(Put([]byte("a")))); err != nil {
+		t.Fatal(err)
+	}
+	for _, testcase := range []struct {
+		expected string
+		changed  bool
+	}{
+		{"a", true},
+		{"b", false},
+	} {
+		state, changed, _, err := p1.ProposeReporting(ctx, "k", CompareAndSwap([]byte(testcase.expected), []byte("a")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "a", string(state); want != have {
+			t.Errorf("CAS from %q: want state %q, have %q", testcase.expected, want, have)
+		}
+		if want, have := testcase.changed, changed; want != have {
+			t.Errorf("CAS from %q: want changed %v, have %v", testcase.expected, want, have)
+		}
+	}
+}
+
+func TestAbsentVersusEmpty(t *testing.T) {
+	var (
+		absent []byte
+		empty  = []byte{}
+	)
+	for _, testcase := range []struct {
+		name    string
+		f       protocol.ReportingChangeFunc
+		current []byte
+		want    []byte
+		changed bool
+	}{
+		{"put if absent, absent", PutIfAbsent([]byte("a")), absent, []byte("a"), true},
+		{"put if absent, empty", PutIfAbsent([]byte("a")), empty, empty, false},
+		{"cas from absent, absent", CompareAndSwap(absent, []byte("a")), absent, []byte("a"), true},
+		{"cas from absent, empty", CompareAndSwap(absent, []byte("a")), empty, empty, false},
+		{"cas from empty, absent", CompareAndSwap(empty, []byte("a")), absent, absent, false},
+		{"cas to absent", CompareAndSwap([]byte("a"), absent), []byte("a"), absent, true},
+		{"delete, empty", Delete(), empty, absent, true},
+		{"delete, absent", Delete(), absent, absent, false},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			next, changed := testcase.f(testcase.current)
+			if want, have := testcase.want == nil, next == nil; want != have {
+				t.Errorf("absent: want %v, have %v", want, have)
+			}
+			if want, have := string(testcase.want), string(next); want != have {
+				t.Errorf("state: want %q, have %q", want, have)
+			}
+			if want, have := testcase.changed, changed; want != have {
+				t.Errorf("changed: want %v, have %v", want, have)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// ChangeFuncTimeoutError is returned by a proposal whose change function didn't
+// return within the timeout set by WithChangeFuncTimeout. The round is
+// abandoned before the accept phase, so the change isn't made.
+type ChangeFuncTimeoutError struct {
+	Key     string
+	Timeout time.Duration
+}
+
+func (cte ChangeFuncTimeoutError) Error() string {
+	return fmt.Sprintf("change function for %q didn't return within %s", cte.Key, cte.Timeout)
+}
+
+// ChangeFuncPanicError is returned by a proposal whose change function
+// panicked. The round is abandoned before the accept phase, so the change
+// isn't made.
+type ChangeFuncPanicError struct {
+	Key   string
+	Value interface{} // as recovered
+	Stack []byte      // of the change function's goroutine
+}
+
+func (cpe ChangeFuncPanicError) Error() string {
+	return fmt.Sprintf("change function for %q panicked: %v", cpe.Key, cpe.Value)
+}
+
+// WithChangeFuncTimeout runs every change function in its own goroutine, and
+// fails the proposal with a ChangeFuncTimeoutError if it hasn't returned after
+// the timeout, or when the proposal's context is done, whichever is first. A
+// change function which blocks, e.g. on an accidental network call, would
+// otherwise hold the proposer for good.
+//
+// Go can't stop a goroutine from outside, so a change function which times out
+// keeps running, and leaks, until it returns, if ever; its result is dropped.
+// The timeout frees the proposer, but it doesn't fix the change function. By
+// default, change functions run inline, with no timeout.
+func WithChangeFuncTimeout(timeout time.Duration) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.changeTimeout = timeout }
+}
+
+// applyChange calls f with the current state, recovering a panic into a
+// ChangeFuncPanicError, and applying the timeout, if there is one. It must be
+// called with the mutex held.
+func (p *MemoryProposer) applyChange(ctx context.Context, key string, f ReportingChangeFunc, current []byte) (next []byte, changed bool, err error) {
+	if p.changeTimeout <= 0 {
+		return p.callChange(key, f, current)
+	}
+
+	type result struct {
+		next    []byte
+		changed bool
+		err     error
+	}
+	results := make(chan result, 1) // so a late change function doesn't block
+	go func() {
+		next, changed, err := p.callChange(key, f, current)
+		results <- result{next, changed, err}
+	}()
+
+	timer := time.NewTimer(p.changeTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.next, r.changed, r.err
+	case <-timer.C:
+		level.Warn(p.logger).Log("method", "applyChange", "key", key, "err", "change function timed out", "timeout", p.changeTimeout)
+		return nil, false, ChangeFuncTimeoutError{Key: key, Timeout: p.changeTimeout}
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// callChange calls f, recovering a panic.
+func (p *MemoryProposer) callChange(key string, f ReportingChangeFunc, current []byte) (next []byte, changed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			level.Error(p.logger).Log("method", "applyChange", "key", key, "err", "change function panicked", "panic", r)
+			next, changed, err = nil, false, ChangeFuncPanicError{Key: key, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	next, changed = f(current)
+	return next, changed, nil
+}
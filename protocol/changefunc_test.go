@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestChangeFuncPanic(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+		key    = "k"
+	)
+	if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := p.Propose(ctx, key, func([]byte) []byte { panic("oops") })
+	if cpe, ok := err.(ChangeFuncPanicError); !ok || cpe.Key != key || cpe.Value != "oops" || len(cpe.Stack) == 0 {
+		t.Fatalf("want ChangeFuncPanicError, have %v", err)
+	}
+	if _, err := p.Explain(ctx, key, func([]byte) ([]byte, bool) { panic("oops") }); err == nil {
+		t.Errorf("explain: want error, have none")
+	}
+
+	// The proposer carries on, and the value wasn't changed.
+	state, _, err := p.Propose(ctx, key, changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []byte("v1"), state; !bytes.Equal(want, have) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestChangeFuncTimeout(t *testing.T) {
+	var (
+		logger  = log.NewLogfmtLogger(newTestWriter(t))
+		a1      = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2      = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3      = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		timeout = 10 * time.Millisecond
+		p       = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithChangeFuncTimeout(timeout), WithAbandonment())
+		ctx     = context.Background()
+		key     = "k"
+		unblock = make(chan struct{})
+	)
+	defer close(unblock)
+	if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	blocking := func(current []byte) []byte {
+		<-unblock
+		return []byte("v2")
+	}
+	_, _, err := p.Propose(ctx, key, blocking)
+	if cte, ok := err.(ChangeFuncTimeoutError); !ok || cte.Key != key || cte.Timeout != timeout {
+		t.Fatalf("want ChangeFuncTimeoutError, have %v", err)
+	}
+
+	// The round was abandoned, so the next proposal goes through at once, and
+	// sees the old value.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !promisesCleared(key, a1, a2, a3) {
+		time.Sleep(time.Millisecond)
+	}
+	if !promisesCleared(key, a1, a2, a3) {
+		t.Error("promises weren't cleared")
+	}
+	state, _, err := p.Propose(ctx, key, changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []byte("v1"), state; !bytes.Equal(want, have) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+
+	// A canceled context stops the wait, too.
+	canceled, cancel := context.WithCancel(ctx)
+	slow := NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), []Acceptor{a1, a2, a3}, WithChangeFuncTimeout(time.Hour))
+	if _, _, err := slow.Propose(canceled, key, func(current []byte) []byte {
+		cancel()
+		return blocking(current)
+	}); err != context.Canceled {
+		t.Errorf("canceled: want %v, have %v", context.Canceled, err)
+	}
+}
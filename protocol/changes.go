@@ -0,0 +1,230 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrChangesNotTracked indicates a request for changes from an acceptor
+	// which doesn't track them.
+	ErrChangesNotTracked = errors.New("changes aren't tracked")
+
+	// ErrWatermarkExpired indicates a request for changes since a watermark
+	// older than the acceptor's retained deletions. The caller has to start
+	// over from watermark zero.
+	ErrWatermarkExpired = errors.New("watermark is older than the retained changes")
+)
+
+// Change is a key whose accepted value changed since a given watermark.
+type Change struct {
+	Key      string
+	Seq      uint64 // of the change, in the acceptor's sequence
+	Accepted Ballot // zero if Deleted
+	Value    []byte
+	Deleted  bool
+}
+
+// WithChangeTracking makes the acceptor number every change to an accepted
+// value, or deletion, in a sequence, so Changes can return the keys which
+// changed since a given point, e.g. for incremental backups. The last
+// deletions deletions are retained; a request for changes older than that
+// fails with ErrWatermarkExpired. By default, changes aren't tracked.
+func WithChangeTracking(deletions int) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) {
+		if deletions < 1 {
+			deletions = 1
+		}
+		a.keepDeletions = deletions
+	}
+}
+
+// Watermark returns the sequence number up to which every change is visible to
+// Changes. It only increases.
+func (a *MemoryAcceptor) Watermark(ctx context.Context) (uint64, error) {
+	if a.changes == nil {
+		return 0, ErrChangesNotTracked
+	}
+	return a.changes.stable(), nil
+}
+
+// Changes returns the latest change to every key which changed after watermark
+// since, in sequence order, and the watermark to pass next time. Keys that
+// changed more than once are returned once. Watermark zero returns every key,
+// i.e. a full copy. A key which was deleted, and not recreated, is returned
+// as Deleted. Promises, and reads, which accept the same value again, aren't
+// changes, as there's nothing new to back up.
+func (a *MemoryAcceptor) Changes(ctx context.Context, since uint64) ([]Change, uint64, error) {
+	if a.changes == nil {
+		return nil, 0, ErrChangesNotTracked
+	}
+
+	// Only changes up to the stable watermark are certain to be visible;
+	// later ones are returned next time.
+	stable, deletions, expired := a.changes.snapshot(since)
+	if expired {
+		return nil, 0, ErrWatermarkExpired
+	}
+
+	var (
+		changes []Change
+		current = map[string]bool{}
+		err     error
+	)
+	a.changes.inner.forEach(func(key string, av acceptedValue) bool {
+		current[key] = true
+		if av.seq <= since || av.seq > stable {
+			return true
+		}
+		var value []byte
+		if value, err = av.raw(key); err != nil {
+			return false
+		}
+		changes = append(changes, Change{Key: key, Seq: av.seq, Accepted: av.accepted, Value: copyValue(value)})
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for key, seq := range deletions {
+		if seq <= stable && !current[key] {
+			changes = append(changes, Change{Key: key, Seq: seq, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Seq < changes[j].Seq })
+	return changes, stable, nil
+}
+
+// changeStore is a store which numbers changes to accepted values. Sequence
+// numbers are assigned under the key's lock, but changes to different keys
+// become visible in any order, so the stable watermark is the greatest below
+// which nothing is still in flight.
+type changeStore struct {
+	inner store
+	max   int // deletions retained
+
+	mtx       sync.Mutex
+	last      uint64              // greatest assigned
+	inflight  map[uint64]struct{} // assigned, not yet visible
+	deletions map[string]uint64   // key to seq, for the most recent deletions
+	order     []deletion          // of deletions, oldest first
+	floor     uint64              // greatest seq of a forgotten deletion
+}
+
+type deletion struct {
+	key string
+	seq uint64
+}
+
+func newChangeStore(inner store, deletions int) *changeStore {
+	return &changeStore{
+		inner:     inner,
+		max:       deletions,
+		inflight:  map[uint64]struct{}{},
+		deletions: map[string]uint64{},
+	}
+}
+
+func (s *changeStore) get(key string) (acceptedValue, bool) {
+	return s.inner.get(key)
+}
+
+func (s *changeStore) update(key string, f func(acceptedValue, bool) (acceptedValue, bool, error)) error {
+	var seq uint64
+	err := s.inner.update(key, func(current acceptedValue, ok bool) (acceptedValue, bool, error) {
+		next, keep, err := f(current, ok)
+		seq = 0
+		switch {
+		case err != nil:
+		case keep && changed(current, next):
+			seq = s.begin(key, false)
+			next.seq = seq
+		case ok && !keep:
+			seq = s.begin(key, true)
+		}
+		return next, keep, err
+	})
+	if seq != 0 {
+		s.end(seq)
+	}
+	return err
+}
+
+// changed reports whether next is a new accepted value. Reads accept the same
+// value again, with a new ballot, but there's nothing new to back up.
+func changed(current, next acceptedValue) bool {
+	if next.accepted == current.accepted {
+		return false
+	}
+	return current.accepted == zeroballot || next.codec != current.codec || !bytes.Equal(next.value, current.value)
+}
+
+func (s *changeStore) forEach(f func(string, acceptedValue) bool) {
+	s.inner.forEach(f)
+}
+
+// begin assigns the next sequence number to a change to key.
+func (s *changeStore) begin(key string, deleted bool) uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.last++
+	s.inflight[s.last] = struct{}{}
+	if deleted {
+		s.deletions[key] = s.last
+		s.order = append(s.order, deletion{key, s.last})
+		for len(s.order) > s.max {
+			oldest := s.order[0]
+			s.order = s.order[1:]
+			if s.deletions[oldest.key] == oldest.seq {
+				delete(s.deletions, oldest.key)
+			}
+			s.floor = oldest.seq
+		}
+	}
+	return s.last
+}
+
+// end marks the change with seq as visible.
+func (s *changeStore) end(seq uint64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.inflight, seq)
+}
+
+func (s *changeStore) stable() uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.stableLocked()
+}
+
+func (s *changeStore) stableLocked() uint64 {
+	stable := s.last
+	for seq := range s.inflight {
+		if seq <= stable {
+			stable = seq - 1
+		}
+	}
+	return stable
+}
+
+// snapshot returns the stable watermark, and the deletions after since. It
+// reports expired if deletions after since may have been forgotten. Changes
+// since zero are a full copy, so they never expire.
+func (s *changeStore) snapshot(since uint64) (stable uint64, deletions map[string]uint64, expired bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if since != 0 && since < s.floor {
+		return 0, nil, true
+	}
+	deletions = map[string]uint64{}
+	for key, seq := range s.deletions {
+		if seq > since {
+			deletions[key] = seq
+		}
+	}
+	return s.stableLocked(), deletions, false
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestChanges(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithChangeTracking(2), WithShards(4), WithCompression(Flate, 0))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1)
+		ctx    = context.Background()
+		backup = map[string]string{}
+	)
+
+	// sync applies the changes since the watermark to the backup, and returns
+	// the next watermark.
+	sync := func(since uint64) uint64 {
+		t.Helper()
+		changes, next, err := a1.Changes(ctx, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range changes {
+			if c.Deleted {
+				delete(backup, c.Key)
+			} else {
+				backup[c.Key] = string(c.Value)
+			}
+		}
+		return next
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := p.Propose(ctx, "promised", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	watermark := sync(0)
+	if want, have := map[string]string{"a": "a", "b": "b", "c": "c", "promised": ""}, backup; !reflect.DeepEqual(want, have) {
+		t.Fatalf("full: want %v, have %v", want, have)
+	}
+
+	// Nothing changed, nothing to sync.
+	if changes, next, err := a1.Changes(ctx, watermark); err != nil || len(changes) != 0 || next != watermark {
+		t.Errorf("unchanged: %v, %d, %v", changes, next, err)
+	}
+
+	// Change one key, and delete another, between runs. Reads aren't changes.
+	if _, _, err := p.Propose(ctx, "b", func([]byte) []byte { return []byte("bb") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Propose(ctx, "a", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	if err := a1.RemoveIfEqual(ctx, "c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err := a1.Changes(ctx, watermark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(changes); want != have {
+		t.Errorf("incremental: want %d changes, have %d: %v", want, have, changes)
+	}
+	watermark = sync(watermark)
+	if want, have := map[string]string{"a": "a", "b": "bb", "promised": ""}, backup; !reflect.DeepEqual(want, have) {
+		t.Errorf("incremental: want %v, have %v", want, have)
+	}
+	if have, _ := a1.Watermark(ctx); have != watermark {
+		t.Errorf("watermark: want %d, have %d", watermark, have)
+	}
+
+	// A deleted key that's recreated is a change, not a deletion.
+	if _, _, err := p.Propose(ctx, "c", changeFuncInitializeOnlyOnce("cc")); err != nil {
+		t.Fatal(err)
+	}
+	watermark = sync(watermark)
+	if want, have := "cc", backup["c"]; want != have {
+		t.Errorf("recreated: want %q, have %q", want, have)
+	}
+
+	// Only the last 2 deletions are retained, so a backup older than the
+	// forgotten ones has to start over.
+	for _, key := range []string{"a", "b", "c"} {
+		state, _, _ := p.Propose(ctx, key, changeFuncRead)
+		if err := a1.RemoveIfEqual(ctx, key, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := a1.Changes(ctx, watermark); err != ErrWatermarkExpired {
+		t.Errorf("expired: want %v, have %v", ErrWatermarkExpired, err)
+	}
+	backup = map[string]string{}
+	sync(0)
+	if want, have := map[string]string{"promised": ""}, backup; !reflect.DeepEqual(want, have) {
+		t.Errorf("restarted: want %v, have %v", want, have)
+	}
+
+	if _, _, err := NewMemoryAcceptor("2", logger).Changes(ctx, 0); err != ErrChangesNotTracked {
+		t.Errorf("untracked: want %v, have %v", ErrChangesNotTracked, err)
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// Checksum is a CRC-32C of a value. Proposers compute it when they send a
+// value to accepters; accepters verify it on receipt, store it alongside the
+// value, and verify it again before returning the value to a preparer; and
+// proposers verify it once more on receipt. So corruption on the wire and in
+// storage are both detected, and distinguishable.
+type Checksum uint32
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumOf returns the checksum of value. The empty value, including nil,
+// has the zero checksum, which matches keys that have never been accepted.
+func checksumOf(value []byte) Checksum {
+	return Checksum(crc32.Checksum(value, castagnoli))
+}
+
+// CorruptValueError is returned when a value doesn't match its checksum.
+// Where says which leg of the value's journey corrupted it: "accept" means
+// between proposer and accepter, detected by the accepter; "storage" means at
+// rest in the acceptor, detected by the acceptor during prepare; and "prepare"
+// means between preparer and proposer, detected by the proposer.
+type CorruptValueError struct {
+	Key   string
+	Where string
+	Want  Checksum
+	Have  Checksum
+}
+
+func (cve CorruptValueError) Error() string {
+	return fmt.Sprintf("corrupt value for key %q (%s): checksum %08x, want %08x", cve.Key, cve.Where, uint32(cve.Have), uint32(cve.Want))
+}
+
+func verifyChecksum(key, where string, value []byte, want Checksum) error {
+	if have := checksumOf(value); have != want {
+		return CorruptValueError{Key: key, Where: where, Want: want, Have: have}
+	}
+	return nil
+}
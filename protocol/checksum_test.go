@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestChecksums(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// Accepters refuse values that don't match their checksum.
+	err := a1.Accept(ctx, "k", Age{}, Ballot{Counter: 1, ID: "x"}, []byte("v"), checksumOf([]byte("w")), nil)
+	if cve, ok := err.(CorruptValueError); !ok || cve.Where != "accept" {
+		t.Fatalf("accept with bad checksum: want accept CorruptValueError, have %v", err)
+	}
+
+	// Write a value, and make sure every acceptor has it.
+	if _, _, err := p1.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p1.FullIdentityRead(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt it in storage on one acceptor. That acceptor refuses to hand it
+	// out.
+	a1.values.update("k", func(av acceptedValue, ok bool) (acceptedValue, bool, error) {
+		av.value = []byte("x")
+		return av, ok, nil
+	})
+	_, _, _, _, err = a1.Prepare(ctx, "k", Age{}, 0, Ballot{Counter: 100, ID: "x"})
+	if cve, ok := err.(CorruptValueError); !ok || cve.Where != "storage" {
+		t.Fatalf("prepare of corrupt value: want storage CorruptValueError, have %v", err)
+	}
+
+	// The proposer still reads the clean value from the rest of the quorum.
+	// Its accept phase repairs the corrupt acceptor along the way.
+	if state, _, err := p1.Propose(ctx, "k", changeFuncRead); err != nil || string(state) != "v" {
+		t.Fatalf("read with one corrupt acceptor: want %q, have %q (%v)", "v", state, err)
+	}
+	for deadline := time.Now().Add(time.Second); string(a1.dumpValue("k")) != "v"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("corrupt acceptor wasn't repaired: have %q", a1.dumpValue("k"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Corruption on the way back from a preparer is caught by the proposer.
+	p2 := NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3, garblingAcceptor{NewMemoryAcceptor("4", log.With(logger, "a", 4))})
+	if _, err := p2.FullIdentityRead(ctx, "j"); err == nil {
+		t.Fatal("full read through garbling acceptor: want error, have none")
+	} else if qe, ok := err.(QuorumError); !ok {
+		t.Fatalf("full read through garbling acceptor: want QuorumError, have %v", err)
+	} else if cve, ok := qe.Failures["4"].(CorruptValueError); !ok || cve.Where != "prepare" {
+		t.Fatalf("full read through garbling acceptor: want prepare CorruptValueError, have %v", qe.Failures["4"])
+	}
+}
+
+// garblingAcceptor corrupts every value it returns from prepare.
+type garblingAcceptor struct{ *MemoryAcceptor }
+
+func (a garblingAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	value, sum, meta, current, err := a.MemoryAcceptor.Prepare(ctx, key, age, epoch, b)
+	return append(value, '!'), sum, meta, current, err
+}
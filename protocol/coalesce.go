@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithCoalescing enables coalescing for ProposeCoalesced. Concurrent calls for
+// the same key are collected into a batch, which stays open for at least
+// window, and then until the proposer is free to start a round. The batch's
+// change functions are applied in arrival order, in a single round. By
+// default, ProposeCoalesced behaves like Propose.
+func WithCoalescing(window time.Duration) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.coalescer = newCoalescer(window) }
+}
+
+// coalescer tracks the open batch for each key.
+type coalescer struct {
+	window  time.Duration
+	mtx     sync.Mutex
+	pending map[string]*batch
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{
+		window:  window,
+		pending: map[string]*batch{},
+	}
+}
+
+// batch is a set of change functions for the same key, proposed in a single
+// round. The results are written by the first caller, which runs the round,
+// before done is closed.
+type batch struct {
+	fs     []ChangeFunc
+	done   chan struct{}
+	states [][]byte
+	b      Ballot
+	err    error
+}
+
+// join adds f to the open batch for key, opening a new one if necessary. It
+// returns the batch, the index of f in it, and whether the caller opened it.
+func (c *coalescer) join(key string, f ChangeFunc) (bt *batch, i int, first bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	bt, ok := c.pending[key]
+	if !ok {
+		bt = &batch{done: make(chan struct{})}
+		c.pending[key] = bt
+	}
+	bt.fs = append(bt.fs, f)
+	return bt, len(bt.fs) - 1, !ok
+}
+
+// close stops the open batch for key from accepting more change functions,
+// and returns them.
+func (c *coalescer) close(key string, bt *batch) []ChangeFunc {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.pending, key)
+	return bt.fs
+}
+
+// ProposeCoalesced is like Propose, but the change may be coalesced with
+// concurrent changes to the same key; see WithCoalescing. The returned state
+// is the state immediately after f was applied, which may be different from
+// the final state of the round. If the round fails, every change in it fails.
+//
+// Only use ProposeCoalesced for changes that can be composed with arbitrary
+// other changes to the same key, like increments or compare-and-swaps. The
+// round runs with the context of the first caller in the batch, so if it's
+// canceled, the whole batch fails.
+func (p *MemoryProposer) ProposeCoalesced(ctx context.Context, key string, f ChangeFunc) (state []byte, b Ballot, err error) {
+	if p.coalescer == nil {
+		return p.Propose(ctx, key, f)
+	}
+
+	bt, i, first := p.coalescer.join(key, f)
+	if !first {
+		select {
+		case <-bt.done:
+		case <-ctx.Done():
+			return nil, b, ctx.Err() // the change may still be made
+		}
+		if bt.err != nil {
+			return nil, bt.b, bt.err
+		}
+		return bt.states[i], bt.b, nil
+	}
+
+	// We opened the batch, so we run the round. Wait for the window, and then
+	// for the proposer, giving other changes a chance to join.
+	defer close(bt.done)
+	select {
+	case <-time.After(p.coalescer.window):
+	case <-ctx.Done():
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// With WithChangeFuncTimeout, a call of the composed change function may be
+	// abandoned, and keep running alongside the rest of the round, or the next
+	// one. So each call builds its own states, and only the latest call to
+	// start may record them: that's the one whose result the round returned.
+	var (
+		fs      = p.coalescer.close(key, bt)
+		mtx     sync.Mutex
+		calls   int
+		latest  [][]byte
+		publish = func(call int, states [][]byte) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if call == calls {
+				latest = states
+			}
+		}
+	)
+	composed := func(current []byte) []byte {
+		mtx.Lock()
+		calls++
+		call := calls
+		mtx.Unlock()
+
+		states := make([][]byte, len(fs))
+		for j, f := range fs {
+			current = f(current)
+			states[j] = current
+		}
+		publish(call, states)
+		return current
+	}
+	_, _, bt.b, bt.err = p.proposeRetry(ctx, key, composed, writeMeta, regularQuorum, roundOptions{})
+	if bt.err != nil {
+		return nil, bt.b, bt.err
+	}
+	mtx.Lock()
+	bt.states = latest
+	mtx.Unlock()
+	return bt.states[i], bt.b, nil
+}
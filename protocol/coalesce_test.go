@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCoalescing(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithCoalescing(50*time.Millisecond))
+		ctx    = context.Background()
+	)
+
+	const n = 50
+	var (
+		wg     sync.WaitGroup
+		start  = make(chan struct{})
+		states = make(chan string, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			state, _, err := p1.ProposeCoalesced(ctx, "counter", changeFuncIncrement)
+			if err != nil {
+				t.Errorf("increment: %v", err)
+				return
+			}
+			states <- string(state)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(states)
+
+	// Every caller sees the state right after its own increment.
+	seen := map[string]bool{}
+	for state := range states {
+		seen[state] = true
+	}
+	for i := 1; i <= n; i++ {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("no caller saw state %d", i)
+		}
+	}
+
+	// And it took far fewer rounds than increments.
+	if rounds := p1.ballot.Counter; rounds > n/5 {
+		t.Errorf("%d increments took %d rounds", n, rounds)
+	}
+}
+
+func TestCoalescingFailure(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("1", log.With(logger, "a", 1))}
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1}, WithCoalescing(50*time.Millisecond))
+		ctx    = context.Background()
+	)
+	a1.setBroken(true)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := p1.ProposeCoalesced(ctx, "counter", changeFuncIncrement); err == nil {
+				t.Errorf("increment with a broken cluster: want error, have none")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCoalescingChangeFuncTimeout(t *testing.T) {
+	var (
+		logger   = log.NewLogfmtLogger(newTestWriter(t))
+		a1       = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2       = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3       = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1       = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithCoalescing(0), WithChangeFuncTimeout(10*time.Millisecond))
+		ctx      = context.Background()
+		unblock  = make(chan struct{})
+		finished = make(chan struct{})
+	)
+
+	// The first batch times out, and its change function carries on.
+	blocking := func(current []byte) []byte {
+		defer close(finished)
+		<-unblock
+		return []byte("late")
+	}
+	if _, _, err := p1.ProposeCoalesced(ctx, "counter", blocking); err == nil {
+		t.Fatal("slow change: want error, have none")
+	}
+
+	// It finishes while the next batch runs, which must still see its own
+	// states, not the late ones.
+	slow := func(current []byte) []byte {
+		close(unblock)
+		<-finished
+		return changeFuncIncrement(current)
+	}
+	state, _, err := p1.ProposeCoalesced(ctx, "counter", slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "1", string(state); want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func BenchmarkCoalescing(b *testing.B) {
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryProposerOption
+	}{
+		{"disabled", nil},
+		{"enabled", []MemoryProposerOption{WithCoalescing(0)}},
+	} {
+		b.Run(testcase.name, func(b *testing.B) {
+			var (
+				a1  = NewMemoryAcceptor("1", log.NewNopLogger())
+				a2  = NewMemoryAcceptor("2", log.NewNopLogger())
+				a3  = NewMemoryAcceptor("3", log.NewNopLogger())
+				p1  = NewMemoryProposerWithOptions("1", log.NewNopLogger(), []Acceptor{a1, a2, a3}, testcase.options...)
+				ctx = context.Background()
+			)
+			b.SetParallelism(100)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p1.ProposeCoalesced(ctx, "counter", changeFuncIncrement)
+				}
+			})
+			b.ReportMetric(float64(p1.ballot.Counter)/float64(b.N), "rounds/op")
+		})
+	}
+}
+
+func changeFuncIncrement(current []byte) []byte {
+	n, _ := strconv.Atoi(string(current))
+	return []byte(strconv.Itoa(n + 1))
+}
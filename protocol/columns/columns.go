@@ -0,0 +1,173 @@
+// Package columns stores several independent registers, called columns, in a
+// single key, so writers which own different columns of a value don't
+// conflict on it. Each operation reads or changes one column, in a single
+// proposal, and leaves the others as they are.
+//
+// A columned value is encoded as a JSON object, mapping each column name to
+// its value in standard base64, with the names in sorted order, and no
+// whitespace, as encoding/json renders a map[string][]byte. An absent column
+// isn't in the object; an empty one maps to "". A key with no columns is
+// absent. Plain reads of the key return the whole encoded object.
+package columns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+// ErrNoBackoff is returned by CompareAndSwap when it's given a nil backoff.
+var ErrNoBackoff = errors.New("compare-and-swap needs a backoff")
+
+// CorruptColumnsError is returned when a key's value isn't a columned value.
+type CorruptColumnsError struct {
+	Key   string
+	Value []byte
+}
+
+func (cce CorruptColumnsError) Error() string {
+	return fmt.Sprintf("key %q has invalid columns %q", cce.Key, cce.Value)
+}
+
+// Encode returns the encoding of the columns. No columns encode as absent.
+func Encode(columns map[string][]byte) []byte {
+	if len(columns) == 0 {
+		return nil
+	}
+	buf, _ := json.Marshal(columns) // can't fail
+	return buf
+}
+
+// Decode returns the columns of an encoded value. An absent value has none.
+func Decode(value []byte) (map[string][]byte, error) {
+	columns := map[string][]byte{}
+	if value == nil {
+		return columns, nil
+	}
+	if err := json.Unmarshal(value, &columns); err != nil || columns == nil {
+		return nil, errors.New("not a columned value")
+	}
+	for _, v := range columns {
+		if v == nil {
+			return nil, errors.New("not a columned value: null column")
+		}
+	}
+	return columns, nil
+}
+
+// SetColumn sets the column to value, and leaves the others as they are. A
+// nil value removes the column. If the state isn't a columned value, it's
+// left as it is, and no change is reported.
+func SetColumn(column string, value []byte) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		columns, err := Decode(current)
+		if err != nil {
+			return current, false
+		}
+		set(columns, column, value)
+		return Encode(columns), true
+	}
+}
+
+// CompareAndSwapColumn sets the column to next, if it's equal to expected,
+// and leaves the others as they are. A nil expected or next means an absent
+// column, which isn't equal to an empty one. If the state isn't a columned
+// value, it's left as it is, and no change is reported.
+func CompareAndSwapColumn(column string, expected, next []byte) protocol.ReportingChangeFunc {
+	return func(current []byte) ([]byte, bool) {
+		columns, err := Decode(current)
+		if err != nil {
+			return current, false
+		}
+		if !equal(columns[column], expected) {
+			return current, false
+		}
+		set(columns, column, next)
+		return Encode(columns), true
+	}
+}
+
+// Get reads the column of key, via a proposal. An absent column is nil.
+func Get(ctx context.Context, proposer protocol.Proposer, key, column string) ([]byte, error) {
+	identity := func(x []byte) []byte { return x }
+	state, _, err := proposer.Propose(ctx, key, identity)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := Decode(state)
+	if err != nil {
+		return nil, CorruptColumnsError{Key: key, Value: state}
+	}
+	return columns[column], nil
+}
+
+// CompareAndSwap sets the column of key to next, if it's equal to expected,
+// in a single proposal, and reports whether it did. Writes to other columns
+// of the key don't make it fail, but concurrent proposals for the key do, at
+// the ballot level, so failed proposals are retried, after the delay given by
+// backoff, until the context is done. The backoff should be made with the
+// proposer's ID, so writers through competing proposers take turns. It's
+// required, as there's no ID to make one with: a nil backoff returns
+// ErrNoBackoff, without a proposal.
+//
+// A proposal which failed in the accept phase may still have been made, so a
+// retry which finds the column already equal to next reports the swap as
+// made. That's right as long as no other writer sets the column to the same
+// value, as when each column has a single writer.
+func CompareAndSwap(ctx context.Context, proposer protocol.Proposer, backoff *protocol.Backoff, key, column string, expected, next []byte) (swapped bool, err error) {
+	if backoff == nil {
+		return false, ErrNoBackoff
+	}
+
+	// The change function may be called more than once, if a round fails, so
+	// only the last call counts.
+	var (
+		f         = CompareAndSwapColumn(column, expected, next)
+		ambiguous bool
+		corrupt   []byte
+	)
+	g := func(current []byte) ([]byte, bool) {
+		corrupt = nil
+		columns, err := Decode(current)
+		switch {
+		case err != nil:
+			corrupt = current
+		case ambiguous && equal(columns[column], next):
+			return current, true
+		}
+		return f(current)
+	}
+
+	for attempt := 1; ; attempt++ {
+		_, swapped, _, err = proposer.ProposeReporting(ctx, key, g)
+		if err == nil && corrupt != nil {
+			return false, CorruptColumnsError{Key: key, Value: corrupt}
+		}
+		var qe protocol.QuorumError
+		if !errors.As(err, &qe) {
+			return swapped, err
+		}
+		ambiguous = ambiguous || qe.Err != protocol.ErrPrepareFailed
+		select {
+		case <-time.After(backoff.Delay(attempt, err)):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+func set(columns map[string][]byte, column string, value []byte) {
+	if value == nil {
+		delete(columns, column)
+		return
+	}
+	columns[column] = value
+}
+
+func equal(a, b []byte) bool {
+	return (a == nil) == (b == nil) && string(a) == string(b)
+}
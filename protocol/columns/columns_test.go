@@ -0,0 +1,141 @@
+package columns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+func TestEncoding(t *testing.T) {
+	value := Encode(map[string][]byte{"b": []byte("2"), "a": []byte("1"), "e": {}})
+	if want, have := `{"a":"MQ==","b":"Mg==","e":""}`, string(value); want != have {
+		t.Errorf("want %s, have %s", want, have)
+	}
+	columns, err := Decode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := columns["e"]; !ok || e == nil || len(e) != 0 {
+		t.Errorf("empty column: have %#v", e)
+	}
+	if Encode(nil) != nil {
+		t.Errorf("no columns: want absent")
+	}
+	for _, corrupt := range []string{`[]`, `null`, `{"a":null}`, `{"a":1}`, `x`} {
+		if _, err := Decode([]byte(corrupt)); err == nil {
+			t.Errorf("%s: want error, have none", corrupt)
+		}
+	}
+}
+
+func TestChangeFuncs(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		f       protocol.ReportingChangeFunc
+		current string
+		want    string
+		changed bool
+	}{
+		{"set, absent", SetColumn("a", []byte("1")), "", `{"a":"MQ=="}`, true},
+		{"set, other", SetColumn("a", []byte("1")), `{"b":"Mg=="}`, `{"a":"MQ==","b":"Mg=="}`, true},
+		{"set, remove last", SetColumn("a", nil), `{"a":"MQ=="}`, "", true},
+		{"set, corrupt", SetColumn("a", []byte("1")), `x`, `x`, false},
+		{"cas, create", CompareAndSwapColumn("a", nil, []byte("1")), `{"b":"Mg=="}`, `{"a":"MQ==","b":"Mg=="}`, true},
+		{"cas, match", CompareAndSwapColumn("b", []byte("2"), []byte("1")), `{"b":"Mg=="}`, `{"b":"MQ=="}`, true},
+		{"cas, mismatch", CompareAndSwapColumn("b", []byte("1"), []byte("3")), `{"b":"Mg=="}`, `{"b":"Mg=="}`, false},
+		{"cas, empty isn't absent", CompareAndSwapColumn("b", nil, []byte("3")), `{"b":""}`, `{"b":""}`, false},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var current []byte
+			if testcase.current != "" {
+				current = []byte(testcase.current)
+			}
+			next, changed := testcase.f(current)
+			if want, have := testcase.want, string(next); want != have {
+				t.Errorf("state: want %s, have %s", want, have)
+			}
+			if want, have := testcase.changed, changed; want != have {
+				t.Errorf("changed: want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		a2  = protocol.NewMemoryAcceptor("2", log.NewNopLogger())
+		a3  = protocol.NewMemoryAcceptor("3", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1, a2, a3)
+		p2  = protocol.NewMemoryProposer("2", log.NewNopLogger(), a1, a2, a3)
+		b1  = protocol.NewBackoff("1", time.Millisecond, 50*time.Millisecond)
+		b2  = protocol.NewBackoff("2", time.Millisecond, 50*time.Millisecond)
+		ctx = context.Background()
+	)
+
+	// Two writers, each through its own proposer, increment their own
+	// columns of the same key. They conflict on ballots, but never on values.
+	const n = 20
+	var wg sync.WaitGroup
+	for _, w := range []struct {
+		proposer protocol.Proposer
+		backoff  *protocol.Backoff
+		column   string
+	}{{p1, b1, "x"}, {p2, b2, "y"}} {
+		wg.Add(1)
+		go func(proposer protocol.Proposer, backoff *protocol.Backoff, column string) {
+			defer wg.Done()
+			var current []byte
+			for i := 1; i <= n; i++ {
+				next := []byte(fmt.Sprint(i))
+				swapped, err := CompareAndSwap(ctx, proposer, backoff, "k", column, current, next)
+				if err != nil {
+					t.Errorf("%s: %v", column, err)
+					return
+				}
+				if !swapped {
+					t.Errorf("%s: swap %d failed", column, i)
+					return
+				}
+				current = next
+			}
+		}(w.proposer, w.backoff, w.column)
+	}
+	wg.Wait()
+
+	for _, column := range []string{"x", "y"} {
+		value, err := Get(ctx, p1, "k", column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := fmt.Sprint(n), string(value); want != have {
+			t.Errorf("%s: want %s, have %s", column, want, have)
+		}
+	}
+
+	// A stale expectation doesn't swap.
+	if swapped, err := CompareAndSwap(ctx, p1, b1, "k", "x", []byte("1"), []byte("z")); err != nil || swapped {
+		t.Errorf("stale: want no swap, have %v (%v)", swapped, err)
+	}
+
+	// A plain value isn't touched.
+	if _, _, err := p1.Propose(ctx, "plain", func([]byte) []byte { return []byte("nope") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompareAndSwap(ctx, p1, b1, "plain", "x", nil, []byte("1")); err == nil {
+		t.Errorf("corrupt: want error, have none")
+	}
+	if _, err := Get(ctx, p1, "plain", "x"); err == nil {
+		t.Errorf("corrupt get: want error, have none")
+	}
+
+	// A backoff is required.
+	if _, err := CompareAndSwap(ctx, p1, nil, "k", "x", nil, []byte("1")); err != ErrNoBackoff {
+		t.Errorf("nil backoff: want %v, have %v", ErrNoBackoff, err)
+	}
+}
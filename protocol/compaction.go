@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// CompactionPolicy says which superseded values Compact removes from an
+// acceptor's history. The zero value removes nothing.
+type CompactionPolicy struct {
+	// MaxVersions is how many superseded values are kept per key, if it's
+	// fewer than the acceptor retains. Zero means no limit.
+	MaxVersions int
+
+	// MaxAge removes superseded values which were superseded longer ago than
+	// this. Zero means no limit.
+	MaxAge time.Duration
+
+	// MaxKeysPerSecond limits the rate at which keys are compacted, so
+	// compaction doesn't compete with traffic. The default is no limit.
+	MaxKeysPerSecond float64
+}
+
+// CompactionStats describes a compaction.
+type CompactionStats struct {
+	Keys     int // scanned
+	Versions int // superseded values removed
+	Bytes    int // of the raw values removed
+	Duration time.Duration
+}
+
+// Compact removes superseded values from the history of every key, according
+// to the policy. The current accepted value of a key is never removed, as
+// it's not part of the history. WithHistory already bounds the versions kept
+// for keys as they're written; Compact also applies to keys which are no
+// longer written, and can remove versions by age. If the context is canceled,
+// the keys compacted so far stay compacted, and the error is returned with
+// their stats.
+func (a *MemoryAcceptor) Compact(ctx context.Context, policy CompactionPolicy) (CompactionStats, error) {
+	var (
+		begin = time.Now()
+		stats CompactionStats
+		keys  []string
+	)
+	a.values.forEach(func(key string, av acceptedValue) bool {
+		if len(av.history) > 0 {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	var tick <-chan time.Time
+	if policy.MaxKeysPerSecond > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / policy.MaxKeysPerSecond))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for _, key := range keys {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			stats.Duration = time.Since(begin)
+			return stats, err
+		}
+
+		now := time.Now()
+		a.values.update(key, func(current acceptedValue, ok bool) (acceptedValue, bool, error) {
+			if !ok {
+				return current, false, nil
+			}
+			kept := compactHistory(current.history, current.acceptedAt, policy, now)
+			for _, h := range current.history[:len(current.history)-len(kept)] {
+				stats.Versions++
+				stats.Bytes += len(h.Value)
+			}
+			current.history = kept
+			return current, true, nil
+		})
+		stats.Keys++
+	}
+
+	stats.Duration = time.Since(begin)
+	level.Debug(a.logger).Log("method", "Compact", "keys", stats.Keys, "versions", stats.Versions, "bytes", stats.Bytes, "took", stats.Duration)
+	return stats, nil
+}
+
+// compactHistory returns the newest part of history which the policy keeps.
+// Each value was superseded when the next was accepted, and the last by the
+// current value, accepted at acceptedAt. The history slice may still be in use
+// by readers, so it's never modified.
+func compactHistory(history []Inspection, acceptedAt time.Time, policy CompactionPolicy, now time.Time) []Inspection {
+	drop := 0
+	if policy.MaxVersions > 0 && len(history) > policy.MaxVersions {
+		drop = len(history) - policy.MaxVersions
+	}
+	if policy.MaxAge > 0 {
+		for drop < len(history) {
+			supersededAt := acceptedAt
+			if drop+1 < len(history) {
+				supersededAt = history[drop+1].AcceptedAt
+			}
+			if now.Sub(supersededAt) <= policy.MaxAge {
+				break
+			}
+			drop++
+		}
+	}
+	return history[drop:]
+}
+
+// Compactor runs Compact on an acceptor periodically, and can be paused, e.g.
+// to keep history intact while investigating an incident.
+type Compactor struct {
+	acceptor *MemoryAcceptor
+	policy   CompactionPolicy
+
+	mtx    sync.Mutex
+	paused bool
+	stats  CompactorStats
+}
+
+// CompactorStats describes the runs of a Compactor.
+type CompactorStats struct {
+	Runs     int
+	Last     CompactionStats
+	Versions int // removed by every run
+	Bytes    int // removed by every run
+}
+
+// NewCompactor returns a compactor for the acceptor, with the given policy.
+func NewCompactor(acceptor *MemoryAcceptor, policy CompactionPolicy) *Compactor {
+	return &Compactor{
+		acceptor: acceptor,
+		policy:   policy,
+	}
+}
+
+// Run compacts every interval, unless paused, until the context is canceled.
+func (c *Compactor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Compact(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Compact runs a compaction now, unless paused, and returns its stats.
+func (c *Compactor) Compact(ctx context.Context) (CompactionStats, bool) {
+	if c.Paused() {
+		return CompactionStats{}, false
+	}
+	stats, _ := c.acceptor.Compact(ctx, c.policy)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.stats.Runs++
+	c.stats.Last = stats
+	c.stats.Versions += stats.Versions
+	c.stats.Bytes += stats.Bytes
+	return stats, true
+}
+
+// Pause stops compaction, until Resume. A run in progress finishes.
+func (c *Compactor) Pause() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.paused = true
+}
+
+// Resume undoes Pause.
+func (c *Compactor) Resume() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.paused = false
+}
+
+// Paused returns true if the compactor is paused.
+func (c *Compactor) Paused() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.paused
+}
+
+// Stats returns the stats of the compactor's runs.
+func (c *Compactor) Stats() CompactorStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.stats
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCompact(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithHistory(10))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2), WithHistory(10))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), WithHistory(10))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+	for key, writes := range map[string]int{"k": 5, "j": 2} {
+		for i := 1; i <= writes; i++ {
+			value := []byte(strconv.Itoa(i))
+			_, b, err := p.Propose(ctx, key, func([]byte) []byte { return value })
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitAccepted(t, key, b, a1, a2, a3)
+		}
+	}
+
+	c := NewCompactor(a1, CompactionPolicy{MaxVersions: 2})
+	c.Pause()
+	if _, ran := c.Compact(ctx); ran {
+		t.Errorf("paused: want no run")
+	}
+	c.Resume()
+	stats, ran := c.Compact(ctx)
+	if !ran {
+		t.Fatal("resumed: want a run")
+	}
+	if stats.Keys != 2 || stats.Versions != 2 || stats.Bytes != 2 {
+		t.Errorf("want 2 keys, 2 versions, 2 bytes, have %+v", stats)
+	}
+
+	// The newest versions, and the current value, are kept.
+	history, err := a1.History(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, h := range history {
+		values = append(values, string(h.Value))
+	}
+	if want, have := "[3 4 5]", fmt.Sprint(values); want != have {
+		t.Errorf("history: want %s, have %s", want, have)
+	}
+	if history, _ := a2.History(ctx, "k"); len(history) != 5 {
+		t.Errorf("other acceptor: want its history intact, have %d values", len(history))
+	}
+
+	// Removing every superseded value still leaves the current one.
+	if _, err := a1.Compact(ctx, CompactionPolicy{MaxAge: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := a1.History(ctx, "k"); len(history) != 1 || string(history[0].Value) != "5" {
+		t.Errorf("after max age: want only the current value, have %+v", history)
+	}
+	if s := c.Stats(); s.Runs != 1 || s.Versions != 2 || s.Last != stats {
+		t.Errorf("compactor stats: want 1 run, 2 versions, have %+v", s)
+	}
+
+	// A canceled compaction stops.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := a2.Compact(canceled, CompactionPolicy{MaxVersions: 1}); err != context.Canceled {
+		t.Errorf("canceled: want %v, have %v", context.Canceled, err)
+	}
+}
+
+func TestCompactHistoryByAge(t *testing.T) {
+	var (
+		now     = time.Now()
+		history = []Inspection{
+			{Value: []byte("1"), AcceptedAt: now.Add(-4 * time.Hour)},
+			{Value: []byte("2"), AcceptedAt: now.Add(-3 * time.Hour)}, // supersedes 1
+			{Value: []byte("3"), AcceptedAt: now.Add(-90 * time.Minute)},
+		}
+		current = now.Add(-time.Minute) // supersedes 3
+	)
+	for _, testcase := range []struct {
+		maxAge time.Duration
+		want   int
+	}{
+		{5 * time.Hour, 3},
+		{2 * time.Hour, 2}, // 1 was superseded 3h ago
+		{time.Hour, 1},     // 2 was superseded 90m ago
+		{time.Second, 0},   // 3 was superseded 1m ago
+	} {
+		kept := compactHistory(history, current, CompactionPolicy{MaxAge: testcase.maxAge}, now)
+		if want, have := testcase.want, len(kept); want != have {
+			t.Errorf("max age %s: want %d kept, have %d", testcase.maxAge, want, have)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"sync"
+)
+
+// Codec compresses values at rest in a MemoryAcceptor.
+type Codec interface {
+	// Name identifies the codec, e.g. in logs.
+	Name() string
+
+	// Compress returns the compressed form of value.
+	Compress(value []byte) ([]byte, error)
+
+	// Decompress returns the value that Compress was given.
+	Decompress(stored []byte) ([]byte, error)
+}
+
+// Flate is a Codec using DEFLATE, at its fastest setting.
+var Flate Codec = &flateCodec{}
+
+type flateCodec struct {
+	writers sync.Pool
+}
+
+func (c *flateCodec) Name() string { return "flate" }
+
+func (c *flateCodec) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, _ := c.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.BestSpeed); err != nil {
+			return nil, err
+		}
+	} else {
+		w.Reset(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCodec) Decompress(stored []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(stored))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// WithCompression makes the acceptor store values of at least threshold bytes
+// compressed with codec, if that makes them smaller. Values are decompressed
+// whenever they're read, so compression is invisible outside the acceptor:
+// checksums are of the raw value, and Prepare, Inspect, and History return
+// raw values, so acceptors with different settings can exchange them freely.
+// Each stored value remembers its codec, so changing the setting doesn't
+// affect values already stored. Retained history isn't compressed. A nil
+// codec disables compression, which is the default.
+func WithCompression(codec Codec, threshold int) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) {
+		a.codec, a.compressAbove = codec, threshold
+	}
+}
+
+// CompressionStats describe the values an acceptor currently stores.
+type CompressionStats struct {
+	Values      int // values stored
+	Compressed  int // of which compressed
+	RawBytes    int // total size of the values
+	StoredBytes int // total size as stored
+}
+
+// CompressionStats returns the stats for the values stored right now.
+func (a *MemoryAcceptor) CompressionStats() CompressionStats {
+	var stats CompressionStats
+	a.values.forEach(func(_ string, av acceptedValue) bool {
+		if av.value == nil {
+			return true
+		}
+		stats.Values++
+		stats.RawBytes += av.rawSize()
+		stats.StoredBytes += len(av.value)
+		if av.codec != nil {
+			stats.Compressed++
+		}
+		return true
+	})
+	return stats
+}
+
+// compress returns value as it should be stored, and the codec it was
+// compressed with, if any. Values which don't get smaller are stored raw.
+func (a *MemoryAcceptor) compress(value []byte) ([]byte, Codec, int) {
+	if a.codec == nil || len(value) < a.compressAbove || len(value) == 0 {
+		return value, nil, 0
+	}
+	stored, err := a.codec.Compress(value)
+	if err != nil || len(stored) >= len(value) {
+		return value, nil, 0
+	}
+	return stored, a.codec, len(value)
+}
+
+// raw returns the value, decompressed. A value which can't be decompressed is
+// reported as corrupt.
+func (av acceptedValue) raw(key string) ([]byte, error) {
+	if av.codec == nil {
+		return av.value, nil
+	}
+	value, err := av.codec.Decompress(av.value)
+	if err != nil {
+		return nil, CorruptValueError{Key: key, Where: "storage", Want: av.sum}
+	}
+	return value, nil
+}
+
+// rawSize returns the size of the value, decompressed.
+func (av acceptedValue) rawSize() int {
+	if av.codec == nil {
+		return len(av.value)
+	}
+	return av.size
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCompression(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithCompression(Flate, 64), WithHistory(2))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), WithCompression(Flate, 0))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+		config = []byte(`{"servers": [` + strings.Repeat(`{"host": "example.com", "port": 8080}, `, 100) + `{}]}`)
+		random = make([]byte, 1024)
+	)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	for key, value := range map[string][]byte{
+		"config": config,
+		"random": random,      // incompressible, so stored raw
+		"small":  []byte("x"), // below a1's threshold
+	} {
+		if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce(string(value))); err != nil {
+			t.Fatal(err)
+		}
+		// Overwrite it, so there's history, too.
+		if _, _, err := p.Propose(ctx, key, func([]byte) []byte { return value }); err != nil {
+			t.Fatal(err)
+		}
+		if state, _, err := p.Propose(ctx, key, changeFuncRead); err != nil || !bytes.Equal(state, value) {
+			t.Fatalf("%s: read %d bytes, %v", key, len(state), err)
+		}
+
+		// The last accept may still be on its way to one of them.
+		agree := func() bool {
+			agree, err := acceptorsAgree(ctx, key, []Inspecter{a1, a2, a3})
+			return err == nil && agree
+		}
+		deadline := time.Now().Add(time.Second)
+		for !agree() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if !agree() {
+			t.Errorf("%s: acceptors don't agree", key)
+		}
+		history, err := a1.History(ctx, key)
+		if err != nil || len(history) == 0 {
+			t.Fatalf("%s: history: %d, %v", key, len(history), err)
+		}
+		for _, h := range history {
+			if !bytes.Equal(h.Value, value) {
+				t.Errorf("%s: history has %d bytes, want %d", key, len(h.Value), len(value))
+			}
+		}
+	}
+
+	stats := a1.CompressionStats()
+	if want, have := 3, stats.Values; want != have {
+		t.Errorf("values: want %d, have %d", want, have)
+	}
+	if want, have := 1, stats.Compressed; want != have {
+		t.Errorf("compressed: want %d, have %d", want, have)
+	}
+	if want, have := len(config)+len(random)+1, stats.RawBytes; want != have {
+		t.Errorf("raw bytes: want %d, have %d", want, have)
+	}
+	if stats.StoredBytes >= len(config) {
+		t.Errorf("stored bytes: want fewer than %d, have %d", len(config), stats.StoredBytes)
+	}
+	if want, have := (CompressionStats{Values: 3, RawBytes: stats.RawBytes, StoredBytes: stats.RawBytes}), a2.CompressionStats(); want != have {
+		t.Errorf("uncompressed: want %+v, have %+v", want, have)
+	}
+
+	// Deletes compare the raw value, too.
+	tombstone, _, err := p.Propose(ctx, "config", func([]byte) []byte { return config })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+		if err := a.RemoveIfEqual(ctx, "config", tombstone); err != nil {
+			t.Errorf("%s: %v", a.Address(), err)
+		}
+	}
+}
+
+func BenchmarkAcceptCompression(b *testing.B) {
+	value := []byte(`{"servers": [` + strings.Repeat(`{"host": "example.com", "port": 8080}, `, 100) + `{}]}`)
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryAcceptorOption
+	}{
+		{"none", nil},
+		{"flate", []MemoryAcceptorOption{WithCompression(Flate, 0)}},
+	} {
+		b.Run(fmt.Sprintf("%s-%dB", testcase.name, len(value)), func(b *testing.B) {
+			var (
+				a   = NewMemoryAcceptor("1", log.NewNopLogger(), testcase.options...)
+				ctx = context.Background()
+				age = Age{ID: "p"}
+				sum = checksumOf(value)
+			)
+			b.SetBytes(int64(len(value)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ballot := Ballot{Counter: uint64(i + 1), ID: "p"}
+				key := fmt.Sprintf("key-%d", i%1024)
+				if _, _, _, _, err := a.Prepare(ctx, key, age, 0, ballot); err != nil {
+					b.Fatal(err)
+				}
+				if err := a.Accept(ctx, key, age, ballot, value, sum, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
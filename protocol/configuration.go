@@ -0,0 +1,390 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNoConfigurationFile indicates a reload was requested of a proposer
+	// without a configuration file.
+	ErrNoConfigurationFile = errors.New("no configuration file")
+
+	// ErrInvalidConfiguration indicates a configuration with a preparer that
+	// isn't also an accepter. No safe sequence of cluster changes produces
+	// that state.
+	ErrInvalidConfiguration = errors.New("invalid configuration: every preparer must also be an accepter")
+)
+
+// Configuration is the set of acceptors a proposer uses, by address, and
+// the weights of those which don't have the default weight of one.
+type Configuration struct {
+	Preparers []string       `json:"preparers"`
+	Accepters []string       `json:"accepters"`
+	Weights   map[string]int `json:"weights,omitempty"`
+}
+
+// Dialer returns an acceptor at the given address.
+type Dialer func(addr string) (Acceptor, error)
+
+// ConfigurationConflictError is returned by ReloadConfiguration when both the
+// file and the proposer's runtime configuration have changed since the file
+// was last read or written by the proposer. Neither side is chosen; an
+// operator should reconcile them.
+type ConfigurationConflictError struct {
+	File    Configuration
+	Runtime Configuration
+}
+
+func (cce ConfigurationConflictError) Error() string {
+	return fmt.Sprintf("configuration file %+v and runtime configuration %+v have both changed", cce.File, cce.Runtime)
+}
+
+// WithConfigurationFile makes the proposer write its configuration to path,
+// atomically, after every change, and enables ReloadConfiguration. The file
+// isn't read at construction; call ReloadConfiguration to do that. By default,
+// the configuration isn't persisted.
+func WithConfigurationFile(path string) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.configPath = path }
+}
+
+// Configuration returns the current set of acceptors, sorted by address.
+func (p *MemoryProposer) Configuration() Configuration {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.configuration()
+}
+
+func (p *MemoryProposer) configuration() Configuration {
+	c := Configuration{Preparers: []string{}, Accepters: []string{}}
+	for addr := range p.preparers {
+		c.Preparers = append(c.Preparers, addr)
+	}
+	for addr := range p.accepters {
+		c.Accepters = append(c.Accepters, addr)
+		if w := p.weight(addr); w != 1 {
+			if c.Weights == nil {
+				c.Weights = map[string]int{}
+			}
+			c.Weights[addr] = w
+		}
+	}
+	sort.Strings(c.Preparers)
+	sort.Strings(c.Accepters)
+	return c
+}
+
+// ReloadConfiguration reads the configuration file, and changes the proposer's
+// configuration to match, using dial to connect to new acceptors. If a
+// connection fails, or the file conflicts with the runtime configuration, the
+// proposer's configuration is unchanged.
+//
+// The change is atomic for this proposer, but it must be one the safe order of
+// cluster changes allows: a new preparer must already be an accepter, and an
+// accepter can only be removed once it's no longer a preparer. So growing the
+// cluster via the file takes two edits, one adding the accepter, and one,
+// after the sweep, adding the preparer; shrinking it is the same in reverse.
+// Other changes are refused with an UnsafeConfigurationError. A proposer with
+// no accepters, loading the file for the first time, takes it as it is. As
+// with any cluster change, it's up to the operator to make the same edits on
+// every proposer.
+func (p *MemoryProposer) ReloadConfiguration(dial Dialer) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.configPath == "" {
+		return ErrNoConfigurationFile
+	}
+	file, err := readConfiguration(p.configPath)
+	if err != nil {
+		return err
+	}
+	if err := file.validate(); err != nil {
+		return err
+	}
+
+	runtime := p.configuration()
+	if p.lastConfig != nil {
+		if !reflect.DeepEqual(runtime, *p.lastConfig) && !reflect.DeepEqual(file, *p.lastConfig) {
+			return ConfigurationConflictError{File: file, Runtime: runtime}
+		}
+	}
+
+	if err := p.checkDiff(file, p.checkOrder); err != nil {
+		return err
+	}
+	if err := p.adopt(file, dial); err != nil {
+		return err
+	}
+	p.lastConfig = &file
+	p.notifyConfiguration() // the file is already up to date
+	p.publishSummary()
+	return nil
+}
+
+// checkDiff calls check for each step needed to change the proposer's
+// configuration to c: each preparer added, and each accepter removed. Steps
+// adding accepters and removing preparers are always allowed. A proposer with
+// no accepters isn't checked. It must be called with the mutex held.
+func (p *MemoryProposer) checkDiff(c Configuration, check func(ConfigurationStep, string) error) error {
+	if len(p.accepters) == 0 {
+		return nil
+	}
+	for _, addr := range c.Preparers {
+		if _, ok := p.preparers[addr]; ok {
+			continue
+		}
+		if err := check(StepAddPreparer, addr); err != nil {
+			return err
+		}
+	}
+	accepters := map[string]bool{}
+	for _, addr := range c.Accepters {
+		accepters[addr] = true
+	}
+	for _, addr := range p.configuration().Accepters {
+		if accepters[addr] {
+			continue
+		}
+		if err := check(StepRemoveAccepter, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adopt replaces the proposer's configuration with c, which must be valid,
+// using dial to connect to new acceptors. If a connection fails, the
+// configuration is unchanged. In strict mode, the change must be one the steps
+// of cluster changes allow, including their sweeps, as for checkStep. The
+// lifecycles of acceptors part of the way through a change are kept, and
+// updated for the change: new accepters which aren't preparers are joining,
+// and preparers which are now only accepters are leaving. It must be called
+// with the mutex held.
+func (p *MemoryProposer) adopt(c Configuration, dial Dialer) error {
+	if p.strict {
+		if err := p.checkDiff(c, p.checkStep); err != nil {
+			return err
+		}
+	}
+
+	// Reuse the acceptors we already know, and dial the rest.
+	known := p.acceptors()
+	for _, addr := range c.Accepters {
+		if _, ok := known[addr]; ok {
+			continue
+		}
+		target, err := dial(addr)
+		if err != nil {
+			return errors.Wrapf(err, "error dialing %s", addr)
+		}
+		known[addr] = target
+	}
+
+	preparers, accepters := map[string]Preparer{}, map[string]Accepter{}
+	for _, addr := range c.Preparers {
+		preparers[addr] = known[addr]
+	}
+	for _, addr := range c.Accepters {
+		accepters[addr] = known[addr]
+	}
+	var weights map[string]int
+	for addr, w := range c.Weights {
+		if _, ok := accepters[addr]; ok && w != 1 {
+			if weights == nil {
+				weights = map[string]int{}
+			}
+			weights[addr] = w
+		}
+	}
+	for addr := range known {
+		if _, ok := accepters[addr]; !ok {
+			p.health.forget(addr)
+		}
+	}
+	states := map[string]lifecycle{}
+	for addr := range accepters {
+		if _, ok := preparers[addr]; ok {
+			continue // a member in both roles
+		}
+		var (
+			_, wasPreparer = p.preparers[addr]
+			_, wasAccepter = p.accepters[addr]
+			state, tracked = p.lifecycle[addr]
+		)
+		switch {
+		case wasPreparer:
+			states[addr] = leaving
+		case tracked:
+			states[addr] = state
+		case !wasAccepter:
+			states[addr] = joining
+		}
+	}
+	p.preparers, p.accepters, p.weights = preparers, accepters, weights
+	p.lifecycle = states
+	return nil
+}
+
+// ConfigurationHook is called after a change to a proposer's configuration,
+// with the configurations before and after.
+type ConfigurationHook func(before, after Configuration)
+
+// OnConfigurationChange registers hook to be called after every change to the
+// proposer's configuration, whether by adding or removing an acceptor, as in
+// GrowCluster and ShrinkCluster, by a reload, or by reconciliation. Hooks are
+// called synchronously, with the proposer's mutex held, so they see changes in
+// the order they were applied, and mustn't call the proposer. Each gets its
+// own copies of the configurations. A hook which panics is logged, and doesn't
+// affect the proposer, or the other hooks.
+func (p *MemoryProposer) OnConfigurationChange(hook ConfigurationHook) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.notified == nil {
+		c := p.configuration()
+		p.notified = &c
+	}
+	p.hooks = append(p.hooks, hook)
+}
+
+// configurationChanged must be called with the mutex held, after every
+// configuration change. It calls the hooks, and saves the configuration.
+func (p *MemoryProposer) configurationChanged() {
+	p.notifyConfiguration()
+	p.saveConfiguration()
+	p.publishSummary()
+}
+
+func (p *MemoryProposer) notifyConfiguration() {
+	if len(p.hooks) == 0 {
+		return
+	}
+	before, after := *p.notified, p.configuration()
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	p.notified = &after
+	for _, hook := range p.hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					level.Error(p.logger).Log("method", "notifyConfiguration", "err", "configuration hook panicked", "panic", r)
+				}
+			}()
+			hook(before.copy(), after.copy())
+		}()
+	}
+}
+
+func (c Configuration) copy() Configuration {
+	res := Configuration{
+		Preparers: append([]string{}, c.Preparers...),
+		Accepters: append([]string{}, c.Accepters...),
+	}
+	if c.Weights != nil {
+		res.Weights = make(map[string]int, len(c.Weights))
+		for addr, w := range c.Weights {
+			res.Weights[addr] = w
+		}
+	}
+	return res
+}
+
+// saveConfiguration writes the configuration file, if there is one. It's
+// called by configurationChanged. The change has already been made, so
+// failures are logged rather than returned. If the file has been edited since
+// we last read or wrote it, it's left alone, and the conflict is reported by
+// the next ReloadConfiguration.
+func (p *MemoryProposer) saveConfiguration() {
+	if p.configPath == "" {
+		return
+	}
+	if p.lastConfig != nil {
+		if file, err := readConfiguration(p.configPath); err == nil && !reflect.DeepEqual(file, *p.lastConfig) {
+			level.Error(p.logger).Log("method", "saveConfiguration", "path", p.configPath, "err", "file was edited; not overwriting")
+			return
+		}
+	}
+	c := p.configuration()
+	if err := writeConfiguration(p.configPath, c); err != nil {
+		level.Error(p.logger).Log("method", "saveConfiguration", "path", p.configPath, "err", err)
+		return
+	}
+	p.lastConfig = &c
+}
+
+func (c Configuration) validate() error {
+	accepters := map[string]bool{}
+	for _, addr := range c.Accepters {
+		accepters[addr] = true
+	}
+	for _, addr := range c.Preparers {
+		if !accepters[addr] {
+			return ErrInvalidConfiguration
+		}
+	}
+	if err := c.validateWeights(); err != nil {
+		return err
+	}
+	return c.validateIntersection()
+}
+
+func readConfiguration(path string) (Configuration, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Configuration{}, err
+	}
+	var c Configuration
+	if err := json.Unmarshal(buf, &c); err != nil {
+		return Configuration{}, errors.Wrapf(err, "error parsing %s", path)
+	}
+	if c.Preparers == nil {
+		c.Preparers = []string{}
+	}
+	if c.Accepters == nil {
+		c.Accepters = []string{}
+	}
+	sort.Strings(c.Preparers)
+	sort.Strings(c.Accepters)
+	return c, nil
+}
+
+// writeConfiguration writes c to path, atomically.
+func writeConfiguration(path string, c Configuration) error {
+	buf, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf)
+}
+
+// writeFileAtomic writes buf to a temporary file in the same directory as
+// path, syncs it, and renames it over path, so readers never see a partial
+// file.
+func writeFileAtomic(path string, buf []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestConfigurationFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caspaxos-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		path      = filepath.Join(dir, "config.json")
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		acceptors = map[string]Acceptor{"1": a1, "2": a2, "3": a3}
+		dial      = func(addr string) (Acceptor, error) {
+			if a, ok := acceptors[addr]; ok {
+				return a, nil
+			}
+			return nil, errors.New("no route to host")
+		}
+	)
+
+	// Every change is written to the file.
+	p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), nil, WithConfigurationFile(path))
+	for _, a := range []Acceptor{a1, a2, a3} {
+		p1.AddAccepter(a)
+		p1.AddPreparer(a)
+	}
+	p1.RemovePreparer(a3)
+	want := Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2", "3"}}
+	if have, err := readConfiguration(path); err != nil || !reflect.DeepEqual(want, have) {
+		t.Fatalf("file after changes: want %+v, have %+v (%v)", want, have, err)
+	}
+
+	// A new proposer picks it up.
+	p2 := NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), nil, WithConfigurationFile(path))
+	if err := p2.ReloadConfiguration(dial); err != nil {
+		t.Fatalf("initial load: %v", err)
+	}
+	if have := p2.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("after initial load: want %+v, have %+v", want, have)
+	}
+
+	// Out-of-band edits are applied on reload.
+	want = Configuration{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3"}}
+	if err := writeConfiguration(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.ReloadConfiguration(dial); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if have := p2.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("after reload: want %+v, have %+v", want, have)
+	}
+
+	// Invalid files, and files with unreachable acceptors, are rejected.
+	for _, c := range []Configuration{
+		{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2"}},
+		{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3", "4"}},
+	} {
+		if err := writeConfiguration(path, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := p2.ReloadConfiguration(dial); err == nil {
+			t.Errorf("reload %+v: want error, have none", c)
+		}
+		if have := p2.Configuration(); !reflect.DeepEqual(want, have) {
+			t.Errorf("after rejected reload: want %+v, have %+v", want, have)
+		}
+	}
+
+	// If both the file and the runtime configuration change, reload reports
+	// a conflict, and neither is overwritten.
+	p3 := NewMemoryProposerWithOptions("3", log.With(logger, "p", 3), nil, WithConfigurationFile(path))
+	edited := Configuration{Preparers: []string{"1"}, Accepters: []string{"1"}}
+	writeConfiguration(path, edited)
+	if err := p3.ReloadConfiguration(dial); err != nil {
+		t.Fatal(err)
+	}
+	writeConfiguration(path, Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2"}})
+	p3.AddAccepter(a3)
+	if _, ok := p3.ReloadConfiguration(dial).(ConfigurationConflictError); !ok {
+		t.Fatalf("reload after concurrent changes: want ConfigurationConflictError")
+	}
+	if want, have := (Configuration{Preparers: []string{"1"}, Accepters: []string{"1", "3"}}), p3.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("runtime after conflict: want %+v, have %+v", want, have)
+	}
+}
+
+func TestConfigurationFileOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caspaxos-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		path      = filepath.Join(dir, "config.json")
+		acceptors = map[string]Acceptor{}
+		dial      = func(addr string) (Acceptor, error) { return acceptors[addr], nil }
+		p         = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), nil, WithConfigurationFile(path))
+	)
+	for _, addr := range []string{"1", "2", "3", "4"} {
+		acceptors[addr] = NewMemoryAcceptor(addr, log.With(logger, "a", addr))
+	}
+
+	var (
+		c123   = Configuration{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3"}}
+		c123a4 = Configuration{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3", "4"}}
+		c1234  = Configuration{Preparers: []string{"1", "2", "3", "4"}, Accepters: []string{"1", "2", "3", "4"}}
+	)
+	reload := func(c Configuration) error {
+		t.Helper()
+		if err := writeConfiguration(path, c); err != nil {
+			t.Fatal(err)
+		}
+		return p.ReloadConfiguration(dial)
+	}
+	unsafe := func(c Configuration, step ConfigurationStep, current Configuration) {
+		t.Helper()
+		err := reload(c)
+		if uce, ok := err.(UnsafeConfigurationError); !ok || uce.Step != step || uce.Target != "4" {
+			t.Errorf("%+v: want UnsafeConfigurationError to %s 4, have %v", c, step, err)
+		}
+		if have := p.Configuration(); !reflect.DeepEqual(current, have) {
+			t.Errorf("after refused reload: want %+v, have %+v", current, have)
+		}
+	}
+	safe := func(c Configuration) {
+		t.Helper()
+		if err := reload(c); err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+	}
+
+	// The first load takes the file as it is.
+	safe(c123)
+
+	// Growing takes two edits, in order.
+	unsafe(c1234, StepAddPreparer, c123)
+	safe(c123a4)
+	safe(c1234)
+
+	// So does shrinking.
+	unsafe(c123, StepRemoveAccepter, c1234)
+	safe(c123a4)
+	safe(c123)
+}
+
+func TestConfigurationHooks(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2)
+		ctx    = context.Background()
+	)
+
+	type change struct{ before, after Configuration }
+	var changes []change
+	p.OnConfigurationChange(func(Configuration, Configuration) { panic("oops") })
+	p.OnConfigurationChange(func(before, after Configuration) {
+		changes = append(changes, change{before.copy(), after.copy()})
+		after.Accepters[0] = "mutated" // mustn't affect the proposer
+	})
+
+	if err := GrowCluster(ctx, a3, []Proposer{p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ShrinkCluster(ctx, a1, []Proposer{p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(a2); err != ErrDuplicate {
+		t.Fatalf("duplicate: want %v, have %v", ErrDuplicate, err)
+	}
+
+	var (
+		c12   = Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2"}}
+		c12a3 = Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2", "3"}}
+		c123  = Configuration{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3"}}
+		c23a1 = Configuration{Preparers: []string{"2", "3"}, Accepters: []string{"1", "2", "3"}}
+		c23   = Configuration{Preparers: []string{"2", "3"}, Accepters: []string{"2", "3"}}
+		want  = []change{{c12, c12a3}, {c12a3, c123}, {c123, c23a1}, {c23a1, c23}}
+	)
+	if !reflect.DeepEqual(want, changes) {
+		t.Errorf("want %+v, have %+v", want, changes)
+	}
+	if want, have := c23, p.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Errorf("final: want %+v, have %+v", want, have)
+	}
+}
@@ -0,0 +1,360 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Conflict policies for CopyFrom, for keys whose value on the destination was
+// accepted at a ballot at least as great as the copied value's.
+const (
+	CopySkipNewer = "skip-newer" // keep the destination's value; the default
+	CopyOverwrite = "overwrite"  // replace it
+	CopyFail      = "fail"       // keep it, and stop the copy
+)
+
+// CopyDropped is the outcome of a key which CopyFrom didn't write, because it
+// was absent on the source, or the transform dropped it.
+const CopyDropped = "dropped"
+
+var (
+	// ErrCopyConflict is returned by CopyFrom, with the fail policy, when the
+	// destination has a newer, different value for a key.
+	ErrCopyConflict = errors.New("newer value exists on the destination")
+
+	// ErrInvalidConflictPolicy indicates a conflict policy CopyFrom doesn't
+	// know.
+	ErrInvalidConflictPolicy = errors.New("invalid conflict policy")
+)
+
+// CopyTransform changes a record on its way from the source to the
+// destination. It may change any field, including the key, and drops the
+// record by returning false.
+type CopyTransform func(ctx context.Context, r ImportRecord) (next ImportRecord, keep bool, err error)
+
+// CopyOptions control CopyFrom.
+type CopyOptions struct {
+	Namespace   string        // whose keys are copied; required
+	Transform   CopyTransform // optional
+	Concurrency int           // keys copied at once; zero means one
+	Checkpoint  string        // path of a file of copied keys, to resume from; optional
+	Conflict    string        // policy; empty means CopySkipNewer
+}
+
+// CopySummary counts the outcomes of a CopyFrom.
+type CopySummary struct {
+	ImportSummary
+	Keys    int // in the namespace, on the source
+	Resumed int // already copied, according to the checkpoint
+	Dropped int
+}
+
+// CopyFrom copies every key of a namespace from another cluster, whose
+// proposer is from and whose acceptors are listed, to this one. Keys are
+// enumerated and read from the source acceptors which are Inspecters, so they
+// should be all of them: each key's value, and metadata, is the one with the
+// highest ballot a majority of them accepted, which is its exported ballot.
+// Keys without one are settled by a read through from. Each is passed through
+// the transform, if there is one, and written as by Import, so a copy run
+// again skips values it already copied, and values written on the destination
+// since. Keys copied with the fail policy which already have the same value on
+// the destination aren't conflicts.
+//
+// With a checkpoint, every key which is done, i.e. not failed, is appended to
+// the file, as a line of JSON, and keys already in it are skipped, so an
+// interrupted copy resumes where it stopped. Remove the file to start over.
+//
+// The outcome of each key is written to report, if it's not nil, as a line of
+// JSON, like Import's, under the source key. A failed key doesn't stop the
+// copy. CopyFrom returns an error if the keys can't be listed, the context is
+// canceled, the report or checkpoint can't be written, or, with the fail
+// policy, there's a conflict; keys in flight are finished first.
+func (p *MemoryProposer) CopyFrom(ctx context.Context, from Proposer, acceptors []Acceptor, options CopyOptions, report io.Writer) (CopySummary, error) {
+	var summary CopySummary
+	if options.Namespace == "" || strings.Contains(options.Namespace, namespaceSeparator) {
+		return summary, ErrInvalidNamespace
+	}
+	switch options.Conflict {
+	case "":
+		options.Conflict = CopySkipNewer
+	case CopySkipNewer, CopyOverwrite, CopyFail:
+	default:
+		return summary, ErrInvalidConflictPolicy
+	}
+	if options.Concurrency < 1 {
+		options.Concurrency = 1
+	}
+
+	keys, err := listKeys(ctx, acceptors, NamespaceKey(options.Namespace, ""))
+	if err != nil {
+		return summary, err
+	}
+	summary.Keys = len(keys)
+
+	var checkpoint *json.Encoder
+	if options.Checkpoint != "" {
+		done, err := readCopyCheckpoint(options.Checkpoint)
+		if err != nil {
+			return summary, err
+		}
+		remaining := keys[:0:0]
+		for _, key := range keys {
+			if done[key] {
+				summary.Resumed++
+				continue
+			}
+			remaining = append(remaining, key)
+		}
+		keys = remaining
+
+		f, err := os.OpenFile(options.Checkpoint, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return summary, errors.Wrap(err, "error opening checkpoint")
+		}
+		defer f.Close()
+		checkpoint = json.NewEncoder(f)
+	}
+
+	var enc *json.Encoder
+	if report != nil {
+		enc = json.NewEncoder(report)
+	}
+
+	var (
+		copyCtx, cancel = context.WithCancel(ctx)
+		mtx             sync.Mutex
+		stopErr         error
+		todo            = make(chan string)
+		wg              sync.WaitGroup
+	)
+	defer cancel()
+
+	// finish records the outcome of a key. It's called with the mutex held.
+	finish := func(outcome ImportOutcome, conflict bool) error {
+		switch outcome.Outcome {
+		case ImportImported:
+			summary.Imported++
+		case ImportSkippedNewer:
+			summary.SkippedNewer++
+		case CopyDropped:
+			summary.Dropped++
+		default:
+			summary.Failed++
+		}
+		if enc != nil {
+			if err := enc.Encode(outcome); err != nil {
+				return err
+			}
+		}
+		if conflict {
+			return ErrCopyConflict
+		}
+		if checkpoint != nil && outcome.Outcome != ImportFailed {
+			if err := checkpoint.Encode(outcome.Key); err != nil {
+				return errors.Wrap(err, "error writing checkpoint")
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range todo {
+				if copyCtx.Err() != nil {
+					continue // stopping, so don't count it
+				}
+				outcome, conflict := p.copyKey(copyCtx, from, acceptors, key, options)
+				mtx.Lock()
+				if err := finish(outcome, conflict); err != nil && stopErr == nil {
+					stopErr = err
+					cancel()
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, key := range keys {
+		select {
+		case todo <- key:
+		case <-copyCtx.Done():
+			break feed
+		}
+	}
+	close(todo)
+	wg.Wait()
+
+	if stopErr != nil {
+		return summary, stopErr
+	}
+	return summary, ctx.Err()
+}
+
+// copyKey copies one key, and reports whether it's a conflict under the
+// policy.
+func (p *MemoryProposer) copyKey(ctx context.Context, from Proposer, acceptors []Acceptor, key string, options CopyOptions) (ImportOutcome, bool) {
+	outcome := ImportOutcome{Key: key, Outcome: ImportFailed}
+	r, err := exportRecord(ctx, key, from, acceptors)
+	if err != nil {
+		outcome.Error = err.Error()
+		return outcome, false
+	}
+	if r.Value == nil {
+		outcome.Outcome = CopyDropped
+		return outcome, false
+	}
+
+	if options.Transform != nil {
+		var keep bool
+		if r, keep, err = options.Transform(ctx, r); err != nil {
+			outcome.Error = errors.Wrap(err, "error transforming record").Error()
+			return outcome, false
+		}
+		if !keep {
+			outcome.Outcome = CopyDropped
+			return outcome, false
+		}
+	}
+
+	outcome = p.importRecord(ctx, r, options.Conflict == CopyOverwrite)
+	outcome.Key = key
+	if outcome.Outcome != ImportSkippedNewer || options.Conflict != CopyFail {
+		return outcome, false
+	}
+	current, _, _, err := p.ReadWithMeta(ctx, r.Key)
+	if err != nil {
+		outcome.Outcome, outcome.Error = ImportFailed, errors.Wrap(err, "error reading destination").Error()
+		return outcome, false
+	}
+	return outcome, !equalValues(current, r.Value)
+}
+
+// errUnsettled indicates a key whose source acceptors still don't agree on
+// its value after a read.
+var errUnsettled = errors.New("source acceptors don't agree on the value")
+
+// exportRecord reads key from the source acceptors: the value and metadata
+// with the highest accepted ballot, which becomes the record's ballot, as
+// Import expects. A read through a proposer would accept the value again, at
+// a new ballot, so every copy would look newer than the last. If a majority
+// of the acceptors didn't accept that ballot, the value may not have been
+// chosen, so a read through from settles the key, and the acceptors are
+// inspected again.
+func exportRecord(ctx context.Context, key string, from Proposer, acceptors []Acceptor) (ImportRecord, error) {
+	for settled := false; ; settled = true {
+		r, chosen := inspectRecord(ctx, key, acceptors)
+		if chosen {
+			return r, nil
+		}
+		if settled {
+			return r, errUnsettled
+		}
+		if _, _, _, err := from.ReadWithMeta(ctx, key); err != nil {
+			return r, errors.Wrap(err, "error reading source")
+		}
+	}
+}
+
+// inspectRecord returns the value with the highest ballot accepted by any of
+// the acceptors which are Inspecters, and whether a majority of the acceptors
+// accepted it. Acceptors which fail, or aren't Inspecters, don't count. The
+// value comes from an acceptor which isn't a witness, as witnesses have none.
+func inspectRecord(ctx context.Context, key string, acceptors []Acceptor) (r ImportRecord, chosen bool) {
+	type result struct {
+		inspection Inspection
+		witness    bool
+	}
+	var results []result
+	for _, acceptor := range acceptors {
+		inspecter, ok := acceptor.(Inspecter)
+		if !ok {
+			continue
+		}
+		inspection, err := inspecter.Inspect(ctx, key)
+		if err != nil {
+			continue
+		}
+		results = append(results, result{inspection, roleOf(acceptor) == RoleWitness})
+		if inspection.Accepted.greaterThan(r.Ballot) {
+			r.Ballot = inspection.Accepted
+		}
+	}
+	if r.Ballot.isZero() {
+		return r, false
+	}
+
+	var agreeing int
+	for _, res := range results {
+		if res.inspection.Accepted != r.Ballot {
+			continue
+		}
+		agreeing++
+		if !res.witness {
+			r.Key, r.Value, r.Meta, chosen = key, res.inspection.Value, res.inspection.Meta, true
+		}
+	}
+	return r, chosen && agreeing > len(acceptors)/2
+}
+
+// readCopyCheckpoint returns the keys in the checkpoint file, if there is one.
+// A partial last line, from an interrupted write, is ignored, so its key is
+// copied again.
+func readCopyCheckpoint(path string) (map[string]bool, error) {
+	done := map[string]bool{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening checkpoint")
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var key string
+		if err := dec.Decode(&key); err != nil {
+			return done, nil
+		}
+		done[key] = true
+	}
+}
+
+// CommandTransform returns a CopyTransform which runs the named command once
+// per record, with the record as a line of JSON on its standard input, and
+// reads the transformed record from its standard output, in the same form.
+// Empty output drops the record, and a command which exits with an error
+// fails it.
+func CommandTransform(name string, args ...string) CopyTransform {
+	return func(ctx context.Context, r ImportRecord) (ImportRecord, bool, error) {
+		in, err := json.Marshal(r)
+		if err != nil {
+			return r, false, err
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdin = bytes.NewReader(append(in, '\n'))
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return r, false, errors.Wrapf(err, "%s: %s", name, bytes.TrimSpace(stderr.Bytes()))
+		}
+		if out = bytes.TrimSpace(out); len(out) == 0 {
+			return r, false, nil
+		}
+		var next ImportRecord
+		if err := json.Unmarshal(out, &next); err != nil {
+			return r, false, errors.Wrapf(err, "%s: invalid output", name)
+		}
+		return next, true, nil
+	}
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestCopyFrom(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		s1     = NewMemoryAcceptor("s1", log.With(logger, "a", "s1"))
+		s2     = NewMemoryAcceptor("s2", log.With(logger, "a", "s2"))
+		s3     = NewMemoryAcceptor("s3", log.With(logger, "a", "s3"))
+		source = []Acceptor{s1, s2, s3}
+		from   = NewMemoryProposer("s", log.With(logger, "p", "s"), source...)
+		to     = NewMemoryProposer("d", log.With(logger, "p", "d"),
+			NewMemoryAcceptor("d1", log.With(logger, "a", "d1")),
+			NewMemoryAcceptor("d2", log.With(logger, "a", "d2")),
+			NewMemoryAcceptor("d3", log.With(logger, "a", "d3")),
+		)
+		ctx = context.Background()
+	)
+
+	dir, err := ioutil.TempDir("", "caspaxos-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "checkpoint")
+
+	if _, _, err := from.ProposeWithMeta(ctx, "team-x/a", changeFuncInitializeOnlyOnce("1"), Metadata{"writer": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"team-x/b": "2", "team-x/drop": "3", "team-y/c": "4"} {
+		if _, _, err := from.Propose(ctx, key, changeFuncInitializeOnlyOnce(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := from.Propose(ctx, "team-x/gone", func([]byte) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination has a newer, different value for b.
+	if _, err := to.Import(ctx, []ImportRecord{{Key: "team-x/b", Value: []byte("newer"), Ballot: Ballot{Counter: 1000, ID: "elsewhere"}}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	drop := func(_ context.Context, r ImportRecord) (ImportRecord, bool, error) {
+		return r, r.Key != "team-x/drop", nil
+	}
+
+	// With the fail policy, the copy stops at b, having copied a.
+	summary, err := to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x", Transform: drop, Checkpoint: checkpoint, Conflict: CopyFail}, nil)
+	if want, have := ErrCopyConflict, err; want != have {
+		t.Fatalf("fail: want %v, have %v", want, have)
+	}
+	if want, have := (CopySummary{Keys: 4, ImportSummary: ImportSummary{Imported: 1, SkippedNewer: 1}}), summary; want != have {
+		t.Errorf("fail: want %+v, have %+v", want, have)
+	}
+
+	// Skipping newer values, the copy resumes after a.
+	summary, err = to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x", Transform: drop, Checkpoint: checkpoint, Concurrency: 4}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (CopySummary{Keys: 4, Resumed: 1, Dropped: 2, ImportSummary: ImportSummary{SkippedNewer: 1}}), summary; want != have {
+		t.Errorf("skip-newer: want %+v, have %+v", want, have)
+	}
+
+	// Overwriting, without the transform or checkpoint, everything present is
+	// copied.
+	summary, err = to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x", Conflict: CopyOverwrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (CopySummary{Keys: 4, Dropped: 1, ImportSummary: ImportSummary{Imported: 3}}), summary; want != have {
+		t.Errorf("overwrite: want %+v, have %+v", want, have)
+	}
+	for key, want := range map[string]string{"team-x/a": "1", "team-x/b": "2", "team-x/drop": "3", "team-y/c": ""} {
+		state, meta, _, err := to.ReadWithMeta(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := string(state); want != have {
+			t.Errorf("%s: want %q, have %q", key, want, have)
+		}
+		if key == "team-x/a" && meta["writer"] != "x" {
+			t.Errorf("%s: want the metadata copied, have %v", key, meta)
+		}
+	}
+
+	// Values which are already the same aren't conflicts.
+	if _, err := to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x", Conflict: CopyFail}, nil); err != nil {
+		t.Errorf("fail, again: want no error, have %v", err)
+	}
+
+	// The source's proposer moves on, past the destination's ballots, which
+	// doesn't make the values it already has any newer, so a write on the
+	// destination since the copy survives another.
+	if _, err := from.Import(ctx, []ImportRecord{{Key: "team-z/other", Value: []byte("5"), Ballot: Ballot{Counter: 5000, ID: "elsewhere"}}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := to.Propose(ctx, "team-x/a", func([]byte) []byte { return []byte("local") }); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (CopySummary{Keys: 4, Dropped: 1, ImportSummary: ImportSummary{SkippedNewer: 3}}), summary; want != have {
+		t.Errorf("again: want %+v, have %+v", want, have)
+	}
+	if state, _, err := to.Propose(ctx, "team-x/a", changeFuncRead); err != nil || string(state) != "local" {
+		t.Errorf("destination write: want it kept, have %q (%v)", state, err)
+	}
+
+	if _, err := to.CopyFrom(ctx, from, source, CopyOptions{Namespace: "team-x", Conflict: "sometimes"}, nil); err != ErrInvalidConflictPolicy {
+		t.Errorf("invalid policy: want %v, have %v", ErrInvalidConflictPolicy, err)
+	}
+}
+
+func TestCommandTransform(t *testing.T) {
+	for _, name := range []string{"cat", "true", "false"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s: %v", name, err)
+		}
+	}
+	var (
+		ctx = context.Background()
+		r   = ImportRecord{Key: "k", Value: []byte("v"), Meta: Metadata{"m": "1"}, Ballot: Ballot{Counter: 2, ID: "x"}}
+	)
+
+	next, keep, err := CommandTransform("cat")(ctx, r)
+	if err != nil || !keep {
+		t.Fatalf("cat: want the record kept, have %v (%v)", keep, err)
+	}
+	if next.Key != r.Key || string(next.Value) != string(r.Value) || next.Meta["m"] != "1" || next.Ballot != r.Ballot {
+		t.Errorf("cat: want %+v, have %+v", r, next)
+	}
+	if _, keep, err := CommandTransform("true")(ctx, r); err != nil || keep {
+		t.Errorf("true: want the record dropped, have %v (%v)", keep, err)
+	}
+	if _, _, err := CommandTransform("false")(ctx, r); err == nil {
+		t.Errorf("false: want an error, have none")
+	}
+}
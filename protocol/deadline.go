@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DeadlineError is returned by a proposal whose context deadline passed
+// before it could complete. Phase is the phase which was in progress, or about
+// to start. After a deadline in the accept phase, the change may or may not
+// have been made.
+type DeadlineError struct {
+	Phase   string        // "prepare" or "accept"
+	Budget  time.Duration // the time the phase had
+	Elapsed time.Duration // the time since the proposal began
+}
+
+func (de DeadlineError) Error() string {
+	return fmt.Sprintf("deadline exceeded in %s phase (budget %s, elapsed %s)", de.Phase, de.Budget, de.Elapsed)
+}
+
+// Unwrap returns context.DeadlineExceeded, so errors.Is works.
+func (de DeadlineError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout returns true.
+func (de DeadlineError) Timeout() bool {
+	return true
+}
+
+// WithPrepareBudget limits the prepare phase of each proposal to the given
+// fraction of the time remaining until the context's deadline, if it has one,
+// so the accept phase is left with the rest. Without it, a slow prepare phase
+// can use up the whole budget, and the round fails having done nothing but
+// make promises. Acceptors see each phase's deadline on the context, so they
+// can abandon requests which can no longer be useful.
+func WithPrepareBudget(fraction float64) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.prepareBudget = fraction }
+}
+
+// prepareContext returns the context for the prepare phase of a round.
+func (p *MemoryProposer) prepareContext(ctx context.Context, begin time.Time) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || p.prepareBudget <= 0 || p.prepareBudget >= 1 {
+		return context.WithCancel(ctx)
+	}
+	share := time.Duration(float64(deadline.Sub(begin)) * p.prepareBudget)
+	return context.WithDeadline(ctx, begin.Add(share))
+}
+
+// deadlineTimer returns a channel which receives when the context's deadline
+// passes, and a func which releases it. Unlike ctx.Done, it ignores
+// cancellation, which is handled separately. If the context has no deadline,
+// the channel is nil.
+func deadlineTimer(ctx context.Context) (<-chan time.Time, func()) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(deadline))
+	return t.C, func() { t.Stop() }
+}
+
+// deadlineError returns the DeadlineError for phase, which began at since, in
+// a proposal which began at begin. A phase which never started had no budget.
+func deadlineError(ctx context.Context, phase string, begin, since time.Time) DeadlineError {
+	deadline, _ := ctx.Deadline()
+	budget := deadline.Sub(since)
+	if budget < 0 {
+		budget = 0
+	}
+	return DeadlineError{Phase: phase, Budget: budget, Elapsed: time.Since(begin)}
+}
@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAcceptorDeadline(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a      = NewMemoryAcceptor("1", logger)
+		b      = Ballot{Counter: 1, ID: "p"}
+		key    = "k"
+	)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	defer cancel()
+
+	if _, _, _, _, err := a.Prepare(ctx, key, Age{ID: "p"}, 0, b); err != context.DeadlineExceeded {
+		t.Errorf("prepare: want %v, have %v", context.DeadlineExceeded, err)
+	}
+	if err := a.Accept(ctx, key, Age{ID: "p"}, b, []byte("x"), checksumOf([]byte("x")), nil); err != context.DeadlineExceeded {
+		t.Errorf("accept: want %v, have %v", context.DeadlineExceeded, err)
+	}
+	if _, ok := a.values.get(key); ok {
+		t.Errorf("expired requests changed the acceptor's state")
+	}
+}
+
+func TestProposeDeadline(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		prepare time.Duration // how long each prepare takes
+		accept  time.Duration // how long each accept takes
+		budget  float64       // share of the deadline for the prepare phase
+		phase   string        // that ran out of time, or empty for success
+	}{
+		{"slow prepare", time.Second, 0, 0, "prepare"},
+		{"slow accept", 0, time.Second, 0, "accept"},
+		{"prepare within deadline", 100 * time.Millisecond, 0, 0, ""},
+		{"prepare exceeds its share", 100 * time.Millisecond, 0, 0.25, "prepare"},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				logger    = log.NewLogfmtLogger(newTestWriter(t))
+				acceptors []Acceptor
+			)
+			for _, id := range []string{"1", "2", "3"} {
+				a := NewMemoryAcceptor(id, log.With(logger, "a", id))
+				acceptors = append(acceptors, slowAcceptor{a, testcase.prepare, testcase.accept})
+			}
+			var (
+				p           = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), acceptors, WithPrepareBudget(testcase.budget))
+				timeout     = 400 * time.Millisecond
+				ctx, cancel = context.WithTimeout(context.Background(), timeout)
+			)
+			defer cancel()
+
+			begin := time.Now()
+			_, _, err := p.Propose(ctx, "k", changeFuncInitializeOnlyOnce("x"))
+			took := time.Since(begin)
+
+			if testcase.phase == "" {
+				if err != nil {
+					t.Fatalf("want success, have %v", err)
+				}
+				return
+			}
+			var de DeadlineError
+			if !errors.As(err, &de) {
+				t.Fatalf("want DeadlineError, have %v", err)
+			}
+			if want, have := testcase.phase, de.Phase; want != have {
+				t.Errorf("phase: want %s, have %s (%v)", want, have, err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%v isn't context.DeadlineExceeded", err)
+			}
+			if want, have := FailureTimeout, ClassifyFailure(err); want != have {
+				t.Errorf("class: want %s, have %s", want, have)
+			}
+			if took > timeout+100*time.Millisecond { // the acceptors are slower than that
+				t.Errorf("proposal waited for stuck acceptors: took %s", took)
+			}
+		})
+	}
+}
+
+// slowAcceptor takes the given time to respond to each prepare and accept,
+// and ignores the context while it does, like a stuck server.
+type slowAcceptor struct {
+	*MemoryAcceptor
+	prepare time.Duration
+	accept  time.Duration
+}
+
+func (a slowAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	time.Sleep(a.prepare)
+	return a.MemoryAcceptor.Prepare(ctx, key, age, epoch, b)
+}
+
+func (a slowAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	time.Sleep(a.accept)
+	return a.MemoryAcceptor.Accept(ctx, key, age, b, value, sum, meta)
+}
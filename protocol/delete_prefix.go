@@ -0,0 +1,283 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrNoInspecters indicates a bulk delete without any acceptors that can list
+// their keys.
+var ErrNoInspecters = errors.New("no acceptor can list its keys")
+
+// deletePrefixCheckpointKey is the reserved key which stores the progress of
+// the bulk delete of a prefix. Keys beginning with \x00, like it, are never
+// deleted by prefix.
+func deletePrefixCheckpointKey(prefix string) string {
+	return "\x00delete-prefix/" + prefix
+}
+
+// DeletePrefixOptions control a DeletePrefixJob.
+type DeletePrefixOptions struct {
+	Rate       float64 // keys deleted per second; zero means no limit
+	DryRun     bool    // only count the matching keys
+	MaxRescans int     // extra passes, to delete keys written during the job
+}
+
+// DeletePrefixProgress is the state of a DeletePrefixJob.
+type DeletePrefixProgress struct {
+	Prefix  string            `json:"prefix"`
+	DryRun  bool              `json:"dry_run"`
+	Matched int               `json:"matched"` // keys found by the latest pass
+	Deleted int               `json:"deleted"`
+	Failed  map[string]string `json:"failed,omitempty"` // error by key, from the latest pass
+	Rescans int               `json:"rescans"`
+	Done    bool              `json:"done"`
+}
+
+// deletePrefixCheckpoint is what's stored under the checkpoint key.
+type deletePrefixCheckpoint struct {
+	Pass    int    `json:"pass"`
+	After   string `json:"after"` // every key up to and including this one was handled in this pass
+	Deleted int    `json:"deleted"`
+}
+
+// DeletePrefixJob deletes every key with a given prefix, one at a time, each
+// via the full GarbageCollect protocol, at a limited rate, so a large delete
+// doesn't overwhelm the cluster.
+//
+// Keys are enumerated from the acceptors which are Inspecters, and handled in
+// sorted order. After each key, the job checkpoints its position on a reserved
+// key, so a job which is restarted, with the same prefix, resumes where the
+// previous one stopped. The checkpoint is removed when the job completes.
+//
+// Keys may be written under the prefix while the job runs. The policy is to
+// delete them too: after each pass, the job enumerates the keys again, and if
+// any remain, including keys which failed to delete, it makes another pass,
+// up to MaxRescans times. Keys left after that are reported as failed. A key
+// written between its tombstone and its collection isn't lost: collection
+// fails, and the key is tried again in the next pass, if there is one.
+type DeletePrefixJob struct {
+	prefix    string
+	options   DeletePrefixOptions
+	proposers []Proposer
+	acceptors []Acceptor
+	logger    log.Logger
+
+	mtx      sync.Mutex
+	progress DeletePrefixProgress
+}
+
+// NewDeletePrefixJob returns a job which deletes every key with prefix. Like
+// GarbageCollect, it needs every proposer and every acceptor in the cluster.
+func NewDeletePrefixJob(prefix string, options DeletePrefixOptions, proposers []Proposer, acceptors []Acceptor, logger log.Logger) *DeletePrefixJob {
+	return &DeletePrefixJob{
+		prefix:    prefix,
+		options:   options,
+		proposers: proposers,
+		acceptors: acceptors,
+		logger:    logger,
+		progress:  DeletePrefixProgress{Prefix: prefix, DryRun: options.DryRun},
+	}
+}
+
+// Progress returns the current state of the job.
+func (j *DeletePrefixJob) Progress() DeletePrefixProgress {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	progress := j.progress
+	progress.Failed = make(map[string]string, len(j.progress.Failed))
+	for key, err := range j.progress.Failed {
+		progress.Failed[key] = err
+	}
+	return progress
+}
+
+// Run runs the job to completion, or until the context is canceled. Failures
+// to delete individual keys are reported in the progress, not returned; an
+// error means the job stopped, and can be resumed by running it again.
+func (j *DeletePrefixJob) Run(ctx context.Context) error {
+	checkpoint, err := j.readCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	j.update(func(p *DeletePrefixProgress) {
+		p.Rescans, p.Deleted = checkpoint.Pass, checkpoint.Deleted
+	})
+
+	var interval time.Duration
+	if j.options.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / j.options.Rate)
+	}
+
+	for ; checkpoint.Pass <= j.options.MaxRescans; checkpoint.Pass, checkpoint.After = checkpoint.Pass+1, "" {
+		keys, err := j.keys(ctx)
+		if err != nil {
+			return err
+		}
+		j.update(func(p *DeletePrefixProgress) {
+			p.Rescans = checkpoint.Pass
+			p.Matched = len(keys)
+			p.Failed = map[string]string{}
+		})
+		if j.options.DryRun {
+			break
+		}
+
+		var remaining []string
+		for _, key := range keys {
+			if key > checkpoint.After {
+				remaining = append(remaining, key)
+			}
+		}
+		if len(remaining) == 0 && checkpoint.After == "" {
+			break // nothing left
+		}
+
+		for _, key := range remaining {
+			if err := wait(ctx, interval); err != nil {
+				return err
+			}
+			if err := j.delete(ctx, key); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				level.Warn(j.logger).Log("method", "Run", "key", key, "pass", checkpoint.Pass, "err", err)
+				j.update(func(p *DeletePrefixProgress) { p.Failed[key] = err.Error() })
+			} else {
+				checkpoint.Deleted++
+				j.update(func(p *DeletePrefixProgress) { p.Deleted++ })
+			}
+			checkpoint.After = key
+			if err := j.writeCheckpoint(ctx, &checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !j.options.DryRun {
+		if err := j.removeCheckpoint(ctx); err != nil {
+			return err
+		}
+	}
+	j.update(func(p *DeletePrefixProgress) { p.Done = true })
+	return nil
+}
+
+// keys returns every key with the prefix, from every Inspecter, sorted.
+func (j *DeletePrefixJob) keys(ctx context.Context) ([]string, error) {
+	return listKeys(ctx, j.acceptors, j.prefix)
+}
+
+// listKeys returns every key with the prefix, from every acceptor which is an
+// Inspecter, sorted. Reserved keys, beginning with \x00, are left out.
+func listKeys(ctx context.Context, acceptors []Acceptor, prefix string) ([]string, error) {
+	var (
+		found      = map[string]bool{}
+		inspecters int
+	)
+	for _, acceptor := range acceptors {
+		inspecter, ok := acceptor.(Inspecter)
+		if !ok {
+			continue
+		}
+		inspecters++
+		keys, err := inspecter.Keys(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing keys of %s", inspecter.Address())
+		}
+		for _, key := range keys {
+			if key != zerokey && !strings.HasPrefix(key, "\x00") && strings.HasPrefix(key, prefix) {
+				found[key] = true
+			}
+		}
+	}
+	if inspecters == 0 {
+		return nil, ErrNoInspecters
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// delete writes a tombstone for key, and collects it.
+func (j *DeletePrefixJob) delete(ctx context.Context, key string) error {
+	tombstone := func([]byte) []byte { return nil }
+	state, b, err := j.proposers[0].Propose(ctx, key, tombstone)
+	if err != nil {
+		return errors.Wrap(err, "error writing tombstone")
+	}
+	t := Tombstone{Ballot: b, State: state}
+	return GarbageCollect(ctx, key, t, j.proposers, j.acceptors, log.With(j.logger, "key", key))
+}
+
+func (j *DeletePrefixJob) readCheckpoint(ctx context.Context) (deletePrefixCheckpoint, error) {
+	var checkpoint deletePrefixCheckpoint
+	identity := func(x []byte) []byte { return x }
+	state, _, err := j.proposers[0].Propose(ctx, deletePrefixCheckpointKey(j.prefix), identity)
+	if err != nil {
+		return checkpoint, errors.Wrap(err, "error reading checkpoint")
+	}
+	if state == nil {
+		return checkpoint, nil
+	}
+	if err := json.Unmarshal(state, &checkpoint); err != nil {
+		return checkpoint, errors.Wrap(err, "error decoding checkpoint")
+	}
+	return checkpoint, nil
+}
+
+func (j *DeletePrefixJob) writeCheckpoint(ctx context.Context, checkpoint *deletePrefixCheckpoint) error {
+	buf, _ := json.Marshal(checkpoint) // can't fail
+	set := func([]byte) []byte { return buf }
+	if _, _, err := j.proposers[0].Propose(ctx, deletePrefixCheckpointKey(j.prefix), set); err != nil {
+		return errors.Wrap(err, "error writing checkpoint")
+	}
+	return nil
+}
+
+// removeCheckpoint deletes the checkpoint, so the next job with the same
+// prefix starts from the beginning. If collection fails, the checkpoint is
+// still absent, just not collected.
+func (j *DeletePrefixJob) removeCheckpoint(ctx context.Context) error {
+	key := deletePrefixCheckpointKey(j.prefix)
+	if err := j.delete(ctx, key); err != nil {
+		if !IsRetryable(err) {
+			return errors.Wrap(err, "error removing checkpoint")
+		}
+		level.Warn(j.logger).Log("method", "Run", "key", key, "err", err)
+	}
+	return nil
+}
+
+func (j *DeletePrefixJob) update(f func(*DeletePrefixProgress)) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	f(&j.progress)
+}
+
+// wait waits for d, or until the context is canceled.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1        = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2        = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		proposers = []Proposer{p1, p2}
+		acceptors = []Acceptor{a1, a2, a3}
+		ctx       = context.Background()
+	)
+	for i := 0; i < 10; i++ {
+		for _, prefix := range []string{"ns/", "other/"} {
+			key := fmt.Sprintf("%s%02d", prefix, i)
+			if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce("x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// A dry run only counts.
+	dry := NewDeletePrefixJob("ns/", DeletePrefixOptions{DryRun: true}, proposers, acceptors, logger)
+	if err := dry.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (DeletePrefixProgress{Prefix: "ns/", DryRun: true, Matched: 10, Failed: map[string]string{}, Done: true}), dry.Progress(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("dry run: want %+v, have %+v", want, have)
+	}
+	if want, have := 10, countKeys(t, a1, "ns/"); want != have {
+		t.Fatalf("after dry run: want %d ns/ keys, have %d", want, have)
+	}
+
+	// A real run deletes, at the given rate.
+	var (
+		rate  = 200.0
+		job   = NewDeletePrefixJob("ns/", DeletePrefixOptions{Rate: rate, MaxRescans: 1}, proposers, acceptors, logger)
+		begin = time.Now()
+	)
+	if err := job.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if min, took := time.Duration(10/rate*float64(time.Second)), time.Since(begin); took < min {
+		t.Errorf("rate limit: want at least %s, took %s", min, took)
+	}
+	if want, have := (DeletePrefixProgress{Prefix: "ns/", Matched: 0, Deleted: 10, Failed: map[string]string{}, Rescans: 1, Done: true}), job.Progress(); !reflect.DeepEqual(want, have) {
+		t.Errorf("run: want %+v, have %+v", want, have)
+	}
+	for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+		if want, have := 0, countKeys(t, a, "ns/"); want != have {
+			t.Errorf("%s: want %d ns/ keys, have %d", a.Address(), want, have)
+		}
+		if want, have := 10, countKeys(t, a, "other/"); want != have {
+			t.Errorf("%s: want %d other/ keys, have %d", a.Address(), want, have)
+		}
+		if want, have := 0, countKeys(t, a, "\x00"); want != have {
+			t.Errorf("%s: want no checkpoint, have %d reserved keys", a.Address(), have)
+		}
+	}
+}
+
+func TestDeletePrefixResume(t *testing.T) {
+	for _, testcase := range []struct {
+		maxRescans int
+		remaining  int
+	}{
+		{0, 1}, // the key behind the checkpoint is left
+		{1, 0}, // the rescan deletes it
+	} {
+		t.Run(fmt.Sprintf("rescans %d", testcase.maxRescans), func(t *testing.T) {
+			var (
+				logger    = log.NewLogfmtLogger(newTestWriter(t))
+				a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+				a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+				a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+				p1        = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+				proposers = []Proposer{p1}
+				acceptors = []Acceptor{a1, a2, a3}
+				ctx       = context.Background()
+			)
+
+			// A previous job deleted ns/00 to ns/04, and stopped. Meanwhile,
+			// ns/02 was written again.
+			buf, _ := json.Marshal(deletePrefixCheckpoint{After: "ns/04", Deleted: 5})
+			if _, _, err := p1.Propose(ctx, deletePrefixCheckpointKey("ns/"), func([]byte) []byte { return buf }); err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range []int{2, 5, 6, 7, 8, 9} {
+				key := fmt.Sprintf("ns/%02d", i)
+				if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce("x")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			job := NewDeletePrefixJob("ns/", DeletePrefixOptions{MaxRescans: testcase.maxRescans}, proposers, acceptors, logger)
+			if err := job.Run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if want, have := 10+1-testcase.remaining, job.Progress().Deleted; want != have {
+				t.Errorf("deleted: want %d, have %d", want, have)
+			}
+			if want, have := testcase.remaining, countKeys(t, a1, "ns/"); want != have {
+				t.Errorf("remaining: want %d, have %d", want, have)
+			}
+
+			// The checkpoint is gone, so the next job starts from scratch.
+			if want, have := 0, countKeys(t, a1, "\x00"); want != have {
+				t.Errorf("want no checkpoint, have %d reserved keys", have)
+			}
+		})
+	}
+}
+
+func countKeys(t *testing.T, a *MemoryAcceptor, prefix string) int {
+	t.Helper()
+	keys, err := a.Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, key := range keys {
+		if key != zerokey && strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ConflictReport is an analysis of the conflicts a proposer has seen, built
+// from its key stats.
+type ConflictReport struct {
+	Keys              []KeyStats        // the most conflicted keys, most first
+	Competitors       map[string]uint64 // conflicts by competing proposer ID, over all keys
+	AverageRetryDepth float64           // retries per proposal, over all keys
+	Suggestions       []string          // for humans
+}
+
+// suggestConflictRate is the fraction of proposals on a key that must conflict
+// before DiagnoseConflicts suggests doing something about it.
+const suggestConflictRate = 0.1
+
+// DiagnoseConflicts reports on the conflicts seen by this proposer, with up to
+// n of the most conflicted keys, and suggestions for reducing contention. It
+// requires WithKeyStats; without it, the report is empty. The report only
+// covers keys still tracked, and ResetKeyStats starts it over.
+func (p *MemoryProposer) DiagnoseConflicts(n int) ConflictReport {
+	var (
+		all      = p.keyStats.top(-1, ByConflicts)
+		report   = ConflictReport{Competitors: map[string]uint64{}}
+		calls    uint64
+		retries  uint64
+		affected []KeyStats
+	)
+	for _, s := range all {
+		calls += s.Proposals - s.Retries
+		retries += s.Retries
+		for id, count := range s.Competitors {
+			report.Competitors[id] += count
+		}
+		if s.Conflicts > 0 {
+			affected = append(affected, s)
+		}
+	}
+	if calls > 0 {
+		report.AverageRetryDepth = float64(retries) / float64(calls)
+	}
+	if n >= 0 && n < len(affected) {
+		affected = affected[:n]
+	}
+	report.Keys = affected
+
+	for _, s := range affected {
+		rate := float64(s.Conflicts) / float64(s.Proposals)
+		if rate < suggestConflictRate {
+			continue
+		}
+		ids := make([]string, 0, len(s.Competitors))
+		for id := range s.Competitors {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		report.Suggestions = append(report.Suggestions, fmt.Sprintf(
+			"%.0f%% of proposals on key %q conflict with other proposers (%s); consider routing writes for this key to one proposer, or enabling coalescing",
+			100*rate, s.Key, strings.Join(ids, ", "),
+		))
+	}
+	return report
+}
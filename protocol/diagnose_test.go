@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDiagnoseConflicts(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithKeyStats(10))
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// One quiet key, and one where p2 gets ahead of p1, so p1 conflicts once
+	// and retries.
+	if _, _, err := p1.Propose(ctx, "quiet", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		p2.Propose(ctx, "contended", changeFuncRead)
+	}
+	if _, _, err := p1.Propose(ctx, "contended", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+
+	report := p1.DiagnoseConflicts(10)
+	if want, have := 1, len(report.Keys); want != have {
+		t.Fatalf("keys: want %d, have %d (%+v)", want, have, report.Keys)
+	}
+	if want, have := "contended", report.Keys[0].Key; want != have {
+		t.Errorf("key: want %q, have %q", want, have)
+	}
+	if want, have := uint64(1), report.Competitors["2"]; want != have {
+		t.Errorf("conflicts with proposer 2: want %d, have %d (%v)", want, have, report.Competitors)
+	}
+	if want, have := 0.5, report.AverageRetryDepth; want != have {
+		t.Errorf("average retry depth: want %v, have %v", want, have)
+	}
+	if want, have := 1, len(report.Suggestions); want != have {
+		t.Fatalf("suggestions: want %d, have %d", want, have)
+	}
+	if s := report.Suggestions[0]; !strings.Contains(s, `"contended"`) || !strings.Contains(s, "(2)") {
+		t.Errorf("suggestion doesn't name the key and competitor: %s", s)
+	}
+
+	// Reset starts the report over.
+	p1.ResetKeyStats()
+	if report := p1.DiagnoseConflicts(10); len(report.Keys) != 0 || len(report.Suggestions) != 0 {
+		t.Errorf("after reset: want an empty report, have %+v", report)
+	}
+}
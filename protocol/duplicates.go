@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// identifyTimeout bounds the node ID requests made while adding an acceptor.
+const identifyTimeout = time.Second
+
+// DuplicateAcceptorError is returned when an acceptor being added reports the
+// same node ID as one already registered at a different address, e.g. because
+// it's reachable under two host names. Counting it twice would silently weaken
+// the quorum math, so it's refused. DuplicateAcceptors reports the same thing
+// for acceptors which are already registered.
+type DuplicateAcceptorError struct {
+	NodeID    string
+	Existing  string // address
+	Duplicate string // address
+}
+
+func (dae DuplicateAcceptorError) Error() string {
+	return fmt.Sprintf("acceptor %s at %s is already registered at %s", dae.NodeID, dae.Duplicate, dae.Existing)
+}
+
+// checkDuplicate returns a DuplicateAcceptorError if target has the node ID of
+// an acceptor registered at another address, in either role. Acceptors which
+// can't be identified, e.g. because they're down, are given the benefit of the
+// doubt, so the cluster can still be changed; DuplicateAcceptors catches them
+// later. It must be called with the mutex held.
+func (p *MemoryProposer) checkDuplicate(target Acceptor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), identifyTimeout)
+	defer cancel()
+
+	id, err := target.NodeID(ctx)
+	if err != nil {
+		level.Debug(p.logger).Log("method", "checkDuplicate", "addr", target.Address(), "err", err)
+		return nil
+	}
+	for addr, existing := range p.acceptors() {
+		if addr == target.Address() {
+			continue
+		}
+		if existingID, err := existing.NodeID(ctx); err == nil && existingID == id {
+			return DuplicateAcceptorError{NodeID: id, Existing: addr, Duplicate: target.Address()}
+		}
+	}
+	return nil
+}
+
+// DuplicateAcceptors returns every registered acceptor which reports the same
+// node ID as another registered at a different address, e.g. because it was
+// added before it could be identified. For each node ID, the acceptor with
+// the lowest address is taken to be the original. Acceptors which can't be
+// identified are skipped.
+func (p *MemoryProposer) DuplicateAcceptors(ctx context.Context) []DuplicateAcceptorError {
+	p.mtx.Lock()
+	targets := p.acceptors()
+	p.mtx.Unlock()
+
+	byID := map[string][]string{}
+	for addr, target := range targets {
+		id, err := target.NodeID(ctx)
+		if err != nil {
+			level.Debug(p.logger).Log("method", "DuplicateAcceptors", "addr", addr, "err", err)
+			continue
+		}
+		byID[id] = append(byID[id], addr)
+	}
+
+	var duplicates []DuplicateAcceptorError
+	for id, addrs := range byID {
+		sort.Strings(addrs)
+		for _, addr := range addrs[1:] {
+			duplicates = append(duplicates, DuplicateAcceptorError{NodeID: id, Existing: addrs[0], Duplicate: addr})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Duplicate < duplicates[j].Duplicate })
+	return duplicates
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDuplicateAcceptor(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		alias  = NewMemoryAcceptor("1.internal", log.With(logger, "a", 1), WithNodeID("1"))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// The same acceptor under another name is refused, in either role.
+	want := DuplicateAcceptorError{NodeID: "1", Existing: "1", Duplicate: "1.internal"}
+	if have := p.AddAccepter(alias); have != want {
+		t.Errorf("AddAccepter: want %v, have %v", want, have)
+	}
+	if have := p.AddPreparer(alias); have != want {
+		t.Errorf("AddPreparer: want %v, have %v", want, have)
+	}
+	if want, have := []string{"1", "2", "3"}, p.Configuration().Accepters; !reflect.DeepEqual(want, have) {
+		t.Errorf("accepters: want %v, have %v", want, have)
+	}
+	if have := p.DuplicateAcceptors(ctx); len(have) != 0 {
+		t.Errorf("DuplicateAcceptors: want none, have %v", have)
+	}
+
+	// A new acceptor is fine, as is the same address in the other role.
+	a4 := NewMemoryAcceptor("4", log.With(logger, "a", 4))
+	if err := p.AddAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(a4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicates that got in some other way are found by the audit.
+	dup := NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, alias)
+	if want, have := []DuplicateAcceptorError{want}, dup.DuplicateAcceptors(ctx); !reflect.DeepEqual(want, have) {
+		t.Errorf("DuplicateAcceptors: want %v, have %v", want, have)
+	}
+	check := NewConfigurationMonitor(dup, nil, nil, logger).Check(ctx)
+	if want, have := []DuplicateAcceptorError{want}, check.Duplicates; !reflect.DeepEqual(want, have) {
+		t.Errorf("Check: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+
+	"github.com/pkg/errors"
+)
+
+// The configuration epoch is a counter, stored as the value of the zero key,
+// which is incremented by every successful configuration change. Proposers
+// with epoch fencing enabled include their known epoch in every prepare
+// message, and acceptors reject prepares carrying an epoch older than the
+// newest one they've seen. That way, a proposer which missed a configuration
+// change, and whose quorum math is therefore wrong, finds out about it instead
+// of carrying on regardless.
+//
+// The zero epoch means "not participating", and is never rejected. This allows
+// proposers with and without epoch fencing to coexist during a rolling upgrade.
+// The initial configuration, before any changes, is epoch 1.
+const initialEpoch = 1
+
+func encodeEpoch(epoch uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, epoch)
+	return buf
+}
+
+func decodeEpoch(buf []byte) uint64 {
+	if len(buf) != 8 {
+		return initialEpoch // includes the never-written case
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+func incrementEpoch(current []byte) []byte {
+	return encodeEpoch(decodeEpoch(current) + 1)
+}
+
+// bumpEpoch increments the configuration epoch, and informs the proposers.
+func bumpEpoch(ctx context.Context, proposers []Proposer) error {
+	proposer := proposers[rand.Intn(len(proposers))]
+	state, _, err := proposer.Propose(ctx, zerokey, incrementEpoch)
+	if err != nil {
+		return errors.Wrap(err, "error incrementing epoch")
+	}
+	epoch := decodeEpoch(state)
+	for _, proposer := range proposers {
+		proposer.FastForwardEpoch(epoch)
+	}
+	return nil
+}
+
+// StaleConfigurationError is returned by acceptors when a prepare message
+// carries an epoch older than the newest one the acceptor has seen. The
+// proposer has missed at least one configuration change, and should refresh
+// its configuration before trying again.
+type StaleConfigurationError struct {
+	Incoming uint64
+	Current  uint64
+}
+
+func (sce StaleConfigurationError) Error() string {
+	return fmt.Sprintf("stale configuration: incoming epoch %d is older than current epoch %d", sce.Incoming, sce.Current)
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestEpochFencing(t *testing.T) {
+	// Build the cluster. The third proposer will miss a configuration change.
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithEpochFencing())
+		p2     = NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), []Acceptor{a1, a2, a3}, WithEpochFencing())
+		p3     = NewMemoryProposerWithOptions("3", log.With(logger, "p", 3), []Acceptor{a1, a2, a3}, WithEpochFencing())
+		legacy = NewMemoryProposer("4", log.With(logger, "p", 4), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// Everyone can write to begin with.
+	for _, p := range []*MemoryProposer{p1, p2, p3, legacy} {
+		if _, _, err := p.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatalf("%s: initial read: %v", p.ballot.ID, err)
+		}
+	}
+
+	// Grow the cluster, but only tell some of the proposers.
+	a4 := NewMemoryAcceptor("4", log.With(logger, "a", 4))
+	if err := GrowCluster(ctx, a4, []Proposer{p1, p2}); err != nil {
+		t.Fatalf("grow cluster: %v", err)
+	}
+
+	// The proposers that took part should have the new epoch.
+	for _, p := range []*MemoryProposer{p1, p2} {
+		if want, have := uint64(initialEpoch+1), p.epoch; want != have {
+			t.Errorf("%s: epoch: want %d, have %d", p.ballot.ID, want, have)
+		}
+	}
+
+	// Acceptors learn about the new epoch from prepares. A full read makes
+	// sure every acceptor has seen it.
+	if _, err := p2.FullIdentityRead(ctx, "k"); err != nil {
+		t.Fatalf("read after grow: %v", err)
+	}
+
+	// The proposer that missed the change should be fenced off.
+	_, _, err := p3.Propose(ctx, "k", changeFuncRead)
+	sce, ok := err.(StaleConfigurationError)
+	if !ok {
+		t.Fatalf("stale proposer: want StaleConfigurationError, have %T (%v)", err, err)
+	}
+	if want, have := uint64(initialEpoch+1), sce.Current; want != have {
+		t.Errorf("stale proposer: current epoch: want %d, have %d", want, have)
+	}
+
+	// Once it's caught up, it works again.
+	p3.AddAccepter(a4)
+	p3.AddPreparer(a4)
+	p3.FastForwardEpoch(sce.Current)
+	if _, _, err := p3.Propose(ctx, "k", changeFuncRead); err != nil {
+		t.Fatalf("refreshed proposer: %v", err)
+	}
+
+	// Proposers without fencing aren't affected.
+	if _, _, err := legacy.Propose(ctx, "k", changeFuncRead); err != nil {
+		t.Fatalf("legacy proposer: %v", err)
+	}
+}
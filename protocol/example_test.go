@@ -0,0 +1,67 @@
+package protocol_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+// A validator in the style of a JSON schema: every value must be a JSON
+// object, with the required fields, of the declared types. Acceptors refuse
+// anything else, whichever proposer sends it.
+func ExampleWithValueValidator() {
+	required := map[string]string{"name": "string", "age": "number"}
+	schema := func(key string, value []byte) error {
+		if value == nil {
+			return nil // deletes are always allowed
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(value, &object); err != nil {
+			return fmt.Errorf("not a JSON object: %v", err)
+		}
+		for field, typ := range required {
+			switch object[field].(type) {
+			case string:
+				if typ != "string" {
+					return fmt.Errorf("%s: want %s, have string", field, typ)
+				}
+			case float64:
+				if typ != "number" {
+					return fmt.Errorf("%s: want %s, have number", field, typ)
+				}
+			case nil:
+				return fmt.Errorf("%s: required", field)
+			default:
+				return fmt.Errorf("%s: want %s", field, typ)
+			}
+		}
+		return nil
+	}
+
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger(), protocol.WithValueValidator(schema))
+		a2  = protocol.NewMemoryAcceptor("2", log.NewNopLogger(), protocol.WithValueValidator(schema))
+		a3  = protocol.NewMemoryAcceptor("3", log.NewNopLogger(), protocol.WithValueValidator(schema))
+		p   = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1, a2, a3)
+		ctx = context.Background()
+	)
+	set := func(value string) protocol.ChangeFunc {
+		return func([]byte) []byte { return []byte(value) }
+	}
+
+	if _, _, err := p.Propose(ctx, "user", set(`{"name":"ada","age":36}`)); err != nil {
+		fmt.Println(err)
+	}
+	if _, _, err := p.Propose(ctx, "user", set(`{"name":"ada","age":"old"}`)); err != nil {
+		fmt.Println(err)
+	}
+	state, _, _ := p.Propose(ctx, "user", func(current []byte) []byte { return current })
+	fmt.Println(string(state))
+
+	// Output:
+	// validation failed for key "user": age: want number, have string
+	// {"name":"ada","age":36}
+}
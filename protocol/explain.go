@@ -0,0 +1,49 @@
+package protocol
+
+import "context"
+
+// Explanation is the outcome of a change, had it been proposed, as found by
+// Explain.
+type Explanation struct {
+	Current []byte   // the current state
+	Meta    Metadata // stored with the current state
+	Ballot  Ballot   // that accepted the current state; zero if never accepted
+	Next    []byte   // the state the change would have produced
+	Changed bool     // as reported by the change function
+}
+
+// Explain is a dry run of a proposal: it runs the prepare phase, to learn the
+// current state, and applies the change function to it, but then abandons the
+// round, without sending any accepts. The change function reports whether its
+// change would apply, e.g. whether a compare-and-swap's comparison matches.
+//
+// Explain isn't free of side effects. The prepare phase leaves promises for
+// its ballot on the preparers, which forces the next proposal to use a higher
+// ballot, and may make a concurrent proposal fail. With WithAbandonment, the
+// promises are released afterwards; otherwise, they stay in place. Releasing
+// them is safe: each preparer only releases the dry run's own promise, and
+// falls back to the greatest one it made to any other round, so a concurrent
+// round which relies on its promise still has it.
+func (p *MemoryProposer) Explain(ctx context.Context, key string, f ReportingChangeFunc) (Explanation, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var (
+		explanation Explanation
+		identity    = func(x []byte) []byte { return x }
+		ro          = roundOptions{explanation: &explanation}
+	)
+	_, _, _, err := p.propose(ctx, key, identity, keepMeta, regularQuorum, ro)
+	if isPrepareFailed(err) {
+		// allow a single retry, to hide fast-forwards
+		_, _, _, err = p.propose(ctx, key, identity, keepMeta, regularQuorum, ro)
+	}
+	if err != nil {
+		return Explanation{}, err
+	}
+	explanation.Next, explanation.Changed, err = p.applyChange(ctx, key, f, copyValue(explanation.Current))
+	if err != nil {
+		return Explanation{}, err
+	}
+	return explanation, nil
+}
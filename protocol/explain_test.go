@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestExplain(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithAbandonment())
+		ctx    = context.Background()
+		key    = "k"
+	)
+
+	cas := func(expected, next string) ReportingChangeFunc {
+		return func(current []byte) ([]byte, bool) {
+			if string(current) != expected {
+				return current, false
+			}
+			return []byte(next), true
+		}
+	}
+
+	_, b, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAccepted(t, key, b, a1, a2, a3)
+
+	for _, testcase := range []struct {
+		name     string
+		expected string
+		next     string
+		changed  bool
+	}{
+		{"match", "foo", "bar", true},
+		{"mismatch", "baz", "foo", false},
+	} {
+		explanation, err := p.Explain(ctx, key, cas(testcase.expected, testcase.next))
+		if err != nil {
+			t.Fatalf("%s: %v", testcase.name, err)
+		}
+		if want, have := "foo", string(explanation.Current); want != have {
+			t.Errorf("%s: current: want %q, have %q", testcase.name, want, have)
+		}
+		if want, have := b, explanation.Ballot; want != have {
+			t.Errorf("%s: ballot: want %v, have %v", testcase.name, want, have)
+		}
+		if want, have := testcase.next, string(explanation.Next); want != have {
+			t.Errorf("%s: next: want %q, have %q", testcase.name, want, have)
+		}
+		if want, have := testcase.changed, explanation.Changed; want != have {
+			t.Errorf("%s: changed: want %v, have %v", testcase.name, want, have)
+		}
+	}
+
+	// Nothing was accepted, and the promises are released.
+	for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+		if want, have := []byte("foo"), a.dumpValue(key); !bytes.Equal(want, have) {
+			t.Errorf("%s: want %q, have %q", a.Address(), want, have)
+		}
+		if inspection, _ := a.Inspect(ctx, key); inspection.Accepted != b {
+			t.Errorf("%s: accepted: want %v, have %v", a.Address(), b, inspection.Accepted)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !promisesCleared(key, a1, a2, a3) {
+		time.Sleep(time.Millisecond)
+	}
+	if !promisesCleared(key, a1, a2, a3) {
+		t.Error("promises weren't cleared")
+	}
+
+	// The dry run's prepares replace a concurrent round's promises. They're
+	// released, but the concurrent round's are kept, as it may still send an
+	// accept.
+	concurrent := Ballot{Counter: 50, ID: "2"}
+	for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+		if _, _, _, _, err := a.Prepare(ctx, key, Age{}, 0, concurrent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Explain(ctx, key, cas("foo", "bar")); err != nil {
+		t.Fatal(err)
+	}
+	promised := func() bool {
+		for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+			if av, _ := a.values.get(key); av.promise != concurrent {
+				return false
+			}
+		}
+		return true
+	}
+	deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && !promised() {
+		time.Sleep(time.Millisecond)
+	}
+	if !promised() {
+		t.Errorf("want the concurrent round's promise %v kept", concurrent)
+	}
+
+	// Proposals carry on as normal.
+	if state, _, err := p.Propose(ctx, key, changeFuncCompareAndSwap("foo", "bar")); err != nil || string(state) != "bar" {
+		t.Errorf("after explain: %q, %v", state, err)
+	}
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// FailureClass is a coarse classification of why an acceptor failed a request.
+type FailureClass string
+
+const (
+	// FailureRejected means the acceptor processed the request and refused
+	// it, e.g. with a ConflictError. The acceptor is alive and reachable.
+	FailureRejected FailureClass = "rejected"
+
+	// FailureTimeout means the request timed out. The acceptor may be down,
+	// or unreachable.
+	FailureTimeout FailureClass = "timeout"
+
+	// FailureRefused means the acceptor's host actively refused the
+	// connection, which usually means the acceptor isn't running.
+	FailureRefused FailureClass = "refused"
+
+	// FailureUnknown is any other error.
+	FailureUnknown FailureClass = "unknown"
+)
+
+// ClassifyFailure classifies an error returned by an acceptor. Transports
+// should return errors which wrap the underlying network errors, so they can
+// be classified.
+func ClassifyFailure(err error) FailureClass {
+	var timeout interface{ Timeout() bool }
+	switch {
+	case isRejection(err):
+		return FailureRejected
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &timeout) && timeout.Timeout():
+		return FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	default:
+		return FailureUnknown
+	}
+}
+
+// Classes counts the failures in the error by class.
+func (qe QuorumError) Classes() map[FailureClass]int {
+	classes := map[FailureClass]int{}
+	for _, err := range qe.Failures {
+		classes[ClassifyFailure(err)]++
+	}
+	return classes
+}
+
+// partitionRounds is how many consecutive requests to an acceptor must have
+// failed for its failure to be considered stable.
+const partitionRounds = 3
+
+// likelyPartition is true if most of the failures are timeouts, and every
+// failing acceptor has been failing for a while, rather than just this once.
+// That pattern is typical of a network partition; acceptors that crash tend to
+// refuse connections, and transient problems don't persist. It must be called
+// with the mutex held.
+func (p *MemoryProposer) likelyPartition(failures map[string]error) bool {
+	var timeouts int
+	for _, err := range failures {
+		if ClassifyFailure(err) == FailureTimeout {
+			timeouts++
+		}
+	}
+	if timeouts*2 <= len(failures) {
+		return false
+	}
+	for addr, err := range failures {
+		if isRejection(err) {
+			continue // alive, if not well
+		}
+		if p.health.consecutiveFailures(addr) < partitionRounds {
+			return false
+		}
+	}
+	return true
+}
+
+// quorumError builds the error for a phase that failed to reach quorum, and
+// reports it to the failure observer, if there is one.
+func (p *MemoryProposer) quorumError(phaseErr error, failures map[string]error) QuorumError {
+	qe := QuorumError{Err: phaseErr, Failures: failures, LikelyPartition: p.likelyPartition(failures)}
+	if p.failureObserver != nil {
+		p.failureObserver(qe)
+	}
+	return qe
+}
+
+// FailureObserver is called after every phase that fails to reach quorum,
+// typically to count failed rounds by their Classes. It's called
+// synchronously, with the proposer locked, so it should be fast, and mustn't
+// call the proposer.
+type FailureObserver func(qe QuorumError)
+
+// WithFailureObserver installs a FailureObserver. By default, failures aren't
+// observed.
+func WithFailureObserver(f FailureObserver) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.failureObserver = f }
+}
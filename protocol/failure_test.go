@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	for _, testcase := range []struct {
+		err  error
+		want FailureClass
+	}{
+		{ConflictError{}, FailureRejected},
+		{AgeError{}, FailureRejected},
+		{context.DeadlineExceeded, FailureTimeout},
+		{fmt.Errorf("prepare: %w", context.DeadlineExceeded), FailureTimeout},
+		{timeoutError{}, FailureTimeout},
+		{refused, FailureRefused},
+		{fmt.Errorf("accept: %w", refused), FailureRefused},
+		{errBroken, FailureUnknown},
+	} {
+		if want, have := testcase.want, ClassifyFailure(testcase.err); want != have {
+			t.Errorf("%v: want %s, have %s", testcase.err, want, have)
+		}
+	}
+}
+
+func TestLikelyPartition(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		err  error
+		want []bool // LikelyPartition, for each failed round
+	}{
+		{"timeouts", timeoutError{}, []bool{false, false, true, true}},
+		{"refused", syscall.ECONNREFUSED, []bool{false, false, false, false}},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				logger   = log.NewLogfmtLogger(newTestWriter(t))
+				a1       = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+				a2       = failingAcceptor{NewMemoryAcceptor("2", log.With(logger, "a", 2)), testcase.err}
+				a3       = failingAcceptor{NewMemoryAcceptor("3", log.With(logger, "a", 3)), testcase.err}
+				observed []QuorumError
+				observe  = func(qe QuorumError) { observed = append(observed, qe) }
+				p1       = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithFailureObserver(observe))
+				ctx      = context.Background()
+			)
+
+			// Every proposal fails twice: once, and once more on retry.
+			for len(observed) < len(testcase.want) {
+				_, _, err := p1.Propose(ctx, "k", changeFuncRead)
+				qe, ok := err.(QuorumError)
+				if !ok {
+					t.Fatalf("want QuorumError, have %T: %v", err, err)
+				}
+				if want, have := observed[len(observed)-1].LikelyPartition, qe.LikelyPartition; want != have {
+					t.Fatalf("returned error doesn't match the observed one")
+				}
+			}
+
+			for i, want := range testcase.want {
+				qe := observed[i]
+				if have := qe.LikelyPartition; want != have {
+					t.Errorf("round %d: likely partition: want %v, have %v", i+1, want, have)
+				}
+				if want, have := 2, qe.Classes()[ClassifyFailure(testcase.err)]; want != have {
+					t.Errorf("round %d: classes: want %d of %s, have %v", i+1, want, ClassifyFailure(testcase.err), qe.Classes())
+				}
+			}
+		})
+	}
+}
+
+// failingAcceptor fails every prepare with the given error.
+type failingAcceptor struct {
+	*MemoryAcceptor
+	err error
+}
+
+func (a failingAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	return nil, 0, nil, zeroballot, a.err
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,183 @@
+package protocol
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// WithThrifty makes the proposer contact only a quorum of acceptors in each
+// phase, preferring the fastest, rather than all of them. If one of them fails,
+// or the phase hasn't reached quorum after expandAfter, another acceptor is
+// contacted, and so on, until quorum is reached or every acceptor has been
+// contacted. Quorum sizes are unchanged, so this is safe, but it trades some
+// latency in the face of failure for fewer messages. By default, every
+// acceptor is contacted in every phase.
+//
+// Acceptors that are rarely contacted don't see most accepts, and drift behind
+// the others. That's fine for safety, but it means they lose more of their
+// value in a failure; use VerifyAll, with repair, to bring them up to date.
+// Probe refreshes the latency of every acceptor, so a recovered acceptor can
+// be chosen again.
+func WithThrifty(expandAfter time.Duration) MemoryProposerOption {
+	return func(p *MemoryProposer) {
+		p.thrifty = expandAfter
+		p.latency = newLatencyTracker()
+	}
+}
+
+// FanoutStats count the messages a proposer sent in proposal rounds, and the
+// messages thrifty mode saved by not contacting every acceptor.
+type FanoutStats struct {
+	Sent  uint64
+	Saved uint64
+}
+
+// FanoutStats returns the counts since the proposer was created.
+func (p *MemoryProposer) FanoutStats() FanoutStats {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.fanoutStats
+}
+
+// fanout launches requests to a phase's targets, in order, and decides when to
+// launch more. It's used with the mutex held.
+type fanout struct {
+	mtx     sync.Mutex
+	n       int // total targets
+	sent    int
+	stopped bool
+	done    chan struct{}
+	launch  func(i int)
+	stats   *FanoutStats
+}
+
+// newFanout launches requests to the first quorum targets, if the proposer is
+// thrifty, or every target otherwise. In thrifty mode, it launches another
+// every expandAfter, until stopped. There are n targets, and launch(i) must
+// send the request to the ith, asynchronously.
+func (p *MemoryProposer) newFanout(n, quorum int, launch func(i int)) *fanout {
+	f := &fanout{n: n, done: make(chan struct{}), launch: launch, stats: &p.fanoutStats}
+	if p.thrifty <= 0 || quorum >= n {
+		for f.more() {
+		}
+		return f
+	}
+	for i := 0; i < quorum; i++ {
+		f.more()
+	}
+	go func(expandAfter time.Duration) {
+		t := time.NewTicker(expandAfter)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if !f.more() {
+					return
+				}
+			case <-f.done:
+				return
+			}
+		}
+	}(p.thrifty)
+	return f
+}
+
+// more launches the next request, if there is one, and returns true if it did.
+func (f *fanout) more() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.stopped || f.sent >= f.n {
+		return false
+	}
+	f.launch(f.sent)
+	f.sent++
+	return true
+}
+
+// stop prevents any more requests, and records the stats. It's idempotent.
+func (f *fanout) stop() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.stopped {
+		return
+	}
+	f.stopped = true
+	close(f.done)
+	f.stats.Sent += uint64(f.sent)
+	f.stats.Saved += uint64(f.n - f.sent)
+}
+
+// order returns addrs sorted by preference: fastest first, if the proposer is
+// thrifty, and randomly otherwise. Acceptors with no recorded latency come
+// first, so they're measured.
+func (p *MemoryProposer) order(addrs []string) []string {
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if p.latency == nil {
+		return addrs
+	}
+	latencies := p.latency.snapshot()
+	sort.SliceStable(addrs, func(i, j int) bool { return latencies[addrs[i]] < latencies[addrs[j]] })
+	return addrs
+}
+
+// latencyTracker keeps a moving average of request latency per acceptor. A
+// nil tracker tracks nothing.
+type latencyTracker struct {
+	mtx  sync.Mutex
+	ewma map[string]time.Duration
+}
+
+// latencyPenalty is recorded for failed requests, so failing acceptors are
+// chosen last.
+const latencyPenalty = time.Minute
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{ewma: map[string]time.Duration{}}
+}
+
+func (t *latencyTracker) observe(addr string, d time.Duration, err error) {
+	if t == nil {
+		return
+	}
+	if err != nil && !isRejection(err) {
+		d = latencyPenalty
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	prev, ok := t.ewma[addr]
+	switch {
+	case !ok, prev >= latencyPenalty, d >= latencyPenalty:
+		t.ewma[addr] = d // failures and recoveries take effect immediately
+	default:
+		t.ewma[addr] = (3*prev + d) / 4
+	}
+}
+
+func (t *latencyTracker) snapshot() map[string]time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	res := make(map[string]time.Duration, len(t.ewma))
+	for addr, d := range t.ewma {
+		res[addr] = d
+	}
+	return res
+}
+
+func preparerAddrs(preparers map[string]Preparer) []string {
+	addrs := make([]string, 0, len(preparers))
+	for addr := range preparers {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func accepterAddrs(accepters map[string]Accepter) []string {
+	addrs := make([]string, 0, len(accepters))
+	for addr := range accepters {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
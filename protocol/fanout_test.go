@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestThrifty(t *testing.T) {
+	var (
+		logger  = log.NewLogfmtLogger(newTestWriter(t))
+		initial []Acceptor
+		ctx     = context.Background()
+	)
+	for i := 1; i <= 7; i++ {
+		initial = append(initial, NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i)))
+	}
+	p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithThrifty(time.Hour))
+
+	// Each round contacts a quorum of 4, in each phase, and no more.
+	for i := 0; i < 10; i++ {
+		if _, _, err := p1.Propose(ctx, "k", changeFuncIncrement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want, have := (FanoutStats{Sent: 10 * 2 * 4, Saved: 10 * 2 * 3}), p1.FanoutStats(); want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+
+	// Quorums intersect, so every write is seen, whichever acceptors were
+	// contacted.
+	state, _, err := p1.Propose(ctx, "k", changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "10", string(state); want != have {
+		t.Errorf("state: want %q, have %q", want, have)
+	}
+
+	// A proposer that contacts everyone doesn't save anything.
+	p2 := NewMemoryProposer("2", log.With(logger, "p", 2), initial...)
+	if _, _, err := p2.Propose(ctx, "fresh", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (FanoutStats{Sent: 2 * 7}), p2.FanoutStats(); want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+}
+
+func TestThriftyExpansion(t *testing.T) {
+	t.Run("failure", func(t *testing.T) {
+		var (
+			logger    = log.NewLogfmtLogger(newTestWriter(t))
+			acceptors []*breakableAcceptor
+			initial   []Acceptor
+			ctx       = context.Background()
+		)
+		for i := 1; i <= 5; i++ {
+			a := &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))}
+			acceptors = append(acceptors, a)
+			initial = append(initial, a)
+		}
+		p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithThrifty(time.Hour))
+
+		// Whichever acceptors are picked first, failures are replaced, and
+		// the proposal succeeds without waiting.
+		acceptors[0].setBroken(true)
+		acceptors[1].setBroken(true)
+		for i := 0; i < 5; i++ {
+			if _, _, err := p1.Propose(ctx, "k", changeFuncIncrement); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("slowness", func(t *testing.T) {
+		var (
+			logger = log.NewLogfmtLogger(newTestWriter(t))
+			gate   = make(chan struct{})
+			ctx    = context.Background()
+		)
+		defer close(gate)
+		initial := []Acceptor{
+			NewMemoryAcceptor("1", log.With(logger, "a", 1)),
+			NewMemoryAcceptor("2", log.With(logger, "a", 2)),
+			gatedAcceptor{NewMemoryAcceptor("3", log.With(logger, "a", 3)), gate},
+		}
+		p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithThrifty(10*time.Millisecond))
+
+		// An acceptor that never answers is eventually bypassed.
+		for i := 0; i < 5; i++ {
+			if _, _, err := p1.Propose(ctx, "k", changeFuncIncrement); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkThrifty(b *testing.B) {
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryProposerOption
+	}{
+		{"all", nil},
+		{"thrifty", []MemoryProposerOption{WithThrifty(time.Second)}},
+	} {
+		b.Run(testcase.name, func(b *testing.B) {
+			var initial []Acceptor
+			for i := 1; i <= 7; i++ {
+				initial = append(initial, NewMemoryAcceptor(fmt.Sprint(i), log.NewNopLogger()))
+			}
+			var (
+				p1  = NewMemoryProposerWithOptions("1", log.NewNopLogger(), initial, testcase.options...)
+				ctx = context.Background()
+			)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := p1.Propose(ctx, "k", changeFuncIncrement); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(p1.FanoutStats().Sent)/float64(b.N), "msgs/op")
+		})
+	}
+}
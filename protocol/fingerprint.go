@@ -0,0 +1,236 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrNoEpochFencing indicates an attempt to reconcile the configuration of a
+// proposer without epoch fencing, whose epoch can't be trusted.
+var ErrNoEpochFencing = errors.New("reconciliation requires epoch fencing")
+
+// Fingerprint summarizes a proposer's configuration: its epoch, and a hash of
+// its preparer and accepter addresses, and their weights. Proposers with the
+// same fingerprint use the same quorums.
+type Fingerprint struct {
+	Epoch uint64 `json:"epoch"`
+	Hash  uint64 `json:"hash"`
+}
+
+func (f Fingerprint) String() string {
+	return fmt.Sprintf("%d/%016x", f.Epoch, f.Hash)
+}
+
+// Fingerprint returns the fingerprint of the proposer's configuration.
+func (p *MemoryProposer) Fingerprint() Fingerprint {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.fingerprint()
+}
+
+func (p *MemoryProposer) fingerprint() Fingerprint {
+	c := p.configuration()
+	h := fnv.New64a()
+	for _, addr := range c.Preparers {
+		fmt.Fprintf(h, "p %s\n", addr)
+	}
+	for _, addr := range c.Accepters {
+		fmt.Fprintf(h, "a %s\n", addr)
+	}
+	for _, addr := range c.Accepters {
+		if w, ok := c.Weights[addr]; ok {
+			fmt.Fprintf(h, "w %s %d\n", addr, w)
+		}
+	}
+	return Fingerprint{Epoch: p.epoch, Hash: h.Sum64()}
+}
+
+// ConfigurationPeer is another proposer, as seen by a ConfigurationMonitor.
+// Remote proposers would implement it over the network.
+type ConfigurationPeer interface {
+	Fingerprint(ctx context.Context) (Fingerprint, error)
+	Configuration(ctx context.Context) (Configuration, error)
+}
+
+// LocalPeer adapts a MemoryProposer in the same process to a
+// ConfigurationPeer.
+func LocalPeer(p *MemoryProposer) ConfigurationPeer {
+	return localPeer{p}
+}
+
+type localPeer struct{ p *MemoryProposer }
+
+func (lp localPeer) Fingerprint(context.Context) (Fingerprint, error) {
+	return lp.p.Fingerprint(), nil
+}
+
+func (lp localPeer) Configuration(context.Context) (Configuration, error) {
+	return lp.p.Configuration(), nil
+}
+
+// ConfigurationCheck is the result of a single ConfigurationMonitor check.
+type ConfigurationCheck struct {
+	Local      Fingerprint
+	Mismatched map[string]Fingerprint   // peers with a different fingerprint, by name
+	Failed     map[string]error         // peers that couldn't be checked, by name
+	Reconciled string                   // the peer whose configuration was adopted, if any
+	Duplicates []DuplicateAcceptorError // acceptors registered at more than one address
+}
+
+// ConfigurationMonitor periodically compares a proposer's configuration with
+// its peers', to detect proposers which have drifted apart, e.g. because one
+// of them missed a step of a cluster change. That's only safe if the grow and
+// shrink procedures were followed everywhere, so a mismatch is logged, and
+// marks the proposer's health as degraded, until the configurations agree.
+// Acceptors registered twice, under different addresses, are logged, too.
+//
+// If reconciliation is enabled, and the proposer uses epoch fencing, a proposer
+// which finds a peer with a newer epoch adopts that peer's configuration. The
+// epoch is incremented by every cluster change, so the proposer with the newer
+// epoch has seen more changes.
+type ConfigurationMonitor struct {
+	proposer *MemoryProposer
+	peers    map[string]ConfigurationPeer
+	dial     Dialer
+	logger   log.Logger
+
+	mtx        sync.Mutex
+	mismatches uint64
+}
+
+// NewConfigurationMonitor returns a monitor for proposer, which compares it
+// with the given peers, by name. If dial is non-nil, the monitor reconciles
+// the proposer's configuration, using dial to connect to new acceptors; that
+// requires the proposer to use epoch fencing.
+func NewConfigurationMonitor(proposer *MemoryProposer, peers map[string]ConfigurationPeer, dial Dialer, logger log.Logger) *ConfigurationMonitor {
+	return &ConfigurationMonitor{
+		proposer: proposer,
+		peers:    peers,
+		dial:     dial,
+		logger:   logger,
+	}
+}
+
+// Run checks the configuration every interval, until the context is canceled.
+func (m *ConfigurationMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Mismatches returns the number of checks which found a mismatch.
+func (m *ConfigurationMonitor) Mismatches() uint64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.mismatches
+}
+
+// Check compares the configurations once, and reconciles them, if enabled.
+// Peers that can't be reached don't count as mismatches.
+func (m *ConfigurationMonitor) Check(ctx context.Context) ConfigurationCheck {
+	check := ConfigurationCheck{
+		Local:      m.proposer.Fingerprint(),
+		Mismatched: map[string]Fingerprint{},
+		Failed:     map[string]error{},
+	}
+
+	var newest string
+	for name, peer := range m.peers {
+		f, err := peer.Fingerprint(ctx)
+		if err != nil {
+			check.Failed[name] = err
+			continue
+		}
+		if f != check.Local {
+			check.Mismatched[name] = f
+			if f.Epoch > check.Local.Epoch && (newest == "" || f.Epoch > check.Mismatched[newest].Epoch) {
+				newest = name
+			}
+		}
+	}
+
+	if len(check.Mismatched) > 0 {
+		m.mtx.Lock()
+		m.mismatches++
+		m.mtx.Unlock()
+		for name, f := range check.Mismatched {
+			level.Warn(m.logger).Log("method", "Check", "peer", name, "local", check.Local, "remote", f, "err", "configuration mismatch")
+		}
+	}
+
+	if newest != "" && m.dial != nil {
+		if err := m.reconcile(ctx, m.peers[newest], check.Mismatched[newest]); err != nil {
+			level.Error(m.logger).Log("method", "Check", "peer", newest, "reconcile", "failed", "err", err)
+		} else {
+			level.Info(m.logger).Log("method", "Check", "peer", newest, "reconcile", "success")
+			check.Reconciled = newest
+			check.Local = m.proposer.Fingerprint()
+			for name, f := range check.Mismatched {
+				if f == check.Local {
+					delete(check.Mismatched, name)
+				}
+			}
+		}
+	}
+
+	check.Duplicates = m.proposer.DuplicateAcceptors(ctx)
+	for _, d := range check.Duplicates {
+		level.Warn(m.logger).Log("method", "Check", "node_id", d.NodeID, "existing", d.Existing, "duplicate", d.Duplicate, "err", "acceptor registered twice")
+	}
+
+	m.proposer.setConfigurationSkew(len(check.Mismatched) > 0)
+	return check
+}
+
+func (m *ConfigurationMonitor) reconcile(ctx context.Context, peer ConfigurationPeer, f Fingerprint) error {
+	c, err := peer.Configuration(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(c.Preparers)
+	sort.Strings(c.Accepters)
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return m.proposer.reconcile(c, f.Epoch, m.dial)
+}
+
+// reconcile adopts a peer's configuration, if the proposer uses epoch fencing,
+// and the peer's epoch is still newer than ours.
+func (p *MemoryProposer) reconcile(c Configuration, epoch uint64, dial Dialer) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if !p.fencing {
+		return ErrNoEpochFencing
+	}
+	if epoch <= p.epoch {
+		return nil // someone beat us to it
+	}
+	if err := p.adopt(c, dial); err != nil {
+		return err
+	}
+	p.epoch = epoch
+	p.configurationChanged()
+	return nil
+}
+
+func (p *MemoryProposer) setConfigurationSkew(skew bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.configSkew = skew
+}
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestConfigurationMonitor(t *testing.T) {
+	for _, testcase := range []struct {
+		name      string
+		fencing   bool
+		reconcile bool
+	}{
+		{"detect", true, false},
+		{"reconcile", true, true},
+		{"reconcile without fencing", false, true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				logger  = log.NewLogfmtLogger(newTestWriter(t))
+				a1      = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+				a2      = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+				a3      = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+				a4      = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+				initial = []Acceptor{a1, a2, a3}
+				p1      = NewMemoryProposer("1", log.With(logger, "p", 1), initial...)
+				p2      = NewMemoryProposer("2", log.With(logger, "p", 2), initial...)
+				ctx     = context.Background()
+			)
+			var options []MemoryProposerOption
+			if testcase.fencing {
+				options = append(options, WithEpochFencing())
+			}
+			p3 := NewMemoryProposerWithOptions("3", log.With(logger, "p", 3), initial, options...)
+
+			// Everyone starts out the same.
+			if want, have := p1.Fingerprint(), p3.Fingerprint(); want != have {
+				t.Fatalf("initial fingerprints: want %s, have %s", want, have)
+			}
+
+			// Grow the cluster, but forget about p3.
+			if err := GrowCluster(ctx, a4, []Proposer{p1, p2}); err != nil {
+				t.Fatal(err)
+			}
+
+			var dial Dialer
+			if testcase.reconcile {
+				dial = func(addr string) (Acceptor, error) {
+					if addr != a4.Address() {
+						t.Fatalf("dialed unexpected address %s", addr)
+					}
+					return a4, nil
+				}
+			}
+			peers := map[string]ConfigurationPeer{"p1": LocalPeer(p1), "p2": LocalPeer(p2)}
+			m := NewConfigurationMonitor(p3, peers, dial, log.With(logger, "monitor", 3))
+			check := m.Check(ctx)
+
+			reconciled := testcase.reconcile && testcase.fencing
+			if want, have := reconciled, check.Reconciled != ""; want != have {
+				t.Fatalf("reconciled: want %v, have %v (%q)", want, have, check.Reconciled)
+			}
+			if want, have := uint64(1), m.Mismatches(); want != have {
+				t.Errorf("mismatches: want %d, have %d", want, have)
+			}
+
+			h := p3.Health()
+			if reconciled {
+				if want, have := p1.Fingerprint(), p3.Fingerprint(); want != have {
+					t.Errorf("after reconcile: want %s, have %s", want, have)
+				}
+				if want, have := p1.Configuration(), p3.Configuration(); !reflect.DeepEqual(want, have) {
+					t.Errorf("after reconcile: want %+v, have %+v", want, have)
+				}
+				if h.ConfigurationSkew || h.State != Healthy {
+					t.Errorf("after reconcile: want healthy, have %+v", h)
+				}
+				return
+			}
+
+			if want, have := 2, len(check.Mismatched); want != have {
+				t.Errorf("mismatched peers: want %d, have %d", want, have)
+			}
+			if !h.ConfigurationSkew || h.State != Degraded {
+				t.Errorf("with skew: want degraded, have %+v", h)
+			}
+
+			// Once p3 is fixed by hand, the next check clears the skew.
+			if err := p3.AddAccepter(a4); err != nil {
+				t.Fatal(err)
+			}
+			if err := p3.AddPreparer(a4); err != nil {
+				t.Fatal(err)
+			}
+			p3.FastForwardEpoch(p1.Fingerprint().Epoch)
+			if check := m.Check(ctx); len(check.Mismatched) != 0 {
+				t.Errorf("after fix: want no mismatches, have %v", check.Mismatched)
+			}
+			if h := p3.Health(); h.ConfigurationSkew {
+				t.Errorf("after fix: want no skew, have %+v", h)
+			}
+		})
+	}
+}
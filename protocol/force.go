@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrForceWriteUnconfirmed indicates a ForceWrite whose confirmation
+	// doesn't match its key.
+	ErrForceWriteUnconfirmed = errors.New("force write not confirmed: the confirmation must repeat the key")
+
+	// ErrForceWriteIncomplete indicates a ForceWrite which some reachable
+	// acceptors didn't accept. The report says which, and why.
+	ErrForceWriteIncomplete = errors.New("force write wasn't accepted by every reachable acceptor")
+)
+
+// ForceWriteOptions are the safety catches of ForceWrite.
+type ForceWriteOptions struct {
+	// Confirm must be the key, to show it wasn't mistyped.
+	Confirm string
+
+	// AllowPartial permits the write when some acceptors can't be read.
+	// They're skipped, and keep their state for the key.
+	AllowPartial bool
+}
+
+// UnreachableAcceptorsError is returned by ForceWrite, without AllowPartial,
+// when some acceptors can't be read. Nothing is written.
+type UnreachableAcceptorsError struct {
+	Unreachable map[string]error // by address
+}
+
+func (uae UnreachableAcceptorsError) Error() string {
+	addrs := make([]string, 0, len(uae.Unreachable))
+	for addr := range uae.Unreachable {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return fmt.Sprintf("can't read acceptors %s; refusing a partial force write", strings.Join(addrs, ", "))
+}
+
+// ForceWriteReport describes what ForceWrite did.
+type ForceWriteReport struct {
+	Key       string
+	Ballot    Ballot                      // that the value was written with
+	Acceptors map[string]ForceWriteResult // by address
+}
+
+// ForceWriteResult describes a force write to a single acceptor.
+type ForceWriteResult struct {
+	MaxBallot Ballot     // greatest ballot the acceptor had seen, for any key
+	Previous  Inspection // of the key, before the write
+	Written   bool
+	Err       error // of the read, or of the write
+}
+
+// ForceWrite is a break-glass tool for a key that no proposal can resolve,
+// e.g. after manual surgery on acceptor state went wrong. It's dangerous: it
+// bypasses consensus, overwriting the key on every acceptor with value, and
+// may destroy a concurrent write, or a value that a minority of acceptors hold.
+// Use it only when proposals for the key keep failing.
+//
+// It reads the greatest ballot seen by every acceptor, for any key, not just a
+// quorum, and sends an accept with a ballot above all of them, and above the
+// proposer's own, with empty metadata. Acceptors must implement Inspecter to be
+// read. If any acceptor can't be read, nothing is written, and an
+// UnreachableAcceptorsError is returned, unless AllowPartial is set. The report
+// has each acceptor's previous state for the key, to measure what the write
+// replaced. If a reachable acceptor rejects the write, e.g. because a
+// concurrent prepare raised its promise, ErrForceWriteIncomplete is returned
+// with the report. Every force write is logged at warning level.
+func (p *MemoryProposer) ForceWrite(ctx context.Context, key string, value []byte, options ForceWriteOptions) (ForceWriteReport, error) {
+	if options.Confirm != key {
+		return ForceWriteReport{}, ErrForceWriteUnconfirmed
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	logger := level.Warn(p.logger)
+	logger.Log("method", "ForceWrite", "key", key, "size", len(value), "allow_partial", options.AllowPartial, "advisory", "bypassing consensus")
+
+	// Read every acceptor.
+	var (
+		targets = p.acceptors()
+		report  = ForceWriteReport{Key: key, Acceptors: make(map[string]ForceWriteResult, len(targets))}
+		max     = p.ballot
+		failed  = map[string]error{}
+	)
+	for addr, target := range targets {
+		var r ForceWriteResult
+		if i, ok := target.(Inspecter); !ok {
+			r.Err = errors.New("acceptor can't be inspected")
+		} else if r.MaxBallot, r.Err = i.MaxBallot(ctx); r.Err == nil {
+			r.Previous, r.Err = i.Inspect(ctx, key)
+		}
+		if r.Err != nil {
+			failed[addr] = r.Err
+		} else if r.MaxBallot.greaterThan(max) {
+			max = r.MaxBallot
+		}
+		report.Acceptors[addr] = r
+	}
+	if len(failed) > 0 && !options.AllowPartial {
+		logger.Log("method", "ForceWrite", "key", key, "result", "refused", "unreachable", len(failed))
+		return report, UnreachableAcceptorsError{Unreachable: failed}
+	}
+
+	// Write a ballot above all of them.
+	if max.Counter > p.ballot.Counter {
+		p.ballot.Counter = max.Counter // fast-forward
+	}
+	b := p.ballot.inc()
+	report.Ballot = b
+	sum := checksumOf(value)
+	incomplete := false
+	for addr, target := range targets {
+		r := report.Acceptors[addr]
+		if r.Err != nil {
+			logger.Log("method", "ForceWrite", "key", key, "addr", addr, "result", "skipped", "err", r.Err)
+			continue
+		}
+		if r.Err = target.Accept(ctx, key, p.age, b, value, sum, Metadata{}); r.Err != nil {
+			incomplete = true
+		} else {
+			r.Written = true
+		}
+		logger.Log("method", "ForceWrite", "key", key, "addr", addr, "ballot", b, "previous_ballot", r.Previous.Accepted, "written", r.Written, "err", r.Err)
+		report.Acceptors[addr] = r
+	}
+	if incomplete {
+		return report, ErrForceWriteIncomplete
+	}
+	return report, nil
+}
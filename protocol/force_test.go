@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestForceWrite(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		b3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, b3)
+		ctx    = context.Background()
+		key    = "k"
+	)
+	if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Botched surgery leaves a bad value on one acceptor, at a high ballot.
+	bad := Ballot{Counter: 1000, ID: "surgeon"}
+	if err := a1.Accept(ctx, key, Age{}, bad, []byte("bad"), checksumOf([]byte("bad")), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ForceWrite(ctx, key, []byte("fixed"), ForceWriteOptions{Confirm: "j"}); err != ErrForceWriteUnconfirmed {
+		t.Errorf("unconfirmed: want %v, have %v", ErrForceWriteUnconfirmed, err)
+	}
+
+	report, err := p.ForceWrite(ctx, key, []byte("fixed"), ForceWriteOptions{Confirm: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ballot.greaterThan(bad) {
+		t.Errorf("ballot %s isn't above %s", report.Ballot, bad)
+	}
+	if want, have := bad, report.Acceptors["1"].Previous.Accepted; want != have {
+		t.Errorf("previous ballot on 1: want %s, have %s", want, have)
+	}
+	if want, have := []byte("bad"), report.Acceptors["1"].Previous.Value; !bytes.Equal(want, have) {
+		t.Errorf("previous value on 1: want %q, have %q", want, have)
+	}
+	for addr, r := range report.Acceptors {
+		if !r.Written || r.Err != nil {
+			t.Errorf("%s: want written, have %+v", addr, r)
+		}
+	}
+	for _, a := range []*MemoryAcceptor{a1, a2, b3.MemoryAcceptor} {
+		if want, have := []byte("fixed"), a.dumpValue(key); !bytes.Equal(want, have) {
+			t.Errorf("acceptor %s: want %q, have %q", a.Address(), want, have)
+		}
+	}
+
+	// Proposals carry on from it.
+	if state, _, err := p.Propose(ctx, key, changeFuncRead); err != nil || string(state) != "fixed" {
+		t.Errorf("read after force write: want fixed, have %q (%v)", state, err)
+	}
+
+	// A reachable acceptor which rejects the write is reported.
+	b3.setBroken(true)
+	report, err = p.ForceWrite(ctx, key, []byte("again"), ForceWriteOptions{Confirm: key})
+	if err != ErrForceWriteIncomplete {
+		t.Errorf("rejected: want %v, have %v", ErrForceWriteIncomplete, err)
+	}
+	if r := report.Acceptors["3"]; r.Written || r.Err != errBroken {
+		t.Errorf("rejected: want the error from 3, have %+v", r)
+	}
+}
+
+func TestForceWriteUnreachable(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		opaque = struct{ Acceptor }{a3} // can't be inspected
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, opaque)
+		ctx    = context.Background()
+		key    = "k"
+	)
+
+	_, err := p.ForceWrite(ctx, key, []byte("v"), ForceWriteOptions{Confirm: key})
+	if uae, ok := err.(UnreachableAcceptorsError); !ok || len(uae.Unreachable) != 1 || uae.Unreachable["3"] == nil {
+		t.Fatalf("want UnreachableAcceptorsError for 3, have %v", err)
+	}
+	if have := a1.dumpValue(key); have != nil {
+		t.Errorf("refused write wrote %q", have)
+	}
+
+	report, err := p.ForceWrite(ctx, key, []byte("v"), ForceWriteOptions{Confirm: key, AllowPartial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := report.Acceptors["3"]; r.Written || r.Err == nil {
+		t.Errorf("partial: want 3 skipped, have %+v", r)
+	}
+	for _, a := range []*MemoryAcceptor{a1, a2} {
+		if want, have := []byte("v"), a.dumpValue(key); !bytes.Equal(want, have) {
+			t.Errorf("acceptor %s: want %q, have %q", a.Address(), want, have)
+		}
+	}
+	if have := a3.dumpValue(key); have != nil {
+		t.Errorf("skipped acceptor has %q", have)
+	}
+}
@@ -0,0 +1,189 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FreezeTableKey is the reserved key which stores the freeze table.
+const FreezeTableKey = "\x00freeze"
+
+// FreezeTable maps key prefixes to the time they thaw. Writes to keys under a
+// frozen prefix are refused by a Freezer, e.g. during a migration, but reads
+// are still served. The zero time means the prefix is frozen until it's
+// unfrozen.
+type FreezeTable map[string]time.Time
+
+// Frozen returns the prefix which freezes key at time now, if any, and when it
+// thaws. The freeze table key itself is never frozen, so it can be unfrozen.
+func (ft FreezeTable) Frozen(key string, now time.Time) (prefix string, until time.Time, ok bool) {
+	if key == FreezeTableKey {
+		return "", until, false
+	}
+	for p, u := range ft {
+		if strings.HasPrefix(key, p) && (u.IsZero() || now.Before(u)) {
+			return p, u, true
+		}
+	}
+	return "", until, false
+}
+
+// FrozenError is returned by a Freezer for a write to a frozen key. The value
+// wasn't changed.
+type FrozenError struct {
+	Key    string
+	Prefix string
+	Until  time.Time // zero if until unfrozen
+}
+
+func (fe FrozenError) Error() string {
+	if fe.Until.IsZero() {
+		return fmt.Sprintf("key %q is frozen by prefix %q", fe.Key, fe.Prefix)
+	}
+	return fmt.Sprintf("key %q is frozen by prefix %q until %s", fe.Key, fe.Prefix, fe.Until.Format(time.RFC3339))
+}
+
+// ReadFreezeTable reads the freeze table via the proposer.
+func ReadFreezeTable(ctx context.Context, proposer Proposer) (FreezeTable, error) {
+	identity := func(x []byte) []byte { return x }
+	state, _, err := proposer.Propose(ctx, FreezeTableKey, identity)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFreezeTable(state)
+}
+
+// Freeze freezes writes to keys under prefix until the given time, or until
+// unfrozen, if it's zero. Freezers only see the change when their cached
+// table expires, and writes already in flight may still complete, so callers
+// should wait at least the Freezers' max age before relying on it. Expired
+// entries are removed from the table along the way.
+func Freeze(ctx context.Context, proposer Proposer, prefix string, until time.Time) error {
+	return updateFreezeTable(ctx, proposer, func(ft FreezeTable) { ft[prefix] = until })
+}
+
+// Unfreeze removes the freeze on prefix, if any.
+func Unfreeze(ctx context.Context, proposer Proposer, prefix string) error {
+	return updateFreezeTable(ctx, proposer, func(ft FreezeTable) { delete(ft, prefix) })
+}
+
+func updateFreezeTable(ctx context.Context, proposer Proposer, f func(FreezeTable)) error {
+	var decodeErr error
+	update := func(current []byte) []byte {
+		ft, err := decodeFreezeTable(current)
+		if decodeErr = err; err != nil {
+			return current
+		}
+		now := time.Now()
+		for prefix, until := range ft {
+			if !until.IsZero() && !now.Before(until) {
+				delete(ft, prefix)
+			}
+		}
+		f(ft)
+		buf, _ := json.Marshal(ft) // can't fail
+		return buf
+	}
+	if _, _, err := proposer.Propose(ctx, FreezeTableKey, update); err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+func decodeFreezeTable(state []byte) (FreezeTable, error) {
+	ft := FreezeTable{}
+	if len(state) == 0 {
+		return ft, nil
+	}
+	if err := json.Unmarshal(state, &ft); err != nil {
+		return nil, errors.Wrap(err, "error decoding freeze table")
+	}
+	return ft, nil
+}
+
+// Freezer wraps a proposer, and refuses writes to frozen keys with a
+// FrozenError, according to the freeze table. The table is cached, and re-read
+// from the cluster when it's older than maxAge.
+//
+// A proposal to a frozen key still runs, so reads are served, but if the
+// change function changes the state, the current state is written back
+// instead, and the proposal fails. The table is checked before the round
+// starts, so a write which is already in flight when the key is frozen
+// completes.
+type Freezer struct {
+	proposer Proposer
+	maxAge   time.Duration
+
+	mtx    sync.Mutex
+	table  FreezeTable
+	readAt time.Time
+	now    func() time.Time
+}
+
+// NewFreezer returns a freezer for proposer.
+func NewFreezer(proposer Proposer, maxAge time.Duration) *Freezer {
+	return &Freezer{
+		proposer: proposer,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}
+}
+
+// Propose makes the proposal, unless it's a write to a frozen key.
+func (f *Freezer) Propose(ctx context.Context, key string, change ChangeFunc) (state []byte, b Ballot, err error) {
+	table, err := f.freezeTable(ctx)
+	if err != nil {
+		return nil, b, err
+	}
+	prefix, until, frozen := table.Frozen(key, f.now())
+	if !frozen {
+		return f.proposer.Propose(ctx, key, change)
+	}
+
+	// The change function may be called more than once, if a round fails, so
+	// only the last call counts.
+	var frozenErr error
+	guarded := func(current []byte) []byte {
+		frozenErr = nil
+		if next := change(copyValue(current)); !equalValues(current, next) {
+			frozenErr = FrozenError{Key: key, Prefix: prefix, Until: until}
+		}
+		return current
+	}
+	state, b, err = f.proposer.Propose(ctx, key, guarded)
+	if err != nil {
+		return nil, b, err
+	}
+	if frozenErr != nil {
+		return nil, b, frozenErr
+	}
+	return state, b, nil
+}
+
+// Invalidate forgets the cached freeze table, so the next proposal reads it
+// again, e.g. after a call to Freeze.
+func (f *Freezer) Invalidate() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.table = nil
+}
+
+func (f *Freezer) freezeTable(ctx context.Context) (FreezeTable, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.table != nil && f.now().Sub(f.readAt) < f.maxAge {
+		return f.table, nil
+	}
+	table, err := ReadFreezeTable(ctx, f.proposer)
+	if err != nil {
+		return nil, err
+	}
+	f.table, f.readAt = table, f.now()
+	return table, nil
+}
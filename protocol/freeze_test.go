@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestFreezer(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		now    = time.Now()
+		ctx    = context.Background()
+	)
+	f := NewFreezer(p, time.Minute)
+	f.now = func() time.Time { return now }
+
+	for _, key := range []string{"users/alice", "orders/1"} {
+		if _, _, err := f.Propose(ctx, key, changeFuncInitializeOnlyOnce("v1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	until := now.Add(time.Hour)
+	if err := Freeze(ctx, p, "users/", until); err != nil {
+		t.Fatal(err)
+	}
+	f.Invalidate()
+
+	// Writes to frozen keys fail, and don't change the value; reads, and
+	// writes to other keys, are fine.
+	_, _, err := f.Propose(ctx, "users/alice", changeFuncCompareAndSwap("v1", "v2"))
+	if fe, ok := err.(FrozenError); !ok || fe.Key != "users/alice" || fe.Prefix != "users/" || !fe.Until.Equal(until) {
+		t.Errorf("write: want FrozenError, have %v", err)
+	}
+	if state, _, err := f.Propose(ctx, "users/alice", changeFuncRead); err != nil || string(state) != "v1" {
+		t.Errorf("read: want v1, have %q, %v", state, err)
+	}
+	if _, _, err := f.Propose(ctx, "orders/1", changeFuncCompareAndSwap("v1", "v2")); err != nil {
+		t.Errorf("other key: %v", err)
+	}
+
+	// Freezes expire by themselves.
+	now = until
+	if _, _, err := f.Propose(ctx, "users/alice", changeFuncCompareAndSwap("v1", "v2")); err != nil {
+		t.Errorf("expired: %v", err)
+	}
+
+	// A freeze without expiry lasts until it's unfrozen. The cached table is
+	// used until it's older than the max age.
+	if err := Freeze(ctx, p, "users/", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Propose(ctx, "users/alice", changeFuncCompareAndSwap("v2", "v3")); err != nil {
+		t.Errorf("cached: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, _, err := f.Propose(ctx, "users/alice", changeFuncCompareAndSwap("v3", "v4")); err == nil {
+		t.Errorf("frozen: want error, have none")
+	}
+	if err := Unfreeze(ctx, p, "users/"); err != nil {
+		t.Fatal(err)
+	}
+	f.Invalidate()
+	if _, _, err := f.Propose(ctx, "users/alice", changeFuncCompareAndSwap("v3", "v4")); err != nil {
+		t.Errorf("unfrozen: %v", err)
+	}
+	if table, err := ReadFreezeTable(ctx, p); err != nil || len(table) != 0 {
+		t.Errorf("table: want empty, have %v, %v", table, err)
+	}
+}
+
+func TestFreezeInFlight(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		f      = NewFreezer(p1, 0) // no caching
+		ctx    = context.Background()
+	)
+
+	// The write's round is under way when the freeze lands, so it completes.
+	var (
+		started = make(chan struct{})
+		frozen  = make(chan struct{})
+		done    = make(chan error)
+	)
+	go func() {
+		_, _, err := f.Propose(ctx, "k", func(current []byte) []byte {
+			close(started)
+			<-frozen
+			return []byte("written")
+		})
+		done <- err
+	}()
+	<-started
+	if err := Freeze(ctx, p2, "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	close(frozen)
+	if err := <-done; err != nil {
+		t.Fatalf("in flight: %v", err)
+	}
+
+	// The next one is refused.
+	if _, _, err := f.Propose(ctx, "k", func([]byte) []byte { return []byte("again") }); err == nil {
+		t.Errorf("after freeze: want error, have none")
+	}
+	if state, _, err := f.Propose(ctx, "k", changeFuncRead); err != nil || string(state) != "written" {
+		t.Errorf("read: want written, have %q, %v", state, err)
+	}
+}
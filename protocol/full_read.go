@@ -0,0 +1,161 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ErrUnsafeExclusion indicates a FullIdentityReadExcluding which would
+// consult too few acceptors: fewer than a quorum of the preparers, or of the
+// accepters. The read isn't made.
+var ErrUnsafeExclusion = errors.New("excluding these acceptors leaves less than a quorum")
+
+// FullIdentityReadOptions control FullIdentityReadExcluding.
+type FullIdentityReadOptions struct {
+	// Exclude are the addresses of acceptors which the operator asserts are
+	// permanently gone, and will be removed from the configuration. They're
+	// not sent the read. An acceptor which is excluded, but comes back before
+	// it's removed, may still have an old value for the key.
+	Exclude []string
+
+	// Timeout limits the read. Zero means only the context's deadline.
+	Timeout time.Duration
+}
+
+// FullIdentityReadResult says which acceptors a FullIdentityReadExcluding
+// consulted, and how they answered. Addresses are sorted.
+type FullIdentityReadResult struct {
+	State     []byte
+	Consulted []string
+	Excluded  []string
+	Failed    map[string]string // consulted acceptors which returned an error, with the error
+	TimedOut  []string          // consulted acceptors which didn't answer in time
+}
+
+// FullIdentityReadExcluding is like FullIdentityRead, but requires every
+// acceptor to answer except those excluded, so an acceptor which has crashed
+// for good doesn't block the garbage collection of every key. The acceptors
+// which are consulted must still make up a quorum of both the preparers and
+// the accepters, or ErrUnsafeExclusion is returned, without a read.
+//
+// The result is returned even if the read fails, to say which acceptors
+// failed, or timed out. Excluding an acceptor is dangerous: if it's still
+// alive, it misses the read, and so still has whatever it had before, which a
+// later step of the garbage collection may depend on it not having. Only
+// exclude acceptors which are being removed.
+func (p *MemoryProposer) FullIdentityReadExcluding(ctx context.Context, key string, options FullIdentityReadOptions) (FullIdentityReadResult, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var (
+		result   = FullIdentityReadResult{Excluded: append([]string{}, options.Exclude...)}
+		excluded = make(map[string]bool, len(options.Exclude))
+	)
+	sort.Strings(result.Excluded)
+	for _, addr := range options.Exclude {
+		_, preparer := p.preparers[addr]
+		_, accepter := p.accepters[addr]
+		if !preparer && !accepter {
+			return result, fmt.Errorf("can't exclude %s: it's not in the configuration", addr)
+		}
+		excluded[addr] = true
+	}
+	var (
+		preparers = preparerAddrs(p.preparers)
+		accepters = accepterAddrs(p.accepters)
+	)
+	for _, addrs := range [][]string{preparers, accepters} {
+		if consulted := p.totalWeight(withoutAddrs(addrs, excluded)); consulted < regularQuorum(p.totalWeight(addrs)) {
+			return result, ErrUnsafeExclusion
+		}
+	}
+	result.Consulted = withoutAddrs(append(preparers, accepters...), excluded)
+	sort.Strings(result.Consulted)
+	result.Consulted = dedupeSorted(result.Consulted)
+
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	// Trace the round, to learn who answered, and pass the trace on to the
+	// proposer's own tracer, if it has one.
+	var (
+		saved = p.tracer
+		trace *RoundTrace
+		ro    = roundOptions{excluded: excluded}
+	)
+	ro.tracer = &tracer{successRate: 1, report: func(rt *RoundTrace) {
+		trace = rt
+		if saved != nil && saved.report != nil && (rt.Err != "" || rand.Float64() < saved.successRate) {
+			saved.report(rt)
+		}
+	}}
+
+	identity := func(x []byte) []byte { return x }
+	state, _, _, err := p.proposeRetry(ctx, key, identity, keepMeta, fullQuorum, ro)
+	if saved == nil {
+		err = untraced(err)
+	}
+	result.State = state
+	if trace != nil {
+		result.Failed, result.TimedOut = roundOutcome(trace, err, withoutAddrs(preparers, excluded), withoutAddrs(accepters, excluded))
+	}
+	return result, err
+}
+
+// roundOutcome returns the acceptors which returned an error in the traced
+// round, and, if it ran out of time, those which hadn't answered in the phase
+// in progress.
+func roundOutcome(trace *RoundTrace, err error, preparers, accepters []string) (failed map[string]string, timedOut []string) {
+	answered := map[string]map[string]bool{"prepare": {}, "accept": {}}
+	for _, e := range trace.Events {
+		answered[e.Phase][e.Addr] = true
+		if e.Err != "" {
+			if failed == nil {
+				failed = map[string]string{}
+			}
+			failed[e.Addr] = e.Err
+		}
+	}
+	var de DeadlineError
+	if !errors.As(err, &de) {
+		return failed, nil
+	}
+	expected := preparers
+	if de.Phase == "accept" {
+		expected = accepters
+	}
+	for _, addr := range expected {
+		if !answered[de.Phase][addr] {
+			timedOut = append(timedOut, addr)
+		}
+	}
+	sort.Strings(timedOut)
+	return failed, timedOut
+}
+
+func withoutAddrs(addrs []string, excluded map[string]bool) []string {
+	res := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !excluded[addr] {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+func dedupeSorted(addrs []string) []string {
+	res := addrs[:0]
+	for i, addr := range addrs {
+		if i == 0 || addr != addrs[i-1] {
+			res = append(res, addr)
+		}
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestFullIdentityReadExcluding(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		b3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, b3)
+		ctx    = context.Background()
+		key    = "k"
+	)
+	if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dead acceptor fails a full read.
+	b3.setBroken(true)
+	if _, err := p.FullIdentityRead(ctx, key); err == nil {
+		t.Fatal("full read with a dead acceptor: want error, have none")
+	}
+	result, err := p.FullIdentityReadExcluding(ctx, key, FullIdentityReadOptions{})
+	if err == nil {
+		t.Fatal("excluding nothing: want error, have none")
+	}
+	if result.Failed["3"] != errBroken.Error() {
+		t.Errorf("excluding nothing: want 3 failed, have %+v", result.Failed)
+	}
+
+	// Unless it's excluded.
+	result, err = p.FullIdentityReadExcluding(ctx, key, FullIdentityReadOptions{Exclude: []string{"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "[1 2]", fmt.Sprint(result.Consulted); want != have {
+		t.Errorf("consulted: want %s, have %s", want, have)
+	}
+	if want, have := "[3]", fmt.Sprint(result.Excluded); want != have {
+		t.Errorf("excluded: want %s, have %s", want, have)
+	}
+	if string(result.State) != "v" || len(result.Failed) != 0 || len(result.TimedOut) != 0 {
+		t.Errorf("want v from everyone consulted, have %+v", result)
+	}
+
+	// Too many exclusions, or unknown ones, are refused.
+	if _, err := p.FullIdentityReadExcluding(ctx, key, FullIdentityReadOptions{Exclude: []string{"2", "3"}}); err != ErrUnsafeExclusion {
+		t.Errorf("excluding a majority: want %v, have %v", ErrUnsafeExclusion, err)
+	}
+	if _, err := p.FullIdentityReadExcluding(ctx, key, FullIdentityReadOptions{Exclude: []string{"4"}}); err == nil {
+		t.Errorf("excluding an unknown acceptor: want error, have none")
+	}
+}
+
+func TestFullIdentityReadExcludingTimeout(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = slowAcceptor{MemoryAcceptor: NewMemoryAcceptor("2", log.With(logger, "a", 2)), prepare: time.Second}
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+	result, err := p.FullIdentityReadExcluding(ctx, "k", FullIdentityReadOptions{Exclude: []string{"3"}, Timeout: 50 * time.Millisecond})
+	if _, ok := err.(DeadlineError); !ok {
+		t.Fatalf("want DeadlineError, have %v", err)
+	}
+	if want, have := "[2]", fmt.Sprint(result.TimedOut); want != have {
+		t.Errorf("timed out: want %s, have %s", want, have)
+	}
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// WithGeneration sets the generation of the proposer's ballots. It must be
+// greater than the generation of every earlier run of a proposer with the same
+// ID, which hasn't kept its ballot counter. A source like the time of startup,
+// in nanoseconds, will do, if clocks don't go backwards; WithGenerationFile is
+// the persistent alternative. By default, the generation is zero.
+func WithGeneration(generation uint64) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.ballot.Generation = generation }
+}
+
+// WithGenerationFile makes the proposer keep its generation in the file at
+// path, and increment it every time the proposer is constructed, so a
+// restarted proposer never reuses a ballot from an earlier run, even though
+// its counter starts again from zero. A missing file starts at generation one.
+// If the file can't be read or written, the generation is taken from the
+// clock instead, and the error is logged. It overrides WithGeneration.
+func WithGenerationFile(path string) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.generationPath = path }
+}
+
+type proposerGeneration struct {
+	Generation uint64 `json:"generation"`
+}
+
+// nextGeneration reads, increments, and writes the generation file. It's
+// called once all of the options are applied.
+func (p *MemoryProposer) nextGeneration() uint64 {
+	var last proposerGeneration
+	buf, err := ioutil.ReadFile(p.generationPath)
+	if err == nil {
+		err = json.Unmarshal(buf, &last)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		level.Error(p.logger).Log("method", "nextGeneration", "path", p.generationPath, "err", err, "advisory", "using the clock")
+		return clockGeneration()
+	}
+
+	next := proposerGeneration{Generation: last.Generation + 1}
+	if buf, err = json.Marshal(next); err == nil {
+		err = writeFileAtomic(p.generationPath, buf)
+	}
+	if err != nil {
+		level.Error(p.logger).Log("method", "nextGeneration", "path", p.generationPath, "err", err, "advisory", "using the clock")
+		return clockGeneration()
+	}
+	return next.Generation
+}
+
+// clockGeneration derives a generation from the clock. It's far greater than
+// any generation counted in a file.
+func clockGeneration() uint64 {
+	return uint64(time.Now().UnixNano())
+}
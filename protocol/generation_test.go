@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestGenerationFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caspaxos-generation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		path      = filepath.Join(dir, "generation.json")
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		acceptors = []Acceptor{a1, a2, a3}
+		ctx       = context.Background()
+	)
+
+	// Each run of the proposer gets the next generation, and its ballots beat
+	// those of the earlier runs, though its counter starts again from zero.
+	var previous Ballot
+	for generation := uint64(1); generation <= 3; generation++ {
+		p := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1, "generation", generation), acceptors, WithGenerationFile(path))
+		_, b, err := p.Propose(ctx, "k", changeFuncRead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := generation, b.Generation; want != have {
+			t.Errorf("run %d: want generation %d, have %d", generation, want, have)
+		}
+		if !b.greaterThan(previous) {
+			t.Errorf("run %d: ballot %s doesn't beat %s", generation, b, previous)
+		}
+		waitAccepted(t, "k", b, a1, a2, a3)
+		previous = b
+	}
+
+	// Without the file, the generation is zero, as before.
+	if _, b, err := NewMemoryProposer("1", logger, acceptors...).Propose(ctx, "j", changeFuncRead); err != nil || b.Generation != 0 {
+		t.Errorf("default: want generation 0, have %s (%v)", b, err)
+	}
+
+	// An unreadable file falls back to the clock.
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := NewMemoryProposerWithOptions("1", logger, acceptors, WithGenerationFile(path))
+	if _, b, err := p.Propose(ctx, "k", changeFuncRead); err != nil || b.Generation <= previous.Generation {
+		t.Errorf("unreadable file: want a clock generation, have %s (%v)", b, err)
+	}
+}
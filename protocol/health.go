@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthState summarizes how close a proposer is to losing quorum.
+type HealthState string
+
+const (
+	// Healthy means no acceptors are failing.
+	Healthy HealthState = "healthy"
+
+	// Degraded means some acceptors are failing, but the proposer can
+	// tolerate at least one more failure in each phase.
+	Degraded HealthState = "degraded"
+
+	// AtRisk means one more failure in some phase will cause an outage, or
+	// that an outage is already underway.
+	AtRisk HealthState = "at-risk"
+)
+
+// Health is a point-in-time report of proposer health.
+type Health struct {
+	State             HealthState
+	Preparers         []string // addresses of failing preparers
+	Accepters         []string // addresses of failing accepters
+	ConfigurationSkew bool     // see ConfigurationMonitor
+}
+
+// Degraded is true when the state is anything other than Healthy.
+func (h Health) Degraded() bool {
+	return h.State != Healthy
+}
+
+// healthTracker keeps a rolling window of request outcomes per acceptor.
+// Protocol rejections, like ballot conflicts, mean the acceptor is alive and
+// well, so they count as successes; only transport-level failures count
+// against an acceptor.
+type healthTracker struct {
+	mtx       sync.Mutex
+	window    int
+	threshold float64
+	outcomes  map[string][]bool    // ring buffer per address, true is success
+	next      map[string]int       // next write position per address
+	observed  map[string]time.Time // of the latest outcome per address
+}
+
+func newHealthTracker(window int, threshold float64) *healthTracker {
+	return &healthTracker{
+		window:    window,
+		threshold: threshold,
+		outcomes:  map[string][]bool{},
+		next:      map[string]int{},
+		observed:  map[string]time.Time{},
+	}
+}
+
+func (t *healthTracker) observe(addr string, err error) {
+	success := err == nil || isRejection(err)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.observed[addr] = time.Now()
+	ring := t.outcomes[addr]
+	if len(ring) < t.window {
+		t.outcomes[addr] = append(ring, success)
+		return
+	}
+	i := t.next[addr]
+	ring[i] = success
+	t.next[addr] = (i + 1) % t.window
+}
+
+// failing returns the subset of addrs currently failing, sorted.
+func (t *healthTracker) failing(addrs []string) []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var res []string
+	for _, addr := range addrs {
+		ring := t.outcomes[addr]
+		if len(ring) == 0 {
+			continue // no news is good news
+		}
+		var n int
+		for _, success := range ring {
+			if success {
+				n++
+			}
+		}
+		if float64(n)/float64(len(ring)) < t.threshold {
+			res = append(res, addr)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// consecutiveFailures returns how many of the most recent requests to addr
+// failed in a row, up to the size of the window.
+func (t *healthTracker) consecutiveFailures(addr string) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	ring := t.outcomes[addr]
+	latest := len(ring) - 1
+	if len(ring) == t.window {
+		latest = (t.next[addr] + t.window - 1) % t.window
+	}
+	var n int
+	for i := 0; i < len(ring); i++ {
+		if ring[(latest-i+len(ring))%len(ring)] {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+func (t *healthTracker) forget(addr string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.outcomes, addr)
+	delete(t.next, addr)
+	delete(t.observed, addr)
+}
+
+// errorRate returns the fraction of the requests to addr in the window which
+// failed, how many requests there were, and when the latest was observed.
+func (t *healthTracker) errorRate(addr string) (rate float64, samples int, observed time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	ring := t.outcomes[addr]
+	if len(ring) == 0 {
+		return 0, 0, time.Time{}
+	}
+	var failures int
+	for _, success := range ring {
+		if !success {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(ring)), len(ring), t.observed[addr]
+}
+
+// healthState computes the health of a single phase, with targets of total
+// weight n, of which failing are failing, and quorum function qf.
+func healthState(n, failing int, qf quorumFunc) HealthState {
+	tolerance := n - qf(n) // how many failures we can absorb
+	switch {
+	case failing == 0:
+		return Healthy
+	case failing < tolerance:
+		return Degraded
+	default:
+		return AtRisk
+	}
+}
+
+func worseHealth(a, b HealthState) HealthState {
+	rank := map[HealthState]int{Healthy: 0, Degraded: 1, AtRisk: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// isRejection is true for errors which indicate the acceptor received and
+// processed the request, but refused it.
+func isRejection(err error) bool {
+	switch err.(type) {
+	case ConflictError, AgeError, ValidationError, StaleConfigurationError:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestHealth(t *testing.T) {
+	// Build a five-node cluster, where each acceptor can be broken.
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		acceptors []*breakableAcceptor
+		initial   []Acceptor
+		ctx       = context.Background()
+	)
+	for i := 1; i <= 5; i++ {
+		a := &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))}
+		acceptors = append(acceptors, a)
+		initial = append(initial, a)
+	}
+	p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithHealthWindow(4, 0.5))
+
+	// Make some proposals, then check the health state.
+	check := func(want HealthState, wantFailing int) {
+		t.Helper()
+		for i := 0; i < 4; i++ {
+			p1.Propose(ctx, "k", changeFuncRead)
+		}
+		h := p1.Health()
+		if have := h.State; want != have {
+			t.Errorf("state: want %s, have %s", want, have)
+		}
+		if want, have := wantFailing, len(h.Accepters); want != have {
+			t.Errorf("failing accepters: want %d, have %d (%v)", want, have, h.Accepters)
+		}
+	}
+
+	// Everything's fine to start with.
+	check(Healthy, 0)
+
+	// Losing one acceptor of five means we can still lose another.
+	acceptors[0].setBroken(true)
+	check(Degraded, 1)
+
+	// Losing two means the next failure is an outage.
+	acceptors[1].setBroken(true)
+	check(AtRisk, 2)
+
+	// Recovery is reflected once the window rolls over.
+	acceptors[0].setBroken(false)
+	acceptors[1].setBroken(false)
+	check(Healthy, 0)
+
+	// Conflicts don't count as failures: a competing proposer that keeps
+	// winning shouldn't make our acceptors look unhealthy.
+	p2 := NewMemoryProposer("2", log.With(logger, "p", 2), initial...)
+	for i := 0; i < 10; i++ {
+		p2.Propose(ctx, "k", changeFuncRead)
+	}
+	check(Healthy, 0)
+}
+
+func TestProbe(t *testing.T) {
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		acceptors []*breakableAcceptor
+		initial   []Acceptor
+		ctx       = context.Background()
+	)
+	for i := 1; i <= 3; i++ {
+		a := &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))}
+		acceptors = append(acceptors, a)
+		initial = append(initial, a)
+	}
+	p1 := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithHealthWindow(4, 0.5))
+
+	// A broken acceptor fails its probes, and is seen as failing.
+	acceptors[0].setBroken(true)
+	for i := 0; i < 4; i++ {
+		failures := p1.Probe(ctx)
+		if want, have := errBroken, failures["1"]; want != have {
+			t.Fatalf("probe: want %v, have %v", want, have)
+		}
+		if want, have := 1, len(failures); want != have {
+			t.Fatalf("probe failures: want %d, have %d", want, have)
+		}
+	}
+	if want, have := []string{"1"}, p1.Health().Accepters; !reflect.DeepEqual(want, have) {
+		t.Fatalf("failing accepters: want %v, have %v", want, have)
+	}
+
+	// Once it's fixed, probes alone are enough to see it recover.
+	acceptors[0].setBroken(false)
+	for i := 0; i < 4; i++ {
+		if failures := p1.Probe(ctx); len(failures) != 0 {
+			t.Fatalf("probe: want no failures, have %v", failures)
+		}
+	}
+	if want, have := Healthy, p1.Health().State; want != have {
+		t.Errorf("state: want %s, have %s", want, have)
+	}
+}
+
+func TestHealthWindowOfZero(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("1", log.With(logger, "a", 1))}
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithHealthWindow(0, 0.5))
+	)
+
+	// It's taken as a window of one request, so the last one decides.
+	a1.setBroken(true)
+	if _, _, err := p1.Propose(context.Background(), "k", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"1"}, p1.Health().Accepters; !reflect.DeepEqual(want, have) {
+		t.Errorf("failing accepters: want %v, have %v", want, have)
+	}
+}
+
+// breakableAcceptor fails every request with a non-protocol error while broken.
+type breakableAcceptor struct {
+	*MemoryAcceptor
+	broken int32
+}
+
+var errBroken = errors.New("acceptor unreachable")
+
+func (a *breakableAcceptor) setBroken(broken bool) {
+	var v int32
+	if broken {
+		v = 1
+	}
+	atomic.StoreInt32(&a.broken, v)
+}
+
+func (a *breakableAcceptor) isBroken() bool {
+	return atomic.LoadInt32(&a.broken) == 1
+}
+
+func (a *breakableAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	if a.isBroken() {
+		return nil, 0, nil, zeroballot, errBroken
+	}
+	return a.MemoryAcceptor.Prepare(ctx, key, age, epoch, b)
+}
+
+func (a *breakableAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	if a.isBroken() {
+		return errBroken
+	}
+	return a.MemoryAcceptor.Accept(ctx, key, age, b, value, sum, meta)
+}
+
+func (a *breakableAcceptor) Ping(ctx context.Context) (PingInfo, error) {
+	if a.isBroken() {
+		return PingInfo{}, errBroken
+	}
+	return a.MemoryAcceptor.Ping(ctx)
+}
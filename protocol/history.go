@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrHistoryUnavailable indicates that no acceptor retains a value as old as
+// the one requested.
+var ErrHistoryUnavailable = errors.New("no acceptor retains a value that old")
+
+// WithHistory makes the acceptor retain up to n superseded values per key,
+// available via History. History is lost when the key is deleted. By default,
+// no history is retained.
+func WithHistory(n int) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.history = n }
+}
+
+// History implements Historian. It returns the retained values for key,
+// followed by the current accepted value, if any.
+func (a *MemoryAcceptor) History(ctx context.Context, key string) ([]Inspection, error) {
+	av, ok := a.values.get(key)
+	if !ok {
+		return nil, nil
+	}
+	res := append(make([]Inspection, 0, len(av.history)+1), av.history...)
+	if !av.accepted.isZero() {
+		value, err := av.raw(key)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Inspection{Value: value, Accepted: av.accepted, AcceptedAt: av.acceptedAt})
+	}
+	for i := range res {
+		res[i].Value = copyValue(res[i].Value)
+	}
+	return res, nil
+}
+
+// appendHistory returns a new slice with the last n of history and h. The old
+// slice may still be in use by readers, so it's never modified.
+func appendHistory(history []Inspection, h Inspection, n int) []Inspection {
+	if len(history) >= n {
+		history = history[len(history)-n+1:]
+	}
+	res := make([]Inspection, 0, len(history)+1)
+	return append(append(res, history...), h)
+}
+
+// HistoricalRead is the result of ReadAt.
+type HistoricalRead struct {
+	Value      []byte
+	Accepted   Ballot
+	AcceptedAt time.Time
+	Source     string // address of the acceptor that supplied the value
+}
+
+// ReadAt returns the value of key as of the given ballot counter: the value
+// with the greatest accepted ballot whose counter is no greater than counter,
+// retained by any of the acceptors. If none of them retains a value that old,
+// it returns ErrHistoryUnavailable. If every acceptor fails, it returns one of
+// their errors.
+//
+// ReadAt doesn't involve consensus, and its result is NOT authoritative. The
+// value may never have been chosen, e.g. if it was accepted by a minority in a
+// round that failed. It's meant for debugging, e.g. to see what a key said
+// when an incident started.
+func ReadAt(ctx context.Context, key string, counter uint64, acceptors []Historian) (HistoricalRead, error) {
+	type result struct {
+		addr    string
+		history []Inspection
+		err     error
+	}
+	var full []Historian
+	for _, acceptor := range acceptors {
+		if roleOf(acceptor) != RoleWitness {
+			full = append(full, acceptor) // witnesses have no values
+		}
+	}
+	results := make(chan result, len(full))
+	for _, acceptor := range full {
+		go func(acceptor Historian) {
+			history, err := acceptor.History(ctx, key)
+			results <- result{acceptor.Address(), history, err}
+		}(acceptor)
+	}
+
+	var (
+		best     HistoricalRead
+		found    bool
+		failures int
+		lastErr  error
+	)
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		if r.err != nil {
+			failures, lastErr = failures+1, r.err
+			continue
+		}
+		for _, h := range r.history {
+			if h.Accepted.Counter > counter {
+				continue
+			}
+			if !found || h.Accepted.greaterThan(best.Accepted) {
+				best, found = HistoricalRead{Value: h.Value, Accepted: h.Accepted, AcceptedAt: h.AcceptedAt, Source: r.addr}, true
+			}
+		}
+	}
+
+	switch {
+	case found:
+		return best, nil
+	case failures > 0 && failures == len(full):
+		return HistoricalRead{}, lastErr
+	default:
+		return HistoricalRead{}, ErrHistoryUnavailable
+	}
+}
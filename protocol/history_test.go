@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReadAt(t *testing.T) {
+	// With two acceptors, every write must reach both.
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithHistory(1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2), WithHistory(3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2)
+		ctx    = context.Background()
+	)
+
+	// Write v1 through v4, remembering the ballot of each.
+	var ballots []Ballot
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		_, b, err := p1.Propose(ctx, "k", func([]byte) []byte { return []byte(v) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		ballots = append(ballots, b)
+	}
+
+	// a1 retains v3 and v4; a2 retains v1 through v4.
+	if history, err := a1.History(ctx, "k"); err != nil {
+		t.Fatal(err)
+	} else if want, have := 2, len(history); want != have {
+		t.Fatalf("a1 history: want %d, have %d", want, have)
+	}
+
+	acceptors := []Historian{a1, a2}
+	for _, testcase := range []struct {
+		counter uint64
+		want    string
+	}{
+		{ballots[0].Counter, "v1"},
+		{ballots[1].Counter, "v2"},
+		{ballots[2].Counter, "v3"},
+		{ballots[3].Counter + 100, "v4"},
+	} {
+		read, err := ReadAt(ctx, "k", testcase.counter, acceptors)
+		if err != nil {
+			t.Errorf("%d: %v", testcase.counter, err)
+			continue
+		}
+		if want, have := testcase.want, string(read.Value); want != have {
+			t.Errorf("%d: want %q, have %q", testcase.counter, want, have)
+		}
+		if read.Accepted.Counter > testcase.counter {
+			t.Errorf("%d: read a value accepted at %s", testcase.counter, read.Accepted)
+		}
+	}
+
+	// Only a2 remembers v1.
+	if read, err := ReadAt(ctx, "k", ballots[0].Counter, acceptors); err != nil {
+		t.Fatal(err)
+	} else if want, have := "2", read.Source; want != have {
+		t.Errorf("source: want %q, have %q", want, have)
+	}
+
+	// Nobody remembers anything before v1.
+	if want, have := ErrHistoryUnavailable, func() error {
+		_, err := ReadAt(ctx, "k", ballots[0].Counter-1, acceptors)
+		return err
+	}(); want != have {
+		t.Errorf("before the first write: want %v, have %v", want, have)
+	}
+	if _, err := ReadAt(ctx, "nonexistent", ballots[3].Counter, acceptors); err != ErrHistoryUnavailable {
+		t.Errorf("nonexistent key: want %v, have %v", ErrHistoryUnavailable, err)
+	}
+}
@@ -0,0 +1,146 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ImportedBallotKey is the metadata key under which Import records the ballot
+// that accepted an imported value where it was exported.
+const ImportedBallotKey = "imported-ballot"
+
+// ImportRecord is a value exported from a cluster, to be imported by Import.
+type ImportRecord struct {
+	Key    string   `json:"key"`
+	Value  []byte   `json:"value"`
+	Meta   Metadata `json:"meta,omitempty"`
+	Ballot Ballot   `json:"ballot"` // that accepted the value, where it was exported
+}
+
+// Outcomes of an ImportRecord.
+const (
+	ImportImported     = "imported"
+	ImportSkippedNewer = "skipped-newer"
+	ImportFailed       = "failed"
+)
+
+// ImportOutcome is what Import did with a record.
+type ImportOutcome struct {
+	Key     string `json:"key"`
+	Outcome string `json:"outcome"`
+	Ballot  Ballot `json:"ballot"` // that wrote the value, or the newer one that kept it
+	Error   string `json:"error,omitempty"`
+}
+
+// ImportSummary counts the outcomes of an Import.
+type ImportSummary struct {
+	Imported     int
+	SkippedNewer int
+	Failed       int
+}
+
+var (
+	errNewerExists    = errors.New("newer value exists")
+	errImportReserved = errors.New("key is reserved")
+)
+
+// Import writes exported records, one proposal each, with each record's
+// metadata, plus its exported ballot under ImportedBallotKey. A record is
+// skipped if the key's accepted ballot is already at least its exported
+// ballot, i.e. a newer value exists; the check is made in the same round as
+// the write, so a concurrent writer can't be overwritten unnoticed. The
+// proposer's counter is first fast-forwarded to the exported ballot's, so the
+// written value's ballot is above it, and importing the same records again
+// skips every one.
+//
+// The outcome of each record is written to report, if it's not nil, as a line
+// of JSON, as soon as it's known. A failed record doesn't stop the import; the
+// caller should check the summary. Import only returns an error if the context
+// is canceled, or the report can't be written, in which case the rest of the
+// records aren't imported.
+func (p *MemoryProposer) Import(ctx context.Context, records []ImportRecord, report io.Writer) (ImportSummary, error) {
+	var (
+		summary ImportSummary
+		enc     *json.Encoder
+	)
+	if report != nil {
+		enc = json.NewEncoder(report)
+	}
+	for _, r := range records {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		outcome := p.importRecord(ctx, r, false)
+		switch outcome.Outcome {
+		case ImportImported:
+			summary.Imported++
+		case ImportSkippedNewer:
+			summary.SkippedNewer++
+		default:
+			summary.Failed++
+		}
+		if enc != nil {
+			if err := enc.Encode(outcome); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, nil
+}
+
+// importRecord writes the record, unless a newer value exists, or whatever
+// exists, if overwrite is set.
+func (p *MemoryProposer) importRecord(ctx context.Context, r ImportRecord, overwrite bool) ImportOutcome {
+	outcome := ImportOutcome{Key: r.Key, Outcome: ImportFailed}
+	if r.Key == zerokey {
+		outcome.Error = errImportReserved.Error()
+		return outcome
+	}
+	meta := r.Meta.copy()
+	if meta == nil {
+		meta = Metadata{}
+	}
+	meta[ImportedBallotKey] = r.Ballot.String()
+	if meta.size() > MaxMetadataSize {
+		outcome.Error = ErrMetadataTooLarge.Error()
+		return outcome
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var (
+		newer Ballot
+		ro    roundOptions
+	)
+	if !overwrite {
+		ro.precondition = func(_ []byte, _ Metadata, accepted Ballot) error {
+			if !r.Ballot.greaterThan(accepted) {
+				newer = accepted
+				return errNewerExists
+			}
+			return nil
+		}
+	}
+
+	if r.Ballot.Counter > p.ballot.Counter {
+		p.ballot.Counter = r.Ballot.Counter // fast-forward
+	}
+	var (
+		value   = copyValue(r.Value)
+		change  = func([]byte) []byte { return value }
+		replace = func(Metadata, bool) Metadata { return meta }
+	)
+	_, _, b, err := p.proposeRetry(ctx, r.Key, change, replace, regularQuorum, ro)
+	switch {
+	case untraced(err) == errNewerExists:
+		outcome.Outcome, outcome.Ballot = ImportSkippedNewer, newer
+	case err != nil:
+		outcome.Error = err.Error()
+	default:
+		outcome.Outcome, outcome.Ballot = ImportImported, b
+	}
+	return outcome
+}
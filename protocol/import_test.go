@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestImport(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// A live writer gets to "live" first, at a ballot above its record's.
+	if _, _, err := p.Propose(ctx, "live", changeFuncInitializeOnlyOnce("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []ImportRecord{
+		{Key: "a", Value: []byte("1"), Meta: Metadata{"writer": "x"}, Ballot: Ballot{Counter: 50, ID: "old"}},
+		{Key: "live", Value: []byte("old"), Ballot: Ballot{Counter: 0, Generation: 1, ID: "old"}},
+		{Key: "", Value: []byte("config"), Ballot: Ballot{Counter: 1, ID: "old"}},
+	}
+	var report bytes.Buffer
+	summary, err := p.Import(ctx, records, &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (ImportSummary{Imported: 1, SkippedNewer: 1, Failed: 1}), summary; want != have {
+		t.Errorf("summary: want %+v, have %+v", want, have)
+	}
+
+	var outcomes []ImportOutcome
+	for s := bufio.NewScanner(&report); s.Scan(); {
+		var outcome ImportOutcome
+		if err := json.Unmarshal(s.Bytes(), &outcome); err != nil {
+			t.Fatal(err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if want, have := 3, len(outcomes); want != have {
+		t.Fatalf("report: want %d lines, have %d", want, have)
+	}
+	for i, want := range []string{ImportImported, ImportSkippedNewer, ImportFailed} {
+		if have := outcomes[i].Outcome; want != have {
+			t.Errorf("%q: want %s, have %s", outcomes[i].Key, want, have)
+		}
+	}
+	if b := outcomes[0].Ballot; !b.greaterThan(records[0].Ballot) {
+		t.Errorf("imported at %s, which isn't above the exported %s", b, records[0].Ballot)
+	}
+	if outcomes[1].Ballot.ID != "1" {
+		t.Errorf("skipped: want the live ballot, have %s", outcomes[1].Ballot)
+	}
+	if outcomes[2].Error == "" {
+		t.Errorf("failed: want an error")
+	}
+
+	state, meta, _, err := p.ReadWithMeta(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(state) != "1" || meta["writer"] != "x" || meta[ImportedBallotKey] != "50/old" {
+		t.Errorf("imported: have %q, %v", state, meta)
+	}
+	if state, _, _ := p.Propose(ctx, "live", changeFuncRead); string(state) != "new" {
+		t.Errorf("live: want new, have %q", state)
+	}
+
+	// Importing again changes nothing.
+	summary, err = p.Import(ctx, records[:2], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (ImportSummary{SkippedNewer: 2}), summary; want != have {
+		t.Errorf("again: want %+v, have %+v", want, have)
+	}
+}
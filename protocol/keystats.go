@@ -0,0 +1,190 @@
+package protocol
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyStats are a proposer's local observations about a single key. They
+// reflect only the proposals made through that proposer, and aren't
+// coordinated with the rest of the cluster in any way.
+type KeyStats struct {
+	Key         string
+	Proposals   uint64            // attempts, including retries
+	Retries     uint64            // attempts that were retries
+	Conflicts   uint64            // attempts that lost to a competing ballot
+	Competitors map[string]uint64 // conflicts by competing proposer ID
+	LastBallot  Ballot            // of the most recent attempt
+	LastWrite   time.Time         // of the most recent successful attempt
+	ValueSize   int               // as of the most recent successful attempt
+}
+
+// maxCompetitors bounds the competing proposer IDs tracked per key. Conflicts
+// with any others are still counted, but not attributed.
+const maxCompetitors = 8
+
+// KeyStatsOrder selects how KeyStats are ranked.
+type KeyStatsOrder string
+
+const (
+	// ByProposals ranks keys by number of proposals, i.e. the hottest keys.
+	ByProposals KeyStatsOrder = "proposals"
+
+	// ByConflicts ranks keys by number of conflicts, i.e. the most contended
+	// keys.
+	ByConflicts KeyStatsOrder = "conflicts"
+)
+
+// keyStatsTracker keeps exact statistics for a bounded number of keys. When
+// it's full, the least recently proposed key is forgotten, so memory use is
+// proportional to capacity, not to the size of the keyspace. A nil tracker
+// tracks nothing.
+type keyStatsTracker struct {
+	mtx      sync.Mutex
+	capacity int
+	order    *list.List               // of *KeyStats, most recently used first
+	index    map[string]*list.Element // key to element in order
+	now      func() time.Time
+}
+
+func newKeyStatsTracker(capacity int) *keyStatsTracker {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &keyStatsTracker{
+		capacity: capacity,
+		order:    list.New(),
+		index:    map[string]*list.Element{},
+		now:      time.Now,
+	}
+}
+
+func (t *keyStatsTracker) observe(key string, retry bool, b Ballot, state []byte, err error) {
+	if t == nil {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	e, ok := t.index[key]
+	if ok {
+		t.order.MoveToFront(e)
+	} else {
+		if t.order.Len() >= t.capacity {
+			oldest := t.order.Back()
+			delete(t.index, oldest.Value.(*KeyStats).Key)
+			t.order.Remove(oldest)
+		}
+		e = t.order.PushFront(&KeyStats{Key: key})
+		t.index[key] = e
+	}
+
+	s := e.Value.(*KeyStats)
+	s.Proposals++
+	if retry {
+		s.Retries++
+	}
+	s.LastBallot = b
+	switch {
+	case err == nil:
+		s.LastWrite, s.ValueSize = t.now(), len(state)
+	case isConflict(err):
+		s.Conflicts++
+		for _, id := range competitors(err) {
+			if _, ok := s.Competitors[id]; !ok && len(s.Competitors) >= maxCompetitors {
+				continue
+			}
+			if s.Competitors == nil {
+				s.Competitors = map[string]uint64{}
+			}
+			s.Competitors[id]++
+		}
+	}
+}
+
+// top returns up to n stats, ranked by order, with ties broken by key.
+func (t *keyStatsTracker) top(n int, by KeyStatsOrder) []KeyStats {
+	if t == nil {
+		return nil
+	}
+
+	t.mtx.Lock()
+	res := make([]KeyStats, 0, t.order.Len())
+	for e := t.order.Front(); e != nil; e = e.Next() {
+		s := *e.Value.(*KeyStats)
+		if s.Competitors != nil {
+			competitors := make(map[string]uint64, len(s.Competitors))
+			for id, n := range s.Competitors {
+				competitors[id] = n
+			}
+			s.Competitors = competitors
+		}
+		res = append(res, s)
+	}
+	t.mtx.Unlock()
+
+	count := func(s KeyStats) uint64 {
+		if by == ByConflicts {
+			return s.Conflicts
+		}
+		return s.Proposals
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if ci, cj := count(res[i]), count(res[j]); ci != cj {
+			return ci > cj
+		}
+		return res[i].Key < res[j].Key
+	})
+	if n >= 0 && n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+func (t *keyStatsTracker) reset() {
+	if t == nil {
+		return
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.order.Init()
+	t.index = map[string]*list.Element{}
+}
+
+// isConflict is true if a proposal failed because at least one acceptor had
+// seen a greater ballot.
+func isConflict(err error) bool {
+	qe, ok := untraced(err).(QuorumError)
+	if !ok {
+		return false
+	}
+	for _, err := range qe.Failures {
+		if _, ok := err.(ConflictError); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// competitors returns the distinct proposer IDs of the ballots that beat ours,
+// in a proposal that failed with conflicts.
+func competitors(err error) []string {
+	qe, ok := untraced(err).(QuorumError)
+	if !ok {
+		return nil
+	}
+	seen := map[string]bool{}
+	var res []string
+	for _, err := range qe.Failures {
+		if ce, ok := err.(ConflictError); ok && !seen[ce.Existing.ID] {
+			seen[ce.Existing.ID] = true
+			res = append(res, ce.Existing.ID)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestKeyStats(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithKeyStats(2))
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// Only the two most recently proposed keys are tracked.
+	for _, key := range []string{"a", "b", "b", "c"} {
+		if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce(key+"-value")); err != nil {
+			t.Fatalf("propose %s: %v", key, err)
+		}
+	}
+	if want, have := "[b:2 c:1]", fmtKeyStats(p1.KeyStats(-1, ByProposals)); want != have {
+		t.Fatalf("after eviction: want %s, have %s", want, have)
+	}
+	if s := p1.KeyStats(1, ByProposals)[0]; s.ValueSize != len("b-value") || s.LastWrite.IsZero() {
+		t.Fatalf("b: want size %d and a write time, have %+v", len("b-value"), s)
+	}
+
+	// Another proposer moves ahead on c, so p1's next attempt conflicts, and
+	// its retry succeeds.
+	for i := 0; i < 5; i++ {
+		p2.Propose(ctx, "c", changeFuncRead)
+	}
+	if _, _, err := p1.Propose(ctx, "c", changeFuncRead); err != nil {
+		t.Fatalf("contended propose: %v", err)
+	}
+	stats := p1.KeyStats(-1, ByConflicts)
+	if want, have := "[c:3 b:2]", fmtKeyStats(stats); want != have {
+		t.Fatalf("after conflict: want %s, have %s", want, have)
+	}
+	if want, have := uint64(1), stats[0].Conflicts; want != have {
+		t.Fatalf("c conflicts: want %d, have %d", want, have)
+	}
+
+	// Reset forgets everything.
+	p1.ResetKeyStats()
+	if want, have := 0, len(p1.KeyStats(-1, ByProposals)); want != have {
+		t.Fatalf("after reset: want %d keys, have %d", want, have)
+	}
+
+	// A proposer without key stats tracks nothing.
+	if stats := p2.KeyStats(-1, ByProposals); stats != nil {
+		t.Fatalf("disabled: want nil, have %v", stats)
+	}
+}
+
+func fmtKeyStats(stats []KeyStats) string {
+	var s []string
+	for _, ks := range stats {
+		s = append(s, fmt.Sprintf("%s:%d", ks.Key, ks.Proposals))
+	}
+	return fmt.Sprint(s)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -17,33 +18,110 @@ var ErrNotEqual = errors.New("not equal")
 
 // MemoryAcceptor persists data in-memory.
 type MemoryAcceptor struct {
-	mtx    sync.Mutex
-	addr   string
-	ages   map[string]Age
-	values map[string]acceptedValue
-	logger log.Logger
+	// Operations on keys take mtx for reading, and synchronize with each
+	// other via the store. Changing ages takes mtx for writing, so that once
+	// RejectByAge returns, no operation that passed the old age check is still
+	// in flight.
+	mtx           sync.RWMutex
+	addr          string
+	nodeID        string
+	epochMtx      sync.Mutex
+	epoch         uint64
+	ages          map[string]Age
+	values        store
+	validator     ValueValidator
+	validation    validationCounter
+	history       int
+	witness       bool
+	codec         Codec
+	compressAbove int
+	usageDepth    int
+	usage         *usageStore // wraps values, if usage is tracked
+	keepDeletions int
+	changes       *changeStore // wraps values, if changes are tracked
+	logger        log.Logger
+
+	// The recovery gate. Like ages, these are guarded by mtx.
+	identityPath      string
+	generation        uint64
+	awaitingAdmission bool
+	floor             Ballot // no ballot up to this one is allowed
 }
 
 // An accepted value is associated with a key in an acceptor.
 // In this way, one acceptor can manage many key-value pairs.
 type acceptedValue struct {
-	promise  Ballot
-	accepted Ballot
-	value    []byte
+	promise    Ballot
+	promised   []Ballot // recent promises, which Abandon may release, oldest first
+	kept       Ballot   // the greatest promise which it may not
+	accepted   Ballot
+	value      []byte // as stored, i.e. compressed, if codec is set
+	codec      Codec
+	size       int // of the raw value, if compressed
+	sum        Checksum
+	meta       Metadata
+	acceptedAt time.Time
+	history    []Inspection // superseded values, oldest first
+	seq        uint64       // of the last change, if changes are tracked
 }
 
 // The zero ballot can be used to clear promises.
 var zeroballot Ballot
 
+// abandonablePromises is how many of a key's most recent promises Abandon can
+// release. Older ones are kept until the next accept.
+const abandonablePromises = 8
+
 // NewMemoryAcceptor returns a usable in-memory acceptor.
 // Useful primarily for testing.
-func NewMemoryAcceptor(addr string, logger log.Logger) *MemoryAcceptor {
-	return &MemoryAcceptor{
+func NewMemoryAcceptor(addr string, logger log.Logger, options ...MemoryAcceptorOption) *MemoryAcceptor {
+	a := &MemoryAcceptor{
 		addr:   addr,
+		nodeID: addr,
 		ages:   map[string]Age{},
-		values: map[string]acceptedValue{},
+		values: newMapStore(),
 		logger: logger,
 	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.keepDeletions > 0 {
+		a.changes = newChangeStore(a.values, a.keepDeletions)
+		a.values = a.changes
+	}
+	if a.usageDepth > 0 {
+		a.usage = newUsageStore(a.values, a.usageDepth)
+		a.values = a.usage
+	}
+	if a.identityPath != "" {
+		a.checkIdentity()
+	}
+	return a
+}
+
+// MemoryAcceptorOption sets an optional parameter on a MemoryAcceptor.
+type MemoryAcceptorOption func(*MemoryAcceptor)
+
+// WithNodeID sets the stable identity of the acceptor, returned by NodeID. By
+// default, the node ID is the address.
+func WithNodeID(id string) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.nodeID = id }
+}
+
+// WithShards spreads keys over n independently-locked shards, reducing
+// contention when there are many keys and many concurrent proposals. By
+// default, all keys share a single lock.
+func WithShards(n int) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.values = newShardedStore(n) }
+}
+
+// WithValueValidator installs a validator that's invoked during Accept, before
+// the value is stored. Values that fail validation are rejected with a
+// ValidationError. The time spent in the validator, and the number of values
+// it rejected, are reported by ValidationStats. By default, all values are
+// accepted.
+func WithValueValidator(v ValueValidator) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.validator = v }
 }
 
 // Address implements Addresser.
@@ -51,49 +129,126 @@ func (a *MemoryAcceptor) Address() string {
 	return a.addr
 }
 
+// NodeID implements Identifier.
+func (a *MemoryAcceptor) NodeID(ctx context.Context) (string, error) {
+	return a.nodeID, nil
+}
+
+// Ping implements Pinger.
+func (a *MemoryAcceptor) Ping(ctx context.Context) (PingInfo, error) {
+	var keys int
+	a.values.forEach(func(string, acceptedValue) bool {
+		keys++
+		return true
+	})
+	return PingInfo{NodeID: a.nodeID, ProtocolVersion: ProtocolVersion, Keys: keys}, nil
+}
+
 // Prepare implements the first-phase responsibilities of an acceptor.
-func (a *MemoryAcceptor) Prepare(ctx context.Context, key string, age Age, b Ballot) (value []byte, current Ballot, err error) {
+func (a *MemoryAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) (value []byte, sum Checksum, meta Metadata, current Ballot, err error) {
 	defer func() {
 		level.Debug(a.logger).Log(
-			"method", "Prepare", "key", key, "age", age, "B", b,
+			"method", "Prepare", "key", key, "age", age, "epoch", epoch, "B", b,
 			"success", err == nil, "return_ballot", current, "err", err,
 		)
 	}()
 
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
+	// A request whose deadline has passed can't be of any use to the
+	// proposer, so don't act on it.
+	if err := ctx.Err(); err == context.DeadlineExceeded {
+		return nil, 0, nil, zeroballot, err
+	}
+
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	// An acceptor which lost its data can't keep its promises.
+	if a.awaitingAdmission {
+		return nil, 0, nil, zeroballot, ErrNotAdmitted
+	}
+	if !a.floor.isZero() && !b.greaterThan(a.floor) {
+		return nil, 0, nil, a.floor, ConflictError{Proposed: b, Existing: a.floor, ExistingKind: "floor"}
+	}
 
 	// From the GC section of the paper: "Acceptors [should] reject messages
 	// from proposers if [the incoming age] is younger than the corresponding
 	// age [that was previously accepted]."
 	if incoming, existing := age, a.ages[age.ID]; incoming.youngerThan(existing) {
-		return nil, zeroballot, AgeError{Incoming: incoming, Existing: existing}
+		return nil, 0, nil, zeroballot, AgeError{Incoming: incoming, Existing: existing}
+	}
+
+	// Reject proposers which have missed a configuration change, and learn
+	// about configuration changes from proposers which haven't. The zero epoch
+	// means the proposer isn't participating in epoch fencing.
+	if epoch != 0 {
+		a.epochMtx.Lock()
+		current := a.epoch
+		if epoch > current {
+			a.epoch = epoch
+		}
+		a.epochMtx.Unlock()
+		if epoch < current {
+			return nil, 0, nil, zeroballot, StaleConfigurationError{Incoming: epoch, Current: current}
+		}
 	}
 
 	// Select the promise/accepted/value tuple for this key.
 	// A zero value is useful.
-	av := a.values[key]
-
-	// rystsov: "If a promise isn't empty during the prepare phase, we should
-	// compare the proposed ballot number against the promise, and update the
-	// promise if the promise is less."
-	//
-	// Here, we exploit the fact that a zero-value ballot number is less than
-	// any non-zero-value ballot number.
-	if av.promise.greaterThan(b) {
-		return av.value, av.promise, ConflictError{Proposed: b, Existing: av.promise, ExistingKind: "promise"}
+	var av acceptedValue
+	err = a.values.update(key, func(existing acceptedValue, _ bool) (acceptedValue, bool, error) {
+		av = existing
+
+		// Never hand out a value we know to be corrupt. Refusing the prepare
+		// means the proposer picks a value from the rest of the quorum, and
+		// then overwrites ours with it in the accept phase. Witnesses keep
+		// the checksum without the value, so there's nothing to check.
+		raw, err := av.raw(key)
+		if err != nil {
+			return av, true, err
+		}
+		value = raw
+		if err := verifyChecksum(key, "storage", value, av.sum); err != nil && !a.witness {
+			return av, true, err
+		}
+
+		// rystsov: "If a promise isn't empty during the prepare phase, we
+		// should compare the proposed ballot number against the promise, and
+		// update the promise if the promise is less."
+		//
+		// Here, we exploit the fact that a zero-value ballot number is less
+		// than any non-zero-value ballot number.
+		if av.promise.greaterThan(b) {
+			current = av.promise
+			return av, true, ConflictError{Proposed: b, Existing: av.promise, ExistingKind: "promise"}
+		}
+
+		// Similarly, return a conflict if we already saw a greater ballot
+		// number.
+		if av.accepted.greaterThan(b) {
+			current = av.accepted
+			return av, true, ConflictError{Proposed: b, Existing: av.accepted, ExistingKind: "accepted"}
+		}
+
+		// If everything is satisfied, from the paper: "persist the ballot
+		// number as a promise." Remember it, in case the round is
+		// abandoned. The slice is copied, as other readers may share it.
+		if av.promise != b {
+			promised := append(append([]Ballot(nil), av.promised...), b)
+			if len(promised) > abandonablePromises {
+				av.kept, promised = promised[0], promised[1:]
+			}
+			av.promise, av.promised = b, promised
+		}
+		return av, true, nil
+	})
+	if _, ok := err.(CorruptValueError); ok {
+		level.Error(a.logger).Log("method", "Prepare", "key", key, "err", err)
+		return nil, 0, nil, zeroballot, err
 	}
-
-	// Similarly, return a conflict if we already saw a greater ballot number.
-	if av.accepted.greaterThan(b) {
-		return av.value, av.accepted, ConflictError{Proposed: b, Existing: av.accepted, ExistingKind: "accepted"}
+	if err != nil {
+		return value, av.sum, av.meta.copy(), current, err
 	}
 
-	// If everything is satisfied, from the paper: "persist the ballot number as
-	// a promise."
-	av.promise = b
-	a.values[key] = av
-
 	// From the paper: "and return a confirmation either with an empty value (if
 	// it hasn't accepted any value yet) or with a tuple of an accepted value
 	// and its ballot number."
@@ -102,11 +257,55 @@ func (a *MemoryAcceptor) Prepare(ctx context.Context, key string, age Age, b Bal
 	// which we take to mean "an empty value". The receiver should interpret
 	// value == nil as an empty value and ignore the returned ballot, which will
 	// be zero.
-	return av.value, av.accepted, nil
+	return value, av.sum, av.meta.copy(), av.accepted, nil
+}
+
+// Abandon releases the promise for key, if and only if it's equal to b. A
+// proposer that gives up on a round after the prepare phase can use it to
+// release its promises, so the next proposer needn't use a higher ballot.
+//
+// Only b's promise is released: the proposer of b will never send an accept
+// with it, but the proposers of the promises b replaced may, and a lower
+// ballot mustn't be accepted before theirs. So the promise goes back to the
+// greatest one which hasn't been abandoned, not to zero. The acceptor
+// remembers a few recent promises; abandoning an older one changes nothing.
+func (a *MemoryAcceptor) Abandon(ctx context.Context, key string, b Ballot) (err error) {
+	defer func() {
+		level.Debug(a.logger).Log(
+			"method", "Abandon", "key", key, "B", b,
+			"success", err == nil, "err", err,
+		)
+	}()
+
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	return a.values.update(key, func(av acceptedValue, ok bool) (acceptedValue, bool, error) {
+		if !ok {
+			return av, false, nil
+		}
+		var promised []Ballot
+		for _, p := range av.promised {
+			if p != b {
+				promised = append(promised, p)
+			}
+		}
+		if len(promised) == len(av.promised) {
+			return av, true, nil // not one we can release
+		}
+		av.promise, av.promised = av.kept, promised
+		if n := len(promised); n > 0 {
+			av.promise = promised[n-1]
+		}
+		if !av.promise.greaterThan(av.accepted) {
+			av.promise = zeroballot // the accepted ballot constrains as much
+		}
+		return av, true, nil
+	})
 }
 
 // Accept implements the second-phase responsibilities of an acceptor.
-func (a *MemoryAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte) (err error) {
+func (a *MemoryAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) (err error) {
 	defer func() {
 		level.Debug(a.logger).Log(
 			"method", "Accept", "key", key, "age", age, "B", b,
@@ -114,8 +313,21 @@ func (a *MemoryAcceptor) Accept(ctx context.Context, key string, age Age, b Ball
 		)
 	}()
 
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
+	// As in Prepare, there's no point acting on an expired request.
+	if err := ctx.Err(); err == context.DeadlineExceeded {
+		return err
+	}
+
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	// As in Prepare.
+	if a.awaitingAdmission {
+		return ErrNotAdmitted
+	}
+	if !a.floor.isZero() && !b.greaterThan(a.floor) {
+		return ConflictError{Proposed: b, Existing: a.floor, ExistingKind: "floor"}
+	}
 
 	// From the GC section of the paper: "Acceptors [should] reject messages
 	// from proposers if [the incoming age] is younger than the corresponding
@@ -124,33 +336,74 @@ func (a *MemoryAcceptor) Accept(ctx context.Context, key string, age Age, b Ball
 		return AgeError{Incoming: incoming, Existing: existing}
 	}
 
-	// Select the promise/accepted/value tuple for this key.
-	// A zero value is useful.
-	av := a.values[key]
-
-	// Return a conflict if it already saw a greater ballot number, either in
-	// the promise or in the actual ballot number.
-	//
-	// rystsov: "During the accept phase, it's not necessary for the promise to
-	// be equal to the passed ballot number. The promise simply cannot be
-	// larger. The promise may even be empty; in this case, the request's ballot
-	// number should be greater than the accepted ballot number."
-	if av.promise.greaterThan(b) {
-		return ConflictError{Proposed: b, Existing: av.promise, ExistingKind: "promise"}
+	// Refuse values that were corrupted on the way here.
+	if err := verifyChecksum(key, "accept", value, sum); err != nil {
+		level.Error(a.logger).Log("method", "Accept", "key", key, "err", err)
+		return err
 	}
 
-	// Similarly.
-	if av.accepted.greaterThan(b) {
-		return ConflictError{Proposed: b, Existing: av.accepted, ExistingKind: "accepted"}
+	// Compress the value before taking the key's lock. Witnesses keep
+	// everything but the value, and its metadata.
+	var (
+		stored = value
+		codec  Codec
+		size   int
+	)
+	if a.witness {
+		stored, meta = nil, nil
+	} else {
+		stored, codec, size = a.compress(value)
 	}
 
-	// If everything is satisfied, from the paper: "Erase the promise, mark the
-	// received tuple as the accepted value."
-	av.promise, av.accepted, av.value = zeroballot, b, value
-	a.values[key] = av
-
-	// From the paper: "Return a confirmation."
-	return nil
+	// Select the promise/accepted/value tuple for this key.
+	// A zero value is useful.
+	return a.values.update(key, func(av acceptedValue, _ bool) (acceptedValue, bool, error) {
+		// Return a conflict if it already saw a greater ballot number, either
+		// in the promise or in the actual ballot number.
+		//
+		// rystsov: "During the accept phase, it's not necessary for the
+		// promise to be equal to the passed ballot number. The promise simply
+		// cannot be larger. The promise may even be empty; in this case, the
+		// request's ballot number should be greater than the accepted ballot
+		// number."
+		if av.promise.greaterThan(b) {
+			return av, true, ConflictError{Proposed: b, Existing: av.promise, ExistingKind: "promise"}
+		}
+
+		// Similarly.
+		if av.accepted.greaterThan(b) {
+			return av, true, ConflictError{Proposed: b, Existing: av.accepted, ExistingKind: "accepted"}
+		}
+
+		// The ballot is fine, but we may still refuse to store the value
+		// itself. This is defense in depth against buggy or malicious
+		// proposers, so it produces a distinct error, rather than a conflict.
+		if a.validator != nil {
+			begin := time.Now()
+			err := a.validator(key, value)
+			a.validation.observe(time.Since(begin), err != nil)
+			if err != nil {
+				return av, true, ValidationError{Key: key, Err: err}
+			}
+		}
+
+		// Keep the value we're about to replace, if we're retaining history.
+		if a.history > 0 && !av.accepted.isZero() && av.accepted != b {
+			if old, err := av.raw(key); err == nil {
+				av.history = appendHistory(av.history, Inspection{Value: old, Accepted: av.accepted, AcceptedAt: av.acceptedAt}, a.history)
+			}
+		}
+
+		// If everything is satisfied, from the paper: "Erase the promise, mark
+		// the received tuple as the accepted value."
+		av.promise, av.accepted, av.value, av.sum, av.meta = zeroballot, b, stored, sum, meta.copy()
+		av.promised, av.kept = nil, zeroballot
+		av.codec, av.size = codec, size
+		av.acceptedAt = time.Now()
+
+		// From the paper: "Return a confirmation."
+		return av, true, nil
+	})
 }
 
 // RejectByAge implements part of the garbage collection responsibilities of an
@@ -187,33 +440,78 @@ func (a *MemoryAcceptor) RemoveIfEqual(ctx context.Context, key string, state []
 		)
 	}()
 
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	return a.values.update(key, func(av acceptedValue, ok bool) (acceptedValue, bool, error) {
+		// If the key is already deleted, we don't need to do anything.
+		if !ok {
+			return av, false, nil // great, no work to do
+		}
+
+		// Witnesses don't have the value, but they have its checksum.
+		if a.witness && av.sum != checksumOf(state) {
+			return av, true, ErrNotEqual
+		}
+		if !a.witness {
+			value, err := av.raw(key)
+			if err != nil {
+				return av, true, err
+			}
+			if !bytes.Equal(value, state) {
+				return av, true, ErrNotEqual
+			}
+		}
+
+		return av, false, nil
+	})
+}
+
+// Keys implements Inspecter.
+func (a *MemoryAcceptor) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	a.values.forEach(func(key string, _ acceptedValue) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, nil
+}
 
-	// If the key is already deleted, we don't need to do anything.
-	av, ok := a.values[key]
+// Inspect implements Inspecter.
+func (a *MemoryAcceptor) Inspect(ctx context.Context, key string) (Inspection, error) {
+	av, ok := a.values.get(key)
 	if !ok {
-		return nil // great, no work to do
+		return Inspection{}, nil
 	}
-
-	if !bytes.Equal(av.value, state) {
-		return ErrNotEqual
+	value, err := av.raw(key)
+	if err != nil {
+		return Inspection{}, err
 	}
+	return Inspection{Value: copyValue(value), Meta: av.meta.copy(), Accepted: av.accepted, AcceptedAt: av.acceptedAt}, nil
+}
 
-	delete(a.values, key)
-	return nil
+// MaxBallot implements Inspecter. It returns the greatest ballot promised or
+// accepted for any key.
+func (a *MemoryAcceptor) MaxBallot(ctx context.Context) (Ballot, error) {
+	var max Ballot
+	a.values.forEach(func(_ string, av acceptedValue) bool {
+		for _, b := range []Ballot{av.promise, av.accepted} {
+			if b.greaterThan(max) {
+				max = b
+			}
+		}
+		return true
+	})
+	return max, nil
 }
 
 func (a *MemoryAcceptor) dumpValue(key string) []byte {
-	a.mtx.Lock()
-	defer a.mtx.Unlock()
-	av, ok := a.values[key]
+	av, ok := a.values.get(key)
 	if !ok {
 		return nil
 	}
-	dst := make([]byte, len(av.value))
-	copy(dst, av.value)
-	return dst
+	value, _ := av.raw(key)
+	return copyValue(value)
 }
 
 // ConflictError is returned by acceptors when there's a ballot conflict.
@@ -227,6 +525,57 @@ func (ce ConflictError) Error() string {
 	return fmt.Sprintf("conflict: proposed ballot %s isn't greater than existing '%s' ballot %s", ce.Proposed, ce.ExistingKind, ce.Existing)
 }
 
+// ValueValidator checks a value before an acceptor stores it. A non-nil error
+// means the value is rejected.
+type ValueValidator func(key string, value []byte) error
+
+// ValidationStats counts the values checked by an acceptor's validator.
+type ValidationStats struct {
+	Validated uint64        // values checked, including rejected ones
+	Rejected  uint64        // values which failed validation
+	Duration  time.Duration // spent in the validator
+}
+
+// ValidationStats returns the counts since the acceptor was created. Without
+// WithValueValidator, they're zero.
+func (a *MemoryAcceptor) ValidationStats() ValidationStats {
+	return a.validation.stats()
+}
+
+// validationCounter accumulates ValidationStats. Accepts for different keys
+// run concurrently, so it has its own lock.
+type validationCounter struct {
+	mtx   sync.Mutex
+	total ValidationStats
+}
+
+func (vc *validationCounter) observe(took time.Duration, rejected bool) {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	vc.total.Validated++
+	if rejected {
+		vc.total.Rejected++
+	}
+	vc.total.Duration += took
+}
+
+func (vc *validationCounter) stats() ValidationStats {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+	return vc.total
+}
+
+// ValidationError is returned by acceptors when a value fails validation. It
+// isn't a conflict; retrying the same change won't help.
+type ValidationError struct {
+	Key string
+	Err error
+}
+
+func (ve ValidationError) Error() string {
+	return fmt.Sprintf("validation failed for key %q: %v", ve.Key, ve.Err)
+}
+
 // AgeError is returned by acceptors when there's an age conflict.
 type AgeError struct {
 	Incoming Age
@@ -1,3 +1,184 @@
 package protocol
 
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
 var _ Acceptor = (*MemoryAcceptor)(nil)
+
+func TestValueValidation(t *testing.T) {
+	// Reject values that are too long.
+	maxlen := func(key string, value []byte) error {
+		if len(value) > 4 {
+			return errors.New("value too long")
+		}
+		return nil
+	}
+
+	// Build the cluster.
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithValueValidator(maxlen))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2), WithValueValidator(maxlen))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), WithValueValidator(maxlen))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+		key    = "k"
+	)
+
+	// A valid value should be accepted.
+	if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce("abc")); err != nil {
+		t.Fatalf("valid write: %v", err)
+	}
+
+	// An invalid value should be rejected with a ValidationError.
+	_, _, err := p1.Propose(ctx, key, changeFuncCompareAndSwap("abc", "abcdefg"))
+	if _, ok := err.(ValidationError); !ok {
+		t.Fatalf("invalid write: want ValidationError, have %T (%v)", err, err)
+	}
+
+	// The acceptors counted both writes, and the rejection.
+	var validated, rejected uint64
+	for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+		stats := a.ValidationStats()
+		validated, rejected = validated+stats.Validated, rejected+stats.Rejected
+	}
+	if validated < 2 || rejected < 1 || rejected >= validated {
+		t.Errorf("stats: have %d validated, %d rejected", validated, rejected)
+	}
+
+	// And the original value should remain.
+	if state, _, err := p1.Propose(ctx, key, changeFuncRead); err != nil {
+		t.Fatalf("read: %v", err)
+	} else if want, have := "abc", string(state); want != have {
+		t.Fatalf("read: want %q, have %q", want, have)
+	}
+}
+
+func TestAbandonKeepsReplacedPromise(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a      = NewMemoryAcceptor("a", log.With(logger, "a", "a"))
+		b      = NewMemoryAcceptor("b", log.With(logger, "a", "b"))
+		c      = NewMemoryAcceptor("c", log.With(logger, "a", "c"))
+		ctx    = context.Background()
+		key    = "k"
+		b0     = Ballot{Counter: 4, ID: "0"}
+		b1     = Ballot{Counter: 5, ID: "1"}
+		b2     = Ballot{Counter: 7, ID: "2"}
+	)
+
+	// P1 prepares 5 on A and B. P2 prepares 7 on A and B, and abandons it.
+	for _, ballot := range []Ballot{b1, b2} {
+		for _, acceptor := range []*MemoryAcceptor{a, b} {
+			if _, _, _, _, err := acceptor.Prepare(ctx, key, Age{}, 0, ballot); err != nil {
+				t.Fatalf("prepare %s on %s: %v", ballot, acceptor.Address(), err)
+			}
+		}
+	}
+	for _, acceptor := range []*MemoryAcceptor{a, b} {
+		if err := acceptor.Abandon(ctx, key, b2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// P0, at 4, mustn't get A's promise back, or it could choose x with A
+	// and C, which P1's accept at 5 would then overwrite on A and B.
+	_, _, _, _, err := a.Prepare(ctx, key, Age{}, 0, b0)
+	if ce, ok := err.(ConflictError); !ok || ce.Existing != b1 {
+		t.Fatalf("prepare %s on a: want a conflict with %s, have %v", b0, b1, err)
+	}
+	if _, _, _, _, err := c.Prepare(ctx, key, Age{}, 0, b0); err != nil {
+		t.Fatal(err)
+	}
+	x := []byte("x")
+	if err := a.Accept(ctx, key, Age{}, b0, x, checksumOf(x), nil); err == nil {
+		t.Fatalf("accept %s on a: want a conflict, have none", b0)
+	}
+
+	// P1's round goes ahead as usual.
+	y := []byte("y")
+	for _, acceptor := range []*MemoryAcceptor{a, b} {
+		if err := acceptor.Accept(ctx, key, Age{}, b1, y, checksumOf(y), nil); err != nil {
+			t.Fatalf("accept %s on %s: %v", b1, acceptor.Address(), err)
+		}
+	}
+
+	// Abandons may arrive out of order. Once every promise is abandoned,
+	// none is left.
+	for _, counter := range []uint64{10, 11} {
+		if _, _, _, _, err := c.Prepare(ctx, "other", Age{}, 0, Ballot{Counter: counter, ID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, counter := range []uint64{10, 11} {
+		if err := c.Abandon(ctx, "other", Ballot{Counter: counter, ID: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, _, _, err := c.Prepare(ctx, "other", Age{}, 0, Ballot{Counter: 1, ID: "0"}); err != nil {
+		t.Errorf("prepare after every promise was abandoned: want no error, have %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithNodeID("node-1"))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1)
+		ctx    = context.Background()
+	)
+
+	// Leave one key with an accepted value, and another with just a promise.
+	if _, _, err := p1.Propose(ctx, "accepted", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, _, err := a1.Prepare(ctx, "promised", Age{}, 0, Ballot{Counter: 100, ID: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := func() (map[string]Inspection, Ballot) {
+		inspections := map[string]Inspection{}
+		for _, key := range []string{"accepted", "promised"} {
+			inspection, err := a1.Inspect(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inspections[key] = inspection
+		}
+		max, err := a1.MaxBallot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inspections, max
+	}
+	beforeInspections, beforeMax := snapshot()
+
+	for i := 0; i < 10; i++ {
+		info, err := a1.Ping(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := (PingInfo{NodeID: "node-1", ProtocolVersion: ProtocolVersion, Keys: 2}), info; want != have {
+			t.Fatalf("want %+v, have %+v", want, have)
+		}
+	}
+
+	afterInspections, afterMax := snapshot()
+	if !reflect.DeepEqual(beforeInspections, afterInspections) {
+		t.Errorf("inspections: before %+v, after %+v", beforeInspections, afterInspections)
+	}
+	if beforeMax != afterMax {
+		t.Errorf("max ballot: before %s, after %s", beforeMax, afterMax)
+	}
+
+	// In particular, the promise still holds against lower ballots.
+	if _, _, _, _, err := a1.Prepare(ctx, "promised", Age{}, 0, Ballot{Counter: 99, ID: "x"}); err == nil {
+		t.Errorf("prepare below the promise: want error, have none")
+	}
+}
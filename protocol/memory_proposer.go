@@ -2,16 +2,27 @@ package protocol
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
 
-// ChangeFunc models client change proposals.
+// ChangeFunc models client change proposals. A nil state means the key is
+// absent, and returning nil deletes it; an empty, non-nil state is a present,
+// empty value.
 type ChangeFunc func(current []byte) (new []byte)
 
+// ReportingChangeFunc is like ChangeFunc, but also reports whether it applied
+// its change, e.g. if a compare-and-swap's comparison succeeded. Comparing the
+// new and current states can't tell the difference between a change that
+// didn't apply, and one that applied but happened to produce the same state.
+type ReportingChangeFunc func(current []byte) (new []byte, changed bool)
+
 var (
 	// ErrPrepareFailed indicates a failure during the first "prepare" phase.
 	ErrPrepareFailed = errors.New("not enough confirmations during prepare phase; proposer ballot was fast-forwarded")
@@ -24,51 +35,242 @@ var (
 
 	// ErrNotFound indicates an attempt to remove a non-present acceptor.
 	ErrNotFound = errors.New("not found")
+
+	// ErrAgeRegression indicates an attempt to move a proposer's age backwards.
+	ErrAgeRegression = errors.New("age can't move backwards")
+
+	// ErrIdentityMismatch indicates an acceptor at a new address isn't the
+	// same logical acceptor as the one it's meant to replace.
+	ErrIdentityMismatch = errors.New("acceptor identity mismatch")
 )
 
+// QuorumError is returned by proposers when a phase can't reach quorum. Err is
+// ErrPrepareFailed or ErrAcceptFailed, depending on the phase, and Failures
+// maps the address of each acceptor that failed to its error. LikelyPartition
+// is true if the failures look like a network partition, rather than crashed
+// acceptors or contention; see Classes for the details.
+type QuorumError struct {
+	Err             error
+	Failures        map[string]error
+	LikelyPartition bool
+}
+
+func (qe QuorumError) Error() string {
+	return fmt.Sprintf("%v (%d failed)", qe.Err, len(qe.Failures))
+}
+
+// Cause returns Err, for compatibility with github.com/pkg/errors.
+func (qe QuorumError) Cause() error {
+	return qe.Err
+}
+
+// Unwrap returns Err, so errors.Is works.
+func (qe QuorumError) Unwrap() error {
+	return qe.Err
+}
+
+func isPrepareFailed(err error) bool {
+	qe, ok := untraced(err).(QuorumError)
+	return ok && qe.Err == ErrPrepareFailed
+}
+
 // MemoryProposer (from the paper) "performs the initialization by communicating
 // with acceptors, and keep minimal state needed to generate unique increasing
 // update IDs (ballot numbers)."
 type MemoryProposer struct {
-	mtx       sync.Mutex
-	age       Age
-	ballot    Ballot
-	preparers map[string]Preparer
-	accepters map[string]Accepter
-	logger    log.Logger
+	mtx             sync.Mutex
+	age             Age
+	ballot          Ballot
+	epoch           uint64
+	fencing         bool
+	abandon         bool
+	prepareBudget   float64
+	preparers       map[string]Preparer
+	accepters       map[string]Accepter
+	weights         map[string]int // absent means 1
+	health          *healthTracker
+	keyStats        *keyStatsTracker
+	coalescer       *coalescer
+	sizeObserver    SizeObserver
+	thrifty         time.Duration
+	latency         *latencyTracker
+	acceptorLatency *acceptorLatencyTracker
+	fanoutStats     FanoutStats
+	failureObserver FailureObserver
+	repairer        *repairer
+	tracer          *tracer
+	configPath      string
+	lastConfig      *Configuration
+	hooks           []ConfigurationHook
+	notified        *Configuration // as of the last call to the hooks
+	configSkew      bool
+	summary         *summaryTracker
+	strict          bool
+	lifecycle       map[string]lifecycle // of acceptors part of the way through a change
+	changeTimeout   time.Duration
+	snapshotExtra   map[string]json.RawMessage // fields of the last imported snapshot we don't understand
+	changeHooks     []ChangeHook
+	generationPath  string
+	logger          log.Logger
 }
 
 // NewMemoryProposer returns a usable Proposer uniquely identified by id.
 // It communicates with the initial set of acceptors.
 func NewMemoryProposer(id string, logger log.Logger, initial ...Acceptor) *MemoryProposer {
+	return NewMemoryProposerWithOptions(id, logger, initial)
+}
+
+// NewMemoryProposerWithOptions is like NewMemoryProposer, but also applies the
+// options.
+func NewMemoryProposerWithOptions(id string, logger log.Logger, initial []Acceptor, options ...MemoryProposerOption) *MemoryProposer {
 	p := &MemoryProposer{
 		age:       Age{Counter: 0, ID: id},
 		ballot:    Ballot{Counter: 0, ID: id},
+		epoch:     initialEpoch,
 		preparers: map[string]Preparer{},
 		accepters: map[string]Accepter{},
+		health:    newHealthTracker(10, 0.5),
 		logger:    logger,
 	}
+	for _, option := range options {
+		option(p)
+	}
+	if p.generationPath != "" {
+		p.ballot.Generation = p.nextGeneration()
+	}
 	for _, target := range initial {
 		p.preparers[target.Address()] = target
 		p.accepters[target.Address()] = target
 	}
+	p.publishSummary()
 	return p
 }
 
-// Propose a change from a client into the cluster.
+// MemoryProposerOption sets an optional parameter on a MemoryProposer.
+type MemoryProposerOption func(*MemoryProposer)
+
+// WithEpochFencing enables configuration epoch fencing. The proposer includes
+// its known epoch in every prepare message, and acceptors which know of a newer
+// epoch reject them with a StaleConfigurationError. By default, fencing is
+// disabled, so that proposers can be upgraded one at a time.
+func WithEpochFencing() MemoryProposerOption {
+	return func(p *MemoryProposer) { p.fencing = true }
+}
+
+// WithAbandonment enables promise release for canceled proposals. If the
+// context is canceled after the prepare phase but before the accept phase,
+// the proposer asynchronously asks each preparer that made a promise to
+// release it. The preparer falls back to the greatest promise it made to any
+// other round, as in MemoryAcceptor.Abandon. By default, promises are left in
+// place.
+func WithAbandonment() MemoryProposerOption {
+	return func(p *MemoryProposer) { p.abandon = true }
+}
+
+// WithHealthWindow sets how many recent requests to each acceptor are
+// considered when computing health, and the minimum success rate over that
+// window for an acceptor to be considered healthy. By default, the window is
+// 10 requests, and the threshold is 0.5. A window of less than one request is
+// taken as one.
+func WithHealthWindow(requests int, threshold float64) MemoryProposerOption {
+	if requests < 1 {
+		requests = 1
+	}
+	return func(p *MemoryProposer) { p.health = newHealthTracker(requests, threshold) }
+}
+
+// SizeObserver is called after every successful round, with the size of the
+// state read in the prepare phase, and the size of the state written in the
+// accept phase. It's called synchronously, with the proposer locked, so it
+// should be fast, and mustn't call the proposer.
+type SizeObserver func(key string, read, written int)
+
+// WithSizeObserver installs a SizeObserver, typically to feed metrics. By
+// default, sizes aren't observed.
+func WithSizeObserver(f SizeObserver) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.sizeObserver = f }
+}
+
+// WithKeyStats enables per-key statistics for up to capacity keys, available
+// via KeyStats. When more keys than that are proposed, the least recently
+// proposed are forgotten. By default, no statistics are kept.
+func WithKeyStats(capacity int) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.keyStats = newKeyStatsTracker(capacity) }
+}
+
+// Propose a change from a client into the cluster. Metadata stored with the
+// value is kept if the change leaves the value as it was, and cleared if not.
 func (p *MemoryProposer) Propose(ctx context.Context, key string, f ChangeFunc) (state []byte, b Ballot, err error) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
 
-	state, b, err = p.propose(ctx, key, f, regularQuorum)
-	if err == ErrPrepareFailed {
-		// allow a single retry, to hide fast-forwards
-		state, b, err = p.propose(ctx, key, f, regularQuorum)
+	state, _, b, err = p.proposeRetry(ctx, key, f, writeMeta, regularQuorum, roundOptions{})
+	return state, b, err
+}
+
+// ProposeWithMeta is like Propose, but replaces the metadata stored alongside
+// the value with meta. The change function still sees only the value.
+func (p *MemoryProposer) ProposeWithMeta(ctx context.Context, key string, f ChangeFunc, meta Metadata) (state []byte, b Ballot, err error) {
+	if meta.size() > MaxMetadataSize {
+		return nil, b, ErrMetadataTooLarge
 	}
 
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	meta = meta.copy()
+	replace := func(Metadata, bool) Metadata { return meta }
+	state, _, b, err = p.proposeRetry(ctx, key, f, replace, regularQuorum, roundOptions{})
 	return state, b, err
 }
 
+// ProposeReporting is like Propose, but for a ReportingChangeFunc. It returns
+// what the change function reported in the successful round.
+func (p *MemoryProposer) ProposeReporting(ctx context.Context, key string, f ReportingChangeFunc) (state []byte, changed bool, b Ballot, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// The change function is called again if a round fails, so only the last
+	// call counts.
+	var previous []byte
+	g := func(current []byte) []byte {
+		var next []byte
+		previous = current
+		next, changed = f(current)
+		return next
+	}
+	state, _, b, err = p.proposeRetry(ctx, key, g, writeMeta, regularQuorum, roundOptions{})
+	if err != nil {
+		return nil, false, b, err
+	}
+	if changed {
+		p.publishChange(key, previous, state, b)
+	}
+	return state, changed, b, nil
+}
+
+// ReadWithMeta performs an identity read of the key, returning the value
+// along with its metadata.
+func (p *MemoryProposer) ReadWithMeta(ctx context.Context, key string) (state []byte, meta Metadata, b Ballot, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	identity := func(x []byte) []byte { return x }
+	return p.proposeRetry(ctx, key, identity, keepMeta, regularQuorum, roundOptions{})
+}
+
+func (p *MemoryProposer) proposeRetry(ctx context.Context, key string, f ChangeFunc, mf metaFunc, qf quorumFunc, ro roundOptions) (state []byte, meta Metadata, b Ballot, err error) {
+	defer p.observeSummary(time.Now(), &err)
+	state, meta, b, err = p.propose(ctx, key, f, mf, qf, ro)
+	p.keyStats.observe(key, false, b, state, err)
+	if isPrepareFailed(err) {
+		// allow a single retry, to hide fast-forwards
+		state, meta, b, err = p.propose(ctx, key, f, mf, qf, ro)
+		p.keyStats.observe(key, true, b, state, err)
+	}
+	return state, meta, b, err
+}
+
 // quorumFunc declares how many of n nodes are required.
 type quorumFunc func(n int) int
 
@@ -77,7 +279,44 @@ var (
 	fullQuorum    = func(n int) int { return n }           // i.e. 2F+1
 )
 
-func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc, qf quorumFunc) (state []byte, b Ballot, err error) {
+// metaFunc declares the metadata to store, given the current metadata, and
+// whether the change changed the value.
+type metaFunc func(current Metadata, changed bool) Metadata
+
+// keepMeta keeps the current metadata, e.g. for reads.
+var keepMeta = func(current Metadata, _ bool) Metadata { return current }
+
+// writeMeta keeps the current metadata only if the value is unchanged. It
+// describes the write of the current value, not of a new one.
+var writeMeta = func(current Metadata, changed bool) Metadata {
+	if changed {
+		return nil
+	}
+	return current
+}
+
+// roundPrecondition is checked after the prepare phase, with what it found.
+// An error stops the round, and abandons it, before the change is applied.
+type roundPrecondition func(state []byte, meta Metadata, accepted Ballot) error
+
+// roundOptions are the parameters of a round beyond its change, metadata and
+// quorum, which only some callers need. The zero value is a plain round.
+type roundOptions struct {
+	precondition roundPrecondition // checked after the prepare phase, e.g. during Import
+	explanation  *Explanation      // filled in after the prepare phase, which ends the round, during Explain
+	excluded     map[string]bool   // not sent anything, during FullIdentityReadExcluding
+	tracer       *tracer           // instead of the proposer's, if not nil
+}
+
+// included returns the addresses which aren't excluded from the round.
+func (ro roundOptions) included(addrs []string) []string {
+	if len(ro.excluded) == 0 {
+		return addrs
+	}
+	return withoutAddrs(addrs, ro.excluded)
+}
+
+func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc, mf metaFunc, qf quorumFunc, ro roundOptions) (state []byte, meta Metadata, b Ballot, err error) {
 	// From the paper: "A client submits the change function to a proposer. The
 	// proposer generates a ballot number B, by incrementing the current ballot
 	// number's counter."
@@ -88,11 +327,51 @@ func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc,
 	// violated."
 	b = p.ballot.inc()
 
+	// The age can be changed while the round is in flight, so every request in
+	// the round uses a copy.
+	age := p.age
+
+	// Decide which epoch to send, if any.
+	var epoch uint64
+	if p.fencing {
+		epoch = p.epoch
+	}
+
 	// Set up a logger, for debugging.
 	logger := level.Debug(log.With(p.logger, "method", "Propose", "key", key, "B", b))
 
-	// If prepare is successful, we'll have an accepted current state.
-	var currentState []byte
+	// Set up a trace, if tracing is enabled. Failures are wrapped on the way
+	// out, so the trace travels with the error.
+	tracer := p.tracer
+	if ro.tracer != nil {
+		tracer = ro.tracer
+	}
+	trace := tracer.start(key, b)
+	defer tracer.finish(trace, &err)
+
+	// Each phase gets its share of the deadline, if there is one.
+	begin := time.Now()
+	prepareCtx, cancel := p.prepareContext(ctx, begin)
+	defer cancel()
+
+	// Preparers wait for the round to finish, to learn if it was abandoned.
+	var (
+		roundDone = make(chan struct{})
+		abandoned bool
+	)
+	defer close(roundDone)
+
+	// If prepare is successful, we'll have an accepted current state, and the
+	// metadata and ballot that go with it.
+	var (
+		currentState  []byte
+		currentMeta   Metadata
+		currentBallot Ballot
+	)
+
+	// Preparers which returned an older value than the current state, if read
+	// repair is enabled.
+	var stale map[string]bool
 
 	// Prepare phase.
 	{
@@ -101,39 +380,103 @@ func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc,
 
 		// We collect prepare results into this channel.
 		type result struct {
-			addr   string
-			value  []byte
-			ballot Ballot
-			err    error
+			addr    string
+			value   []byte
+			meta    Metadata
+			ballot  Ballot
+			witness bool
+			err     error
 		}
 		results := make(chan result, len(p.preparers))
 
-		// Broadcast the prepare requests to the preparers.
-		// (Preparers are just acceptors, serving their first role.)
+		// Broadcast the prepare requests to the preparers, or as many as we
+		// need, if we're thrifty. (Preparers are just acceptors, serving their
+		// first role.)
+		var (
+			addrs   = p.order(ro.included(preparerAddrs(p.preparers)))
+			targets = make([]Preparer, len(addrs))
+		)
+		for i, addr := range addrs {
+			targets[i] = p.preparers[addr]
+		}
 		logger.Log("broadcast_to", len(p.preparers))
-		for addr, target := range p.preparers {
+		fo := p.newFanout(len(addrs), p.quorumSize(addrs, qf), func(i int) {
 			go func(addr string, target Preparer) {
-				value, ballot, err := target.Prepare(ctx, key, p.age, b)
-				results <- result{addr, value, ballot, err}
-			}(addr, target)
-		}
+				begin := time.Now()
+				value, sum, meta, ballot, err := target.Prepare(prepareCtx, key, age, epoch, b)
+				p.health.observe(addr, err)
+				p.latency.observe(addr, time.Since(begin), err)
+				p.acceptorLatency.observe(time.Now(), addr, phasePrepare, time.Since(begin), err)
+				promised := err == nil
+				witness := roleOf(target) == RoleWitness
+				if err == nil && !witness {
+					err = verifyChecksum(key, "prepare", value, sum)
+				}
+				results <- result{addr, value, meta, ballot, witness, err}
+
+				// If we made a promise, and the round is abandoned, release it.
+				// Doing it here, rather than in the main goroutine, ensures the
+				// release can't overtake the prepare.
+				if p.abandon && promised {
+					<-roundDone
+					if abandoned {
+						if err := target.Abandon(context.Background(), key, b); err != nil {
+							logger.Log("addr", addr, "abandon", "failed", "err", err)
+						}
+					}
+				}
+			}(addrs[i], targets[i])
+		})
+		defer fo.stop()
 
 		// From the paper: "The proposer waits for F+1 confirmations. If they
 		// all contain the empty value, then the proposer defines the current
 		// state as nil; otherwise, it picks the value of the tuple with the
 		// highest ballot number."
 		var (
-			quorum          = qf(len(p.preparers))
+			total           = p.totalWeight(addrs)
+			quorum          = qf(total)
+			tolerance       = total - quorum
+			failures        = map[string]error{}
 			biggestConfirm  Ballot
+			biggestWitness  Ballot
 			biggestConflict Ballot
 		)
+		prepared := map[string]Ballot{}
 
 		// Broadcast the prepare request to the preparers. Observe that once
 		// we've got confirmation from a quorum of preparers, we ignore any
-		// subsequent messages.
-		for i := 0; i < cap(results) && quorum > 0; i++ {
-			result := <-results
-			if result.err != nil {
+		// subsequent messages. Similarly, once so many have failed that quorum
+		// is impossible, or the phase is out of time, there's no point waiting
+		// for the rest. A witness ahead of every full replica means we don't
+		// know the current value, so we wait for more even after quorum.
+		expired, release := deadlineTimer(prepareCtx)
+		defer release()
+		witnessAhead := func() bool { return biggestWitness.greaterThan(biggestConfirm) }
+		for i := 0; i < cap(results) && (quorum > 0 || witnessAhead()) && p.failedWeight(failures) <= tolerance; i++ {
+			var result result
+			select {
+			case result = <-results:
+			case <-expired:
+				logger.Log("result", "deadline", "abandon", p.abandon)
+				abandoned = p.abandon
+				return nil, nil, b, deadlineError(prepareCtx, "prepare", begin, begin)
+			}
+			trace.add("prepare", result.addr, result.ballot, result.err)
+			if sce, ok := result.err.(StaleConfigurationError); ok {
+				// A stale configuration means our quorum math can't be
+				// trusted, so the proposal fails immediately. The caller
+				// should refresh our configuration before trying again.
+				logger.Log("addr", result.addr, "result", "stale", "err", result.err)
+				return nil, nil, b, sce
+			} else if _, ok := result.err.(CorruptValueError); ok {
+				// A corrupt value can't be used, but the other preparers may
+				// still make a quorum. If so, the accept phase overwrites the
+				// corrupt value, which is all the read repair we need.
+				level.Error(p.logger).Log("method", "Propose", "key", key, "addr", result.addr, "err", result.err)
+				failures[result.addr] = result.err
+				fo.more()
+			} else if result.err != nil {
 				// A conflict indicates that the proposed ballot is too old and
 				// will be rejected; the largest conflicting ballot number
 				// should be used to fast-forward the proposer's ballot number
@@ -142,39 +485,115 @@ func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc,
 				if result.ballot.greaterThan(biggestConflict) {
 					biggestConflict = result.ballot
 				}
+				failures[result.addr] = result.err
+				fo.more()
 			} else {
 				// A confirmation indicates the proposed ballot will succeed,
 				// and the preparer has accepted it as a promise; the largest
 				// confirmed ballot number is used to select which returned
-				// value will be chosen as the current value.
-				logger.Log("addr", result.addr, "result", "confirm", "ballot", result.ballot)
-				if result.ballot.greaterThan(biggestConfirm) {
-					biggestConfirm, currentState = result.ballot, result.value
+				// value will be chosen as the current value. Witnesses count
+				// toward quorum, but never provide the value.
+				logger.Log("addr", result.addr, "result", "confirm", "ballot", result.ballot, "witness", result.witness)
+				if result.witness {
+					if result.ballot.greaterThan(biggestWitness) {
+						biggestWitness = result.ballot
+					}
+				} else if result.ballot.greaterThan(biggestConfirm) {
+					biggestConfirm, currentState, currentMeta = result.ballot, result.value, result.meta
+				}
+				if p.repairer != nil {
+					prepared[result.addr] = result.ballot
+				}
+				quorum -= p.weight(result.addr)
+				if quorum <= 0 && witnessAhead() {
+					fo.more()
 				}
-				quorum--
 			}
 		}
+		fo.stop()
+
+		// Fast-forward our ballot number's counter to the highest number we
+		// saw from the conflicted preparers, in one jump, however far behind
+		// we are, so a subsequent proposal might succeed. A preparer which
+		// conflicted will conflict again, even if this round succeeds, so we
+		// jump past it either way.
+		p.fastForwardPast(biggestConflict)
 
 		// If we finish collecting results and haven't achieved quorum, the
-		// proposal fails. We should fast-forward our ballot number's counter to
-		// the highest number we saw from the conflicted preparers, so a
-		// subsequent proposal might succeed. We could try to re-submit the same
-		// request with our updated ballot number, but for now let's leave that
-		// responsibility to the caller.
+		// proposal fails. We could try to re-submit the same request with our
+		// updated ballot number, but for now let's leave that responsibility
+		// to the caller.
 		if quorum > 0 {
 			logger.Log("result", "failed", "fast_forward_to", biggestConflict.Counter)
-			p.ballot.Counter = biggestConflict.Counter // fast-forward
-			return nil, b, ErrPrepareFailed
+			if prepareCtx.Err() == context.DeadlineExceeded {
+				return nil, nil, b, deadlineError(prepareCtx, "prepare", begin, begin)
+			}
+			return nil, nil, b, p.quorumError(ErrPrepareFailed, failures)
+		}
+		if witnessAhead() {
+			logger.Log("result", "failed", "witness_ballot", biggestWitness, "full_ballot", biggestConfirm)
+			return nil, nil, b, ErrNoFullReplica
 		}
 
 		logger.Log("result", "success")
+		currentBallot = biggestConfirm
+		stale = make(map[string]bool, len(prepared))
+		for addr, ballot := range prepared {
+			if biggestConfirm.greaterThan(ballot) {
+				stale[addr] = true
+			}
+		}
+	}
+
+	// If the caller has gone away in the meantime, there's no point carrying
+	// on. But our promises will force the next proposer to use a higher ballot
+	// number, so release them, if we're allowed to.
+	if err := ctx.Err(); err != nil {
+		logger.Log("result", "canceled", "abandon", p.abandon, "err", err)
+		abandoned = p.abandon
+		if err == context.DeadlineExceeded {
+			return nil, nil, b, deadlineError(ctx, "accept", begin, time.Now())
+		}
+		return nil, nil, b, err
+	}
+
+	// A precondition which fails stops here, too, and abandons the round.
+	if ro.precondition != nil {
+		if err := ro.precondition(currentState, currentMeta, currentBallot); err != nil {
+			logger.Log("result", "precondition", "abandon", p.abandon, "err", err)
+			abandoned = p.abandon
+			return nil, nil, b, err
+		}
+	}
+
+	// An explanation stops here, and abandons the round, as if canceled.
+	if ro.explanation != nil {
+		logger.Log("result", "explained", "abandon", p.abandon)
+		abandoned = p.abandon
+		*ro.explanation = Explanation{Current: currentState, Meta: currentMeta, Ballot: currentBallot}
+		return currentState, currentMeta, b, nil
 	}
 
 	// We've successfully completed the prepare phase. From the paper: "The
 	// proposer applies the change function to the current state. It will send
 	// that new state along with the generated ballot number B (together, known
 	// as an "accept" message) to the acceptors."
-	newState := f(currentState)
+	//
+	// A change function which panics, or times out, fails the proposal, and
+	// abandons the round, as if canceled.
+	newState, _, err := p.applyChange(ctx, key, func(current []byte) ([]byte, bool) { return f(current), true }, currentState)
+	if err != nil {
+		logger.Log("result", "change_failed", "abandon", p.abandon, "err", err)
+		abandoned = p.abandon
+		if err == context.DeadlineExceeded {
+			return nil, nil, b, deadlineError(ctx, "accept", begin, time.Now())
+		}
+		return nil, nil, b, err
+	}
+	var (
+		newSum  = checksumOf(newState)
+		newMeta = mf(currentMeta, !equalValues(currentState, newState))
+	)
 
 	// Accept phase.
 	{
@@ -183,59 +602,141 @@ func (p *MemoryProposer) propose(ctx context.Context, key string, f ChangeFunc,
 
 		// We collect accept results into this channel.
 		type result struct {
-			addr string
-			err  error
+			addr    string
+			witness bool
+			err     error
 		}
 		results := make(chan result, len(p.accepters))
 
-		// Broadcast accept messages to the accepters.
+		// Broadcast accept messages to the accepters, or as many as we need.
+		var (
+			addrs   = p.order(ro.included(accepterAddrs(p.accepters)))
+			targets = make([]Accepter, len(addrs))
+		)
+		for i, addr := range addrs {
+			targets[i] = p.accepters[addr]
+		}
 		logger.Log("broadcast_to", len(p.accepters))
-		for addr, target := range p.accepters {
+		fo := p.newFanout(len(addrs), p.quorumSize(addrs, qf), func(i int) {
 			go func(addr string, target Accepter) {
-				err := target.Accept(ctx, key, p.age, b, newState)
-				results <- result{addr, err}
-			}(addr, target)
-		}
+				begin := time.Now()
+				err := target.Accept(ctx, key, age, b, newState, newSum, newMeta)
+				p.health.observe(addr, err)
+				p.latency.observe(addr, time.Since(begin), err)
+				p.acceptorLatency.observe(time.Now(), addr, phaseAccept, time.Since(begin), err)
+				results <- result{addr, roleOf(target) == RoleWitness, err}
+			}(addrs[i], targets[i])
+		})
+		defer fo.stop()
 
 		// From the paper: "The proposer waits for the F+1 confirmations."
 		// Observe that once we've got confirmation from a quorum of accepters,
-		// we ignore any subsequent messages.
-		quorum := qf(len(p.accepters))
-		for i := 0; i < cap(results) && quorum > 0; i++ {
-			result := <-results
-			if result.err != nil {
-				logger.Log("addr", result.addr, "result", "conflict", "err", err)
+		// we ignore any subsequent messages; and, as in the prepare phase, once
+		// quorum is impossible, or we're out of time, we stop waiting. A quorum
+		// of witnesses alone doesn't store the value, so we wait for a full
+		// replica, too.
+		var (
+			total       = p.totalWeight(addrs)
+			quorum      = qf(total)
+			tolerance   = total - quorum
+			failures    = map[string]error{}
+			validation  error
+			stored      bool
+			acceptBegin = time.Now()
+		)
+		expired, release := deadlineTimer(ctx)
+		defer release()
+		for i := 0; i < cap(results) && (quorum > 0 || !stored) && p.failedWeight(failures) <= tolerance; i++ {
+			var result result
+			select {
+			case result = <-results:
+			case <-expired:
+				logger.Log("result", "deadline")
+				return nil, nil, b, deadlineError(ctx, "accept", begin, acceptBegin)
+			}
+			trace.add("accept", result.addr, Ballot{}, result.err)
+			if ve, ok := result.err.(ValidationError); ok {
+				logger.Log("addr", result.addr, "result", "invalid", "err", result.err)
+				validation = ve
+				failures[result.addr] = result.err
+				fo.more()
+			} else if result.err != nil {
+				logger.Log("addr", result.addr, "result", "conflict", "err", result.err)
+				failures[result.addr] = result.err
+				fo.more()
 			} else {
-				logger.Log("addr", result.addr, "result", "confirm")
-				quorum--
+				logger.Log("addr", result.addr, "result", "confirm", "witness", result.witness)
+				delete(stale, result.addr) // it's up to date now
+				quorum -= p.weight(result.addr)
+				stored = stored || !result.witness
+				if quorum <= 0 && !stored {
+					fo.more()
+				}
 			}
 		}
+		fo.stop()
 
-		// If we don't get quorum, I guess we must fail the proposal.
+		// If we don't get quorum, I guess we must fail the proposal. If any
+		// accepter refused the value itself, that's more useful to the caller
+		// than a generic failure, as retrying won't help.
+		// Accepters which conflicted have seen a higher ballot since the
+		// prepare phase, and say which; jump past it, as above.
+		for _, err := range failures {
+			if ce, ok := err.(ConflictError); ok {
+				p.fastForwardPast(ce.Existing)
+			}
+		}
 		if quorum > 0 {
 			logger.Log("result", "failed", "err", "not enough confirmations")
-			return nil, b, ErrAcceptFailed
+			if validation != nil {
+				return nil, nil, b, validation
+			}
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, nil, b, deadlineError(ctx, "accept", begin, acceptBegin)
+			}
+			return nil, nil, b, p.quorumError(ErrAcceptFailed, failures)
+		}
+		if !stored {
+			logger.Log("result", "failed", "err", "no full replica confirmed")
+			return nil, nil, b, ErrNoFullReplica
 		}
 
 		// Log the success.
 		logger.Log("result", "success")
+
+		// Any preparer that returned an older value, and hasn't confirmed
+		// the accept yet, gets it again.
+		if p.repairer != nil {
+			for addr := range stale {
+				if target, ok := p.accepters[addr]; ok {
+					p.repairer.enqueue(repair{addr, target, key, age, b, newState, newSum, newMeta})
+				}
+			}
+		}
+	}
+
+	// Report the sizes, and return the new state to the caller.
+	if p.sizeObserver != nil {
+		p.sizeObserver(key, len(currentState), len(newState))
 	}
+	return newState, newMeta, b, nil
+}
 
-	// Return the new state to the caller.
-	return newState, b, nil
+// fastForwardPast moves the ballot number's counter up to b's, so the next
+// ballot is greater than b. Failures that aren't conflicts don't carry a
+// ballot, so it never moves the counter backwards, which would reuse ballot
+// numbers. It must be called with the mutex held.
+func (p *MemoryProposer) fastForwardPast(b Ballot) {
+	if b.Counter > p.ballot.Counter {
+		p.ballot.Counter = b.Counter
+	}
 }
 
 // AddAccepter adds the target acceptor to the pool of accepters used in the
 // second phase of proposals. It's the first step in growing the cluster, which
 // is a global process that needs to be orchestrated by an operator.
 func (p *MemoryProposer) AddAccepter(target Acceptor) error {
-	p.mtx.Lock()
-	defer p.mtx.Unlock()
-	if _, ok := p.accepters[target.Address()]; ok {
-		return ErrDuplicate
-	}
-	p.accepters[target.Address()] = target
-	return nil
+	return p.AddWeightedAccepter(target, 1)
 }
 
 // AddPreparer adds the target acceptor to the pool of preparers used in the
@@ -247,7 +748,15 @@ func (p *MemoryProposer) AddPreparer(target Acceptor) error {
 	if _, ok := p.preparers[target.Address()]; ok {
 		return ErrDuplicate
 	}
+	if err := p.checkDuplicate(target); err != nil {
+		return err
+	}
+	if err := p.strictStep(StepAddPreparer, target.Address()); err != nil {
+		return err
+	}
 	p.preparers[target.Address()] = target
+	p.advanceLifecycle(StepAddPreparer, target.Address())
+	p.configurationChanged()
 	return nil
 }
 
@@ -260,7 +769,22 @@ func (p *MemoryProposer) RemovePreparer(target Acceptor) error {
 	if _, ok := p.preparers[target.Address()]; !ok {
 		return ErrNotFound
 	}
+	if err := p.strictStep(StepRemovePreparer, target.Address()); err != nil {
+		return err
+	}
+	c := p.configuration()
+	c.Preparers = withoutAddrs(c.Preparers, map[string]bool{target.Address(): true})
+	if err := c.validateIntersection(); err != nil {
+		return err
+	}
 	delete(p.preparers, target.Address())
+	if _, ok := p.accepters[target.Address()]; ok {
+		p.advanceLifecycle(StepRemovePreparer, target.Address())
+	}
+	if _, ok := p.accepters[target.Address()]; !ok {
+		p.health.forget(target.Address())
+	}
+	p.configurationChanged()
 	return nil
 }
 
@@ -273,7 +797,185 @@ func (p *MemoryProposer) RemoveAccepter(target Acceptor) error {
 	if _, ok := p.accepters[target.Address()]; !ok {
 		return ErrNotFound
 	}
+	if err := p.strictStep(StepRemoveAccepter, target.Address()); err != nil {
+		return err
+	}
+	c := p.configuration()
+	c.Accepters = withoutAddrs(c.Accepters, map[string]bool{target.Address(): true})
+	if err := c.validateIntersection(); err != nil {
+		return err
+	}
 	delete(p.accepters, target.Address())
+	p.advanceLifecycle(StepRemoveAccepter, target.Address())
+	delete(p.weights, target.Address())
+	if _, ok := p.preparers[target.Address()]; !ok {
+		p.health.forget(target.Address())
+	}
+	p.configurationChanged()
+	return nil
+}
+
+// FastForwardEpoch sets the proposer's known configuration epoch, if the
+// given epoch is newer. It's called after each configuration change.
+func (p *MemoryProposer) FastForwardEpoch(epoch uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if epoch > p.epoch {
+		p.epoch = epoch
+	}
+}
+
+// Health reports how close the proposer is to losing quorum, based on the
+// recent success rate of requests to each acceptor. Conflicts and other
+// protocol rejections don't count as failures. A configuration mismatch found
+// by a ConfigurationMonitor makes the proposer at least degraded.
+func (p *MemoryProposer) Health() Health {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.currentHealth()
+}
+
+// currentHealth implements Health. It must be called with the mutex held.
+func (p *MemoryProposer) currentHealth() Health {
+	var preparers, accepters []string
+	for addr := range p.preparers {
+		preparers = append(preparers, addr)
+	}
+	for addr := range p.accepters {
+		accepters = append(accepters, addr)
+	}
+
+	h := Health{
+		Preparers:         p.health.failing(preparers),
+		Accepters:         p.health.failing(accepters),
+		ConfigurationSkew: p.configSkew,
+	}
+	h.State = worseHealth(
+		healthState(p.totalWeight(preparers), p.totalWeight(h.Preparers), regularQuorum),
+		healthState(p.totalWeight(accepters), p.totalWeight(h.Accepters), regularQuorum),
+	)
+	if h.ConfigurationSkew {
+		h.State = worseHealth(h.State, Degraded)
+	}
+	return h
+}
+
+// Probe pings every acceptor, and records the outcomes in the health window,
+// as if they were proposals. Probing lets a failing acceptor be seen to
+// recover without risking real proposals on it, and Ping doesn't touch any
+// promises, so probing never interferes with proposals from other proposers.
+// It returns the error from each acceptor that failed, by address.
+func (p *MemoryProposer) Probe(ctx context.Context) map[string]error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	targets := p.acceptors()
+	type result struct {
+		addr string
+		err  error
+	}
+	results := make(chan result, len(targets))
+	for addr, target := range targets {
+		go func(addr string, target Pinger) {
+			begin := time.Now()
+			_, err := target.Ping(ctx)
+			p.latency.observe(addr, time.Since(begin), err)
+			results <- result{addr, err}
+		}(addr, target)
+	}
+
+	failures := map[string]error{}
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		p.health.observe(r.addr, r.err)
+		if r.err != nil {
+			level.Debug(p.logger).Log("method", "Probe", "addr", r.addr, "err", r.err)
+			failures[r.addr] = r.err
+		}
+	}
+	return failures
+}
+
+// acceptors returns every preparer and accepter, by address. It must be called
+// with the mutex held.
+func (p *MemoryProposer) acceptors() map[string]Acceptor {
+	res := map[string]Acceptor{}
+	for addr, target := range p.preparers {
+		res[addr] = target.(Acceptor)
+	}
+	for addr, target := range p.accepters {
+		res[addr] = target.(Acceptor)
+	}
+	return res
+}
+
+// UpdateAcceptorAddress replaces the acceptor registered at the old address
+// with the target, in whichever roles (preparer, accepter) the old acceptor
+// held. It's meant for acceptors that move to a new address but keep their
+// data, and so doesn't change quorum membership; the target must report the
+// same node ID as the acceptor it replaces.
+func (p *MemoryProposer) UpdateAcceptorAddress(ctx context.Context, old string, target Acceptor) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	// Find the old acceptor in either role.
+	var existing Identifier
+	preparer, isPreparer := p.preparers[old]
+	accepter, isAccepter := p.accepters[old]
+	switch {
+	case isPreparer:
+		existing, _ = preparer.(Identifier)
+	case isAccepter:
+		existing, _ = accepter.(Identifier)
+	default:
+		return ErrNotFound
+	}
+	if existing == nil {
+		return ErrNotFound
+	}
+
+	// The new address mustn't already be in use.
+	addr := target.Address()
+	if addr != old {
+		_, dupPreparer := p.preparers[addr]
+		_, dupAccepter := p.accepters[addr]
+		if dupPreparer || dupAccepter {
+			return ErrDuplicate
+		}
+	}
+
+	// Both sides must agree on who they are.
+	want, err := existing.NodeID(ctx)
+	if err != nil {
+		return err
+	}
+	have, err := target.NodeID(ctx)
+	if err != nil {
+		return err
+	}
+	if want != have {
+		return ErrIdentityMismatch
+	}
+
+	// Swap, preserving roles. History at the old address is irrelevant.
+	p.health.forget(old)
+	if isPreparer {
+		delete(p.preparers, old)
+		p.preparers[addr] = target
+	}
+	if isAccepter {
+		delete(p.accepters, old)
+		p.accepters[addr] = target
+	}
+	if w, ok := p.weights[old]; ok {
+		delete(p.weights, old)
+		p.weights[addr] = w
+	}
+	if state, ok := p.lifecycle[old]; ok {
+		delete(p.lifecycle, old)
+		p.lifecycle[addr] = state
+	}
+	p.configurationChanged()
 	return nil
 }
 
@@ -282,11 +984,7 @@ func (p *MemoryProposer) RemoveAccepter(target Acceptor) error {
 // keys.
 func (p *MemoryProposer) FullIdentityRead(ctx context.Context, key string) (state []byte, err error) {
 	identity := func(x []byte) []byte { return x }
-	state, _, err = p.propose(ctx, key, identity, fullQuorum)
-	if err == ErrPrepareFailed {
-		// allow a single retry, to hide fast-forwards
-		state, _, err = p.propose(ctx, key, identity, fullQuorum)
-	}
+	state, _, _, err = p.proposeRetry(ctx, key, identity, keepMeta, fullQuorum, roundOptions{})
 	return state, err
 }
 
@@ -310,3 +1008,43 @@ func (p *MemoryProposer) FastForwardIncrement(ctx context.Context, key string, t
 	// Finally, our age.
 	return p.age.inc(), nil
 }
+
+// Age returns the current age of the proposer.
+func (p *MemoryProposer) Age() Age {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.age
+}
+
+// FastForward moves the proposer's age counter forward to counter, and makes
+// sure every subsequent ballot has a greater counter, too. Unlike
+// FastForwardIncrement, it isn't tied to a key; it's meant for operators
+// whose proposer has somehow lost track of its state. Moving the age
+// backwards would let acceptors reject the proposer, so a counter less than
+// the current age returns ErrAgeRegression.
+func (p *MemoryProposer) FastForward(counter uint64) (Age, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if counter < p.age.Counter {
+		return p.age, ErrAgeRegression
+	}
+
+	p.age.Counter = counter
+	if p.ballot.Counter < counter {
+		p.ballot.Counter = counter
+	}
+	return p.age, nil
+}
+
+// KeyStats returns statistics for the top n keys, ranked by order. A negative
+// n returns every tracked key. The statistics are local to this proposer; see
+// WithKeyStats.
+func (p *MemoryProposer) KeyStats(n int, by KeyStatsOrder) []KeyStats {
+	return p.keyStats.top(n, by)
+}
+
+// ResetKeyStats forgets all per-key statistics.
+func (p *MemoryProposer) ResetKeyStats() {
+	p.keyStats.reset()
+}
@@ -1,3 +1,440 @@
 package protocol
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
 var _ Proposer = (*MemoryProposer)(nil)
+
+func TestUpdateAcceptorAddress(t *testing.T) {
+	// Build the cluster.
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithNodeID("node-1"))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2), WithNodeID("node-2"))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), WithNodeID("node-3"))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// An unknown old address should fail.
+	if want, have := ErrNotFound, p1.UpdateAcceptorAddress(ctx, "nonexistent", a1); want != have {
+		t.Errorf("unknown address: want %v, have %v", want, have)
+	}
+
+	// A target that claims to be a different acceptor should fail.
+	imposter := NewMemoryAcceptor("1-moved", log.With(logger, "a", "imposter"), WithNodeID("node-9"))
+	if want, have := ErrIdentityMismatch, p1.UpdateAcceptorAddress(ctx, "1", imposter); want != have {
+		t.Errorf("imposter: want %v, have %v", want, have)
+	}
+
+	// A target at an address that's already registered should fail.
+	clash := NewMemoryAcceptor("2", log.With(logger, "a", "clash"), WithNodeID("node-1"))
+	if want, have := ErrDuplicate, p1.UpdateAcceptorAddress(ctx, "1", clash); want != have {
+		t.Errorf("clash: want %v, have %v", want, have)
+	}
+
+	// The same logical acceptor at a new address should succeed.
+	moved := NewMemoryAcceptor("1-moved", log.With(logger, "a", "moved"), WithNodeID("node-1"))
+	if err := p1.UpdateAcceptorAddress(ctx, "1", moved); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+
+	// Quorum membership shouldn't have changed.
+	if want, have := 3, len(p1.preparers); want != have {
+		t.Errorf("preparers: want %d, have %d", want, have)
+	}
+	if want, have := 3, len(p1.accepters); want != have {
+		t.Errorf("accepters: want %d, have %d", want, have)
+	}
+	if _, ok := p1.preparers["1"]; ok {
+		t.Errorf("old address still registered as preparer")
+	}
+	if _, ok := p1.accepters["1-moved"]; !ok {
+		t.Errorf("new address not registered as accepter")
+	}
+
+	// And proposals should still work.
+	if _, _, err := p1.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatalf("propose after move: %v", err)
+	}
+	if _, err := p1.FullIdentityRead(ctx, "k"); err != nil { // make sure every acceptor has the value
+		t.Fatalf("full read after move: %v", err)
+	}
+	if want, have := "v", string(moved.dumpValue("k")); want != have {
+		t.Errorf("moved acceptor value: want %q, have %q", want, have)
+	}
+}
+
+func TestAbandonment(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryProposerOption
+		inflate bool
+	}{
+		{"disabled", nil, true},
+		{"enabled", []MemoryProposerOption{WithAbandonment()}, false},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			// Build the cluster, with acceptors that cancel the context right
+			// after a successful prepare.
+			var (
+				logger = log.NewLogfmtLogger(newTestWriter(t))
+				a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+				a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+				a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+				c1     = cancelingAcceptor{a1}
+				c2     = cancelingAcceptor{a2}
+				c3     = cancelingAcceptor{a3}
+				p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{c1, c2, c3}, testcase.options...)
+				p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+				key    = "k"
+				n      = 100
+			)
+
+			// A storm of canceled proposals.
+			for i := 0; i < n; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				ctx = context.WithValue(ctx, cancelKey{}, cancel)
+				if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce("x")); err != context.Canceled {
+					t.Fatalf("proposal %d: want %v, have %v", i+1, context.Canceled, err)
+				}
+			}
+
+			// Abandonment is asynchronous, so give it a moment.
+			if !testcase.inflate {
+				deadline := time.Now().Add(time.Second)
+				for time.Now().Before(deadline) && !promisesCleared(key, a1, a2, a3) {
+					time.Sleep(time.Millisecond)
+				}
+				if !promisesCleared(key, a1, a2, a3) {
+					t.Fatal("promises weren't cleared")
+				}
+			}
+
+			// A fresh proposer shouldn't need to chase the canceled ballots,
+			// unless they were left in place.
+			_, b, err := p2.Propose(context.Background(), key, changeFuncInitializeOnlyOnce("y"))
+			if err != nil {
+				t.Fatalf("fresh proposal: %v", err)
+			}
+			if inflated := b.Counter > uint64(n); inflated != testcase.inflate {
+				t.Fatalf("fresh proposal ballot %s: want inflated=%v, have %v", b, testcase.inflate, inflated)
+			}
+		})
+	}
+}
+
+type cancelKey struct{}
+
+// cancelingAcceptor cancels the context, if it carries a cancel func, right
+// after a prepare, modeling a client that goes away between phases.
+type cancelingAcceptor struct{ *MemoryAcceptor }
+
+func (a cancelingAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	value, sum, meta, current, err := a.MemoryAcceptor.Prepare(ctx, key, age, epoch, b)
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+	return value, sum, meta, current, err
+}
+
+// promisesCleared reports whether no acceptor has a promise for key above the
+// greatest ballot any of them accepted. An acceptor may keep the promise of a
+// round which finished without it, as Abandon only releases the abandoned
+// round's own, but not one of a round which never did.
+func promisesCleared(key string, acceptors ...*MemoryAcceptor) bool {
+	var accepted Ballot
+	for _, a := range acceptors {
+		if av, _ := a.values.get(key); av.accepted.greaterThan(accepted) {
+			accepted = av.accepted
+		}
+	}
+	for _, a := range acceptors {
+		if av, _ := a.values.get(key); av.promise.greaterThan(accepted) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQuorumFailFast(t *testing.T) {
+	logger := log.NewLogfmtLogger(newTestWriter(t))
+
+	// Every combination of broken acceptors in a five-node cluster. Quorum is
+	// three, so up to two failures can be tolerated.
+	for mask := 0; mask < 1<<5; mask++ {
+		var (
+			initial []Acceptor
+			broken  = map[string]bool{}
+		)
+		for i := 0; i < 5; i++ {
+			a := &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))}
+			if mask&(1<<uint(i)) != 0 {
+				a.setBroken(true)
+				broken[a.Address()] = true
+			}
+			initial = append(initial, a)
+		}
+		t.Run(fmt.Sprintf("%05b", mask), func(t *testing.T) {
+			p := NewMemoryProposer("1", log.With(logger, "p", 1), initial...)
+			_, _, err := p.Propose(context.Background(), "k", changeFuncRead)
+			if len(broken) <= 2 {
+				if err != nil {
+					t.Fatalf("%d broken: want success, have %v", len(broken), err)
+				}
+				return
+			}
+			qe, ok := err.(QuorumError)
+			if !ok {
+				t.Fatalf("%d broken: want QuorumError, have %T (%v)", len(broken), err, err)
+			}
+			if want, have := ErrPrepareFailed, qe.Err; want != have {
+				t.Errorf("phase: want %v, have %v", want, have)
+			}
+			if want, have := 3, len(qe.Failures); have < want {
+				t.Errorf("failures: want at least %d, have %d", want, have)
+			}
+			for addr, err := range qe.Failures {
+				if !broken[addr] || err != errBroken {
+					t.Errorf("failure from %s (broken=%v): %v", addr, broken[addr], err)
+				}
+			}
+		})
+	}
+}
+
+func TestQuorumShortCircuit(t *testing.T) {
+	// In each case, two acceptors hang until the test is over. The proposer
+	// should never need to wait for them.
+	for _, testcase := range []struct {
+		name    string
+		broken  int // of the other three
+		wantErr error
+	}{
+		{"three successes", 0, nil},
+		{"three prepare failures", 3, ErrPrepareFailed},
+		{"three accept failures", -3, ErrAcceptFailed},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				logger  = log.NewLogfmtLogger(newTestWriter(t))
+				gate    = make(chan struct{})
+				initial []Acceptor
+			)
+			defer close(gate)
+			for i := 0; i < 3; i++ {
+				a := NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))
+				switch {
+				case testcase.broken > 0:
+					b := &breakableAcceptor{MemoryAcceptor: a}
+					b.setBroken(true)
+					initial = append(initial, b)
+				case testcase.broken < 0:
+					initial = append(initial, acceptFailingAcceptor{a})
+				default:
+					initial = append(initial, a)
+				}
+			}
+			for i := 3; i < 5; i++ {
+				initial = append(initial, gatedAcceptor{NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i)), gate})
+			}
+
+			p := NewMemoryProposer("1", log.With(logger, "p", 1), initial...)
+			errc := make(chan error, 1)
+			go func() {
+				_, _, err := p.Propose(context.Background(), "k", changeFuncInitializeOnlyOnce("v"))
+				errc <- err
+			}()
+
+			select {
+			case err := <-errc:
+				if testcase.wantErr == nil {
+					if err != nil {
+						t.Fatalf("want success, have %v", err)
+					}
+					return
+				}
+				qe, ok := err.(QuorumError)
+				if !ok {
+					t.Fatalf("want QuorumError, have %T (%v)", err, err)
+				}
+				if want, have := testcase.wantErr, qe.Err; want != have {
+					t.Fatalf("want %v, have %v", want, have)
+				}
+				if !errors.Is(err, testcase.wantErr) {
+					t.Errorf("errors.Is(%v, %v): want true, have false", err, testcase.wantErr)
+				}
+				if want, have := 3, len(qe.Failures); want != have {
+					t.Fatalf("failures: want %d, have %d", want, have)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("proposer waited for hanging acceptors")
+			}
+		})
+	}
+}
+
+// gatedAcceptor hangs on every request until the gate is closed.
+type gatedAcceptor struct {
+	*MemoryAcceptor
+	gate chan struct{}
+}
+
+func (a gatedAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) ([]byte, Checksum, Metadata, Ballot, error) {
+	<-a.gate
+	return nil, 0, nil, zeroballot, errBroken
+}
+
+func (a gatedAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	<-a.gate
+	return errBroken
+}
+
+// acceptFailingAcceptor prepares normally, but fails every accept.
+type acceptFailingAcceptor struct{ *MemoryAcceptor }
+
+func (a acceptFailingAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	return errBroken
+}
+
+func TestAdministrativeFastForward(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	_, before, err := p1.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving forward works, and is visible via Age.
+	age, err := p1.FastForward(100)
+	if err != nil {
+		t.Fatalf("fast-forward: %v", err)
+	}
+	if want, have := (Age{Counter: 100, ID: "1"}), age; want != have {
+		t.Fatalf("fast-forward: want %s, have %s", want, have)
+	}
+	if want, have := age, p1.Age(); want != have {
+		t.Fatalf("Age: want %s, have %s", want, have)
+	}
+
+	// Subsequent proposals use strictly greater ballots.
+	_, after, err := p1.Propose(ctx, "k", changeFuncRead)
+	if err != nil {
+		t.Fatalf("propose after fast-forward: %v", err)
+	}
+	if !after.greaterThan(before) || after.Counter <= 100 {
+		t.Fatalf("ballot after fast-forward: %s, want greater than %s and counter over 100", after, before)
+	}
+
+	// Staying put is fine, but moving backwards isn't.
+	if _, err := p1.FastForward(100); err != nil {
+		t.Fatalf("fast-forward to current age: %v", err)
+	}
+	if want, have := ErrAgeRegression, func() error { _, err := p1.FastForward(50); return err }(); want != have {
+		t.Fatalf("fast-forward backwards: want %v, have %v", want, have)
+	}
+	if want, have := uint64(100), p1.Age().Counter; want != have {
+		t.Fatalf("age after failed fast-forward: want %d, have %d", want, have)
+	}
+}
+
+func TestSizeObserver(t *testing.T) {
+	type observation struct {
+		key           string
+		read, written int
+	}
+	var (
+		observations []observation
+		observe      = func(key string, read, written int) {
+			observations = append(observations, observation{key, read, written})
+		}
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		p1     = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1}, WithSizeObserver(observe))
+		ctx    = context.Background()
+	)
+
+	p1.Propose(ctx, "k", changeFuncInitializeOnlyOnce("abc"))
+	p1.Propose(ctx, "k", changeFuncRead)
+	p1.Propose(ctx, "k", func([]byte) []byte { return []byte("abcdef") })
+
+	want := []observation{{"k", 0, 3}, {"k", 3, 3}, {"k", 3, 6}}
+	if len(want) != len(observations) {
+		t.Fatalf("want %v, have %v", want, observations)
+	}
+	for i := range want {
+		if want[i] != observations[i] {
+			t.Errorf("observation %d: want %v, have %v", i, want[i], observations[i])
+		}
+	}
+}
+
+func TestFastForwardFromConflicts(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		old    = NewMemoryProposer("old", log.With(logger, "p", "old"), a1, a2, a3)
+		fresh  = NewMemoryProposerWithOptions("fresh", log.With(logger, "p", "fresh"), []Acceptor{a1, a2, a3}, WithKeyStats(1))
+		ctx    = context.Background()
+	)
+	if _, err := old.FastForward(10000); err != nil {
+		t.Fatal(err)
+	}
+	_, existing, err := old.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A proposer with counter zero jumps straight past the cluster's ballots,
+	// and succeeds on its second round.
+	_, b, err := fresh.Propose(ctx, "k", changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := existing.Counter+1, b.Counter; want != have {
+		t.Errorf("counter: want %d, have %d", want, have)
+	}
+	if s := fresh.KeyStats(1, ByProposals); s[0].Proposals > 2 {
+		t.Errorf("want at most 2 rounds, have %d", s[0].Proposals)
+	}
+
+	// A conflict in the accept phase jumps past the competing ballot, too.
+	if _, err := old.FastForward(20000); err != nil {
+		t.Fatal(err)
+	}
+	race := func(x []byte) []byte {
+		if _, _, err := old.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Error(err)
+		}
+		return x
+	}
+	if _, _, err := fresh.Propose(ctx, "k", race); err == nil {
+		t.Fatal("want the accept phase to conflict, have no error")
+	}
+	before := fresh.KeyStats(1, ByProposals)[0].Proposals
+	if _, b, err = fresh.Propose(ctx, "k", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+	if b.Counter <= 20000 {
+		t.Errorf("counter after accept conflict: want over 20000, have %d", b.Counter)
+	}
+	if want, have := before+1, fresh.KeyStats(1, ByProposals)[0].Proposals; want != have {
+		t.Errorf("want 1 more round, have %d", have-before)
+	}
+}
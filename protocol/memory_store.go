@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// store holds the per-key state of a MemoryAcceptor. Implementations must be
+// safe for concurrent use, and update must be atomic with respect to other
+// operations on the same key.
+type store interface {
+	// get returns the state for key, if it exists.
+	get(key string) (acceptedValue, bool)
+
+	// update replaces the state for key with the result of f, which receives
+	// the current state, or the zero value and ok false if the key doesn't
+	// exist. If f returns keep false, the key is deleted. If f returns an
+	// error, the store isn't modified, and the error is returned.
+	update(key string, f func(current acceptedValue, ok bool) (next acceptedValue, keep bool, err error)) error
+
+	// forEach calls f for every key, until f returns false. It sees a
+	// consistent state for each key, but not necessarily across keys.
+	forEach(f func(key string, av acceptedValue) bool)
+}
+
+// mapStore is a store backed by a single map, guarded by a single mutex.
+type mapStore struct {
+	mtx    sync.RWMutex
+	values map[string]acceptedValue
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{
+		values: map[string]acceptedValue{},
+	}
+}
+
+func (s *mapStore) get(key string) (acceptedValue, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	av, ok := s.values[key]
+	return av, ok
+}
+
+func (s *mapStore) update(key string, f func(acceptedValue, bool) (acceptedValue, bool, error)) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	current, ok := s.values[key]
+	next, keep, err := f(current, ok)
+	if err != nil {
+		return err
+	}
+	if !keep {
+		delete(s.values, key)
+		return nil
+	}
+	s.values[key] = next
+	return nil
+}
+
+func (s *mapStore) forEach(f func(string, acceptedValue) bool) {
+	// Copy first, so f can't block writers.
+	s.mtx.RLock()
+	snapshot := make(map[string]acceptedValue, len(s.values))
+	for k, v := range s.values {
+		snapshot[k] = v
+	}
+	s.mtx.RUnlock()
+
+	for k, v := range snapshot {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// shardedStore spreads keys over a fixed number of mapStores, by hash. It
+// reduces lock contention when there are many keys and many goroutines.
+type shardedStore struct {
+	shards []*mapStore
+}
+
+func newShardedStore(n int) *shardedStore {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*mapStore, n)
+	for i := range shards {
+		shards[i] = newMapStore()
+	}
+	return &shardedStore{shards: shards}
+}
+
+func (s *shardedStore) shard(key string) *mapStore {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *shardedStore) get(key string) (acceptedValue, bool) {
+	return s.shard(key).get(key)
+}
+
+func (s *shardedStore) update(key string, f func(acceptedValue, bool) (acceptedValue, bool, error)) error {
+	return s.shard(key).update(key, f)
+}
+
+func (s *shardedStore) forEach(f func(string, acceptedValue) bool) {
+	for _, shard := range s.shards {
+		done := false
+		shard.forEach(func(k string, v acceptedValue) bool {
+			if !f(k, v) {
+				done = true
+				return false
+			}
+			return true
+		})
+		if done {
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestStores(t *testing.T) {
+	for name, s := range map[string]store{
+		"map":     newMapStore(),
+		"sharded": newShardedStore(8),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Missing keys are reported as such.
+			if _, ok := s.get("a"); ok {
+				t.Fatal("get missing key: ok")
+			}
+
+			// Updates are stored.
+			set := func(value string) func(acceptedValue, bool) (acceptedValue, bool, error) {
+				return func(av acceptedValue, _ bool) (acceptedValue, bool, error) {
+					av.value = []byte(value)
+					return av, true, nil
+				}
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				if err := s.update(key, set(key+"-value")); err != nil {
+					t.Fatalf("update %s: %v", key, err)
+				}
+			}
+			if av, ok := s.get("b"); !ok || string(av.value) != "b-value" {
+				t.Fatalf("get b: want %q, have %q (ok %v)", "b-value", av.value, ok)
+			}
+
+			// Failed updates aren't.
+			fail := func(av acceptedValue, _ bool) (acceptedValue, bool, error) {
+				av.value = []byte("bad")
+				return av, true, ErrNotEqual
+			}
+			if want, have := ErrNotEqual, s.update("b", fail); want != have {
+				t.Fatalf("failed update: want %v, have %v", want, have)
+			}
+			if av, _ := s.get("b"); string(av.value) != "b-value" {
+				t.Fatalf("after failed update: want %q, have %q", "b-value", av.value)
+			}
+
+			// Deletes remove the key.
+			del := func(av acceptedValue, _ bool) (acceptedValue, bool, error) { return av, false, nil }
+			if err := s.update("b", del); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, ok := s.get("b"); ok {
+				t.Fatal("get deleted key: ok")
+			}
+
+			// Iteration sees every remaining key.
+			seen := map[string]bool{}
+			s.forEach(func(key string, _ acceptedValue) bool { seen[key] = true; return true })
+			if want, have := 2, len(seen); want != have || !seen["a"] || !seen["c"] {
+				t.Fatalf("forEach: want a and c, have %v", seen)
+			}
+		})
+	}
+}
+
+func TestStoresConcurrentIteration(t *testing.T) {
+	// Run with -race.
+	for name, s := range map[string]store{
+		"map":     newMapStore(),
+		"sharded": newShardedStore(8),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				wg   sync.WaitGroup
+				done = make(chan struct{})
+			)
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						key := fmt.Sprintf("key-%d-%d", i, j%100)
+						s.update(key, func(av acceptedValue, _ bool) (acceptedValue, bool, error) {
+							av.accepted.Counter++
+							return av, j%7 != 0, nil
+						})
+					}
+				}(i)
+			}
+			go func() { wg.Wait(); close(done) }()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					s.forEach(func(string, acceptedValue) bool { return true })
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryAcceptorConcurrent(b *testing.B) {
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryAcceptorOption
+	}{
+		{"single", nil},
+		{"sharded-32", []MemoryAcceptorOption{WithShards(32)}},
+	} {
+		b.Run(testcase.name, func(b *testing.B) {
+			var (
+				a     = NewMemoryAcceptor("1", log.NewNopLogger(), testcase.options...)
+				ctx   = context.Background()
+				age   = Age{ID: "p"}
+				value = []byte("value")
+				sum   = checksumOf(value)
+				ctr   uint64
+			)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddUint64(&ctr, 1)
+					key := fmt.Sprintf("key-%d", n%1024)
+					ballot := Ballot{Counter: n, ID: "p"}
+					a.Prepare(ctx, key, age, 0, ballot)
+					a.Accept(ctx, key, age, ballot, value, sum, nil)
+				}
+			})
+		})
+	}
+}
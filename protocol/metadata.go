@@ -0,0 +1,36 @@
+package protocol
+
+import "errors"
+
+// Metadata is stored alongside a value, e.g. the identity of the writer, or a
+// trace ID. Acceptors store it opaquely. It's never seen by change functions,
+// and so never participates in compare-and-swap; it's replaced wholesale by
+// each ProposeWithMeta call. A Propose call which changes the value clears it,
+// as it described the old value's write, and one which doesn't, like a read,
+// keeps it.
+type Metadata map[string]string
+
+// MaxMetadataSize is the largest permitted size of a Metadata, counted as the
+// total length of all keys and values.
+const MaxMetadataSize = 4096
+
+// ErrMetadataTooLarge indicates a Metadata exceeded MaxMetadataSize.
+var ErrMetadataTooLarge = errors.New("metadata too large")
+
+func (m Metadata) size() (n int) {
+	for k, v := range m {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+func (m Metadata) copy() Metadata {
+	if m == nil {
+		return nil
+	}
+	dst := make(Metadata, len(m))
+	for k, v := range m {
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMetadata(t *testing.T) {
+	// Build the cluster.
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+		key    = "k"
+	)
+
+	verify := func(p Proposer, wantValue string, wantMeta Metadata) {
+		t.Helper()
+		state, meta, _, err := p.ReadWithMeta(ctx, key)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if want, have := wantValue, string(state); want != have {
+			t.Errorf("value: want %q, have %q", want, have)
+		}
+		if want, have := wantMeta, meta; !reflect.DeepEqual(want, have) {
+			t.Errorf("meta: want %v, have %v", want, have)
+		}
+	}
+
+	// Write a value with some metadata.
+	first := Metadata{"writer": "alice", "reason": "initial"}
+	if _, _, err := p1.ProposeWithMeta(ctx, key, changeFuncInitializeOnlyOnce("v1"), first); err != nil {
+		t.Fatalf("write with meta: %v", err)
+	}
+	verify(p2, "v1", first)
+
+	// The change function sees only the value, so CAS works as usual. Plain
+	// proposals which leave the value alone keep the metadata.
+	if state, _, err := p2.Propose(ctx, key, changeFuncCompareAndSwap("v0", "v2")); err != nil {
+		t.Fatalf("failed CAS: %v", err)
+	} else if want, have := "v1", string(state); want != have {
+		t.Fatalf("failed CAS: want %q, have %q", want, have)
+	}
+	verify(p1, "v1", first)
+
+	// But those which change it clear the metadata, which described the
+	// previous write, not theirs.
+	if state, _, err := p2.Propose(ctx, key, changeFuncCompareAndSwap("v1", "v2")); err != nil {
+		t.Fatalf("CAS: %v", err)
+	} else if want, have := "v2", string(state); want != have {
+		t.Fatalf("CAS: want %q, have %q", want, have)
+	}
+	verify(p1, "v2", nil)
+
+	// Metadata is replaced wholesale.
+	second := Metadata{"writer": "bob"}
+	if _, _, err := p2.ProposeWithMeta(ctx, key, changeFuncCompareAndSwap("v2", "v3"), second); err != nil {
+		t.Fatalf("second write with meta: %v", err)
+	}
+	verify(p1, "v3", second)
+
+	// Mutating the caller's map afterwards has no effect.
+	second["writer"] = "mallory"
+	verify(p1, "v3", Metadata{"writer": "bob"})
+
+	// Oversized metadata is rejected.
+	huge := Metadata{"x": strings.Repeat("x", MaxMetadataSize)}
+	if _, _, err := p1.ProposeWithMeta(ctx, key, changeFuncRead, huge); err != ErrMetadataTooLarge {
+		t.Fatalf("huge meta: want %v, have %v", ErrMetadataTooLarge, err)
+	}
+	verify(p2, "v3", Metadata{"writer": "bob"})
+}
@@ -0,0 +1,204 @@
+package protocol
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata keys which mark a key part of the way through a Move.
+const (
+	// MovedToKey marks a key whose value has moved to the key it names. Its
+	// own value is absent.
+	MovedToKey = "moved-to"
+
+	// MovedFromKey marks a key whose value was copied from the key it names,
+	// and is authoritative only once that key has a MovedToKey naming it.
+	MovedFromKey = "moved-from"
+)
+
+var (
+	// ErrMoveSourceAbsent indicates a Move of a key without a value.
+	ErrMoveSourceAbsent = errors.New("key to move has no value")
+
+	// ErrMoveTargetExists indicates a Move to a key which already has a value.
+	ErrMoveTargetExists = errors.New("key to move to already has a value")
+
+	// ErrMoveConflict indicates a Move whose key was written by someone else
+	// during the move. The move is rolled back.
+	ErrMoveConflict = errors.New("key was written during the move")
+
+	// ErrKeyMoved indicates a Move of a key which is being moved elsewhere.
+	ErrKeyMoved = errors.New("key is being moved elsewhere")
+
+	errInvalidMove = errors.New("invalid move")
+)
+
+// Move renames a key: its value, and metadata, are moved to another key, which
+// must be absent. It's made in four steps, each a conditional proposal:
+//
+//  1. The value is copied to the new key, marked with MovedFromKey.
+//  2. The old key's value is replaced by a forwarding marker, MovedToKey, if
+//     it's unchanged since it was copied. This is the moment of the move.
+//  3. The new key's MovedFromKey is removed.
+//  4. The old key's forwarding marker is removed.
+//
+// ReadFollowing, at any point, resolves exactly one of the keys to the value:
+// the old key until step 2, and the new key from then on. Plain reads of the
+// new key see the copy from step 1, but its marker says it's not yet
+// authoritative.
+//
+// If Move fails part of the way, e.g. the proposer crashes, calling it again
+// with the same keys resumes it. If the old key is written by someone else
+// before step 2, the copy is removed, and ErrMoveConflict is returned. Writes
+// to the new key during the move aren't detected, so writers should leave
+// both keys alone until it's done.
+func (p *MemoryProposer) Move(ctx context.Context, from, to string) error {
+	return p.move(ctx, from, to, func(int) error { return nil })
+}
+
+// move is Move, calling after once each step is done, for tests.
+func (p *MemoryProposer) move(ctx context.Context, from, to string, after func(step int) error) error {
+	if from == to || from == zerokey || to == zerokey {
+		return errInvalidMove
+	}
+
+	value, meta, _, err := p.ReadWithMeta(ctx, from)
+	if err != nil {
+		return err
+	}
+	switch {
+	case meta[MovedToKey] == to:
+		// Resume, after step 2.
+	case meta[MovedToKey] != "":
+		return ErrKeyMoved
+	case value == nil:
+		return ErrMoveSourceAbsent
+	default:
+		if err := p.moveCopy(ctx, from, to, value, meta); err != nil {
+			return err
+		}
+		if err := after(1); err != nil {
+			return err
+		}
+		if err := p.moveForward(ctx, from, to, value); err != nil {
+			return err
+		}
+		if err := after(2); err != nil {
+			return err
+		}
+	}
+
+	// Step 3: the new key is authoritative on its own.
+	if err := p.proposeIf(ctx, to, func(state []byte, meta Metadata) ([]byte, Metadata, error) {
+		return state, withoutMeta(meta, MovedFromKey), nil
+	}); err != nil {
+		return err
+	}
+	if err := after(3); err != nil {
+		return err
+	}
+
+	// Step 4: the forwarding marker is no longer needed.
+	return p.proposeIf(ctx, from, func(state []byte, meta Metadata) ([]byte, Metadata, error) {
+		if meta[MovedToKey] != to {
+			return nil, nil, ErrMoveConflict
+		}
+		return nil, nil, nil
+	})
+}
+
+// moveCopy is step 1 of Move. A copy left by an earlier attempt of the same
+// move is overwritten.
+func (p *MemoryProposer) moveCopy(ctx context.Context, from, to string, value []byte, meta Metadata) error {
+	copied := withoutMeta(meta, MovedFromKey)
+	copied[MovedFromKey] = from
+	if copied.size() > MaxMetadataSize {
+		return ErrMetadataTooLarge
+	}
+	return p.proposeIf(ctx, to, func(state []byte, meta Metadata) ([]byte, Metadata, error) {
+		if state != nil && meta[MovedFromKey] != from {
+			return nil, nil, ErrMoveTargetExists
+		}
+		return value, copied, nil
+	})
+}
+
+// moveForward is step 2 of Move. If the old key has changed, the copy from
+// step 1 is removed.
+func (p *MemoryProposer) moveForward(ctx context.Context, from, to string, value []byte) error {
+	err := p.proposeIf(ctx, from, func(state []byte, meta Metadata) ([]byte, Metadata, error) {
+		if !equalValues(state, value) || meta[MovedToKey] != "" {
+			return nil, nil, ErrMoveConflict
+		}
+		return nil, Metadata{MovedToKey: to}, nil
+	})
+	if err != ErrMoveConflict {
+		return err
+	}
+	if err := p.proposeIf(ctx, to, func(state []byte, meta Metadata) ([]byte, Metadata, error) {
+		if meta[MovedFromKey] != from {
+			return state, meta, nil // not our copy
+		}
+		return nil, nil, nil
+	}); err != nil {
+		return errors.Wrap(err, "error removing copy, after conflict")
+	}
+	return ErrMoveConflict
+}
+
+// ReadFollowing reads a key, like ReadWithMeta, but follows a Move in
+// progress: a key with a forwarding marker resolves to the key it names, and
+// a copy which isn't yet authoritative resolves to absent. It returns the
+// key which was resolved.
+func (p *MemoryProposer) ReadFollowing(ctx context.Context, key string) (state []byte, resolved string, err error) {
+	state, meta, _, err := p.ReadWithMeta(ctx, key)
+	if err != nil {
+		return nil, key, err
+	}
+	if to := meta[MovedToKey]; to != "" {
+		state, _, _, err = p.ReadWithMeta(ctx, to)
+		return state, to, err
+	}
+	if from := meta[MovedFromKey]; from != "" {
+		_, fromMeta, _, err := p.ReadWithMeta(ctx, from)
+		if err != nil {
+			return nil, key, err
+		}
+		if fromMeta[MovedToKey] != key {
+			return nil, key, nil // the move hasn't happened yet
+		}
+	}
+	return state, key, nil
+}
+
+// proposeIf makes a proposal whose change sees the metadata as well as the
+// state, and can fail, which stops the round before anything is accepted.
+// The error is returned as it is.
+func (p *MemoryProposer) proposeIf(ctx context.Context, key string, f func(state []byte, meta Metadata) ([]byte, Metadata, error)) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var (
+		next     []byte
+		nextMeta Metadata
+		change   = func([]byte) []byte { return next }
+		replace  = func(Metadata, bool) Metadata { return nextMeta }
+		ro       = roundOptions{precondition: func(state []byte, meta Metadata, _ Ballot) (err error) {
+			next, nextMeta, err = f(copyValue(state), meta.copy())
+			return err
+		}}
+	)
+	_, _, _, err := p.proposeRetry(ctx, key, change, replace, regularQuorum, ro)
+	return untraced(err)
+}
+
+// withoutMeta returns a copy of meta, without key.
+func withoutMeta(meta Metadata, key string) Metadata {
+	res := meta.copy()
+	if res == nil {
+		res = Metadata{}
+	}
+	delete(res, key)
+	return res
+}
@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMove(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+	if _, _, err := p.ProposeWithMeta(ctx, "old", changeFuncInitializeOnlyOnce("v"), Metadata{"writer": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Move(ctx, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	state, meta, _, err := p.ReadWithMeta(ctx, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(state) != "v" || meta["writer"] != "x" || meta[MovedFromKey] != "" {
+		t.Errorf("new: have %q, %v", state, meta)
+	}
+	if state, meta, _, _ := p.ReadWithMeta(ctx, "old"); state != nil || len(meta) != 0 {
+		t.Errorf("old: want absent, have %q, %v", state, meta)
+	}
+
+	if err := p.Move(ctx, "old", "other"); err != ErrMoveSourceAbsent {
+		t.Errorf("absent: want %v, have %v", ErrMoveSourceAbsent, err)
+	}
+	if _, _, err := p.Propose(ctx, "other", changeFuncInitializeOnlyOnce("w")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Move(ctx, "new", "other"); err != ErrMoveTargetExists {
+		t.Errorf("target exists: want %v, have %v", ErrMoveTargetExists, err)
+	}
+}
+
+func TestMoveInterrupted(t *testing.T) {
+	errCrash := errors.New("crash")
+	for _, testcase := range []struct {
+		step  int
+		owner string // resolves to the value after the crash
+	}{
+		{1, "old"},
+		{2, "new"},
+		{3, "new"},
+	} {
+		var (
+			logger = log.NewLogfmtLogger(newTestWriter(t))
+			a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+			a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+			a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+			p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+			ctx    = context.Background()
+		)
+		if _, _, err := p.Propose(ctx, "old", changeFuncInitializeOnlyOnce("v")); err != nil {
+			t.Fatal(err)
+		}
+		crash := func(step int) error {
+			if step == testcase.step {
+				return errCrash
+			}
+			return nil
+		}
+		if err := p.move(ctx, "old", "new", crash); err != errCrash {
+			t.Fatalf("step %d: want %v, have %v", testcase.step, errCrash, err)
+		}
+
+		// Whichever key is read, exactly one of them resolves to the value.
+		for _, key := range []string{"old", "new"} {
+			state, resolved, err := p.ReadFollowing(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := testcase.owner == key
+			if have := string(state) == "v" && resolved == key; want != have {
+				t.Errorf("step %d: %s: want authoritative %v, have %q at %s", testcase.step, key, want, state, resolved)
+			}
+			if state != nil && string(state) != "v" {
+				t.Errorf("step %d: %s: have %q", testcase.step, key, state)
+			}
+		}
+
+		// Moving again finishes the job.
+		if err := p.Move(ctx, "old", "new"); err != nil {
+			t.Fatalf("step %d: resume: %v", testcase.step, err)
+		}
+		if state, resolved, _ := p.ReadFollowing(ctx, "old"); state != nil || resolved != "old" {
+			t.Errorf("step %d: old: want absent, have %q at %s", testcase.step, state, resolved)
+		}
+		if state, meta, _, _ := p.ReadWithMeta(ctx, "new"); string(state) != "v" || meta[MovedFromKey] != "" {
+			t.Errorf("step %d: new: have %q, %v", testcase.step, state, meta)
+		}
+	}
+}
+
+func TestMoveConflict(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+	if _, _, err := p.Propose(ctx, "old", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone writes the old key after it's copied.
+	write := func(step int) error {
+		if step == 1 {
+			_, _, err := p.Propose(ctx, "old", func([]byte) []byte { return []byte("w") })
+			return err
+		}
+		return nil
+	}
+	if err := p.move(ctx, "old", "new", write); err != ErrMoveConflict {
+		t.Fatalf("want %v, have %v", ErrMoveConflict, err)
+	}
+	if state, resolved, _ := p.ReadFollowing(ctx, "old"); string(state) != "w" || resolved != "old" {
+		t.Errorf("old: want w, have %q at %s", state, resolved)
+	}
+	if state, _, _, _ := p.ReadWithMeta(ctx, "new"); state != nil {
+		t.Errorf("new: want the copy removed, have %q", state)
+	}
+}
@@ -0,0 +1,186 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidNamespace indicates a namespace name that's empty, or contains the
+// separator.
+var ErrInvalidNamespace = errors.New("invalid namespace")
+
+// namespaceSeparator separates the namespace from the key. Namespace names
+// can't contain it, so every namespace has a disjoint set of keys.
+const namespaceSeparator = "/"
+
+// NamespaceKey returns the key in the underlying cluster that corresponds to
+// key in namespace ns.
+func NamespaceKey(ns, key string) string {
+	return ns + namespaceSeparator + key
+}
+
+// Quota limits the use of a namespace. Zero values mean no limit.
+type Quota struct {
+	MaxKeys               int     // keys with a value, even an empty one
+	MaxBytes              int     // total size of all values
+	MaxProposalsPerSecond float64 // including reads
+}
+
+// Usage is the consumption of a namespace, as counted by a Namespace.
+type Usage struct {
+	Keys  int
+	Bytes int
+}
+
+// QuotaExceededError is returned by namespaces when a proposal would exceed
+// one of the namespace's quotas. Resource is "keys", "bytes", or "proposals".
+type QuotaExceededError struct {
+	Namespace string
+	Resource  string
+}
+
+func (qee QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %q: %s quota exceeded", qee.Namespace, qee.Resource)
+}
+
+// Namespace scopes proposals to a subset of the keyspace, by prefixing keys,
+// and enforces a quota. Acceptors are unaware of namespaces.
+//
+// Usage is counted from the changes made through the Namespace, starting from
+// zero when it's constructed. It's local to the Namespace, so if the same
+// namespace is used via several proposers, each enforces its quota separately.
+type Namespace struct {
+	name     string
+	proposer Proposer
+	quota    Quota
+
+	mtx    sync.Mutex
+	usage  Usage
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewNamespace returns a namespace called name, whose proposals go through the
+// given proposer.
+func NewNamespace(name string, proposer Proposer, quota Quota) (*Namespace, error) {
+	if name == "" || strings.Contains(name, namespaceSeparator) {
+		return nil, ErrInvalidNamespace
+	}
+	n := &Namespace{
+		name:     name,
+		proposer: proposer,
+		quota:    quota,
+		tokens:   quota.MaxProposalsPerSecond,
+		now:      time.Now,
+	}
+	n.last = n.now()
+	return n, nil
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Usage returns the current usage of the namespace.
+func (n *Namespace) Usage() Usage {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.usage
+}
+
+// Propose is like Proposer.Propose, but for a key in the namespace. If the
+// change would exceed the key or byte quota, it isn't made, and the proposal
+// returns a QuotaExceededError.
+func (n *Namespace) Propose(ctx context.Context, key string, f ChangeFunc) (state []byte, b Ballot, err error) {
+	if !n.allow() {
+		return nil, b, QuotaExceededError{Namespace: n.name, Resource: "proposals"}
+	}
+
+	// The change function may be called more than once, if a round fails, so
+	// only the last call determines the change in usage.
+	var (
+		delta    Usage
+		exceeded string
+	)
+	g := func(current []byte) []byte {
+		next := f(current)
+		delta, exceeded = usageDelta(current, next), ""
+		if resource := n.exceeds(delta); resource != "" {
+			delta, exceeded = Usage{}, resource
+			return current // no change
+		}
+		return next
+	}
+
+	state, b, err = n.proposer.Propose(ctx, NamespaceKey(n.name, key), g)
+	if err != nil {
+		return nil, b, err
+	}
+	if exceeded != "" {
+		return nil, b, QuotaExceededError{Namespace: n.name, Resource: exceeded}
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.usage.Keys += delta.Keys
+	n.usage.Bytes += delta.Bytes
+	return state, b, nil
+}
+
+// allow takes a token from the proposal rate limiter, if there is one.
+func (n *Namespace) allow() bool {
+	rate := n.quota.MaxProposalsPerSecond
+	if rate <= 0 {
+		return true
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	now := n.now()
+	if elapsed := now.Sub(n.last); elapsed > 0 { // clocks can go backwards
+		n.tokens += elapsed.Seconds() * rate
+	}
+	if n.tokens > rate {
+		n.tokens = rate // burst of at most one second's worth
+	}
+	n.last = now
+
+	if n.tokens < 1 {
+		return false
+	}
+	n.tokens--
+	return true
+}
+
+// exceeds returns the resource whose quota delta would exceed, if any.
+// Changes that don't increase usage are always allowed.
+func (n *Namespace) exceeds(delta Usage) string {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if max := n.quota.MaxKeys; max > 0 && delta.Keys > 0 && n.usage.Keys+delta.Keys > max {
+		return "keys"
+	}
+	if max := n.quota.MaxBytes; max > 0 && delta.Bytes > 0 && n.usage.Bytes+delta.Bytes > max {
+		return "bytes"
+	}
+	return ""
+}
+
+func usageDelta(current, next []byte) Usage {
+	var keys int
+	switch {
+	case current == nil && next != nil:
+		keys = 1
+	case current != nil && next == nil:
+		keys = -1
+	}
+	return Usage{Keys: keys, Bytes: len(next) - len(current)}
+}
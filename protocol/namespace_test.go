@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestNamespace(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	for _, name := range []string{"", "a/b"} {
+		if _, err := NewNamespace(name, p1, Quota{}); err != ErrInvalidNamespace {
+			t.Errorf("NewNamespace(%q): want %v, have %v", name, ErrInvalidNamespace, err)
+		}
+	}
+
+	red, _ := NewNamespace("red", p1, Quota{MaxKeys: 2, MaxBytes: 10})
+	blue, _ := NewNamespace("blue", p1, Quota{})
+
+	// The same key in different namespaces is different keys.
+	if _, _, err := red.Propose(ctx, "k", changeFuncInitializeOnlyOnce("r")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := blue.Propose(ctx, "k", changeFuncInitializeOnlyOnce("b")); err != nil {
+		t.Fatal(err)
+	}
+	if state, _, _ := p1.Propose(ctx, NamespaceKey("red", "k"), changeFuncRead); string(state) != "r" {
+		t.Fatalf("red/k: want %q, have %q", "r", state)
+	}
+
+	// Key quota.
+	if _, _, err := red.Propose(ctx, "j", changeFuncInitializeOnlyOnce("r")); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := red.Propose(ctx, "i", changeFuncInitializeOnlyOnce("r"))
+	if want, have := (QuotaExceededError{Namespace: "red", Resource: "keys"}), err; want != have {
+		t.Fatalf("third key: want %v, have %v", want, have)
+	}
+	if state, _, _ := red.Propose(ctx, "i", changeFuncRead); state != nil {
+		t.Fatalf("rejected key was written: %q", state)
+	}
+
+	// Byte quota, which existing keys are subject to as well.
+	_, _, err = red.Propose(ctx, "k", func([]byte) []byte { return []byte("0123456789") })
+	if want, have := (QuotaExceededError{Namespace: "red", Resource: "bytes"}), err; want != have {
+		t.Fatalf("too many bytes: want %v, have %v", want, have)
+	}
+	if _, _, err := red.Propose(ctx, "k", func([]byte) []byte { return []byte("012345678") }); err != nil {
+		t.Fatalf("exactly enough bytes: %v", err)
+	}
+	if want, have := (Usage{Keys: 2, Bytes: 10}), red.Usage(); want != have {
+		t.Fatalf("usage: want %+v, have %+v", want, have)
+	}
+
+	// Deleting frees quota.
+	if _, _, err := red.Propose(ctx, "j", func([]byte) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (Usage{Keys: 1, Bytes: 9}), red.Usage(); want != have {
+		t.Fatalf("usage after delete: want %+v, have %+v", want, have)
+	}
+}
+
+func TestNamespaceRateLimit(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1)
+		ctx    = context.Background()
+		now    = time.Now()
+	)
+
+	ns, _ := NewNamespace("ns", p1, Quota{MaxProposalsPerSecond: 2})
+	ns.now = func() time.Time { return now }
+
+	for i, want := range []error{nil, nil, QuotaExceededError{Namespace: "ns", Resource: "proposals"}} {
+		if _, _, have := ns.Propose(ctx, "k", changeFuncRead); want != have {
+			t.Fatalf("proposal %d: want %v, have %v", i+1, want, have)
+		}
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, _, err := ns.Propose(ctx, "k", changeFuncRead); err != nil {
+		t.Fatalf("after refill: %v", err)
+	}
+}
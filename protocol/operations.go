@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"math/rand"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
@@ -15,15 +16,31 @@ type Proposer interface {
 	// sent this way.
 	Propose(ctx context.Context, key string, f ChangeFunc) (state []byte, b Ballot, err error)
 
+	// ProposeReporting is like Propose, but the change function also reports
+	// whether it applied its change.
+	ProposeReporting(ctx context.Context, key string, f ReportingChangeFunc) (state []byte, changed bool, b Ballot, err error)
+
+	// These methods are like Propose, but also deal with the metadata stored
+	// alongside each value.
+	ProposeWithMeta(ctx context.Context, key string, f ChangeFunc, meta Metadata) (state []byte, b Ballot, err error)
+	ReadWithMeta(ctx context.Context, key string) (state []byte, meta Metadata, b Ballot, err error)
+
 	// These methods are for configuration changes.
 	AddAccepter(target Acceptor) error
 	AddPreparer(target Acceptor) error
 	RemovePreparer(target Acceptor) error
 	RemoveAccepter(target Acceptor) error
+	UpdateAcceptorAddress(ctx context.Context, old string, target Acceptor) error
+	FastForwardEpoch(epoch uint64)
 
 	// These methods are for garbage collection, for deletes.
 	FullIdentityRead(ctx context.Context, key string) (state []byte, err error)
 	FastForwardIncrement(ctx context.Context, key string, tombstone Ballot) (Age, error)
+
+	// These methods are for operators, e.g. after restoring a proposer from
+	// an old backup.
+	Age() Age
+	FastForward(counter uint64) (Age, error)
 }
 
 // Acceptor models a complete, uniquely-addressable acceptor.
@@ -33,6 +50,8 @@ type Proposer interface {
 // second-phase "accept" responsibilities only.
 type Acceptor interface {
 	Addresser
+	Identifier
+	Pinger
 	Preparer
 	Accepter
 	GarbageCollecter
@@ -43,14 +62,42 @@ type Addresser interface {
 	Address() string // typically "protocol://host:port"
 }
 
+// Identifier models something with a stable identity, which survives changes
+// to its address. Implementations that talk over a network should remember the
+// ID they last observed, so identity can still be established after the remote
+// acceptor has moved.
+type Identifier interface {
+	NodeID(ctx context.Context) (string, error)
+}
+
+// Pinger models a cheap liveness check. Ping must never change the state of
+// the acceptor, so it's safe to call as often as needed, e.g. while probing an
+// acceptor that's been failing.
+type Pinger interface {
+	Ping(ctx context.Context) (PingInfo, error)
+}
+
+// PingInfo is returned by a successful Ping.
+type PingInfo struct {
+	NodeID          string
+	ProtocolVersion int
+	Keys            int // number of keys with any state
+}
+
+// ProtocolVersion is the version of the acceptor protocol implemented by this
+// package. It changes when acceptors and proposers of different versions can't
+// safely be mixed.
+const ProtocolVersion = 1
+
 // Preparer models the first-phase responsibilities of an acceptor.
 type Preparer interface {
-	Prepare(ctx context.Context, key string, age Age, b Ballot) (value []byte, current Ballot, err error)
+	Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) (value []byte, sum Checksum, meta Metadata, current Ballot, err error)
+	Abandon(ctx context.Context, key string, b Ballot) error
 }
 
 // Accepter models the second-phase responsibilities of an acceptor.
 type Accepter interface {
-	Accept(ctx context.Context, key string, age Age, b Ballot, value []byte) error
+	Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error
 }
 
 // GarbageCollecter models the garbage collection responsibilities of an acceptor.
@@ -59,8 +106,34 @@ type GarbageCollecter interface {
 	RemoveIfEqual(ctx context.Context, key string, state []byte) error
 }
 
+// Inspecter models direct, read-only access to the state of an acceptor, for
+// operators. It bypasses the protocol entirely, so it mustn't be used to serve
+// reads.
+type Inspecter interface {
+	Addresser
+	Keys(ctx context.Context) ([]string, error)
+	Inspect(ctx context.Context, key string) (Inspection, error)
+	MaxBallot(ctx context.Context) (Ballot, error)
+}
+
+// Inspection is the state of a single key in an acceptor.
+type Inspection struct {
+	Value      []byte
+	Meta       Metadata // of the current value; not kept in history
+	Accepted   Ballot
+	AcceptedAt time.Time // zero if never accepted
+}
+
+// Historian models access to the values an acceptor accepted in the past, for
+// debugging. Like Inspecter, it bypasses the protocol.
+type Historian interface {
+	Addresser
+	History(ctx context.Context, key string) ([]Inspection, error) // oldest first
+}
+
 // Assign special meaning to the zero/empty key "", which we use to increment
-// ballot numbers for operations like changing cluster configuration.
+// ballot numbers for operations like changing cluster configuration, and to
+// store the configuration epoch.
 const zerokey = ""
 
 // Note: When growing (or shrinking) a cluster from an odd number of acceptors
@@ -117,6 +190,7 @@ func GrowCluster(ctx context.Context, target Acceptor, proposers []Proposer) err
 	if _, _, err := proposer.Propose(ctx, zerokey, identity); err != nil {
 		return errors.Wrap(err, "during grow step 2 (identity read)")
 	}
+	confirmSweep(proposers)
 
 	// From the paper: "Connect to each proposer and update its configuration to
 	// send 'prepare' messages to the [new] set of acceptors, and to require F+2
@@ -128,8 +202,12 @@ func GrowCluster(ctx context.Context, target Acceptor, proposers []Proposer) err
 		undo = append(undo, func() { proposer.RemovePreparer(target) })
 	}
 
-	// Success! Kill the undo stack, and return.
+	// Success! Kill the undo stack. The configuration has changed, so failing
+	// to bump the epoch is an error, but there's nothing to undo.
 	undo = []func(){}
+	if err := bumpEpoch(ctx, proposers); err != nil {
+		return errors.Wrap(err, "during grow step 4 (bump epoch)")
+	}
 	return nil
 }
 
@@ -162,6 +240,7 @@ func ShrinkCluster(ctx context.Context, target Acceptor, proposers []Proposer) e
 	if _, _, err := proposer.Propose(ctx, zerokey, identity); err != nil {
 		return errors.Wrap(err, "during shrink step 2 (identity read)")
 	}
+	confirmSweep(proposers)
 
 	// And then remove it as an accepter.
 	for _, proposer := range proposers {
@@ -171,8 +250,11 @@ func ShrinkCluster(ctx context.Context, target Acceptor, proposers []Proposer) e
 		undo = append(undo, func() { proposer.AddAccepter(target) })
 	}
 
-	// Done.
+	// Done, modulo the epoch; see GrowCluster.
 	undo = []func(){}
+	if err := bumpEpoch(ctx, proposers); err != nil {
+		return errors.Wrap(err, "during shrink step 4 (bump epoch)")
+	}
 	return nil
 }
 
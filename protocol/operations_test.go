@@ -11,7 +11,7 @@ import (
 func TestConfigurationChange(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger = log.NewLogfmtLogger(testWriter{t})
+		logger = log.NewLogfmtLogger(newTestWriter(t))
 		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
@@ -83,7 +83,7 @@ func TestConfigurationChange(t *testing.T) {
 func TestGarbageCollection(t *testing.T) {
 	// Build the cluster.
 	var (
-		logger  = log.NewLogfmtLogger(testWriter{t})
+		logger  = log.NewLogfmtLogger(newTestWriter(t))
 		a1      = NewMemoryAcceptor("1", log.With(logger, "a", 1))
 		a2      = NewMemoryAcceptor("2", log.With(logger, "a", 2))
 		a3      = NewMemoryAcceptor("3", log.With(logger, "a", 3))
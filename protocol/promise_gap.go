@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"context"
+	"sort"
+)
+
+// PromiseGap is a key with an outstanding promise: a prepare which was never
+// followed by an accept, e.g. because its round was canceled, and wasn't
+// abandoned. A key whose promise is far ahead of its accepted ballot is
+// usually the work of a client which keeps canceling its proposals.
+type PromiseGap struct {
+	Key      string
+	Promise  Ballot
+	Accepted Ballot
+	Gap      uint64 // promise counter less accepted counter
+}
+
+// PromiseGaps returns up to n keys with outstanding promises, with the widest
+// gap between their promised and accepted ballot counters first. It scans
+// every key. Outstanding promises are harmless for safety, but each forces the
+// next proposer for its key to jump its ballot counter past it.
+//
+// Decaying old promises would be unsafe: a promise also stops the acceptor
+// from accepting a lower ballot, which the round that got the promise relies
+// on, however long it takes. For the same reason, Abandon, which proposers
+// with WithAbandonment use to release the promises of their canceled rounds,
+// doesn't clear a promise: it falls back to the greatest one the acceptor made
+// to any other round, which some round may still rely on.
+func (a *MemoryAcceptor) PromiseGaps(ctx context.Context, n int) ([]PromiseGap, error) {
+	var gaps []PromiseGap
+	a.values.forEach(func(key string, av acceptedValue) bool {
+		if av.promise.isZero() || !av.promise.greaterThan(av.accepted) {
+			return true
+		}
+		var gap uint64
+		if av.promise.Counter > av.accepted.Counter {
+			gap = av.promise.Counter - av.accepted.Counter
+		}
+		gaps = append(gaps, PromiseGap{Key: key, Promise: av.promise, Accepted: av.accepted, Gap: gap})
+		return true
+	})
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Gap != gaps[j].Gap {
+			return gaps[i].Gap > gaps[j].Gap
+		}
+		return gaps[i].Key < gaps[j].Key
+	})
+	if n >= 0 && len(gaps) > n {
+		gaps = gaps[:n]
+	}
+	return gaps, nil
+}
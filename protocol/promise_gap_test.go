@@ -0,0 +1,69 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPromiseGaps(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+		read   = func(x []byte) ([]byte, bool) { return x, false }
+	)
+	for _, key := range []string{"settled", "hot", "warm"} {
+		if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Explain leaves its promises in place, like a canceled round.
+	for _, testcase := range []struct {
+		key string
+		n   int
+	}{
+		{"warm", 2},
+		{"hot", 5},
+	} {
+		for i := 0; i < testcase.n; i++ {
+			if _, err := p.Explain(ctx, testcase.key, read); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	gaps, err := a1.PromiseGaps(ctx, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, len(gaps); want != have {
+		t.Fatalf("want %d gaps, have %d: %+v", want, have, gaps)
+	}
+	if gaps[0].Key != "hot" || gaps[1].Key != "warm" || gaps[0].Gap <= gaps[1].Gap {
+		t.Errorf("want hot before warm, have %+v", gaps)
+	}
+	for _, gap := range gaps {
+		if want, have := gap.Promise.Counter-gap.Accepted.Counter, gap.Gap; want != have {
+			t.Errorf("%s: want gap %d, have %d", gap.Key, want, have)
+		}
+	}
+	if gaps, _ := a1.PromiseGaps(ctx, 1); len(gaps) != 1 || gaps[0].Key != "hot" {
+		t.Errorf("limited: want only hot, have %+v", gaps)
+	}
+
+	// The next write fulfills the promise.
+	_, b, err := p.Propose(ctx, "hot", changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAccepted(t, "hot", b, a1)
+	if gaps, _ := a1.PromiseGaps(ctx, -1); len(gaps) != 1 || gaps[0].Key != "warm" {
+		t.Errorf("after write: want only warm, have %+v", gaps)
+	}
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import "github.com/go-kit/kit/log/level"
+
+// ChangeEvent describes a round, proposed by ProposeReporting, which committed,
+// and in which the change function reported that it changed the value.
+type ChangeEvent struct {
+	Key      string
+	Previous Checksum // of the value the change was applied to
+	Value    []byte   // the new value; nil if the change deleted the key
+	Ballot   Ballot   // of the round
+}
+
+// ChangeHook is called with each ChangeEvent.
+type ChangeHook func(ChangeEvent)
+
+// OnChange registers hook to be called after every successful ProposeReporting
+// whose change function reported a change, e.g. a compare-and-swap whose
+// comparison matched. It's not called for a change which didn't apply, or for
+// a round which failed. So of two writers racing to make the same transition,
+// only the one which made it publishes it. Propose, and the other methods
+// taking a ChangeFunc, can't tell whether their change applied, and don't call
+// the hooks.
+//
+// Delivery is at most once. A round which fails in the accept phase may still
+// have made its change, which the next round then finds, and no event is
+// published for it.
+//
+// As with OnConfigurationChange, hooks are called synchronously, with the
+// proposer's mutex held, and mustn't call the proposer. Each gets its own copy
+// of the value. A hook which panics is logged, and doesn't affect the
+// proposal, or the other hooks.
+func (p *MemoryProposer) OnChange(hook ChangeHook) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.changeHooks = append(p.changeHooks, hook)
+}
+
+// publishChange calls the change hooks. It must be called with the mutex held.
+func (p *MemoryProposer) publishChange(key string, previous, value []byte, b Ballot) {
+	if len(p.changeHooks) == 0 {
+		return
+	}
+	sum := checksumOf(previous)
+	for _, hook := range p.changeHooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					level.Error(p.logger).Log("method", "publishChange", "key", key, "err", "change hook panicked", "panic", r)
+				}
+			}()
+			hook(ChangeEvent{Key: key, Previous: sum, Value: copyValue(value), Ballot: b})
+		}()
+	}
+}
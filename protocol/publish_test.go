@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestPublishChange(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+		key    = "counter"
+		writes = 50
+
+		mtx    sync.Mutex
+		events []ChangeEvent
+	)
+	for _, p := range []*MemoryProposer{p1, p2} {
+		p.OnChange(func(e ChangeEvent) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			events = append(events, e)
+		})
+	}
+	p1.OnChange(func(ChangeEvent) { panic("oops") })
+
+	// Each writer increments the counter with a compare-and-swap, retrying
+	// until its own comparison matches, so both keep racing for the same
+	// transitions.
+	increment := func(p *MemoryProposer) {
+		for {
+			current, _, err := p.Propose(ctx, key, changeFuncRead)
+			if err != nil {
+				continue
+			}
+			n, _ := strconv.Atoi(string(current))
+			_, changed, _, err := p.ProposeReporting(ctx, key, func(x []byte) ([]byte, bool) {
+				if string(x) != string(current) {
+					return x, false
+				}
+				return []byte(strconv.Itoa(n + 1)), true
+			})
+			if err == nil && changed {
+				return
+			}
+		}
+	}
+	var wg sync.WaitGroup
+	for _, p := range []*MemoryProposer{p1, p2} {
+		wg.Add(1)
+		go func(p *MemoryProposer) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				increment(p)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	// One event per successful write, and never two for the same transition.
+	if want, have := 2*writes, len(events); want != have {
+		t.Errorf("events: want %d, have %d", want, have)
+	}
+	seen := map[string]bool{}
+	for _, e := range events {
+		n, _ := strconv.Atoi(string(e.Value))
+		previous := []byte(strconv.Itoa(n - 1))
+		if n == 1 {
+			previous = nil
+		}
+		if e.Key != key || e.Previous != checksumOf(previous) || e.Ballot.isZero() {
+			t.Errorf("bad event %+v", e)
+		}
+		if seen[string(e.Value)] {
+			t.Errorf("transition to %s published twice", e.Value)
+		}
+		seen[string(e.Value)] = true
+	}
+
+	// A change which doesn't apply isn't published.
+	before := len(events)
+	if _, changed, _, err := p1.ProposeReporting(ctx, key, func(x []byte) ([]byte, bool) { return x, false }); err != nil || changed {
+		t.Fatalf("no-op: want no change, have %v (%v)", changed, err)
+	}
+	if want, have := before, len(events); want != have {
+		t.Errorf("after no-op: want %d events, have %d", want, have)
+	}
+}
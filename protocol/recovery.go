@@ -0,0 +1,180 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// ErrNotAdmitted is returned by an acceptor which has lost its data, and is
+// waiting for an operator to admit it back into the cluster.
+var ErrNotAdmitted = errors.New("acceptor lost its data, and hasn't been re-admitted")
+
+// acceptorIdentity is what the recovery gate persists: who the acceptor is,
+// and which generation of data it should have.
+type acceptorIdentity struct {
+	NodeID     string `json:"node_id"`
+	Generation uint64 `json:"generation"`
+}
+
+// WithRecoveryGate makes the acceptor persist its identity at path, and refuse
+// to take part in the protocol if it starts without the data that identity
+// says it should have. An acceptor which lost its data may have made promises
+// it no longer knows about, and breaking them can break consistency, so it
+// refuses prepares and accepts with ErrNotAdmitted until an operator calls
+// Admit.
+//
+// The identity records the generation of the acceptor's data, which starts at
+// one, and is incremented by every admission. A MemoryAcceptor's data never
+// survives a restart, so it starts at generation zero, and any restart with an
+// existing identity file is gated. A missing file means a new acceptor, which
+// isn't gated; an unreadable one, or one with some other node ID, is treated
+// as lost data.
+func WithRecoveryGate(path string) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.identityPath = path }
+}
+
+// checkIdentity applies the recovery gate at startup. It's called once all of
+// the options are applied.
+func (a *MemoryAcceptor) checkIdentity() {
+	buf, err := ioutil.ReadFile(a.identityPath)
+	if os.IsNotExist(err) {
+		a.generation = 1
+		if err := a.writeIdentity(); err != nil {
+			level.Error(a.logger).Log("method", "checkIdentity", "path", a.identityPath, "err", err)
+			a.awaitingAdmission = true // we'd be gated after a restart anyway
+		}
+		return
+	}
+
+	var id acceptorIdentity
+	if err == nil {
+		err = json.Unmarshal(buf, &id)
+	}
+	switch {
+	case err != nil:
+		level.Warn(a.logger).Log("method", "checkIdentity", "path", a.identityPath, "err", err, "advisory", "identity unreadable; refusing to serve until admitted")
+	case id.NodeID != a.nodeID:
+		level.Warn(a.logger).Log("method", "checkIdentity", "path", a.identityPath, "identity", id.NodeID, "advisory", "identity belongs to another acceptor; refusing to serve until admitted")
+	case id.Generation != a.generation:
+		level.Warn(a.logger).Log("method", "checkIdentity", "path", a.identityPath, "want_generation", id.Generation, "have_generation", a.generation, "advisory", "data is missing; refusing to serve until admitted")
+	default:
+		return
+	}
+	a.generation = id.Generation
+	a.awaitingAdmission = true
+}
+
+func (a *MemoryAcceptor) writeIdentity() error {
+	buf, err := json.MarshalIndent(acceptorIdentity{NodeID: a.nodeID, Generation: a.generation}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.identityPath, buf)
+}
+
+// Admitted returns false if the acceptor is gated, waiting for Admit.
+func (a *MemoryAcceptor) Admitted() bool {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+	return !a.awaitingAdmission
+}
+
+// AdmitReport describes what Admit did.
+type AdmitReport struct {
+	Keys       int    // keys copied from the sources
+	Floor      Ballot // the greatest ballot any source had seen
+	Generation uint64 // of the acceptor's data, now
+}
+
+// Admit brings a gated acceptor up to date, and lets it take part in the
+// protocol again. It's a state transfer, like a learner catching up: every key
+// known to the sources is copied, with the value of the greatest accepted
+// ballot any of them has, and the acceptor refuses ballots up to the greatest
+// any of them has seen, so it can't break a promise it made before it lost
+// its data. Metadata isn't copied, as Inspecter doesn't report it.
+//
+// Sources should be every other acceptor in the cluster. Admit fails if any of
+// them can't be read, as the floor might be too low. Admitting an acceptor
+// which isn't gated is a no-op.
+func (a *MemoryAcceptor) Admit(ctx context.Context, sources []Inspecter) (AdmitReport, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if !a.awaitingAdmission {
+		return AdmitReport{Generation: a.generation}, nil
+	}
+
+	var (
+		floor = a.floor
+		keys  = map[string]bool{}
+	)
+	for _, source := range sources {
+		b, err := source.MaxBallot(ctx)
+		if err != nil {
+			return AdmitReport{}, errors.Wrapf(err, "error reading max ballot from %s", source.Address())
+		}
+		if b.greaterThan(floor) {
+			floor = b
+		}
+		sourceKeys, err := source.Keys(ctx)
+		if err != nil {
+			return AdmitReport{}, errors.Wrapf(err, "error listing keys on %s", source.Address())
+		}
+		for _, key := range sourceKeys {
+			keys[key] = true
+		}
+	}
+
+	report := AdmitReport{Floor: floor}
+	for key := range keys {
+		var best Inspection
+		for _, source := range sources {
+			if roleOf(source) == RoleWitness {
+				continue // no value to copy
+			}
+			inspection, err := source.Inspect(ctx, key)
+			if err != nil {
+				return AdmitReport{}, errors.Wrapf(err, "error inspecting %s on %s", key, source.Address())
+			}
+			if inspection.Accepted.greaterThan(best.Accepted) {
+				best = inspection
+			}
+		}
+		if best.Accepted.isZero() {
+			continue
+		}
+		a.install(key, best)
+		report.Keys++
+	}
+
+	a.floor, a.generation = floor, a.generation+1
+	if err := a.writeIdentity(); err != nil {
+		return AdmitReport{}, errors.Wrap(err, "error writing identity")
+	}
+	a.awaitingAdmission = false
+	report.Generation = a.generation
+	level.Info(a.logger).Log("method", "Admit", "keys", report.Keys, "floor", floor, "generation", a.generation)
+	return report, nil
+}
+
+// install stores an accepted value copied from another acceptor.
+func (a *MemoryAcceptor) install(key string, inspection Inspection) {
+	var (
+		sum    = checksumOf(inspection.Value)
+		stored []byte
+		codec  Codec
+		size   int
+	)
+	if !a.witness {
+		stored, codec, size = a.compress(copyValue(inspection.Value))
+	}
+	a.values.update(key, func(av acceptedValue, _ bool) (acceptedValue, bool, error) {
+		av.accepted, av.value, av.codec, av.size, av.sum = inspection.Accepted, stored, codec, size, sum
+		av.acceptedAt = inspection.AcceptedAt
+		return av, true, nil
+	})
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRecoveryGate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caspaxos-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		path   = filepath.Join(dir, "identity.json")
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithRecoveryGate(path))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+		n      = 5
+	)
+
+	// A new acceptor isn't gated.
+	if !a1.Admitted() {
+		t.Fatal("new acceptor: not admitted")
+	}
+	for i := 0; i < n; i++ {
+		if _, _, err := p.Propose(ctx, fmt.Sprintf("k%d", i), changeFuncInitializeOnlyOnce(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The data directory is wiped, and the acceptor restarts.
+	restarted := NewMemoryAcceptor("1", log.With(logger, "a", 1), WithRecoveryGate(path))
+	if restarted.Admitted() {
+		t.Fatal("restarted acceptor: admitted")
+	}
+	if _, _, _, _, err := restarted.Prepare(ctx, "k0", Age{}, 0, Ballot{Counter: 100, ID: "x"}); err != ErrNotAdmitted {
+		t.Errorf("Prepare: want %v, have %v", ErrNotAdmitted, err)
+	}
+	if err := restarted.Accept(ctx, "k0", Age{}, Ballot{Counter: 100, ID: "x"}, nil, 0, nil); err != ErrNotAdmitted {
+		t.Errorf("Accept: want %v, have %v", ErrNotAdmitted, err)
+	}
+
+	// The rest of the cluster still works without it.
+	p = NewMemoryProposer("2", log.With(logger, "p", 2), restarted, a2, a3)
+	if state, _, err := p.Propose(ctx, "k0", changeFuncRead); err != nil || string(state) != "0" {
+		t.Fatalf("read while gated: %q, %v", state, err)
+	}
+
+	// Admission copies the data, and fences off old ballots.
+	report, err := restarted.Admit(ctx, []Inspecter{a2, a3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := n, report.Keys; want != have {
+		t.Errorf("keys: want %d, have %d", want, have)
+	}
+	if want, have := uint64(2), report.Generation; want != have {
+		t.Errorf("generation: want %d, have %d", want, have)
+	}
+	if !restarted.Admitted() {
+		t.Fatal("after Admit: not admitted")
+	}
+	for i := 0; i < n; i++ {
+		if want, have := fmt.Sprint(i), string(restarted.dumpValue(fmt.Sprintf("k%d", i))); want != have {
+			t.Errorf("k%d: want %q, have %q", i, want, have)
+		}
+	}
+	if _, _, _, _, err := restarted.Prepare(ctx, "new", Age{}, 0, report.Floor); err == nil {
+		t.Errorf("Prepare at the floor: want conflict, have success")
+	}
+	for i := 0; i < n; i++ {
+		if _, _, err := p.Propose(ctx, fmt.Sprintf("k%d", i), changeFuncRead); err != nil {
+			t.Fatalf("read after Admit: %v", err)
+		}
+	}
+
+	// Losing the data again gates it again.
+	if NewMemoryAcceptor("1", log.With(logger, "a", 1), WithRecoveryGate(path)).Admitted() {
+		t.Error("second restart: admitted")
+	}
+
+	// As does someone else's identity.
+	if NewMemoryAcceptor("2", log.With(logger, "a", 2), WithRecoveryGate(path)).Admitted() {
+		t.Error("other node ID: admitted")
+	}
+}
@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// WithReadRepair enables read repair. The prepare phase of every round reveals
+// which acceptors hold an older value than the rest, usually because they
+// missed accepts while they were unreachable. If such an acceptor doesn't
+// confirm the round's accept before the round completes, the proposer sends it
+// that accept again, asynchronously, so it catches up without waiting for a
+// VerifyAll. Ordinary reads are rounds too, so read traffic alone is enough.
+//
+// Repairs wait in a queue of the given size, and are sent at most rate per
+// second; repairs that don't fit in the queue are dropped. A repair is exactly
+// the accept message of a completed round, with its ballot and value, which
+// the acceptor is free to reject if it has moved on, so it's always safe.
+func WithReadRepair(queue int, rate float64) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.repairer = newRepairer(queue, rate, p.logger) }
+}
+
+// RepairStats count the read repairs made by a proposer.
+type RepairStats struct {
+	Issued    uint64 // repairs sent
+	Succeeded uint64 // repairs the acceptor accepted
+	Dropped   uint64 // repairs not sent, because the queue was full
+}
+
+// RepairStats returns the counts since the proposer was created.
+func (p *MemoryProposer) RepairStats() RepairStats {
+	return p.repairer.snapshot()
+}
+
+// repair is the accept message of a completed round, for a stale acceptor.
+type repair struct {
+	addr   string
+	target Accepter
+	key    string
+	age    Age
+	b      Ballot
+	value  []byte
+	sum    Checksum
+	meta   Metadata
+}
+
+// repairer sends queued repairs, from a goroutine which runs while there are
+// any. A nil repairer repairs nothing.
+type repairer struct {
+	queue    chan repair
+	interval time.Duration
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	running bool
+	stats   RepairStats
+}
+
+func newRepairer(queue int, rate float64, logger log.Logger) *repairer {
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	return &repairer{
+		queue:    make(chan repair, queue),
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// enqueue queues rp, or drops it if the queue is full.
+func (r *repairer) enqueue(rp repair) {
+	if r == nil {
+		return
+	}
+	select {
+	case r.queue <- rp:
+	default:
+		r.mtx.Lock()
+		r.stats.Dropped++
+		r.mtx.Unlock()
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.running {
+		r.running = true
+		go r.run()
+	}
+}
+
+func (r *repairer) run() {
+	for {
+		r.mtx.Lock()
+		var rp repair
+		select {
+		case rp = <-r.queue:
+		default:
+			r.running = false
+			r.mtx.Unlock()
+			return
+		}
+		r.stats.Issued++
+		r.mtx.Unlock()
+
+		err := rp.target.Accept(context.Background(), rp.key, rp.age, rp.b, rp.value, rp.sum, rp.meta)
+		level.Debug(r.logger).Log("method", "repair", "key", rp.key, "addr", rp.addr, "B", rp.b, "success", err == nil, "err", err)
+		if err == nil {
+			r.mtx.Lock()
+			r.stats.Succeeded++
+			r.mtx.Unlock()
+		}
+
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *repairer) snapshot() RepairStats {
+	if r == nil {
+		return RepairStats{}
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.stats
+}
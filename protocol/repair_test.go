@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReadRepair(t *testing.T) {
+	for _, testcase := range []struct {
+		name    string
+		options []MemoryProposerOption
+		repair  bool
+	}{
+		{"disabled", nil, false},
+		{"enabled", []MemoryProposerOption{WithReadRepair(16, 1000)}, true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				logger = log.NewLogfmtLogger(newTestWriter(t))
+				a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+				a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+				a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+				writer = NewMemoryProposer("w", log.With(logger, "p", "w"), a1, a2)
+				reader = NewMemoryProposerWithOptions("r", log.With(logger, "p", "r"), []Acceptor{slowAcceptor{a1, 20 * time.Millisecond, 0}, a2, lossyAcceptor{a3}}, testcase.options...)
+				ctx    = context.Background()
+				n      = 5
+			)
+
+			// a3 is partitioned away while the keys are written.
+			for i := 0; i < n; i++ {
+				key := fmt.Sprintf("k%d", i)
+				if _, _, err := writer.Propose(ctx, key, changeFuncInitializeOnlyOnce("x")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The partition heals, but the round's own accepts to a3 are still
+			// lost, so only a repair can bring it up to date. a1 is slow, so
+			// a3 is always in the prepare quorum, and seen to be stale.
+			lossy := context.WithValue(ctx, lossyKey{}, true)
+			for i := 0; i < n; i++ {
+				key := fmt.Sprintf("k%d", i)
+				if state, _, err := reader.Propose(lossy, key, changeFuncRead); err != nil || string(state) != "x" {
+					t.Fatalf("read %s: %q, %v", key, state, err)
+				}
+			}
+
+			converged := func() bool {
+				for i := 0; i < n; i++ {
+					if string(a3.dumpValue(fmt.Sprintf("k%d", i))) != "x" {
+						return false
+					}
+				}
+				return true
+			}
+			deadline := time.Now().Add(time.Second)
+			for testcase.repair && !converged() && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if want, have := testcase.repair, converged(); want != have {
+				t.Fatalf("a3 converged: want %v, have %v", want, have)
+			}
+
+			want := RepairStats{}
+			if testcase.repair {
+				want = RepairStats{Issued: uint64(n), Succeeded: uint64(n)}
+			}
+			if have := reader.RepairStats(); want != have {
+				t.Errorf("stats: want %+v, have %+v", want, have)
+			}
+		})
+	}
+}
+
+func TestReadRepairQueue(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		writer = NewMemoryProposer("w", log.With(logger, "p", "w"), a1, a2)
+		reader = NewMemoryProposerWithOptions("r", log.With(logger, "p", "r"), []Acceptor{slowAcceptor{a1, 20 * time.Millisecond, 0}, a2, lossyAcceptor{a3}}, WithReadRepair(1, 10))
+		ctx    = context.Background()
+		lossy  = context.WithValue(ctx, lossyKey{}, true)
+		n      = 5
+	)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if _, _, err := writer.Propose(ctx, key, changeFuncInitializeOnlyOnce("x")); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := reader.Propose(lossy, key, changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first repair is sent right away, and the next waits in the queue,
+	// which is then full, for the rate limit. The rest are dropped. If the
+	// first is still in the queue when the second arrives, that's dropped too.
+	if dropped := reader.RepairStats().Dropped; dropped < uint64(n-2) || dropped > uint64(n-1) {
+		t.Errorf("dropped: want %d or %d, have %d", n-2, n-1, dropped)
+	}
+}
+
+type lossyKey struct{}
+
+// lossyAcceptor fails accepts whose context is marked lossy, modeling accepts
+// lost to a partition.
+type lossyAcceptor struct{ *MemoryAcceptor }
+
+func (a lossyAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	if lossy, _ := ctx.Value(lossyKey{}).(bool); lossy {
+		return errBroken
+	}
+	return a.MemoryAcceptor.Accept(ctx, key, age, b, value, sum, meta)
+}
@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RoutingTableKey is the reserved key which stores the routing table.
+const RoutingTableKey = "\x00routing"
+
+// RoutingTable maps key prefixes to the address of the proposer which owns
+// them. Routing is a conflict-avoidance optimization: if every write to a key
+// goes through the same proposer, writes to it don't conflict. Correctness
+// never depends on it.
+type RoutingTable map[string]string
+
+// Owner returns the owner of key, by longest matching prefix.
+func (rt RoutingTable) Owner(key string) (addr string, ok bool) {
+	var longest = -1
+	for prefix, owner := range rt {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			addr, ok, longest = owner, true, len(prefix)
+		}
+	}
+	return addr, ok
+}
+
+// ReadRoutingTable reads the routing table via the proposer.
+func ReadRoutingTable(ctx context.Context, proposer Proposer) (RoutingTable, error) {
+	identity := func(x []byte) []byte { return x }
+	state, _, err := proposer.Propose(ctx, RoutingTableKey, identity)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoutingTable(state)
+}
+
+// SetRoute assigns prefix to the proposer at addr. An empty addr removes the
+// route for prefix.
+func SetRoute(ctx context.Context, proposer Proposer, prefix, addr string) error {
+	var decodeErr error
+	update := func(current []byte) []byte {
+		rt, err := decodeRoutingTable(current)
+		if decodeErr = err; err != nil {
+			return current
+		}
+		if addr == "" {
+			delete(rt, prefix)
+		} else {
+			rt[prefix] = addr
+		}
+		buf, _ := json.Marshal(rt) // can't fail
+		return buf
+	}
+	if _, _, err := proposer.Propose(ctx, RoutingTableKey, update); err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+func decodeRoutingTable(state []byte) (RoutingTable, error) {
+	rt := RoutingTable{}
+	if len(state) == 0 {
+		return rt, nil
+	}
+	if err := json.Unmarshal(state, &rt); err != nil {
+		return nil, errors.Wrap(err, "error decoding routing table")
+	}
+	return rt, nil
+}
+
+// RouterStats count the proposals made through a Router.
+type RouterStats struct {
+	Local     uint64 // proposals made by the local proposer
+	Forwarded uint64 // proposals forwarded to the owner
+	Fallback  uint64 // proposals made locally because the owner was unreachable
+}
+
+// Router sends each proposal to the proposer which owns its key, according to
+// the routing table, or to the local proposer if the key has no owner, or its
+// owner isn't one of the known peers. The table is cached, and re-read from the
+// cluster when it's older than maxAge.
+//
+// If the owner can't be reached, because it refused the connection, the
+// proposal is made locally instead. Other errors from the owner are returned
+// as they are: after a timeout, for example, the owner may have applied the
+// change, and applying it again locally could apply it twice.
+type Router struct {
+	local     Proposer
+	localAddr string
+	peers     map[string]Proposer
+	maxAge    time.Duration
+
+	mtx    sync.Mutex
+	table  RoutingTable
+	readAt time.Time
+	stats  RouterStats
+	now    func() time.Time
+}
+
+// NewRouter returns a router for the local proposer, at localAddr, with the
+// given peers, by address.
+func NewRouter(local Proposer, localAddr string, peers map[string]Proposer, maxAge time.Duration) *Router {
+	return &Router{
+		local:     local,
+		localAddr: localAddr,
+		peers:     peers,
+		maxAge:    maxAge,
+		now:       time.Now,
+	}
+}
+
+// Propose routes the proposal to the owner of key.
+func (r *Router) Propose(ctx context.Context, key string, f ChangeFunc) (state []byte, b Ballot, err error) {
+	table, err := r.routingTable(ctx)
+	if err != nil {
+		return nil, b, err
+	}
+
+	owner, ok := table.Owner(key)
+	peer, known := r.peers[owner]
+	if !ok || owner == r.localAddr || !known {
+		r.count(func(s *RouterStats) { s.Local++ })
+		return r.local.Propose(ctx, key, f)
+	}
+
+	state, b, err = peer.Propose(ctx, key, f)
+	if err != nil && ClassifyFailure(err) == FailureRefused {
+		r.count(func(s *RouterStats) { s.Fallback++ })
+		return r.local.Propose(ctx, key, f)
+	}
+	r.count(func(s *RouterStats) { s.Forwarded++ })
+	return state, b, err
+}
+
+// Stats returns the counts since the router was created.
+func (r *Router) Stats() RouterStats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.stats
+}
+
+// Invalidate forgets the cached routing table, so the next proposal reads it
+// again, e.g. after a call to SetRoute.
+func (r *Router) Invalidate() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.table = nil
+}
+
+func (r *Router) routingTable(ctx context.Context) (RoutingTable, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.table != nil && r.now().Sub(r.readAt) < r.maxAge {
+		return r.table, nil
+	}
+	table, err := ReadRoutingTable(ctx, r.local)
+	if err != nil {
+		return nil, err
+	}
+	r.table, r.readAt = table, r.now()
+	return table, nil
+}
+
+func (r *Router) count(f func(*RouterStats)) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	f(&r.stats)
+}
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRoutingTableOwner(t *testing.T) {
+	rt := RoutingTable{"users/": "a", "users/admin/": "b", "": "c"}
+	for key, want := range map[string]string{
+		"users/alice":     "a",
+		"users/admin/bob": "b",
+		"orders/1":        "c",
+	} {
+		if have, ok := rt.Owner(key); !ok || want != have {
+			t.Errorf("%s: want %q, have %q (%v)", key, want, have, ok)
+		}
+	}
+	if _, ok := (RoutingTable{"users/": "a"}).Owner("orders/1"); ok {
+		t.Errorf("unrouted key has an owner")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		now    = time.Now()
+		ctx    = context.Background()
+	)
+	r := NewRouter(p1, "p1", map[string]Proposer{"p2": p2, "p3": refusingProposer{p2}}, time.Minute)
+	r.now = func() time.Time { return now }
+
+	if err := SetRoute(ctx, p1, "users/", "p2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRoute(ctx, p1, "orders/", "p3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Owned keys go to their owner; the rest stay local. The ballot tells us
+	// which proposer made the proposal.
+	for _, testcase := range []struct {
+		key  string
+		want string
+	}{
+		{"users/alice", "2"},
+		{"orders/1", "1"}, // p3 is unreachable
+		{"other", "1"},
+	} {
+		_, b, err := r.Propose(ctx, testcase.key, changeFuncRead)
+		if err != nil {
+			t.Fatalf("%s: %v", testcase.key, err)
+		}
+		if want, have := testcase.want, b.ID; want != have {
+			t.Errorf("%s: want proposer %s, have %s", testcase.key, want, have)
+		}
+	}
+	if want, have := (RouterStats{Local: 1, Forwarded: 1, Fallback: 1}), r.Stats(); want != have {
+		t.Errorf("stats: want %+v, have %+v", want, have)
+	}
+
+	// Route changes take effect when the cached table expires.
+	if err := SetRoute(ctx, p1, "users/", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, b, _ := r.Propose(ctx, "users/alice", changeFuncRead); b.ID != "2" {
+		t.Errorf("before expiry: want proposer 2, have %s", b.ID)
+	}
+	now = now.Add(time.Minute)
+	if _, b, _ := r.Propose(ctx, "users/alice", changeFuncRead); b.ID != "1" {
+		t.Errorf("after expiry: want proposer 1, have %s", b.ID)
+	}
+}
+
+// refusingProposer fails every proposal as if the connection was refused.
+type refusingProposer struct{ Proposer }
+
+func (refusingProposer) Propose(ctx context.Context, key string, f ChangeFunc) ([]byte, Ballot, error) {
+	return nil, Ballot{}, syscall.ECONNREFUSED
+}
@@ -0,0 +1,128 @@
+// Package schema stores values with a schema version, and lazily migrates old
+// values to the latest version when they're read.
+//
+// Each value is wrapped in an envelope: the schema version, as a uvarint,
+// followed by the payload. Version N is produced from version N-1 by the
+// (N-1)th migrator, so with migrators m1 and m2, the latest version is 3, and
+// a version 1 value is read as m2(m1(payload)).
+package schema
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/peterbourgon/caspaxos/protocol"
+	"github.com/peterbourgon/caspaxos/protocol/change"
+)
+
+// ErrInvalidEnvelope indicates a stored value that isn't a valid envelope.
+var ErrInvalidEnvelope = errors.New("invalid schema envelope")
+
+// Migrator converts a payload from one schema version to the next.
+type Migrator func(payload []byte) ([]byte, error)
+
+// MigrationError is returned when a migrator fails. The stored value is never
+// modified by a failed migration.
+type MigrationError struct {
+	Key  string
+	From int // the version the migrator was given
+	Err  error
+}
+
+func (me MigrationError) Error() string {
+	return fmt.Sprintf("migrating %q from schema version %d: %v", me.Key, me.From, me.Err)
+}
+
+// VersionError is returned when a stored value has a version newer than the
+// latest one known, e.g. because it was written by a newer program.
+type VersionError struct {
+	Key     string
+	Version int
+	Latest  int
+}
+
+func (ve VersionError) Error() string {
+	return fmt.Sprintf("%q has schema version %d, but the latest known version is %d", ve.Key, ve.Version, ve.Latest)
+}
+
+// Store reads and writes versioned values via a proposer.
+type Store struct {
+	proposer  protocol.Proposer
+	migrators []Migrator
+	writeBack bool
+}
+
+// NewStore returns a store using the given migrators, in order. If writeBack
+// is true, values migrated during a read are written back, so the data
+// converges on the latest version over time.
+func NewStore(proposer protocol.Proposer, migrators []Migrator, writeBack bool) *Store {
+	return &Store{
+		proposer:  proposer,
+		migrators: migrators,
+		writeBack: writeBack,
+	}
+}
+
+// Latest returns the latest schema version.
+func (s *Store) Latest() int {
+	return len(s.migrators) + 1
+}
+
+// Put writes payload, which must be in the latest schema version.
+func (s *Store) Put(ctx context.Context, key string, payload []byte) error {
+	_, _, err := s.proposer.Propose(ctx, key, change.Plain(change.Put(encode(s.Latest(), payload))))
+	return err
+}
+
+// Get reads the value for key, and returns its payload in the latest schema
+// version. A key with no value returns a nil payload.
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	stored, _, err := s.proposer.Propose(ctx, key, change.Plain(change.Identity()))
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) == 0 {
+		return nil, nil
+	}
+
+	version, payload, err := decode(stored)
+	if err != nil {
+		return nil, err
+	}
+	if version > s.Latest() {
+		return nil, VersionError{Key: key, Version: version, Latest: s.Latest()}
+	}
+	if version == s.Latest() {
+		return payload, nil
+	}
+
+	for v := version; v < s.Latest(); v++ {
+		if payload, err = s.migrators[v-1](payload); err != nil {
+			return nil, MigrationError{Key: key, From: v, Err: err}
+		}
+	}
+
+	// Only replace the value we migrated. If it changed in the meantime, the
+	// writer knew better, so we leave it be. The write-back is best-effort:
+	// if it fails, the next read will try again.
+	if s.writeBack {
+		s.proposer.Propose(ctx, key, change.Plain(change.CompareAndSwap(stored, encode(s.Latest(), payload))))
+	}
+	return payload, nil
+}
+
+func encode(version int, payload []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(payload))
+	n := binary.PutUvarint(buf, uint64(version))
+	return append(buf[:n], payload...)
+}
+
+func decode(stored []byte) (version int, payload []byte, err error) {
+	v, n := binary.Uvarint(stored)
+	if n <= 0 || v == 0 {
+		return 0, nil, ErrInvalidEnvelope
+	}
+	return int(v), stored[n:], nil
+}
@@ -0,0 +1,95 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+func TestMigrationConverges(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		a2  = protocol.NewMemoryAcceptor("2", log.NewNopLogger())
+		a3  = protocol.NewMemoryAcceptor("3", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1, a2, a3)
+		ctx = context.Background()
+	)
+
+	// Version 1 is a name; version 2 adds a greeting; version 3 shouts.
+	migrators := []Migrator{
+		func(payload []byte) ([]byte, error) { return append([]byte("hello, "), payload...), nil },
+		func(payload []byte) ([]byte, error) { return bytes.ToUpper(payload), nil },
+	}
+
+	// Write mixed-version data, as successively newer programs would.
+	for version, store := range []*Store{
+		NewStore(p1, migrators[:0], false),
+		NewStore(p1, migrators[:1], false),
+		NewStore(p1, migrators[:2], false),
+	} {
+		payload := map[int]string{0: "alice", 1: "hello, bob", 2: "HELLO, CAROL"}[version]
+		if err := store.Put(ctx, fmt.Sprintf("k%d", version+1), []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reads through the latest store see the latest version, and write it
+	// back, so the stored data converges.
+	store := NewStore(p1, migrators, true)
+	for round := 0; round < 2; round++ {
+		for key, want := range map[string]string{"k1": "HELLO, ALICE", "k2": "HELLO, BOB", "k3": "HELLO, CAROL"} {
+			have, err := store.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("round %d, %s: %v", round, key, err)
+			}
+			if want != string(have) {
+				t.Fatalf("round %d, %s: want %q, have %q", round, key, want, have)
+			}
+		}
+	}
+	for _, key := range []string{"k1", "k2", "k3"} {
+		stored, _, err := p1.Propose(ctx, key, func(x []byte) []byte { return x })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version, _, err := decode(stored); err != nil || version != 3 {
+			t.Errorf("%s: want stored version 3, have %d (%v)", key, version, err)
+		}
+	}
+}
+
+func TestMigrationFailure(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1)
+		ctx = context.Background()
+	)
+
+	if err := NewStore(p1, nil, false).Put(ctx, "k", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	before, _, _ := p1.Propose(ctx, "k", func(x []byte) []byte { return x })
+
+	failing := NewStore(p1, []Migrator{func([]byte) ([]byte, error) { return nil, errors.New("nope") }}, true)
+	_, err := failing.Get(ctx, "k")
+	if me, ok := err.(MigrationError); !ok || me.From != 1 {
+		t.Fatalf("want MigrationError from version 1, have %v", err)
+	}
+	after, _, _ := p1.Propose(ctx, "k", func(x []byte) []byte { return x })
+	if !bytes.Equal(before, after) {
+		t.Fatalf("stored value changed: before %q, after %q", before, after)
+	}
+
+	// Values from the future aren't guessed at.
+	if err := failing.Put(ctx, "k", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStore(p1, nil, false).Get(ctx, "k"); err != (VersionError{Key: "k", Version: 2, Latest: 1}) {
+		t.Fatalf("want VersionError, have %v", err)
+	}
+}
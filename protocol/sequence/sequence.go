@@ -0,0 +1,143 @@
+// Package sequence allocates unique, monotonically increasing IDs from named
+// sequences. Each sequence is a key whose value is the next unallocated ID, in
+// decimal. IDs start at zero.
+package sequence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+var (
+	// ErrExhausted indicates a reservation which would overflow the sequence.
+	ErrExhausted = errors.New("sequence exhausted")
+
+	// ErrInvalidCount indicates a reservation of zero IDs.
+	ErrInvalidCount = errors.New("count must be positive")
+)
+
+// CorruptSequenceError is returned when a sequence's value isn't a number.
+type CorruptSequenceError struct {
+	Name  string
+	Value []byte
+}
+
+func (cse CorruptSequenceError) Error() string {
+	return fmt.Sprintf("sequence %q has invalid value %q", cse.Name, cse.Value)
+}
+
+// Reserve atomically reserves count IDs from the named sequence, in a single
+// proposal, and returns them as the range [start, end). If the proposal fails,
+// the IDs may or may not have been reserved, but they'll never be returned by
+// another reservation, so it's always safe to try again.
+func Reserve(ctx context.Context, proposer protocol.Proposer, name string, count uint64) (start, end uint64, err error) {
+	if count == 0 {
+		return 0, 0, ErrInvalidCount
+	}
+
+	// The change function may be called more than once, if a round fails, so
+	// only the last call counts.
+	var changeErr error
+	advance := func(current []byte) []byte {
+		changeErr = nil
+		next, err := parse(name, current)
+		if err != nil {
+			changeErr = err
+			return current
+		}
+		if next > math.MaxUint64-count {
+			changeErr = ErrExhausted
+			return current
+		}
+		return []byte(strconv.FormatUint(next+count, 10))
+	}
+
+	state, _, err := proposer.Propose(ctx, name, advance)
+	if err != nil {
+		return 0, 0, err
+	}
+	if changeErr != nil {
+		return 0, 0, changeErr
+	}
+	end, err = parse(name, state)
+	if err != nil {
+		return 0, 0, err
+	}
+	return end - count, end, nil
+}
+
+func parse(name string, value []byte) (uint64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, CorruptSequenceError{Name: name, Value: value}
+	}
+	return n, nil
+}
+
+// Allocator hands out IDs from a named sequence, one at a time. It reserves
+// them in blocks, so most allocations don't need a proposal. IDs from a single
+// allocator are increasing; IDs from different allocators are unique, but
+// interleave by block. IDs left in a block when the allocator is discarded are
+// never used.
+type Allocator struct {
+	proposer  protocol.Proposer
+	name      string
+	blockSize uint64
+
+	mtx       sync.Mutex
+	next, end uint64
+}
+
+// NewAllocator returns an allocator for the named sequence, which reserves
+// blockSize IDs at a time.
+func NewAllocator(proposer protocol.Proposer, name string, blockSize uint64) *Allocator {
+	if blockSize < 1 {
+		blockSize = 1
+	}
+	return &Allocator{
+		proposer:  proposer,
+		name:      name,
+		blockSize: blockSize,
+	}
+}
+
+// Next returns the next ID. When the current block is exhausted, it reserves a
+// new one. Proposals that fail because of contention with other proposers are
+// retried, with backoff, until the context is done.
+func (a *Allocator) Next(ctx context.Context) (uint64, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for attempt := 1; a.next >= a.end; attempt++ {
+		start, end, err := Reserve(ctx, a.proposer, a.name, a.blockSize)
+		var qe protocol.QuorumError
+		switch {
+		case err == nil:
+			a.next, a.end = start, end
+		case errors.As(err, &qe):
+			backoff := time.Duration(rand.Int63n(int64(attempt) * int64(time.Millisecond)))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		default:
+			return 0, err
+		}
+	}
+
+	id := a.next
+	a.next++
+	return id, nil
+}
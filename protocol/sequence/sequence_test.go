@@ -0,0 +1,120 @@
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/peterbourgon/caspaxos/protocol"
+)
+
+func TestReserve(t *testing.T) {
+	var (
+		a1  = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		p1  = protocol.NewMemoryProposer("1", log.NewNopLogger(), a1)
+		ctx = context.Background()
+	)
+
+	for _, want := range [][2]uint64{{0, 3}, {3, 13}, {13, 14}} {
+		start, end, err := Reserve(ctx, p1, "s", want[1]-want[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := [2]uint64{start, end}; want != have {
+			t.Errorf("want %v, have %v", want, have)
+		}
+	}
+
+	if _, _, err := Reserve(ctx, p1, "s", 0); err != ErrInvalidCount {
+		t.Errorf("zero count: want %v, have %v", ErrInvalidCount, err)
+	}
+
+	// A value that isn't a sequence is left alone.
+	if _, _, err := p1.Propose(ctx, "t", func([]byte) []byte { return []byte("nope") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Reserve(ctx, p1, "t", 1); err == nil {
+		t.Errorf("corrupt: want error, have none")
+	} else if cse, ok := err.(CorruptSequenceError); !ok || cse.Name != "t" {
+		t.Errorf("corrupt: want CorruptSequenceError for t, have %v", err)
+	}
+	if state, _, _ := p1.Propose(ctx, "t", func(x []byte) []byte { return x }); string(state) != "nope" {
+		t.Errorf("corrupt value was modified: %q", state)
+	}
+
+	// Overflow is refused.
+	if _, _, err := p1.Propose(ctx, "u", func([]byte) []byte { return []byte("18446744073709551615") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Reserve(ctx, p1, "u", 1); err != ErrExhausted {
+		t.Errorf("overflow: want %v, have %v", ErrExhausted, err)
+	}
+}
+
+func TestAllocatorUniqueness(t *testing.T) {
+	// Several proposers share the cluster, so refills conflict.
+	var (
+		a1        = protocol.NewMemoryAcceptor("1", log.NewNopLogger())
+		a2        = protocol.NewMemoryAcceptor("2", log.NewNopLogger())
+		a3        = protocol.NewMemoryAcceptor("3", log.NewNopLogger())
+		acceptors = []protocol.Acceptor{a1, a2, a3}
+		proposers []protocol.Proposer
+	)
+	for i := 1; i <= 3; i++ {
+		proposers = append(proposers, protocol.NewMemoryProposer(fmt.Sprint(i), log.NewNopLogger(), acceptors...))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const (
+		allocators = 12
+		perWorker  = 200
+	)
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		seen = map[uint64]int{}
+		errs = make(chan error, allocators)
+	)
+	for i := 0; i < allocators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a := NewAllocator(proposers[i%len(proposers)], "ids", 7)
+			var prev uint64
+			for j := 0; j < perWorker; j++ {
+				id, err := a.Next(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if j > 0 && id <= prev {
+					errs <- fmt.Errorf("allocator %d: %d after %d", i, id, prev)
+					return
+				}
+				prev = id
+				mtx.Lock()
+				seen[id]++
+				mtx.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if want, have := allocators*perWorker, len(seen); want != have {
+		t.Errorf("distinct IDs: want %d, have %d", want, have)
+	}
+	for id, n := range seen {
+		if n > 1 {
+			t.Errorf("ID %d allocated %d times", id, n)
+		}
+	}
+}
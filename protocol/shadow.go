@@ -0,0 +1,260 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// ShadowOptions control a ShadowAcceptor. The zero value is useful.
+type ShadowOptions struct {
+	// QueueSize is how many requests may wait to be sent to the shadow.
+	// Requests which don't fit are dropped, so the shadow never slows down
+	// the primary. The default is 1024.
+	QueueSize int
+
+	// KeyFraction is the fraction of keys whose requests are shadowed, chosen
+	// by hash, so every request for a chosen key is shadowed, and the shadow's
+	// state for it stays comparable. The default is every key.
+	KeyFraction float64
+
+	// Timeout limits each request to the shadow. The default is one second.
+	Timeout time.Duration
+
+	// Samples is how many of the most recent mismatches are kept. The default
+	// is 100.
+	Samples int
+}
+
+// ShadowMismatch is a request to which the shadow responded differently from
+// the primary.
+type ShadowMismatch struct {
+	Key      string // empty for RejectByAge
+	Method   string
+	Expected string // the primary's response
+	Got      string // the shadow's response
+	At       time.Time
+}
+
+// ShadowStats describes the requests a ShadowAcceptor has shadowed.
+type ShadowStats struct {
+	Matched    uint64
+	Mismatched uint64
+	Failed     uint64 // requests which either side couldn't answer
+	Dropped    uint64 // requests which didn't fit in the queue
+	Recent     []ShadowMismatch
+}
+
+// ShadowAcceptor is an Acceptor which passes every request to a primary
+// acceptor, and returns its response, as if it weren't there. But requests
+// which change state, and their responses, are also queued, and replayed to a
+// shadow acceptor, e.g. a new implementation, and their responses compared.
+// Only the primary's response counts, and only the primary's time is spent by
+// the caller, so the shadow can be slow, wrong, or down, without any effect on
+// the rounds.
+//
+// Requests are replayed in the order in which the primary responded. Requests
+// for the same key that are concurrent may be applied by the primary in a
+// different order, and be reported as mismatches; a real difference usually
+// persists in the mismatches that follow. A dropped request leaves the shadow
+// behind on its key, so later mismatches for the key may be spurious, too.
+// Rejections are compared in full, as they describe the acceptor's state.
+//
+// Run must be called to replay requests to the shadow.
+type ShadowAcceptor struct {
+	primary Acceptor
+	shadow  Acceptor
+	options ShadowOptions
+	queue   chan shadowRequest
+
+	mtx   sync.Mutex
+	stats ShadowStats
+}
+
+type shadowRequest struct {
+	key      string
+	method   string
+	expected string
+	replay   func(ctx context.Context) string
+}
+
+// NewShadowAcceptor returns an acceptor which shadows primary with shadow.
+func NewShadowAcceptor(primary, shadow Acceptor, options ShadowOptions) *ShadowAcceptor {
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	if options.KeyFraction <= 0 || options.KeyFraction > 1 {
+		options.KeyFraction = 1
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = time.Second
+	}
+	if options.Samples <= 0 {
+		options.Samples = 100
+	}
+	return &ShadowAcceptor{
+		primary: primary,
+		shadow:  shadow,
+		options: options,
+		queue:   make(chan shadowRequest, options.QueueSize),
+	}
+}
+
+// Run replays queued requests to the shadow, one at a time, until the context
+// is canceled.
+func (a *ShadowAcceptor) Run(ctx context.Context) {
+	for {
+		select {
+		case r := <-a.queue:
+			a.replay(ctx, r)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stats returns the outcomes of the shadowed requests so far.
+func (a *ShadowAcceptor) Stats() ShadowStats {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	s := a.stats
+	s.Recent = append([]ShadowMismatch{}, a.stats.Recent...)
+	return s
+}
+
+// Address implements Addresser. It's the primary's.
+func (a *ShadowAcceptor) Address() string {
+	return a.primary.Address()
+}
+
+// NodeID implements Identifier. It's the primary's.
+func (a *ShadowAcceptor) NodeID(ctx context.Context) (string, error) {
+	return a.primary.NodeID(ctx)
+}
+
+// Ping implements Pinger. Only the primary is pinged.
+func (a *ShadowAcceptor) Ping(ctx context.Context) (PingInfo, error) {
+	return a.primary.Ping(ctx)
+}
+
+// Role implements RoleReporter. It's the primary's.
+func (a *ShadowAcceptor) Role() Role {
+	return roleOf(a.primary)
+}
+
+// Prepare implements Preparer.
+func (a *ShadowAcceptor) Prepare(ctx context.Context, key string, age Age, epoch uint64, b Ballot) (value []byte, sum Checksum, meta Metadata, current Ballot, err error) {
+	value, sum, meta, current, err = a.primary.Prepare(ctx, key, age, epoch, b)
+	a.enqueue(key, "Prepare", describePrepare(value, sum, meta, current, err), func(ctx context.Context) string {
+		return describePrepare(a.shadow.Prepare(ctx, key, age, epoch, b))
+	})
+	return value, sum, meta, current, err
+}
+
+// Abandon implements Preparer.
+func (a *ShadowAcceptor) Abandon(ctx context.Context, key string, b Ballot) error {
+	err := a.primary.Abandon(ctx, key, b)
+	a.enqueue(key, "Abandon", describeOutcome(err), func(ctx context.Context) string {
+		return describeOutcome(a.shadow.Abandon(ctx, key, b))
+	})
+	return err
+}
+
+// Accept implements Accepter.
+func (a *ShadowAcceptor) Accept(ctx context.Context, key string, age Age, b Ballot, value []byte, sum Checksum, meta Metadata) error {
+	err := a.primary.Accept(ctx, key, age, b, value, sum, meta)
+	value, meta = copyValue(value), meta.copy()
+	a.enqueue(key, "Accept", describeOutcome(err), func(ctx context.Context) string {
+		return describeOutcome(a.shadow.Accept(ctx, key, age, b, value, sum, meta))
+	})
+	return err
+}
+
+// RejectByAge implements GarbageCollecter. It's shadowed whatever the key
+// fraction.
+func (a *ShadowAcceptor) RejectByAge(ctx context.Context, ages []Age) error {
+	err := a.primary.RejectByAge(ctx, ages)
+	ages = append([]Age{}, ages...)
+	a.enqueue("", "RejectByAge", describeOutcome(err), func(ctx context.Context) string {
+		return describeOutcome(a.shadow.RejectByAge(ctx, ages))
+	})
+	return err
+}
+
+// RemoveIfEqual implements GarbageCollecter.
+func (a *ShadowAcceptor) RemoveIfEqual(ctx context.Context, key string, state []byte) error {
+	err := a.primary.RemoveIfEqual(ctx, key, state)
+	state = copyValue(state)
+	a.enqueue(key, "RemoveIfEqual", describeOutcome(err), func(ctx context.Context) string {
+		return describeOutcome(a.shadow.RemoveIfEqual(ctx, key, state))
+	})
+	return err
+}
+
+// enqueue queues a request for the shadow, if its key is shadowed, and it
+// fits. A request the primary couldn't answer isn't comparable, but it's
+// still replayed, as it may have changed the primary's state.
+func (a *ShadowAcceptor) enqueue(key, method, expected string, replay func(context.Context) string) {
+	if key != "" && a.options.KeyFraction < 1 && keyFraction(key) >= a.options.KeyFraction {
+		return
+	}
+	select {
+	case a.queue <- shadowRequest{key: key, method: method, expected: expected, replay: replay}:
+	default:
+		a.mtx.Lock()
+		a.stats.Dropped++
+		a.mtx.Unlock()
+	}
+}
+
+func (a *ShadowAcceptor) replay(ctx context.Context, r shadowRequest) {
+	ctx, cancel := context.WithTimeout(ctx, a.options.Timeout)
+	defer cancel()
+	got := r.replay(ctx)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	switch {
+	case r.expected == unanswered || got == unanswered:
+		a.stats.Failed++
+	case r.expected == got:
+		a.stats.Matched++
+	default:
+		a.stats.Mismatched++
+		if len(a.stats.Recent) >= a.options.Samples {
+			a.stats.Recent = a.stats.Recent[1:]
+		}
+		a.stats.Recent = append(a.stats.Recent, ShadowMismatch{Key: r.key, Method: r.method, Expected: r.expected, Got: got, At: time.Now()})
+	}
+}
+
+// unanswered describes a request which failed without a rejection, e.g. on
+// the network, so says nothing about the acceptor's state.
+const unanswered = "unanswered"
+
+func describeOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case isRejection(err):
+		return err.Error()
+	default:
+		return unanswered
+	}
+}
+
+func describePrepare(value []byte, sum Checksum, meta Metadata, current Ballot, err error) string {
+	if err != nil {
+		return describeOutcome(err)
+	}
+	return fmt.Sprintf("value=%q sum=%d meta=%v current=%s", value, sum, meta, current)
+}
+
+// keyFraction maps key to [0, 1), uniformly.
+func keyFraction(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
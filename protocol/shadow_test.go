@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestShadowAcceptor(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		good   = NewShadowAcceptor(a1, NewMemoryAcceptor("1s", log.With(logger, "a", "1s")), ShadowOptions{})
+		wrong  = NewMemoryAcceptor("2s", log.With(logger, "a", "2s"))
+		bad    = NewShadowAcceptor(a2, wrong, ShadowOptions{})
+		b3s    = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3s", log.With(logger, "a", "3s"))}
+		down   = NewShadowAcceptor(a3, b3s, ShadowOptions{})
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), good, bad, down)
+		ctx    = context.Background()
+	)
+
+	// One shadow has a value the primary never had; another is down.
+	if err := wrong.Accept(ctx, "k", Age{}, Ballot{Counter: 1, ID: "x"}, []byte("wrong"), checksumOf([]byte("wrong")), nil); err != nil {
+		t.Fatal(err)
+	}
+	b3s.setBroken(true)
+
+	for i := 0; i < 5; i++ {
+		value := []byte(strconv.Itoa(i))
+		if _, _, err := p.Propose(ctx, "k", func([]byte) []byte { return value }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, a := range []*ShadowAcceptor{good, bad, down} {
+		drain(ctx, a)
+	}
+
+	if s := good.Stats(); s.Matched == 0 || s.Mismatched != 0 || s.Failed != 0 || s.Dropped != 0 {
+		t.Errorf("identical shadow: want only matches, have %+v", s)
+	}
+	s := bad.Stats()
+	if s.Mismatched == 0 || len(s.Recent) == 0 {
+		t.Fatalf("wrong shadow: want mismatches, have %+v", s)
+	}
+	if m := s.Recent[0]; m.Key != "k" || m.Method == "" || m.Expected == m.Got {
+		t.Errorf("wrong shadow: bad mismatch %+v", m)
+	}
+	if s := down.Stats(); s.Failed == 0 || s.Mismatched != 0 {
+		t.Errorf("shadow down: want failures, not mismatches, have %+v", s)
+	}
+}
+
+func TestShadowAcceptorQueue(t *testing.T) {
+	var (
+		logger  = log.NewLogfmtLogger(newTestWriter(t))
+		ctx     = context.Background()
+		primary = NewMemoryAcceptor("1", logger)
+		shadow  = NewMemoryAcceptor("1s", logger)
+	)
+
+	// Without Run, the queue fills up, and the rest are dropped.
+	full := NewShadowAcceptor(primary, shadow, ShadowOptions{QueueSize: 2})
+	for i := uint64(1); i <= 5; i++ {
+		full.Prepare(ctx, "k", Age{}, 0, Ballot{Counter: i, ID: "p"})
+	}
+	if want, have := uint64(3), full.Stats().Dropped; want != have {
+		t.Errorf("dropped: want %d, have %d", want, have)
+	}
+
+	// Keys are chosen by hash.
+	var in, out string
+	for i := 0; in == "" || out == ""; i++ {
+		if key := strconv.Itoa(i); keyFraction(key) < 0.5 {
+			in = key
+		} else {
+			out = key
+		}
+	}
+	half := NewShadowAcceptor(primary, shadow, ShadowOptions{KeyFraction: 0.5})
+	half.Prepare(ctx, out, Age{}, 0, Ballot{Counter: 1, ID: "p"})
+	if want, have := 0, len(half.queue); want != have {
+		t.Errorf("unchosen key: want %d queued, have %d", want, have)
+	}
+	half.Prepare(ctx, in, Age{}, 0, Ballot{Counter: 1, ID: "p"})
+	if want, have := 1, len(half.queue); want != have {
+		t.Errorf("chosen key: want %d queued, have %d", want, have)
+	}
+}
+
+// drain replays every queued request.
+func drain(ctx context.Context, a *ShadowAcceptor) {
+	for len(a.queue) > 0 {
+		a.replay(ctx, <-a.queue)
+	}
+}
@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotVersion is the version of the format written by ExportConfiguration.
+const snapshotVersion = 1
+
+var (
+	// ErrSnapshotChecksum indicates a configuration snapshot which doesn't
+	// match its checksum, because it was damaged, truncated, or edited by hand.
+	ErrSnapshotChecksum = errors.New("configuration snapshot doesn't match its checksum")
+
+	// ErrConfigurationExists indicates an import onto a proposer which already
+	// has a different configuration, without force.
+	ErrConfigurationExists = errors.New("proposer already has a different configuration")
+)
+
+// ExportConfiguration returns a snapshot of the proposer's configuration, as a
+// single JSON document, for rebuilding proposers which have all been lost. It
+// has the fields of a Configuration, a version, and a checksum. Fields of a
+// snapshot imported from a newer version, which this one doesn't understand,
+// are exported again unchanged.
+//
+// Everything else a fleet of proposers relies on, like the routing and freeze
+// tables, is stored on the acceptors, so it survives the loss of the proposers,
+// and isn't part of the snapshot.
+func (p *MemoryProposer) ExportConfiguration() ([]byte, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return encodeSnapshot(p.configuration(), p.snapshotExtra)
+}
+
+// ImportConfiguration changes the proposer's configuration to match the
+// snapshot, using dial to connect to new acceptors, as ReloadConfiguration
+// does for a file. It's idempotent: importing a snapshot of the configuration
+// the proposer already has changes nothing. If the proposer has a different
+// configuration, with any accepters, the import is refused with
+// ErrConfigurationExists, unless force is set, since the snapshot could be
+// older than the configuration it would replace.
+//
+// As with any cluster change, it's up to the operator to import the same
+// snapshot into every proposer.
+func (p *MemoryProposer) ImportConfiguration(snapshot []byte, dial Dialer, force bool) error {
+	c, extra, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	current := p.configuration()
+	if reflect.DeepEqual(current, c) {
+		p.snapshotExtra = extra
+		return nil
+	}
+	if len(current.Accepters) > 0 && !force {
+		return ErrConfigurationExists
+	}
+	if err := p.adopt(c, dial); err != nil {
+		return err
+	}
+	p.snapshotExtra = extra
+	p.configurationChanged()
+	return nil
+}
+
+// snapshotFields are the fields of a snapshot which this version understands.
+var snapshotFields = []string{"version", "preparers", "accepters", "weights", "checksum"}
+
+// encodeSnapshot writes c, and the extra fields, with a checksum over all of
+// them. The checksum is taken over the compact encoding of every other field,
+// sorted by name, so it doesn't depend on formatting.
+func encodeSnapshot(c Configuration, extra map[string]json.RawMessage) ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(extra)+len(snapshotFields))
+	for name, value := range extra {
+		fields[name] = value
+	}
+	known := map[string]interface{}{
+		"version":   snapshotVersion,
+		"preparers": c.Preparers,
+		"accepters": c.Accepters,
+	}
+	if len(c.Weights) > 0 {
+		known["weights"] = c.Weights
+	}
+	for name, value := range known {
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = buf
+	}
+
+	sum, err := snapshotChecksum(fields)
+	if err != nil {
+		return nil, err
+	}
+	fields["checksum"], _ = json.Marshal(sum) // can't fail
+	return json.MarshalIndent(fields, "", "  ")
+}
+
+// decodeSnapshot verifies and parses a snapshot, returning its configuration,
+// and the fields it doesn't understand.
+func decodeSnapshot(snapshot []byte) (c Configuration, extra map[string]json.RawMessage, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		return c, nil, errors.Wrap(err, "error parsing configuration snapshot")
+	}
+	var want Checksum
+	if err := json.Unmarshal(fields["checksum"], &want); err != nil {
+		return c, nil, ErrSnapshotChecksum
+	}
+	delete(fields, "checksum")
+	if have, err := snapshotChecksum(fields); err != nil || have != want {
+		return c, nil, ErrSnapshotChecksum
+	}
+
+	var version int
+	if err := json.Unmarshal(fields["version"], &version); err != nil || version < 1 {
+		return c, nil, errors.New("configuration snapshot has no version")
+	}
+	if err := json.Unmarshal(snapshot, &c); err != nil {
+		return c, nil, errors.Wrap(err, "error parsing configuration snapshot")
+	}
+	if c.Preparers == nil {
+		c.Preparers = []string{}
+	}
+	if c.Accepters == nil {
+		c.Accepters = []string{}
+	}
+	if len(c.Weights) == 0 {
+		c.Weights = nil
+	}
+	sort.Strings(c.Preparers)
+	sort.Strings(c.Accepters)
+
+	for _, name := range snapshotFields {
+		delete(fields, name)
+	}
+	if len(fields) > 0 {
+		extra = fields
+	}
+	return c, extra, nil
+}
+
+// snapshotChecksum returns the checksum of the fields, which mustn't include
+// the checksum itself.
+func snapshotChecksum(fields map[string]json.RawMessage) (Checksum, error) {
+	buf, err := json.Marshal(fields) // sorted, and compacted
+	if err != nil {
+		return 0, err
+	}
+	return checksumOf(buf), nil
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestConfigurationSnapshot(t *testing.T) {
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		a4        = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+		acceptors = map[string]Acceptor{"1": a1, "2": a2, "3": a3, "4": a4}
+		dial      = func(addr string) (Acceptor, error) {
+			if a, ok := acceptors[addr]; ok {
+				return a, nil
+			}
+			return nil, errors.New("no route to host")
+		}
+	)
+
+	p1 := NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2)
+	if err := p1.AddWeightedAccepter(a3, 2); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := p1.ExportConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new proposer picks it up, and exports the same snapshot.
+	p2 := NewMemoryProposer("2", log.With(logger, "p", 2))
+	var changes int
+	p2.OnConfigurationChange(func(Configuration, Configuration) { changes++ })
+	if err := p2.ImportConfiguration(snapshot, dial, false); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := p1.Configuration(), p2.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Errorf("after import: want %+v, have %+v", want, have)
+	}
+	if again, err := p2.ExportConfiguration(); err != nil || !bytes.Equal(snapshot, again) {
+		t.Errorf("export after import: want\n%s\nhave\n%s (%v)", snapshot, again, err)
+	}
+
+	// Importing it again is a no-op.
+	if err := p2.ImportConfiguration(snapshot, dial, false); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, changes; want != have {
+		t.Errorf("configuration changes: want %d, have %d", want, have)
+	}
+
+	// A proposer with a different configuration refuses it, unless forced.
+	p3 := NewMemoryProposer("3", log.With(logger, "p", 3), a4)
+	if want, have := ErrConfigurationExists, p3.ImportConfiguration(snapshot, dial, false); want != have {
+		t.Errorf("existing configuration: want %v, have %v", want, have)
+	}
+	if err := p3.ImportConfiguration(snapshot, dial, true); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := p1.Configuration(), p3.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Errorf("after forced import: want %+v, have %+v", want, have)
+	}
+
+	// A damaged snapshot is refused.
+	damaged := bytes.Replace(snapshot, []byte(`"3"`), []byte(`"4"`), -1)
+	if want, have := ErrSnapshotChecksum, p2.ImportConfiguration(damaged, dial, true); want != have {
+		t.Errorf("damaged: want %v, have %v", want, have)
+	}
+
+	// So is an invalid configuration, even with a valid checksum.
+	invalid := resign(t, snapshot, func(fields map[string]json.RawMessage) {
+		fields["preparers"] = json.RawMessage(`["1", "2", "5"]`)
+	})
+	if want, have := ErrInvalidConfiguration, p2.ImportConfiguration(invalid, dial, true); want != have {
+		t.Errorf("invalid: want %v, have %v", want, have)
+	}
+}
+
+func TestConfigurationSnapshotFutureFields(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		dial   = func(addr string) (Acceptor, error) { return a1, nil }
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1)
+	)
+	snapshot, err := p1.ExportConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot from a newer version, with fields we don't know.
+	future := resign(t, snapshot, func(fields map[string]json.RawMessage) {
+		fields["version"] = json.RawMessage(`2`)
+		fields["webhooks"] = json.RawMessage(`[{"url": "http://example.com/hook"}]`)
+	})
+	p2 := NewMemoryProposer("2", log.With(logger, "p", 2))
+	if err := p2.ImportConfiguration(future, dial, false); err != nil {
+		t.Fatal(err)
+	}
+	exported, err := p2.ExportConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(exported, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := `[{"url":"http://example.com/hook"}]`, compact(t, fields["webhooks"]); want != have {
+		t.Errorf("unknown field: want %s, have %s", want, have)
+	}
+	if err := p1.ImportConfiguration(exported, dial, false); err != nil {
+		t.Errorf("re-import: %v", err)
+	}
+}
+
+// resign changes the fields of a snapshot, and updates its checksum.
+func resign(t *testing.T, snapshot []byte, change func(map[string]json.RawMessage)) []byte {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		t.Fatal(err)
+	}
+	delete(fields, "checksum")
+	change(fields)
+	sum, err := snapshotChecksum(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields["checksum"], _ = json.Marshal(sum)
+	buf, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func compact(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
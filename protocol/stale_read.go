@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"context"
+	"time"
+)
+
+// StaleReadOptions are the freshness criteria for ReadStale.
+type StaleReadOptions struct {
+	// MaxStaleness is how long ago the value may have been accepted.
+	MaxStaleness time.Duration
+
+	// MinAgreeing is how many acceptors must have accepted the same ballot.
+	// The default is a majority of the acceptors queried.
+	MinAgreeing int
+}
+
+// ReadStale reads key directly from the acceptors, without a proposer round.
+// It picks the value with the highest accepted ballot, and returns it if
+// enough acceptors agree on that ballot, and the most recent of their accepts
+// is no older than the max staleness. Otherwise, it falls back to a regular
+// read via the proposer. The returned bool is true if the value was read
+// directly from the acceptors. Witnesses have no values, so they're ignored.
+//
+// ReadStale is NOT linearizable. An acceptor's accepted value may have been
+// superseded by a write that hasn't reached it yet, or it may be the value of
+// a round that never completed. It's meant for things like dashboards, which
+// tolerate stale data, and would rather not hit a proposer. Note that a key
+// which hasn't been written recently always falls back, as there's no way to
+// tell from its acceptors that it's still current.
+func ReadStale(ctx context.Context, key string, acceptors []Inspecter, fallback Proposer, options StaleReadOptions) (state []byte, direct bool, err error) {
+	minAgreeing := options.MinAgreeing
+	if minAgreeing <= 0 {
+		minAgreeing = len(acceptors)/2 + 1
+	}
+
+	results := make(chan Inspection, len(acceptors))
+	for _, acceptor := range acceptors {
+		go func(acceptor Inspecter) {
+			inspection, err := acceptor.Inspect(ctx, key)
+			if err != nil || roleOf(acceptor) == RoleWitness {
+				inspection = Inspection{} // don't count it
+			}
+			results <- inspection
+		}(acceptor)
+	}
+
+	var (
+		best     Inspection
+		agreeing int
+		latest   time.Time
+	)
+	for i := 0; i < cap(results); i++ {
+		inspection := <-results
+		if inspection.Accepted.isZero() {
+			continue
+		}
+		switch {
+		case inspection.Accepted.greaterThan(best.Accepted):
+			best, agreeing, latest = inspection, 1, inspection.AcceptedAt
+		case inspection.Accepted == best.Accepted:
+			agreeing++
+			if inspection.AcceptedAt.After(latest) {
+				latest = inspection.AcceptedAt
+			}
+		}
+	}
+
+	if agreeing >= minAgreeing && time.Since(latest) <= options.MaxStaleness {
+		return best.Value, true, nil
+	}
+
+	identity := func(x []byte) []byte { return x }
+	state, _, err = fallback.Propose(ctx, key, identity)
+	return state, false, err
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReadStale(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2)
+		ctx    = context.Background()
+		all    = []Inspecter{a1, a2, a3}
+	)
+
+	// Every acceptor has k; only a1 and a2 have j.
+	if _, _, err := p1.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p1.FullIdentityRead(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p2.Propose(ctx, "j", changeFuncInitializeOnlyOnce("w")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testcase := range []struct {
+		name    string
+		key     string
+		options StaleReadOptions
+		sleep   time.Duration
+		want    string
+		direct  bool
+	}{
+		{"fresh", "k", StaleReadOptions{MaxStaleness: time.Minute}, 0, "v", true},
+		{"majority", "j", StaleReadOptions{MaxStaleness: time.Minute}, 0, "w", true},
+		{"too few agree", "j", StaleReadOptions{MaxStaleness: time.Minute, MinAgreeing: 3}, 0, "w", false},
+		{"too stale", "k", StaleReadOptions{MaxStaleness: time.Millisecond}, 5 * time.Millisecond, "v", false},
+		{"missing", "x", StaleReadOptions{MaxStaleness: time.Minute}, 0, "", false},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			time.Sleep(testcase.sleep)
+			state, direct, err := ReadStale(ctx, testcase.key, all, p1, testcase.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := testcase.want, string(state); want != have {
+				t.Errorf("state: want %q, have %q", want, have)
+			}
+			if want, have := testcase.direct, direct; want != have {
+				t.Errorf("direct: want %v, have %v", want, have)
+			}
+		})
+	}
+}
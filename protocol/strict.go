@@ -0,0 +1,158 @@
+package protocol
+
+import "fmt"
+
+// ConfigurationStep is one call in a cluster change.
+type ConfigurationStep string
+
+const (
+	// StepAddAccepter is AddAccepter, or AddWeightedAccepter.
+	StepAddAccepter ConfigurationStep = "add accepter"
+
+	// StepAddPreparer is AddPreparer.
+	StepAddPreparer ConfigurationStep = "add preparer"
+
+	// StepRemovePreparer is RemovePreparer.
+	StepRemovePreparer ConfigurationStep = "remove preparer"
+
+	// StepRemoveAccepter is RemoveAccepter.
+	StepRemoveAccepter ConfigurationStep = "remove accepter"
+)
+
+// UnsafeConfigurationError is returned by proposers in strict mode for a
+// configuration change made out of order. Reason explains the required order.
+type UnsafeConfigurationError struct {
+	Step   ConfigurationStep
+	Target string // address
+	Reason string
+}
+
+func (uce UnsafeConfigurationError) Error() string {
+	return fmt.Sprintf("unsafe configuration change: can't %s %s: %s", uce.Step, uce.Target, uce.Reason)
+}
+
+// WithStrictConfiguration makes the proposer refuse cluster changes made out
+// of the order that keeps them safe, with an UnsafeConfigurationError. Growing
+// the cluster, a target is added as an accepter, then a sweep is confirmed,
+// and only then is it added as a preparer. Shrinking the cluster, it's the same
+// in reverse: it's removed as a preparer, then a sweep is confirmed, and only
+// then is it removed as an accepter. GrowCluster and ShrinkCluster confirm the
+// sweep after their identity reads; operators changing the configuration by
+// hand must call ConfirmSweep. Reverting a step that was just taken is always
+// allowed, so the undo of a failed change works. Whole configurations, from a
+// reload, a snapshot import, or reconciliation, are checked as if each of
+// their differences were a step, unless the proposer has no accepters yet. By
+// default, strict mode is off, and the order is the operator's responsibility.
+func WithStrictConfiguration() MemoryProposerOption {
+	return func(p *MemoryProposer) { p.strict = true }
+}
+
+// lifecycle is the state of an acceptor which is part of the way through a
+// cluster change. Acceptors which aren't are either members in both roles, or
+// not members at all, and aren't tracked.
+type lifecycle int
+
+const (
+	joining lifecycle = iota // accepter, not yet swept
+	joined                   // accepter, swept, so it can become a preparer
+	leaving                  // no longer a preparer, not yet swept
+	left                     // no longer a preparer, swept, so it can go
+)
+
+// ConfirmSweep records that the identity read required between the steps of a
+// cluster change has completed, for every acceptor part of the way through
+// one.
+func (p *MemoryProposer) ConfirmSweep() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for addr, state := range p.lifecycle {
+		switch state {
+		case joining:
+			p.lifecycle[addr] = joined
+		case leaving:
+			p.lifecycle[addr] = left
+		}
+	}
+}
+
+// sweepConfirmer is implemented by proposers which track the steps of cluster
+// changes, like MemoryProposer.
+type sweepConfirmer interface {
+	ConfirmSweep()
+}
+
+// confirmSweep tells the proposers which care that the sweep is done.
+func confirmSweep(proposers []Proposer) {
+	for _, proposer := range proposers {
+		if c, ok := proposer.(sweepConfirmer); ok {
+			c.ConfirmSweep()
+		}
+	}
+}
+
+// CheckConfigurationStep reports whether the step for the acceptor at addr
+// would be allowed in strict mode, without taking it, e.g. to validate a
+// planned change. It works whether or not strict mode is enabled.
+func (p *MemoryProposer) CheckConfigurationStep(step ConfigurationStep, addr string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.checkStep(step, addr)
+}
+
+// checkStep implements CheckConfigurationStep. It must be called with the
+// mutex held.
+func (p *MemoryProposer) checkStep(step ConfigurationStep, addr string) error {
+	if err := p.checkOrder(step, addr); err != nil {
+		return err
+	}
+	state, ok := p.lifecycle[addr]
+	switch {
+	case step == StepAddPreparer && ok && state == joining:
+		return UnsafeConfigurationError{Step: step, Target: addr, Reason: "a sweep must be confirmed after adding it as an accepter"}
+	case step == StepRemoveAccepter && ok && state == leaving:
+		return UnsafeConfigurationError{Step: step, Target: addr, Reason: "a sweep must be confirmed after removing it as a preparer"}
+	}
+	return nil
+}
+
+// checkOrder checks that the acceptor at addr has the roles the step needs,
+// whether or not the sweeps in between were confirmed. It must be called with
+// the mutex held.
+func (p *MemoryProposer) checkOrder(step ConfigurationStep, addr string) error {
+	var (
+		_, accepter = p.accepters[addr]
+		_, preparer = p.preparers[addr]
+	)
+	switch {
+	case step == StepAddPreparer && !accepter:
+		return UnsafeConfigurationError{Step: step, Target: addr, Reason: "it must be added as an accepter first"}
+	case step == StepRemoveAccepter && preparer:
+		return UnsafeConfigurationError{Step: step, Target: addr, Reason: "it must be removed as a preparer first"}
+	}
+	return nil
+}
+
+// strictStep checks the step, if strict mode is enabled. It must be called
+// with the mutex held.
+func (p *MemoryProposer) strictStep(step ConfigurationStep, addr string) error {
+	if !p.strict {
+		return nil
+	}
+	return p.checkStep(step, addr)
+}
+
+// advanceLifecycle records a step that was taken. It must be called with the
+// mutex held.
+func (p *MemoryProposer) advanceLifecycle(step ConfigurationStep, addr string) {
+	if p.lifecycle == nil {
+		p.lifecycle = map[string]lifecycle{}
+	}
+	switch step {
+	case StepAddAccepter:
+		p.lifecycle[addr] = joining
+	case StepRemovePreparer:
+		p.lifecycle[addr] = leaving
+	case StepAddPreparer, StepRemoveAccepter:
+		delete(p.lifecycle, addr)
+	}
+}
@@ -0,0 +1,188 @@
+package protocol
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestStrictConfiguration(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		a4     = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+		p      = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a1, a2, a3}, WithStrictConfiguration())
+	)
+
+	unsafe := func(step ConfigurationStep, err error) {
+		t.Helper()
+		if uce, ok := err.(UnsafeConfigurationError); !ok || uce.Step != step || uce.Target != "4" {
+			t.Errorf("%s: want UnsafeConfigurationError, have %v", step, err)
+		}
+	}
+
+	// Growing.
+	unsafe(StepAddPreparer, p.AddPreparer(a4))
+	if err := p.AddAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepAddPreparer, p.AddPreparer(a4))
+	unsafe(StepAddPreparer, p.CheckConfigurationStep(StepAddPreparer, "4"))
+	p.ConfirmSweep()
+	if err := p.CheckConfigurationStep(StepAddPreparer, "4"); err != nil {
+		t.Errorf("check after sweep: %v", err)
+	}
+	if err := p.AddPreparer(a4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shrinking.
+	unsafe(StepRemoveAccepter, p.RemoveAccepter(a4))
+	if err := p.RemovePreparer(a4); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepRemoveAccepter, p.RemoveAccepter(a4))
+	p.ConfirmSweep()
+	if err := p.RemoveAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undoing a step is fine.
+	if err := p.AddAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveAccepter(a4); err != nil {
+		t.Errorf("undo add accepter: %v", err)
+	}
+	if err := p.RemovePreparer(a3); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(a3); err != nil {
+		t.Errorf("undo remove preparer: %v", err)
+	}
+
+	// Without strict mode, anything goes, but the check still works.
+	lax := NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+	unsafe(StepAddPreparer, lax.CheckConfigurationStep(StepAddPreparer, "4"))
+	if err := lax.AddPreparer(a4); err != nil {
+		t.Errorf("lax: %v", err)
+	}
+}
+
+func TestStrictClusterChange(t *testing.T) {
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		a4        = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+		initial   = []Acceptor{a1, a2, a3}
+		p1        = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithStrictConfiguration())
+		p2        = NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), initial, WithStrictConfiguration())
+		proposers = []Proposer{p1, p2}
+		ctx       = context.Background()
+	)
+
+	// The cluster changes confirm their own sweeps, on every proposer.
+	if err := GrowCluster(ctx, a4, proposers); err != nil {
+		t.Fatal(err)
+	}
+	if err := ShrinkCluster(ctx, a4, proposers); err != nil {
+		t.Fatal(err)
+	}
+	if err := ShrinkCluster(ctx, a3, proposers); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*MemoryProposer{p1, p2} {
+		if want, have := 2, len(p.Configuration().Preparers); want != have {
+			t.Errorf("proposer %s: want %d preparers, have %d", p.Age().ID, want, have)
+		}
+	}
+}
+
+func TestStrictConfigurationReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "caspaxos-strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		path      = filepath.Join(dir, "config.json")
+		a1        = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2        = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3        = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		a4        = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+		initial   = []Acceptor{a1, a2, a3}
+		acceptors = map[string]Acceptor{"1": a1, "2": a2, "3": a3, "4": a4}
+		dial      = func(addr string) (Acceptor, error) { return acceptors[addr], nil }
+		p         = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithStrictConfiguration(), WithConfigurationFile(path))
+		c1234     = Configuration{Preparers: []string{"1", "2", "3", "4"}, Accepters: []string{"1", "2", "3", "4"}}
+	)
+
+	unsafe := func(step ConfigurationStep, target string, err error) {
+		t.Helper()
+		if uce, ok := err.(UnsafeConfigurationError); !ok || uce.Step != step || uce.Target != target {
+			t.Errorf("%s %s: want UnsafeConfigurationError, have %v", step, target, err)
+		}
+	}
+
+	// Reloading an unchanged file doesn't forget that 4 is joining.
+	if err := p.AddAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReloadConfiguration(dial); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepAddPreparer, "4", p.AddPreparer(a4))
+
+	// Nor can the file make it a preparer before the sweep.
+	if err := writeConfiguration(path, c1234); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepAddPreparer, "4", p.ReloadConfiguration(dial))
+	p.ConfirmSweep()
+	if err := p.ReloadConfiguration(dial); err != nil {
+		t.Errorf("reload after sweep: %v", err)
+	}
+
+	// Importing a snapshot keeps joining acceptors joining, and makes removed
+	// preparers leaving.
+	var (
+		q = NewMemoryProposerWithOptions("2", log.With(logger, "p", 2), initial, WithStrictConfiguration())
+		r = NewMemoryProposer("3", log.With(logger, "p", 3), initial...)
+	)
+	if err := q.AddAccepter(a4); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{r.AddAccepter(a4), r.RemovePreparer(a3)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot, err := r.ExportConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ImportConfiguration(snapshot, dial, true); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepAddPreparer, "4", q.AddPreparer(a4))
+	unsafe(StepRemoveAccepter, "3", q.RemoveAccepter(a3))
+
+	// And a snapshot can't skip a sweep either.
+	if err := r.AddPreparer(a4); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err = r.ExportConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+	unsafe(StepAddPreparer, "4", q.ImportConfiguration(snapshot, dial, true))
+}
@@ -0,0 +1,187 @@
+package protocol
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary is a pre-aggregated report of a proposer's health and recent
+// activity, for status pages and dashboards that would rather not assemble it
+// from raw metrics.
+type Summary struct {
+	Preparers      int           `json:"preparers"`
+	Accepters      int           `json:"accepters"`
+	Health         Health        `json:"health"`
+	OneMinute      WindowSummary `json:"1m"`
+	FiveMinutes    WindowSummary `json:"5m"`
+	FifteenMinutes WindowSummary `json:"15m"`
+}
+
+// WindowSummary describes the proposals completed in a recent window of time.
+// Latency quantiles are approximate, to within about 20%.
+type WindowSummary struct {
+	Proposals    uint64        `json:"proposals"`
+	Failures     uint64        `json:"failures"`
+	Conflicts    uint64        `json:"conflicts"` // failures caused by a competing ballot
+	Rate         float64       `json:"rate"`      // proposals per second
+	ConflictRate float64       `json:"conflict_rate"`
+	P50          time.Duration `json:"p50_ns"`
+	P99          time.Duration `json:"p99_ns"`
+}
+
+// WithSummary makes the proposer keep per-second counts and latencies of its
+// proposals for the last 15 minutes, for Summary. By default, they aren't kept.
+func WithSummary() MemoryProposerOption {
+	return func(p *MemoryProposer) { p.summary = newSummaryTracker() }
+}
+
+// Summary returns the proposer's latest summary. It doesn't wait for the
+// proposer, so it's cheap to call while proposals are in flight: health and
+// membership are as of the end of the last proposal or configuration change.
+// Without WithSummary, it's empty.
+func (p *MemoryProposer) Summary() Summary {
+	if p.summary == nil {
+		return Summary{}
+	}
+	s, _ := p.summary.latest.Load().(Summary)
+	now := time.Now()
+	s.OneMinute = p.summary.window(now, time.Minute)
+	s.FiveMinutes = p.summary.window(now, 5*time.Minute)
+	s.FifteenMinutes = p.summary.window(now, 15*time.Minute)
+	return s
+}
+
+// observeSummary records a proposal that began at begin, and failed with err,
+// if it's not nil, and publishes the proposer's health. It must be called with
+// the mutex held.
+func (p *MemoryProposer) observeSummary(begin time.Time, err *error) {
+	if p.summary == nil {
+		return
+	}
+	_, conflict := ConflictingBallot(*err)
+	p.summary.observe(time.Now(), time.Since(begin), *err != nil, conflict)
+	p.publishSummary()
+}
+
+// publishSummary stores the parts of the summary which need the mutex. It must
+// be called with the mutex held.
+func (p *MemoryProposer) publishSummary() {
+	if p.summary == nil {
+		return
+	}
+	p.summary.latest.Store(Summary{
+		Preparers: len(p.preparers),
+		Accepters: len(p.accepters),
+		Health:    p.currentHealth(),
+	})
+}
+
+// summarySeconds is how many per-second buckets a summaryTracker keeps.
+const summarySeconds = 15 * 60
+
+// latencyBuckets is the number of latency histogram buckets. Bucket i counts
+// latencies up to 2^(i/4) microseconds, which covers more than an hour.
+const latencyBuckets = 128
+
+// summaryTracker keeps a ring of per-second buckets. It has its own mutex,
+// held only briefly, so summaries never wait for a proposal in flight. A nil
+// tracker tracks nothing.
+type summaryTracker struct {
+	latest atomic.Value // Summary, without the windows
+
+	mtx     sync.Mutex
+	buckets [summarySeconds]summaryBucket
+}
+
+type summaryBucket struct {
+	second    int64 // Unix time
+	proposals uint64
+	failures  uint64
+	conflicts uint64
+	latencies [latencyBuckets]uint32
+}
+
+func newSummaryTracker() *summaryTracker {
+	t := &summaryTracker{}
+	t.latest.Store(Summary{})
+	return t
+}
+
+func (t *summaryTracker) observe(now time.Time, d time.Duration, failed, conflict bool) {
+	second := now.Unix()
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	b := &t.buckets[second%summarySeconds]
+	if b.second != second {
+		*b = summaryBucket{second: second}
+	}
+	b.proposals++
+	if failed {
+		b.failures++
+	}
+	if conflict {
+		b.conflicts++
+	}
+	b.latencies[latencyBucket(d)]++
+}
+
+func (t *summaryTracker) window(now time.Time, d time.Duration) WindowSummary {
+	var (
+		ws        WindowSummary
+		latencies [latencyBuckets]uint64
+		seconds   = int64(d / time.Second)
+		newest    = now.Unix()
+	)
+	t.mtx.Lock()
+	for second := newest - seconds + 1; second <= newest; second++ {
+		b := &t.buckets[(second%summarySeconds+summarySeconds)%summarySeconds]
+		if b.second != second {
+			continue
+		}
+		ws.Proposals += b.proposals
+		ws.Failures += b.failures
+		ws.Conflicts += b.conflicts
+		for i, n := range b.latencies {
+			latencies[i] += uint64(n)
+		}
+	}
+	t.mtx.Unlock()
+
+	ws.Rate = float64(ws.Proposals) / d.Seconds()
+	if ws.Proposals > 0 {
+		ws.ConflictRate = float64(ws.Conflicts) / float64(ws.Proposals)
+	}
+	ws.P50 = quantile(latencies[:], ws.Proposals, 0.50)
+	ws.P99 = quantile(latencies[:], ws.Proposals, 0.99)
+	return ws
+}
+
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(4 * math.Log2(us)))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	return i
+}
+
+// quantile returns the upper bound of the bucket containing the q quantile of
+// the n latencies in the histogram.
+func quantile(histogram []uint64, n uint64, q float64) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(n)))
+	var seen uint64
+	for i, count := range histogram {
+		if seen += count; seen >= rank {
+			return time.Duration(math.Pow(2, float64(i)/4) * float64(time.Microsecond))
+		}
+	}
+	return 0
+}
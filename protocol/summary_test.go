@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSummary(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		b1     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("1", log.With(logger, "a", 1))}
+		b2     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("2", log.With(logger, "a", 2))}
+		b3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		p      = NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{b1, b2, b3}, WithSummary())
+		ctx    = context.Background()
+	)
+
+	if want, have := 3, p.Summary().Accepters; want != have {
+		t.Errorf("accepters before any proposal: want %d, have %d", want, have)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, _, err := p.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b2.setBroken(true)
+	b3.setBroken(true)
+	for i := 0; i < 10; i++ {
+		if _, _, err := p.Propose(ctx, "k", changeFuncRead); err == nil {
+			t.Fatal("want failure, have success")
+		}
+	}
+
+	s := p.Summary()
+	if s.Preparers != 3 || s.Accepters != 3 {
+		t.Errorf("membership: want 3 preparers and 3 accepters, have %d and %d", s.Preparers, s.Accepters)
+	}
+	if want, have := AtRisk, s.Health.State; want != have {
+		t.Errorf("health: want %s, have %s", want, have)
+	}
+	for name, ws := range map[string]WindowSummary{"1m": s.OneMinute, "15m": s.FifteenMinutes} {
+		if ws.Proposals != 20 || ws.Failures != 10 || ws.Conflicts != 0 {
+			t.Errorf("%s: want 20 proposals, 10 failures, no conflicts, have %+v", name, ws)
+		}
+		if ws.P50 <= 0 || ws.P99 < ws.P50 {
+			t.Errorf("%s: want sensible latencies, have p50 %s, p99 %s", name, ws.P50, ws.P99)
+		}
+	}
+}
+
+func TestSummaryWindows(t *testing.T) {
+	var (
+		tracker = newSummaryTracker()
+		now     = time.Now()
+	)
+	tracker.observe(now.Add(-10*time.Minute), time.Millisecond, true, true)
+	tracker.observe(now.Add(-2*time.Minute), 10*time.Millisecond, false, false)
+	for i := 0; i < 99; i++ {
+		tracker.observe(now, time.Millisecond, false, false)
+	}
+	tracker.observe(now, time.Second, false, false)
+
+	for _, testcase := range []struct {
+		window    time.Duration
+		proposals uint64
+		conflicts uint64
+	}{
+		{time.Minute, 100, 0},
+		{5 * time.Minute, 101, 0},
+		{15 * time.Minute, 102, 1},
+	} {
+		ws := tracker.window(now, testcase.window)
+		if ws.Proposals != testcase.proposals || ws.Conflicts != testcase.conflicts {
+			t.Errorf("%s: want %d proposals and %d conflicts, have %+v", testcase.window, testcase.proposals, testcase.conflicts, ws)
+		}
+	}
+
+	// Quantiles are the upper bounds of their buckets, within 20%.
+	ws := tracker.window(now, time.Minute)
+	if ws.P50 < time.Millisecond || ws.P50 > 1200*time.Microsecond {
+		t.Errorf("p50: want about 1ms, have %s", ws.P50)
+	}
+	if ws.P99 < time.Millisecond || ws.P99 > 1200*time.Microsecond {
+		t.Errorf("p99: want about 1ms, have %s", ws.P99)
+	}
+	if want, have := float64(100)/60, ws.Rate; want != have {
+		t.Errorf("rate: want %f, have %f", want, have)
+	}
+
+	// Old buckets are reused.
+	later := now.Add(15 * time.Minute)
+	tracker.observe(later, time.Millisecond, false, false)
+	if ws := tracker.window(later, 15*time.Minute); ws.Proposals != 1 {
+		t.Errorf("15m later: want 1 proposal, have %+v", ws)
+	}
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Topology is a graph of proposers and the acceptors they know, for rendering
+// the cluster, e.g. with d3. Nodes are identified by proposer ID or acceptor
+// address, and edges go from proposers to acceptors.
+type Topology struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+// TopologyNode is a proposer or an acceptor.
+type TopologyNode struct {
+	ID     string      `json:"id"`
+	Kind   string      `json:"kind"`             // "proposer" or "acceptor"
+	Role   Role        `json:"role,omitempty"`   // of an acceptor
+	Health HealthState `json:"health,omitempty"` // of a proposer
+}
+
+// TopologyEdge is what a proposer knows about one of its acceptors. The
+// error rate is over the proposer's health window, and is only as fresh as
+// the latest request to the acceptor, at Observed. An edge with no samples
+// hasn't been used yet, and its health is unknown.
+type TopologyEdge struct {
+	Source    string        `json:"source"` // proposer ID
+	Target    string        `json:"target"` // acceptor address
+	Preparer  bool          `json:"preparer"`
+	Accepter  bool          `json:"accepter"`
+	Weight    int           `json:"weight"`
+	Failing   bool          `json:"failing"`
+	ErrorRate float64       `json:"error_rate"`
+	Samples   int           `json:"samples"`
+	Observed  time.Time     `json:"observed"`             // zero if never
+	Latency   time.Duration `json:"latency_ns,omitempty"` // moving average, with WithThrifty
+}
+
+// Topology returns the proposer's view of the cluster: itself, its acceptors,
+// and an edge to each. It's assembled from what the proposer already tracks,
+// and never sends a request, so it's cheap to call often. Use MergeTopologies
+// to combine the views of several proposers.
+func (p *MemoryProposer) Topology() Topology {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var (
+		id        = p.ballot.ID
+		targets   = p.acceptors()
+		addrs     = make([]string, 0, len(targets))
+		failing   = map[string]bool{}
+		health    = p.currentHealth()
+		latencies map[string]time.Duration
+	)
+	for addr := range targets {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range append(health.Preparers, health.Accepters...) {
+		failing[addr] = true
+	}
+	if p.latency != nil {
+		latencies = p.latency.snapshot()
+	}
+
+	t := Topology{Nodes: []TopologyNode{{ID: id, Kind: "proposer", Health: health.State}}}
+	for _, addr := range addrs {
+		_, preparer := p.preparers[addr]
+		_, accepter := p.accepters[addr]
+		rate, samples, observed := p.health.errorRate(addr)
+		t.Nodes = append(t.Nodes, TopologyNode{ID: addr, Kind: "acceptor", Role: roleOf(targets[addr])})
+		t.Edges = append(t.Edges, TopologyEdge{
+			Source:    id,
+			Target:    addr,
+			Preparer:  preparer,
+			Accepter:  accepter,
+			Weight:    p.weight(addr),
+			Failing:   failing[addr],
+			ErrorRate: rate,
+			Samples:   samples,
+			Observed:  observed,
+			Latency:   latencies[addr],
+		})
+	}
+	return t
+}
+
+// MergeTopologies combines the views of several proposers. Acceptors known to
+// more than one appear once, with an edge from each.
+func MergeTopologies(topologies ...Topology) Topology {
+	var (
+		res  Topology
+		seen = map[string]bool{}
+	)
+	for _, t := range topologies {
+		for _, node := range t.Nodes {
+			if key := node.Kind + "/" + node.ID; !seen[key] {
+				seen[key] = true
+				res.Nodes = append(res.Nodes, node)
+			}
+		}
+		res.Edges = append(res.Edges, t.Edges...)
+	}
+	return res
+}
+
+// DOT renders the topology in the Graphviz DOT language. Proposers are boxes,
+// witnesses are dashed, and failing edges are red. Edges are labeled with the
+// acceptor's roles, and weight, if it's not one.
+func (t Topology) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph topology {\n")
+	for _, node := range t.Nodes {
+		attrs := fmt.Sprintf("label=%q", node.ID)
+		switch {
+		case node.Kind == "proposer":
+			attrs += " shape=box"
+		case node.Role == RoleWitness:
+			attrs += " style=dashed"
+		}
+		fmt.Fprintf(&buf, "\t%q [%s];\n", node.Kind+"/"+node.ID, attrs)
+	}
+	for _, edge := range t.Edges {
+		var label string
+		switch {
+		case edge.Preparer && edge.Accepter:
+			label = "prepare+accept"
+		case edge.Preparer:
+			label = "prepare"
+		default:
+			label = "accept"
+		}
+		if edge.Weight != 1 {
+			label += fmt.Sprintf(" weight %d", edge.Weight)
+		}
+		attrs := fmt.Sprintf("label=%q", label)
+		if edge.Failing {
+			attrs += " color=red"
+		}
+		fmt.Fprintf(&buf, "\t%q -> %q [%s];\n", "proposer/"+edge.Source, "acceptor/"+edge.Target, attrs)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
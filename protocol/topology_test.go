@@ -0,0 +1,73 @@
+package protocol
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestTopology(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		b2     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("2", log.With(logger, "a", 2))}
+		w3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), AsWitness())
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, b2, w3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, b2)
+		ctx    = context.Background()
+	)
+
+	// Before any requests, health is unknown.
+	for _, edge := range p1.Topology().Edges {
+		if edge.Samples != 0 || !edge.Observed.IsZero() {
+			t.Errorf("%s: want no samples, have %+v", edge.Target, edge)
+		}
+	}
+
+	b2.setBroken(true)
+	for i := 0; i < 4; i++ {
+		if _, _, err := p1.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	topology := p1.Topology()
+	if want, have := 4, len(topology.Nodes); want != have {
+		t.Fatalf("nodes: want %d, have %d", want, have)
+	}
+	if node := topology.Nodes[3]; node.ID != "3" || node.Role != RoleWitness {
+		t.Errorf("witness: have %+v", node)
+	}
+	edges := map[string]TopologyEdge{}
+	for _, edge := range topology.Edges {
+		if edge.Source != "1" || !edge.Preparer || !edge.Accepter || edge.Weight != 1 {
+			t.Errorf("%s: bad edge %+v", edge.Target, edge)
+		}
+		edges[edge.Target] = edge
+	}
+	if edge := edges["2"]; !edge.Failing || edge.ErrorRate != 1 || edge.Samples == 0 || edge.Observed.IsZero() {
+		t.Errorf("broken acceptor: want a failing edge, have %+v", edge)
+	}
+	if edge := edges["1"]; edge.Failing || edge.ErrorRate != 0 || edge.Samples == 0 {
+		t.Errorf("healthy acceptor: want a healthy edge, have %+v", edge)
+	}
+
+	merged := MergeTopologies(topology, p2.Topology())
+	if len(merged.Nodes) != 5 || len(merged.Edges) != 5 {
+		t.Errorf("merged: want 5 nodes and 5 edges, have %d and %d", len(merged.Nodes), len(merged.Edges))
+	}
+
+	dot := merged.DOT()
+	for _, want := range []string{
+		`"proposer/1" [label="1" shape=box];`,
+		`"acceptor/3" [label="3" style=dashed];`,
+		`"proposer/1" -> "acceptor/2" [label="prepare+accept" color=red];`,
+		`"proposer/2" -> "acceptor/1" [label="prepare+accept"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT: want %s, have\n%s", want, dot)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// RoundTrace is a record of a single proposal round: every response the
+// proposer acted on, in the order it acted on them. Responses which arrived
+// after the proposer stopped waiting for a phase aren't included.
+type RoundTrace struct {
+	Key      string        `json:"key"`
+	Ballot   Ballot        `json:"ballot"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Events   []TraceEvent  `json:"events"`
+	Err      string        `json:"err,omitempty"`
+}
+
+// TraceEvent is a single response from an acceptor, within a RoundTrace.
+type TraceEvent struct {
+	Phase   string        `json:"phase"` // prepare or accept
+	Addr    string        `json:"addr"`
+	Elapsed time.Duration `json:"elapsed_ns"` // since the start of the round
+	Ballot  Ballot        `json:"ballot"`     // returned by preparers
+	Err     string        `json:"err,omitempty"`
+}
+
+// TracedError is returned by proposers with tracing enabled, when a round
+// fails. It wraps the original error, which is available via Cause, Unwrap,
+// or errors.As.
+type TracedError struct {
+	Err   error
+	Trace *RoundTrace
+}
+
+func (te TracedError) Error() string {
+	return te.Err.Error()
+}
+
+// Cause implements github.com/pkg/errors.Causer.
+func (te TracedError) Cause() error {
+	return te.Err
+}
+
+// Unwrap implements the Go 1.13 errors interface.
+func (te TracedError) Unwrap() error {
+	return te.Err
+}
+
+// String renders the trace for humans.
+func (rt *RoundTrace) String() string {
+	s := fmt.Sprintf("round %s on %q, %s", rt.Ballot, rt.Key, rt.Duration)
+	for _, e := range rt.Events {
+		s += fmt.Sprintf("\n  +%s %s %s ballot=%s err=%q", e.Elapsed, e.Phase, e.Addr, e.Ballot, e.Err)
+	}
+	if rt.Err != "" {
+		s += fmt.Sprintf("\n  failed: %s", rt.Err)
+	}
+	return s
+}
+
+// WithTracing enables round traces. Failed rounds always produce a trace,
+// which is attached to the returned error as a TracedError. Successful rounds
+// produce a trace with the given probability. Every trace is passed to report,
+// if it's non-nil, synchronously, with the proposer locked. By default,
+// tracing is disabled, and costs nothing.
+func WithTracing(successRate float64, report func(*RoundTrace)) MemoryProposerOption {
+	return func(p *MemoryProposer) { p.tracer = &tracer{successRate: successRate, report: report} }
+}
+
+type tracer struct {
+	successRate float64
+	report      func(*RoundTrace)
+}
+
+// start returns a new trace, or nil if tracing is disabled. Every method on
+// RoundTrace accepts a nil receiver.
+func (t *tracer) start(key string, b Ballot) *RoundTrace {
+	if t == nil {
+		return nil
+	}
+	return &RoundTrace{Key: key, Ballot: b, Start: time.Now()}
+}
+
+func (rt *RoundTrace) add(phase, addr string, b Ballot, err error) {
+	if rt == nil {
+		return
+	}
+	e := TraceEvent{Phase: phase, Addr: addr, Elapsed: time.Since(rt.Start), Ballot: b}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	rt.Events = append(rt.Events, e)
+}
+
+// finish completes the trace, wraps *err if it's non-nil, and reports it, if
+// it's sampled.
+func (t *tracer) finish(rt *RoundTrace, err *error) {
+	if rt == nil {
+		return
+	}
+	rt.Duration = time.Since(rt.Start)
+	if *err != nil {
+		rt.Err = (*err).Error()
+		*err = TracedError{Err: *err, Trace: rt}
+	} else if rand.Float64() >= t.successRate {
+		return
+	}
+	if t.report != nil {
+		t.report(rt)
+	}
+}
+
+// untraced returns the error underneath any TracedError.
+func untraced(err error) error {
+	if te, ok := err.(TracedError); ok {
+		return te.Err
+	}
+	return err
+}
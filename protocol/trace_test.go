@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestTracing(t *testing.T) {
+	var (
+		logger    = log.NewLogfmtLogger(newTestWriter(t))
+		acceptors []*breakableAcceptor
+		initial   []Acceptor
+		reported  []*RoundTrace
+		ctx       = context.Background()
+	)
+	for i := 1; i <= 3; i++ {
+		a := &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor(fmt.Sprint(i), log.With(logger, "a", i))}
+		acceptors = append(acceptors, a)
+		initial = append(initial, a)
+	}
+	report := func(rt *RoundTrace) { reported = append(reported, rt) }
+	p := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), initial, WithTracing(1, report))
+
+	// A successful round is reported, with both phases.
+	if _, _, err := p.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 1, len(reported); want != have {
+		t.Fatalf("reported: want %d, have %d", want, have)
+	}
+	var phases = map[string]int{}
+	for _, e := range reported[0].Events {
+		phases[e.Phase]++
+	}
+	if phases["prepare"] < 2 || phases["accept"] < 2 {
+		t.Errorf("want at least a quorum of events in each phase, have %s", reported[0])
+	}
+	if reported[0].Err != "" {
+		t.Errorf("successful round: want no error, have %q", reported[0].Err)
+	}
+
+	// A failed round is reported, and the trace is attached to the error,
+	// which still looks like the underlying error to the caller.
+	acceptors[0].setBroken(true)
+	acceptors[1].setBroken(true)
+	_, _, err := p.Propose(ctx, "k", changeFuncRead)
+	var te TracedError
+	if !errors.As(err, &te) {
+		t.Fatalf("want TracedError, have %T: %v", err, err)
+	}
+	var qe QuorumError
+	if !errors.As(err, &qe) || qe.Err != ErrPrepareFailed {
+		t.Fatalf("want prepare QuorumError, have %v", err)
+	}
+	if want, have := te.Trace, reported[len(reported)-1]; want != have {
+		t.Errorf("want the attached trace to be reported")
+	}
+	var broken int
+	for _, e := range te.Trace.Events {
+		if e.Phase == "prepare" && e.Err == errBroken.Error() {
+			broken++
+		}
+	}
+	if want, have := 2, broken; want != have {
+		t.Errorf("broken prepare events: want %d, have %d\n%s", want, have, te.Trace)
+	}
+	if te.Trace.Err == "" {
+		t.Errorf("failed round: want an error in the trace")
+	}
+}
+
+func TestTracingSampling(t *testing.T) {
+	var (
+		logger   = log.NewLogfmtLogger(newTestWriter(t))
+		reported int
+		ctx      = context.Background()
+	)
+	a := NewMemoryAcceptor("1", log.With(logger, "a", 1))
+	p := NewMemoryProposerWithOptions("1", log.With(logger, "p", 1), []Acceptor{a}, WithTracing(0, func(*RoundTrace) { reported++ }))
+	for i := 0; i < 10; i++ {
+		if _, _, err := p.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reported != 0 {
+		t.Errorf("with a success rate of 0, want no reports, have %d", reported)
+	}
+}
@@ -0,0 +1,212 @@
+package protocol
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUsageNotTracked indicates a usage request to an acceptor which
+	// doesn't track usage.
+	ErrUsageNotTracked = errors.New("usage isn't tracked")
+
+	// ErrUsageDepth indicates a usage request deeper than the acceptor tracks.
+	ErrUsageDepth = errors.New("usage isn't tracked that deep")
+)
+
+// UsageReporter is implemented by acceptors which report their usage by key
+// prefix. Remote acceptors would implement it over the network.
+type UsageReporter interface {
+	Addresser
+	Usage(ctx context.Context, depth int) (map[string]Usage, error)
+}
+
+// WithUsage makes the acceptor count the keys and bytes under every key
+// prefix, to the given depth, as values are accepted and removed. Prefixes
+// are made of the segments of keys split by the namespace separator, not
+// counting the last, so at depth 1, "red/k" and "red/j" count toward "red",
+// "red/x/k" also counts toward "red", and "k" counts toward the empty prefix.
+// Bytes are the sizes of the raw values. Witnesses have no values, so they
+// count nothing. Depth must be at least 1. By default, usage isn't tracked.
+func WithUsage(depth int) MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.usageDepth = depth }
+}
+
+// Usage implements UsageReporter. It returns the usage under every prefix of
+// at most depth segments that has any keys. It's maintained as values change,
+// so it's cheap.
+func (a *MemoryAcceptor) Usage(ctx context.Context, depth int) (map[string]Usage, error) {
+	if a.usage == nil {
+		return nil, ErrUsageNotTracked
+	}
+	if depth < 0 || depth > a.usage.depth {
+		return nil, ErrUsageDepth
+	}
+	return a.usage.report(depth), nil
+}
+
+// RecomputeUsage rebuilds the usage counters from a full scan of the stored
+// values, to correct any drift. Writes made during the scan may still be
+// miscounted, so it's best run when the acceptor is quiet.
+func (a *MemoryAcceptor) RecomputeUsage() error {
+	if a.usage == nil {
+		return ErrUsageNotTracked
+	}
+	counts := map[string]Usage{}
+	a.usage.inner.forEach(func(key string, av acceptedValue) bool {
+		u := usageOf(av, true)
+		if u == (Usage{}) {
+			return true
+		}
+		prefix := usagePrefix(key, a.usage.depth)
+		c := counts[prefix]
+		c.Keys, c.Bytes = c.Keys+u.Keys, c.Bytes+u.Bytes
+		counts[prefix] = c
+		return true
+	})
+	a.usage.reset(counts)
+	return nil
+}
+
+// ClusterUsage merges the usage reported by the acceptors, taking the maximum
+// for each prefix, as each value is stored on several acceptors, and the
+// most complete of them has the most. Acceptors which fail are skipped,
+// unless they all fail.
+func ClusterUsage(ctx context.Context, acceptors []UsageReporter, depth int) (map[string]Usage, error) {
+	var (
+		merged = map[string]Usage{}
+		err    error
+		ok     bool
+	)
+	for _, acceptor := range acceptors {
+		usage, usageErr := acceptor.Usage(ctx, depth)
+		if usageErr != nil {
+			err = errors.Wrapf(usageErr, "error reading usage from %s", acceptor.Address())
+			continue
+		}
+		ok = true
+		for prefix, u := range usage {
+			m := merged[prefix]
+			if u.Keys > m.Keys {
+				m.Keys = u.Keys
+			}
+			if u.Bytes > m.Bytes {
+				m.Bytes = u.Bytes
+			}
+			merged[prefix] = m
+		}
+	}
+	if !ok && err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// SeedUsage sets the namespace's usage from the cluster's, as reported by
+// the acceptors, so a new Namespace enforces its quota from the start. It
+// should be called before the first proposal.
+func (n *Namespace) SeedUsage(ctx context.Context, acceptors []UsageReporter) error {
+	usage, err := ClusterUsage(ctx, acceptors, 1)
+	if err != nil {
+		return err
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.usage = usage[n.name]
+	return nil
+}
+
+// usageStore is a store which counts usage by prefix. Counters are kept at the
+// full depth, and aggregated for shallower reports.
+type usageStore struct {
+	inner store
+	depth int
+
+	mtx    sync.Mutex
+	counts map[string]Usage
+}
+
+func newUsageStore(inner store, depth int) *usageStore {
+	return &usageStore{inner: inner, depth: depth, counts: map[string]Usage{}}
+}
+
+func (s *usageStore) get(key string) (acceptedValue, bool) {
+	return s.inner.get(key)
+}
+
+func (s *usageStore) update(key string, f func(acceptedValue, bool) (acceptedValue, bool, error)) error {
+	var before, after Usage
+	err := s.inner.update(key, func(current acceptedValue, ok bool) (acceptedValue, bool, error) {
+		next, keep, err := f(current, ok)
+		if err == nil {
+			before, after = usageOf(current, ok), usageOf(next, keep)
+		}
+		return next, keep, err
+	})
+	if err != nil || before == after {
+		return err
+	}
+
+	// Deltas commute, so it doesn't matter that they're applied outside the
+	// store's lock.
+	prefix := usagePrefix(key, s.depth)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c := s.counts[prefix]
+	c.Keys += after.Keys - before.Keys
+	c.Bytes += after.Bytes - before.Bytes
+	if c == (Usage{}) {
+		delete(s.counts, prefix)
+	} else {
+		s.counts[prefix] = c
+	}
+	return nil
+}
+
+func (s *usageStore) forEach(f func(string, acceptedValue) bool) {
+	s.inner.forEach(f)
+}
+
+func (s *usageStore) report(depth int) map[string]Usage {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	report := make(map[string]Usage, len(s.counts))
+	for prefix, c := range s.counts {
+		if depth < s.depth {
+			prefix = usagePrefix(prefix+namespaceSeparator, depth)
+		}
+		r := report[prefix]
+		r.Keys, r.Bytes = r.Keys+c.Keys, r.Bytes+c.Bytes
+		report[prefix] = r
+	}
+	return report
+}
+
+func (s *usageStore) reset(counts map[string]Usage) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.counts = counts
+}
+
+// usagePrefix returns the prefix of key of at most depth segments, not counting
+// the last.
+func usagePrefix(key string, depth int) string {
+	segments := strings.Split(key, namespaceSeparator)
+	segments = segments[:len(segments)-1]
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, namespaceSeparator)
+}
+
+// usageOf returns the usage of a stored value. Keys without a value, i.e.
+// promises, and deleted values, don't count.
+func usageOf(av acceptedValue, ok bool) Usage {
+	if !ok || av.value == nil {
+		return Usage{}
+	}
+	return Usage{Keys: 1, Bytes: av.rawSize()}
+}
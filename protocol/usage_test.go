@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestUsage(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1), WithUsage(2), WithShards(4))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2), WithUsage(2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3), WithUsage(2), WithCompression(Flate, 0))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	for key, value := range map[string]string{
+		"red/a":    "1",
+		"red/b":    "22",
+		"red/x/c":  "333",
+		"blue/a":   "4444",
+		"toplevel": "55555",
+		"gone/a":   "666666",
+	} {
+		if _, _, err := p.Propose(ctx, key, changeFuncInitializeOnlyOnce(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := p.Propose(ctx, "gone/a", func([]byte) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Propose(ctx, "red/b", func([]byte) []byte { return []byte("2") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Propose(ctx, "read/only", changeFuncRead); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		depth1 = map[string]Usage{"red": {3, 5}, "blue": {1, 4}, "": {1, 5}}
+		depth2 = map[string]Usage{"red": {2, 2}, "red/x": {1, 3}, "blue": {1, 4}, "": {1, 5}}
+	)
+
+	// The last accepts may still be on their way to one of the acceptors, and
+	// the merge takes the maximum, so wait for shrinking usage to settle.
+	var (
+		usage    map[string]Usage
+		err      error
+		deadline = time.Now().Add(time.Second)
+	)
+	for {
+		if usage, err = ClusterUsage(ctx, []UsageReporter{a1, a2, a3}, 1); err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(depth1, usage) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if want, have := depth1, usage; !reflect.DeepEqual(want, have) {
+		t.Errorf("depth 1: want %v, have %v", want, have)
+	}
+	if usage, err = ClusterUsage(ctx, []UsageReporter{a1, a2, a3}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := depth2, usage; !reflect.DeepEqual(want, have) {
+		t.Errorf("depth 2: want %v, have %v", want, have)
+	}
+
+	if _, err := a1.Usage(ctx, 3); err != ErrUsageDepth {
+		t.Errorf("too deep: want %v, have %v", ErrUsageDepth, err)
+	}
+	if _, err := NewMemoryAcceptor("4", logger).Usage(ctx, 1); err != ErrUsageNotTracked {
+		t.Errorf("untracked: want %v, have %v", ErrUsageNotTracked, err)
+	}
+
+	// Recomputing finds the same thing.
+	before, _ := a1.Usage(ctx, 2)
+	if err := a1.RecomputeUsage(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := a1.Usage(ctx, 2); !reflect.DeepEqual(before, after) {
+		t.Errorf("after recompute: want %v, have %v", before, after)
+	}
+
+	// A new namespace starts from the cluster's usage.
+	red, _ := NewNamespace("red", p, Quota{MaxKeys: 3})
+	if err := red.SeedUsage(ctx, []UsageReporter{a1, a2, a3}); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := depth1["red"], red.Usage(); want != have {
+		t.Errorf("seeded: want %v, have %v", want, have)
+	}
+	if _, _, err := red.Propose(ctx, "d", changeFuncInitializeOnlyOnce("x")); err == nil {
+		t.Errorf("fourth key: want quota exceeded, have success")
+	}
+}
@@ -0,0 +1,24 @@
+package protocol
+
+import "bytes"
+
+// States are byte slices, where a nil state means the key is absent: it was
+// never written, or it was deleted by writing nil. An empty, non-nil state is
+// a value like any other. Acceptors, proposers, and transports must all
+// preserve the difference. Acceptors that can't record it, e.g. because their
+// storage predates it, should report every empty value as absent, which is
+// how empty values were always treated.
+
+// copyValue returns a copy of v, preserving nil.
+func copyValue(v []byte) []byte {
+	if v == nil {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+// equalValues is like bytes.Equal, except an absent value isn't equal to an
+// empty one.
+func equalValues(a, b []byte) bool {
+	return (a == nil) == (b == nil) && bytes.Equal(a, b)
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAbsentVersusEmpty(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	if _, _, err := p1.Propose(ctx, "empty", func([]byte) []byte { return []byte{} }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p1.Propose(ctx, "deleted", func([]byte) []byte { return []byte("x") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p1.Propose(ctx, "deleted", func([]byte) []byte { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading through the proposer preserves the difference. A full identity
+	// read also makes sure every acceptor has the value, for the next check.
+	for _, testcase := range []struct {
+		key     string
+		present bool
+	}{
+		{"empty", true},
+		{"deleted", false},
+		{"never-written", false},
+	} {
+		state, err := p1.FullIdentityRead(ctx, testcase.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := testcase.present, state != nil; want != have {
+			t.Errorf("%s: present: want %v, have %v", testcase.key, want, have)
+		}
+		if len(state) != 0 {
+			t.Errorf("%s: want no bytes, have %q", testcase.key, state)
+		}
+
+		// So does inspecting the acceptors directly.
+		for _, a := range []*MemoryAcceptor{a1, a2, a3} {
+			inspection, err := a.Inspect(ctx, testcase.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, have := testcase.present, inspection.Value != nil; want != have {
+				t.Errorf("%s on %s: present: want %v, have %v", testcase.key, a.Address(), want, have)
+			}
+		}
+	}
+}
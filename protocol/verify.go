@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VerifyOptions control VerifyAll. The zero value is useful.
+type VerifyOptions struct {
+	// Concurrency is how many keys are verified at once. The default is 1.
+	Concurrency int
+
+	// MaxKeysPerSecond limits the rate at which keys are verified. The
+	// default is no limit.
+	MaxKeysPerSecond float64
+
+	// Repair makes divergent keys converge, via a full identity read.
+	Repair bool
+
+	// Progress, if set, is called after each key is verified. Calls are
+	// serialized.
+	Progress func(done, total int)
+}
+
+// VerifySummary is the result of VerifyAll.
+type VerifySummary struct {
+	OK        int              // keys which every acceptor agreed on
+	Repaired  int              // keys which were divergent, and repaired
+	Divergent []string         // keys which were divergent, and not repaired
+	Failed    map[string]error // keys which couldn't be verified or repaired
+}
+
+// VerifyAll checks every key held by any of the acceptors, and reports those
+// where the acceptors don't all agree on the accepted ballot and value. That's
+// normal for keys written recently, or while an acceptor was unavailable; but
+// it means the cluster has less redundancy than it should. With the Repair
+// option, divergent keys are repaired by a full identity read via the proposer,
+// which writes the current value to every acceptor.
+//
+// An error is returned only if the set of keys can't be determined. Problems
+// with individual keys are reported in the summary.
+func VerifyAll(ctx context.Context, proposer Proposer, acceptors []Inspecter, options VerifyOptions) (VerifySummary, error) {
+	summary := VerifySummary{Failed: map[string]error{}}
+
+	// Take the union of every acceptor's keys. Any acceptor may have keys the
+	// others don't know about.
+	union := map[string]bool{}
+	for _, acceptor := range acceptors {
+		keys, err := acceptor.Keys(ctx)
+		if err != nil {
+			return summary, errors.Wrapf(err, "error listing keys on %s", acceptor.Address())
+		}
+		for _, key := range keys {
+			union[key] = true
+		}
+	}
+	keys := make([]string, 0, len(union))
+	for key := range union {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Feed the keys to the workers, at the allowed rate.
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	work := make(chan string)
+	go func() {
+		defer close(work)
+		var tick <-chan time.Time
+		if options.MaxKeysPerSecond > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / options.MaxKeysPerSecond))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for _, key := range keys {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case work <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Verify the keys, and collect the results.
+	var (
+		wg       sync.WaitGroup
+		mtx      sync.Mutex
+		verified = map[string]bool{}
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				repaired, err := verifyKey(ctx, key, proposer, acceptors, options.Repair)
+
+				mtx.Lock()
+				switch {
+				case err == errDivergent:
+					summary.Divergent = append(summary.Divergent, key)
+				case err != nil:
+					summary.Failed[key] = err
+				case repaired:
+					summary.Repaired++
+				default:
+					summary.OK++
+				}
+				verified[key] = true
+				if options.Progress != nil {
+					options.Progress(len(verified), len(keys))
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Keys we didn't get to, because the context was canceled, have failed.
+	if err := ctx.Err(); err != nil {
+		for _, key := range keys {
+			if !verified[key] {
+				summary.Failed[key] = err
+			}
+		}
+	}
+
+	sort.Strings(summary.Divergent)
+	return summary, nil
+}
+
+var errDivergent = errors.New("acceptors are divergent")
+
+// verifyKey returns errDivergent if the acceptors don't agree on key, and
+// repair is false. Otherwise, it makes them agree, and returns true.
+func verifyKey(ctx context.Context, key string, proposer Proposer, acceptors []Inspecter, repair bool) (repaired bool, err error) {
+	agree, err := acceptorsAgree(ctx, key, acceptors)
+	if err != nil {
+		return false, err
+	}
+	if agree {
+		return false, nil
+	}
+	if !repair {
+		return false, errDivergent
+	}
+
+	if _, err := proposer.FullIdentityRead(ctx, key); err != nil {
+		return false, errors.Wrap(err, "error repairing")
+	}
+	if agree, err = acceptorsAgree(ctx, key, acceptors); err != nil {
+		return false, err
+	}
+	if !agree {
+		return false, errors.New("acceptors still divergent after repair")
+	}
+	return true, nil
+}
+
+// acceptorsAgree reports whether the acceptors have accepted the same ballot,
+// and the full replicas the same value. Witnesses have no value to compare.
+func acceptorsAgree(ctx context.Context, key string, acceptors []Inspecter) (bool, error) {
+	var (
+		firstValue  []byte
+		firstBallot Ballot
+		haveValue   bool
+	)
+	for i, acceptor := range acceptors {
+		inspection, err := acceptor.Inspect(ctx, key)
+		if err != nil {
+			return false, errors.Wrapf(err, "error inspecting %s", acceptor.Address())
+		}
+		if i == 0 {
+			firstBallot = inspection.Accepted
+		} else if inspection.Accepted != firstBallot {
+			return false, nil
+		}
+		if roleOf(acceptor) == RoleWitness {
+			continue
+		}
+		if !haveValue {
+			firstValue, haveValue = inspection.Value, true
+			continue
+		}
+		if !equalValues(inspection.Value, firstValue) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestVerifyAll(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2)
+		ctx    = context.Background()
+		all    = []Inspecter{a1, a2, a3}
+	)
+
+	// Keys a and c are on every acceptor. Key b misses a3.
+	for _, key := range []string{"a", "c"} {
+		if _, _, err := p1.Propose(ctx, key, changeFuncInitializeOnlyOnce(key)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p1.FullIdentityRead(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := p2.Propose(ctx, "b", changeFuncInitializeOnlyOnce("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without repair, the divergent key is reported.
+	var progress []int
+	summary, err := VerifyAll(ctx, p1, all, VerifyOptions{
+		Progress: func(done, total int) { progress = append(progress, done, total) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (VerifySummary{OK: 2, Divergent: []string{"b"}, Failed: map[string]error{}}), summary; !reflect.DeepEqual(want, have) {
+		t.Fatalf("verify: want %+v, have %+v", want, have)
+	}
+	if want, have := []int{1, 3, 2, 3, 3, 3}, progress; !reflect.DeepEqual(want, have) {
+		t.Fatalf("progress: want %v, have %v", want, have)
+	}
+
+	// With repair, it's repaired.
+	summary, err = VerifyAll(ctx, p1, all, VerifyOptions{Concurrency: 2, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := (VerifySummary{OK: 2, Repaired: 1, Failed: map[string]error{}}), summary; !reflect.DeepEqual(want, have) {
+		t.Fatalf("verify with repair: want %+v, have %+v", want, have)
+	}
+	if want, have := "b", string(a3.dumpValue("b")); want != have {
+		t.Fatalf("repaired value: want %q, have %q", want, have)
+	}
+
+	// Keys that can't be repaired are failures.
+	a3.setBroken(true)
+	if _, _, err := p1.Propose(ctx, "b", func([]byte) []byte { return []byte("b2") }); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = VerifyAll(ctx, p1, all, VerifyOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 2, summary.OK; want != have {
+		t.Errorf("verify with broken acceptor: want %d OK, have %d", want, have)
+	}
+	if _, ok := summary.Failed["b"]; !ok || len(summary.Failed) != 1 {
+		t.Errorf("verify with broken acceptor: want b failed, have %v", summary.Failed)
+	}
+}
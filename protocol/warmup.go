@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+// WarmupReport describes what Warmup did.
+type WarmupReport struct {
+	Acceptors map[string]WarmupResult // by address
+	Before    uint64                  // ballot counter before warm-up
+	After     uint64                  // ballot counter after warm-up
+}
+
+// WarmupResult describes warm-up for a single acceptor.
+type WarmupResult struct {
+	Latency   time.Duration // of the ping
+	MaxBallot Ballot
+	Err       error
+}
+
+// Warmup pings every acceptor, so that connections are established before
+// the first proposal, and fast-forwards the ballot counter past the greatest
+// ballot any of them has seen, so the first proposal doesn't conflict. Only
+// acceptors that implement Inspecter can report their greatest ballot. Errors
+// are reported per acceptor, but don't fail the warm-up, as a proposer can work
+// fine without a minority of its acceptors.
+func (p *MemoryProposer) Warmup(ctx context.Context) WarmupReport {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	targets := p.acceptors()
+	type result struct {
+		addr string
+		WarmupResult
+	}
+	results := make(chan result, len(targets))
+	for addr, target := range targets {
+		go func(addr string, target Acceptor) {
+			begin := time.Now()
+			_, err := target.Ping(ctx)
+			r := result{addr, WarmupResult{Latency: time.Since(begin), Err: err}}
+			if i, ok := target.(Inspecter); ok && err == nil {
+				r.MaxBallot, r.Err = i.MaxBallot(ctx)
+			}
+			results <- r
+		}(addr, target)
+	}
+
+	report := WarmupReport{
+		Acceptors: map[string]WarmupResult{},
+		Before:    p.ballot.Counter,
+	}
+	for i := 0; i < cap(results); i++ {
+		r := <-results
+		report.Acceptors[r.addr] = r.WarmupResult
+		level.Debug(p.logger).Log("method", "Warmup", "addr", r.addr, "latency", r.Latency, "max_ballot", r.MaxBallot, "err", r.Err)
+		if r.Err == nil && r.MaxBallot.Counter > p.ballot.Counter {
+			p.ballot.Counter = r.MaxBallot.Counter // fast-forward
+		}
+	}
+	report.After = p.ballot.Counter
+
+	level.Info(p.logger).Log("method", "Warmup", "acceptors", len(targets), "counter_before", report.Before, "counter_after", report.After)
+	return report
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestWarmup(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p1     = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2, a3)
+		p2     = NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2, a3)
+		ctx    = context.Background()
+	)
+
+	// Move the cluster's ballots well ahead of a fresh proposer.
+	for i := 0; i < 10; i++ {
+		if _, _, err := p1.Propose(ctx, "k", changeFuncRead); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := p2.Warmup(ctx)
+	if want, have := 3, len(report.Acceptors); want != have {
+		t.Fatalf("acceptors: want %d, have %d", want, have)
+	}
+	for addr, result := range report.Acceptors {
+		if result.Err != nil {
+			t.Errorf("%s: %v", addr, result.Err)
+		}
+	}
+	if want, have := uint64(0), report.Before; want != have {
+		t.Errorf("counter before: want %d, have %d", want, have)
+	}
+	if want, have := uint64(10), report.After; want != have {
+		t.Errorf("counter after: want %d, have %d", want, have)
+	}
+
+	// The first proposal succeeds without a conflict, i.e. without a retry.
+	_, b, err := p2.Propose(ctx, "k", changeFuncRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := uint64(11), b.Counter; want != have {
+		t.Errorf("first ballot after warm-up: want counter %d, have %d", want, have)
+	}
+}
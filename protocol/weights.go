@@ -0,0 +1,279 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidWeight indicates an acceptor weight less than one.
+	ErrInvalidWeight = errors.New("acceptor weight must be at least 1")
+
+	// ErrWeightMajority indicates a configuration in which a single acceptor
+	// holds a majority of the weight, so it alone would make a quorum, and
+	// its failure would stop the cluster.
+	ErrWeightMajority = errors.New("invalid configuration: a single acceptor holds a majority of the weight")
+
+	// ErrQuorumIntersection indicates a configuration in which a prepare
+	// quorum and an accept quorum needn't share an acceptor, because too much
+	// of the accepters' weight is on acceptors which aren't preparers. A value
+	// accepted by such an accept quorum could be missed by the next prepare.
+	ErrQuorumIntersection = errors.New("invalid configuration: prepare and accept quorums needn't intersect")
+)
+
+// AddWeightedAccepter is like AddAccepter, but the acceptor counts weight
+// times toward quorum, in both phases. Quorums are weighted majorities: more
+// than half of the total weight of the preparers, or the accepters, must
+// respond. By default, every acceptor has weight one, which makes them plain
+// majorities.
+//
+// Weights are part of the configuration, and every proposer must use the same
+// ones, so they can only be changed by removing an acceptor and adding it
+// again, via the usual cluster change procedures. No accepter may hold a
+// majority of the weight, so add heavier acceptors after lighter ones, or load
+// the whole configuration with ReloadConfiguration.
+//
+// Until the acceptor is also a preparer, every prepare quorum must still share
+// an acceptor with every accept quorum, or an accepted write could be lost, so
+// heavier acceptors may be refused with ErrQuorumIntersection, e.g. weight 2
+// beside three preparers of weight 1. Such an acceptor can only join as part
+// of a whole configuration, loaded by a proposer with no accepters. Removing
+// one as a preparer breaks the intersection in the same way, so RemovePreparer
+// and RemoveAccepter refuse removals which leave quorums that needn't
+// intersect, with the same error. Removing a heavy acceptor may need another
+// acceptor added first, to outweigh it.
+func (p *MemoryProposer) AddWeightedAccepter(target Acceptor, weight int) error {
+	if weight < 1 {
+		return ErrInvalidWeight
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	addr := target.Address()
+	if _, ok := p.accepters[addr]; ok {
+		return ErrDuplicate
+	}
+	if err := p.checkDuplicate(target); err != nil {
+		return err
+	}
+	if err := p.strictStep(StepAddAccepter, addr); err != nil {
+		return err
+	}
+
+	c := p.configuration()
+	c.Accepters = append(c.Accepters, addr)
+	if weight != 1 {
+		if c.Weights == nil {
+			c.Weights = map[string]int{}
+		}
+		c.Weights[addr] = weight
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	p.accepters[addr] = target
+	if _, ok := p.preparers[addr]; !ok {
+		p.advanceLifecycle(StepAddAccepter, addr)
+	}
+	if weight != 1 {
+		if p.weights == nil {
+			p.weights = map[string]int{}
+		}
+		p.weights[addr] = weight
+	}
+	p.configurationChanged()
+	return nil
+}
+
+// weight returns the weight of the acceptor at addr.
+func (p *MemoryProposer) weight(addr string) int {
+	if w, ok := p.weights[addr]; ok {
+		return w
+	}
+	return 1
+}
+
+// totalWeight returns the sum of the weights of addrs.
+func (p *MemoryProposer) totalWeight(addrs []string) (total int) {
+	for _, addr := range addrs {
+		total += p.weight(addr)
+	}
+	return total
+}
+
+// failedWeight returns the sum of the weights of the failed acceptors.
+func (p *MemoryProposer) failedWeight(failures map[string]error) (total int) {
+	for addr := range failures {
+		total += p.weight(addr)
+	}
+	return total
+}
+
+// quorumSize returns how many of addrs, in order, make a quorum.
+func (p *MemoryProposer) quorumSize(addrs []string, qf quorumFunc) int {
+	need := qf(p.totalWeight(addrs))
+	for i, addr := range addrs {
+		if need -= p.weight(addr); need <= 0 {
+			return i + 1
+		}
+	}
+	return len(addrs)
+}
+
+// weightOf returns the weight of addr in the configuration.
+func (c Configuration) weightOf(addr string) int {
+	if w, ok := c.Weights[addr]; ok {
+		return w
+	}
+	return 1
+}
+
+// validateWeights checks that every weight is at least one, and that no
+// single accepter holds a majority of the weight, unless every weight is one.
+// Preparers are a subset of the accepters, and only differ from them in the
+// middle of a cluster change, so they aren't checked.
+func (c Configuration) validateWeights() error {
+	if len(c.Weights) == 0 {
+		return nil
+	}
+	var total int
+	for _, addr := range c.Accepters {
+		if c.weightOf(addr) < 1 {
+			return ErrInvalidWeight
+		}
+		total += c.weightOf(addr)
+	}
+	for _, addr := range c.Accepters {
+		if 2*c.weightOf(addr) > total {
+			return ErrWeightMajority
+		}
+	}
+	return nil
+}
+
+// validateIntersection checks that every prepare quorum shares an acceptor
+// with every accept quorum. An accept quorum holds at least the accept quorum
+// weight less the weight of the accepters which aren't preparers on
+// preparers, and a prepare quorum holds at least the prepare quorum weight,
+// so together they must hold more than the preparers' total weight. With
+// every weight one, that allows the one accepter which isn't a preparer in
+// the middle of a cluster change. Without preparers, nothing can be prepared,
+// so anything goes.
+func (c Configuration) validateIntersection() error {
+	if len(c.Preparers) == 0 {
+		return nil
+	}
+	var (
+		preparers                                    = map[string]bool{}
+		preparerWeight, accepterWeight, accepterOnly int
+	)
+	for _, addr := range c.Preparers {
+		preparers[addr] = true
+		preparerWeight += c.weightOf(addr)
+	}
+	for _, addr := range c.Accepters {
+		accepterWeight += c.weightOf(addr)
+		if !preparers[addr] {
+			accepterOnly += c.weightOf(addr)
+		}
+	}
+	if regularQuorum(preparerWeight)+regularQuorum(accepterWeight)-accepterOnly <= preparerWeight {
+		return ErrQuorumIntersection
+	}
+	return nil
+}
+
+// maxFaultTolerance is the largest number of accepters FaultTolerance
+// analyzes. It checks every subset.
+const maxFaultTolerance = 16
+
+// FaultTolerance returns every largest set of acceptors which can fail at the
+// same time, while both phases can still make a weighted quorum. Each set is
+// sorted, and so are the sets. Sets which are contained in another aren't
+// listed. Clusters with too many accepters aren't analyzed, and return nil.
+func (c Configuration) FaultTolerance() [][]string {
+	addrs := c.Accepters
+	if len(addrs) > maxFaultTolerance {
+		return nil
+	}
+
+	var preparerTotal, accepterTotal int
+	preparers := map[string]bool{}
+	for _, addr := range c.Preparers {
+		preparers[addr] = true
+		preparerTotal += c.weightOf(addr)
+	}
+	for _, addr := range addrs {
+		accepterTotal += c.weightOf(addr)
+	}
+
+	tolerable := func(set uint) bool {
+		var preparerLost, accepterLost int
+		for i, addr := range addrs {
+			if set&(1<<uint(i)) == 0 {
+				continue
+			}
+			accepterLost += c.weightOf(addr)
+			if preparers[addr] {
+				preparerLost += c.weightOf(addr)
+			}
+		}
+		return preparerTotal-preparerLost >= regularQuorum(preparerTotal) &&
+			accepterTotal-accepterLost >= regularQuorum(accepterTotal)
+	}
+
+	// A tolerable set is maximal if adding any other acceptor makes it
+	// intolerable.
+	var sets [][]string
+	for set := uint(1); set < 1<<uint(len(addrs)); set++ {
+		if !tolerable(set) {
+			continue
+		}
+		maximal := true
+		for i := range addrs {
+			if bit := uint(1) << uint(i); set&bit == 0 && tolerable(set|bit) {
+				maximal = false
+				break
+			}
+		}
+		if !maximal {
+			continue
+		}
+		var failed []string
+		for i, addr := range addrs {
+			if set&(1<<uint(i)) != 0 {
+				failed = append(failed, addr)
+			}
+		}
+		sort.Strings(failed)
+		sets = append(sets, failed)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return strings.Join(sets[i], "\x00") < strings.Join(sets[j], "\x00")
+	})
+	return sets
+}
+
+// DescribeFaultTolerance describes FaultTolerance for people, e.g. "can lose
+// 1 and 3 simultaneously; or 2".
+func (c Configuration) DescribeFaultTolerance() string {
+	if len(c.Accepters) > maxFaultTolerance {
+		return fmt.Sprintf("not analyzed: more than %d acceptors", maxFaultTolerance)
+	}
+	sets := c.FaultTolerance()
+	if len(sets) == 0 {
+		return "can't lose any acceptor"
+	}
+	descriptions := make([]string, len(sets))
+	for i, set := range sets {
+		descriptions[i] = strings.Join(set, " and ")
+		if len(set) > 1 {
+			descriptions[i] += " simultaneously"
+		}
+	}
+	return fmt.Sprintf("can lose %s", strings.Join(descriptions, "; or "))
+}
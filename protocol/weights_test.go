@@ -0,0 +1,221 @@
+package protocol
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestWeightedQuorum(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		b1     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("1", log.With(logger, "a", 1))}
+		b2     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("2", log.With(logger, "a", 2))}
+		b3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		b4     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("4", log.With(logger, "a", 4))}
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), b2, b3)
+		ctx    = context.Background()
+	)
+
+	// 1 is the big box, with weight 2 of 5, so a quorum is weight 3. It's
+	// added before 4, while the quorums still intersect.
+	if err := p.AddWeightedAccepter(b1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(b1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddAccepter(b4); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(b4); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := map[string]int{"1": 2}, p.Configuration().Weights; !reflect.DeepEqual(want, have) {
+		t.Fatalf("weights: want %v, have %v", want, have)
+	}
+
+	for _, testcase := range []struct {
+		broken []*breakableAcceptor
+		ok     bool
+	}{
+		{nil, true},
+		{[]*breakableAcceptor{b1}, true},
+		{[]*breakableAcceptor{b2, b3}, true}, // a plain majority of 4 couldn't lose 2
+		{[]*breakableAcceptor{b1, b2}, false},
+		{[]*breakableAcceptor{b2, b3, b4}, false},
+	} {
+		for _, b := range testcase.broken {
+			b.setBroken(true)
+		}
+		_, _, err := p.Propose(ctx, "k", changeFuncRead)
+		if want, have := testcase.ok, err == nil; want != have {
+			t.Errorf("broken %v: want success %v, have %v", addrsOf(testcase.broken), want, err)
+		}
+		for _, b := range testcase.broken {
+			b.setBroken(false)
+		}
+	}
+}
+
+func TestWeightedQuorumIntersection(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		b1     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("1", log.With(logger, "a", 1))}
+		b2     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("2", log.With(logger, "a", 2))}
+		b3     = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("3", log.With(logger, "a", 3))}
+		h      = &breakableAcceptor{MemoryAcceptor: NewMemoryAcceptor("h", log.With(logger, "a", "h"))}
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), b1, b2, b3)
+		ctx    = context.Background()
+	)
+
+	// With h an accepter of weight 3, {1, h} would be an accept quorum, of
+	// weight 4 of 6, and {2, 3} a prepare quorum, of 2 of 3, which don't
+	// intersect, so a write accepted by the first would be lost to the second.
+	if want, have := ErrQuorumIntersection, p.AddWeightedAccepter(h, 3); want != have {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+
+	// So a write needs 2 of the 3, as before.
+	b2.setBroken(true)
+	b3.setBroken(true)
+	if _, _, err := p.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err == nil {
+		t.Fatal("write with only 1 of 3: want failure, have success")
+	}
+	b3.setBroken(false)
+	if _, _, err := p.Propose(ctx, "k", changeFuncInitializeOnlyOnce("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	// And a read by any other 2 sees it.
+	b2.setBroken(false)
+	b1.setBroken(true)
+	if state, _, err := p.Propose(ctx, "k", changeFuncRead); err != nil || string(state) != "v" {
+		t.Errorf("read: want v, have %q (%v)", state, err)
+	}
+}
+
+func TestWeightedRemovalIntersection(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		a4     = NewMemoryAcceptor("4", log.With(logger, "a", 4))
+		h      = NewMemoryAcceptor("h", log.With(logger, "a", "h"))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a2, a3)
+		ctx    = context.Background()
+	)
+
+	// h, of weight 2, joins while the quorums still intersect, then 1.
+	if err := p.AddWeightedAccepter(h, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(h); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddAccepter(a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddPreparer(a1); err != nil {
+		t.Fatal(err)
+	}
+	before := p.Configuration()
+
+	// Without h as a preparer, {1, h} would be an accept quorum, of weight 3
+	// of 5, and {2, 3} a prepare quorum, of 2 of 3, which don't intersect.
+	if want, have := ErrQuorumIntersection, p.RemovePreparer(h); want != have {
+		t.Fatalf("remove preparer: want %v, have %v", want, have)
+	}
+	if err := ShrinkCluster(ctx, h, []Proposer{p}); err == nil {
+		t.Fatal("shrink: want an error, have none")
+	}
+	if want, have := before, p.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("after refusals: want %+v, have %+v", want, have)
+	}
+
+	// Another acceptor outweighs it, so it can leave.
+	if err := GrowCluster(ctx, a4, []Proposer{p}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ShrinkCluster(ctx, h, []Proposer{p}); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := []string{"1", "2", "3", "4"}, p.Configuration().Accepters; !reflect.DeepEqual(want, have) {
+		t.Errorf("accepters: want %v, have %v", want, have)
+	}
+}
+
+func TestWeightValidation(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		a1     = NewMemoryAcceptor("1", log.With(logger, "a", 1))
+		a2     = NewMemoryAcceptor("2", log.With(logger, "a", 2))
+		a3     = NewMemoryAcceptor("3", log.With(logger, "a", 3))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), a1, a2)
+	)
+	if want, have := ErrInvalidWeight, p.AddWeightedAccepter(a3, 0); want != have {
+		t.Errorf("zero weight: want %v, have %v", want, have)
+	}
+	if want, have := ErrWeightMajority, p.AddWeightedAccepter(a3, 3); want != have {
+		t.Errorf("majority weight: want %v, have %v", want, have)
+	}
+	if want, have := (Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2"}}), p.Configuration(); !reflect.DeepEqual(want, have) {
+		t.Errorf("after refusals: want %+v, have %+v", want, have)
+	}
+
+	// Weights are part of the fingerprint.
+	before := p.Fingerprint()
+	if err := p.AddWeightedAccepter(a3, 2); err != nil {
+		t.Fatal(err)
+	}
+	q := NewMemoryProposer("2", log.With(logger, "p", 2), a1, a2)
+	if err := q.AddAccepter(a3); err != nil {
+		t.Fatal(err)
+	}
+	if p.Fingerprint() == q.Fingerprint() || p.Fingerprint() == before {
+		t.Errorf("fingerprints don't reflect weights")
+	}
+}
+
+func TestFaultTolerance(t *testing.T) {
+	for _, testcase := range []struct {
+		c    Configuration
+		want string
+	}{
+		{
+			Configuration{Preparers: []string{"1", "2", "3"}, Accepters: []string{"1", "2", "3"}},
+			"can lose 1; or 2; or 3",
+		},
+		{
+			Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2"}},
+			"can't lose any acceptor",
+		},
+		{
+			Configuration{Preparers: []string{"1", "2", "3", "4"}, Accepters: []string{"1", "2", "3", "4"}, Weights: map[string]int{"1": 2}},
+			"can lose 1; or 2 and 3 simultaneously; or 2 and 4 simultaneously; or 3 and 4 simultaneously",
+		},
+	} {
+		if err := testcase.c.validate(); err != nil {
+			t.Errorf("%+v: %v", testcase.c, err)
+		}
+		if want, have := testcase.want, testcase.c.DescribeFaultTolerance(); want != have {
+			t.Errorf("%+v: want %q, have %q", testcase.c, want, have)
+		}
+	}
+
+	invalid := Configuration{Preparers: []string{"1", "2"}, Accepters: []string{"1", "2"}, Weights: map[string]int{"1": 2}}
+	if want, have := ErrWeightMajority, invalid.validate(); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func addrsOf(acceptors []*breakableAcceptor) []string {
+	addrs := make([]string, len(acceptors))
+	for i, a := range acceptors {
+		addrs[i] = a.Address()
+	}
+	return addrs
+}
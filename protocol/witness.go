@@ -0,0 +1,65 @@
+package protocol
+
+import "errors"
+
+// ErrNoFullReplica indicates a phase which reached quorum, but not safely,
+// because of witnesses. In the prepare phase, a witness had accepted a newer
+// ballot than any full replica that responded, so the current value is
+// unknown. In the accept phase, only witnesses confirmed, so the value
+// wouldn't be stored anywhere.
+var ErrNoFullReplica = errors.New("quorum has no full replica with the current value")
+
+// Role is the part an acceptor plays in the cluster.
+type Role string
+
+const (
+	// RoleFull is an acceptor which stores ballots and values.
+	RoleFull Role = "full"
+
+	// RoleWitness is an acceptor which stores ballots, but not values.
+	RoleWitness Role = "witness"
+)
+
+// RoleReporter is implemented by acceptors which report their role. Acceptors
+// which don't are full replicas. Transports should carry the role, so remote
+// witnesses are treated as witnesses.
+type RoleReporter interface {
+	Role() Role
+}
+
+func roleOf(target interface{}) Role {
+	if r, ok := target.(RoleReporter); ok {
+		return r.Role()
+	}
+	return RoleFull
+}
+
+// AsWitness makes the acceptor a witness, or tiebreaker. A witness takes part
+// in promises and accepts like any acceptor, and counts toward quorum, but it
+// discards the value of each accept, and keeps only the ballot and checksum.
+// That makes it cheap to run, e.g. as the third site of a cluster which has
+// full replicas in two.
+//
+// Proposers never take a value from a witness. Instead, they apply two rules,
+// which together keep the protocol safe. First, every accept quorum must
+// include a full replica, so every chosen value is stored somewhere. Second,
+// the prepare phase isn't complete until the highest ballot accepted by any
+// responding witness has also been reported by a responding full replica; if
+// it can't be, the proposal fails with ErrNoFullReplica, rather than risk
+// choosing an older value. So a cluster which has lost the only full replica
+// with the latest value stops making progress on that key, but never goes
+// back in time.
+//
+// Inspect and History on a witness return no values, and VerifyAll, ReadStale,
+// and ReadAt take that into account.
+func AsWitness() MemoryAcceptorOption {
+	return func(a *MemoryAcceptor) { a.witness = true }
+}
+
+// Role implements RoleReporter.
+func (a *MemoryAcceptor) Role() Role {
+	if a.witness {
+		return RoleWitness
+	}
+	return RoleFull
+}
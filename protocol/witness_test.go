@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestWitness(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		f1     = NewMemoryAcceptor("f1", log.With(logger, "a", "f1"))
+		f2     = NewMemoryAcceptor("f2", log.With(logger, "a", "f2"))
+		w      = NewMemoryAcceptor("w", log.With(logger, "a", "w"), AsWitness())
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1), f1, f2, w)
+		ctx    = context.Background()
+
+		// Proposers which can't reach f1, or f2. Each has its own broken
+		// acceptor, which stays broken, so none of its requests land late.
+		withoutF1 = NewMemoryProposer("2", log.With(logger, "p", 2), brokenAcceptor(f1), f2, w)
+		withoutF2 = NewMemoryProposer("3", log.With(logger, "p", 3), f1, brokenAcceptor(f2), w)
+	)
+
+	// The witness takes part, but keeps no value.
+	_, b, err := p.Propose(ctx, "k", changeFuncInitializeOnlyOnce("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAccepted(t, "k", b, f1, f2, w)
+	if want, have := RoleWitness, roleOf(w); want != have {
+		t.Errorf("role: want %s, have %s", want, have)
+	}
+	if have := w.dumpValue("k"); have != nil {
+		t.Errorf("witness value: want none, have %q", have)
+	}
+
+	// y is accepted by f2 and the witness only.
+	if _, b, err = withoutF1.Propose(ctx, "k", changeFuncCompareAndSwap("x", "y")); err != nil {
+		t.Fatal(err)
+	}
+	waitAccepted(t, "k", b, f2, w)
+
+	// Without f2, the witness knows f1 is behind, so the read fails, rather
+	// than return x.
+	if state, _, err := withoutF2.Propose(ctx, "k", changeFuncRead); err != ErrNoFullReplica {
+		t.Errorf("read without f2: want %v, have %q, %v", ErrNoFullReplica, state, err)
+	}
+
+	// A read with every acceptor brings f1 up to date, so a write accepted
+	// by f1 and the witness can be read without f2.
+	state, b, err := p.Propose(ctx, "k", changeFuncRead)
+	if err != nil || string(state) != "y" {
+		t.Fatalf("read: want %q, have %q, %v", "y", state, err)
+	}
+	waitAccepted(t, "k", b, f1, f2, w)
+	if _, b, err = withoutF2.Propose(ctx, "k", changeFuncCompareAndSwap("y", "z")); err != nil {
+		t.Fatal(err)
+	}
+	waitAccepted(t, "k", b, f1, w)
+	if state, _, err := withoutF2.Propose(ctx, "k", changeFuncRead); err != nil || string(state) != "z" {
+		t.Errorf("read without f2: want %q, have %q, %v", "z", state, err)
+	}
+}
+
+func TestWitnessAcceptQuorum(t *testing.T) {
+	var (
+		logger = log.NewLogfmtLogger(newTestWriter(t))
+		p      = NewMemoryProposer("1", log.With(logger, "p", 1),
+			acceptFailingAcceptor{NewMemoryAcceptor("f1", log.With(logger, "a", "f1"))},
+			acceptFailingAcceptor{NewMemoryAcceptor("f2", log.With(logger, "a", "f2"))},
+			NewMemoryAcceptor("w1", log.With(logger, "a", "w1"), AsWitness()),
+			NewMemoryAcceptor("w2", log.With(logger, "a", "w2"), AsWitness()),
+			NewMemoryAcceptor("w3", log.With(logger, "a", "w3"), AsWitness()),
+		)
+	)
+
+	// The witnesses alone make a quorum, but wouldn't store the value.
+	if _, _, err := p.Propose(context.Background(), "k", changeFuncInitializeOnlyOnce("x")); err != ErrNoFullReplica {
+		t.Errorf("want %v, have %v", ErrNoFullReplica, err)
+	}
+}
+
+// waitAccepted waits for the acceptors to accept b for key, as a proposal
+// returns once a quorum has, and the rest may still be on their way.
+func waitAccepted(t *testing.T, key string, b Ballot, acceptors ...*MemoryAcceptor) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for _, a := range acceptors {
+		for {
+			inspection, err := a.Inspect(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inspection.Accepted == b {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: want ballot %s, have %s", a.Address(), b, inspection.Accepted)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func brokenAcceptor(a *MemoryAcceptor) *breakableAcceptor {
+	b := &breakableAcceptor{MemoryAcceptor: a}
+	b.setBroken(true)
+	return b
+}